@@ -1,11 +1,8 @@
 package main
 
 import (
-	"fmt"
 	"log"
 
-	"go.uber.org/zap"
-
 	"github.com/vkupriya/go-metrics/internal/server"
 )
 
@@ -16,17 +13,8 @@ var (
 )
 
 func main() {
-	logConfig := zap.NewDevelopmentConfig()
-	logger, err := logConfig.Build()
-	if err != nil {
-		log.Panic(fmt.Errorf("failed to initialize Logger: %w", err))
-	}
-
-	logger.Sugar().Infof("Build version: %s", buildVersion)
-	logger.Sugar().Infof("Build date: %s", buildDate)
-	logger.Sugar().Infof("Build commit: %s", buildCommit)
-
-	if err := server.Start(logger); err != nil {
-		logger.Sugar().Errorf("server has been terminated with error: %w", err)
+	build := server.BuildInfo{Version: buildVersion, Date: buildDate, Commit: buildCommit}
+	if err := server.Start(build); err != nil {
+		log.Fatalf("server has been terminated with error: %v", err)
 	}
 }
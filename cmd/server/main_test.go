@@ -6,23 +6,16 @@ import (
 	"testing"
 	"time"
 
-	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/vkupriya/go-metrics/internal/server"
 )
 
 func TestServer(t *testing.T) {
-	logConfig := zap.NewDevelopmentConfig()
-	logger, err := logConfig.Build()
-	if err != nil {
-		t.Error(err)
-	}
-
 	g := errgroup.Group{}
 
 	g.Go(func() error {
-		if err := server.Start(logger); err != nil {
+		if err := server.Start(server.BuildInfo{Version: "test"}); err != nil {
 			return fmt.Errorf("server failed: %w", err)
 		}
 		return nil
@@ -0,0 +1,99 @@
+// Package address parses addresses of the metric server shared by the server and agents.
+//
+// Address is either TCP host:port, optionally prefixed with http://, or path of a Unix domain socket
+// prefixed with unix:, e.g. unix:/run/go-metrics/http.sock or unix:///run/go-metrics/http.sock.
+package address
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Networks of addresses.
+const (
+	TCP  = "tcp"
+	Unix = "unix"
+)
+
+const (
+	unixPrefix = "unix:"
+	// Port of gRPC server used if its address isn't configured.
+	defaultGRPCPort = "3200"
+	// How long connection to an existing socket is attempted before it's considered stale.
+	staleCheckTimeout = time.Second
+)
+
+// Parse returns network and address of the server for net.Listen or net.Dial.
+func Parse(addr string) (network, address string) {
+	if path, ok := strings.CutPrefix(addr, unixPrefix); ok {
+		return Unix, strings.TrimPrefix(path, "//")
+	}
+	return TCP, strings.TrimPrefix(addr, "http://")
+}
+
+// Listen listens on the address. Stale socket file left by a previous process is removed before listening
+// on a Unix domain socket, the file is removed again when the listener is closed. Listening fails if
+// another process accepts connections on the socket.
+func Listen(addr string) (net.Listener, error) {
+	network, address := Parse(addr)
+	if network == Unix {
+		if err := removeSocket(address); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return l, nil
+}
+
+func removeSocket(path string) error {
+	info, err := os.Stat(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return nil
+	case err != nil:
+		return fmt.Errorf("failed to check socket file %s: %w", path, err)
+	case info.Mode()&fs.ModeSocket == 0:
+		return fmt.Errorf("failed to listen on %s: file exists and is not a socket", path)
+	}
+	// Socket of a running process accepts connections, only sockets of exited processes are stale.
+	if conn, err := net.DialTimeout(Unix, path, staleCheckTimeout); err == nil {
+		if err := conn.Close(); err != nil {
+			return fmt.Errorf("failed to close connection to socket %s: %w", path, err)
+		}
+		return fmt.Errorf("failed to listen on %s: address in use", path)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove stale socket file %s: %w", path, err)
+	}
+	return nil
+}
+
+// GRPCTarget returns target of gRPC client connecting to the address.
+func GRPCTarget(addr string) string {
+	network, address := Parse(addr)
+	if network == Unix {
+		return unixPrefix + address
+	}
+	return address
+}
+
+// DefaultGRPC returns gRPC address used if it isn't configured: port 3200 on the host of HTTP address,
+// loopback is used for local and Unix domain socket HTTP addresses.
+func DefaultGRPC(httpAddr string) string {
+	network, address := Parse(httpAddr)
+	host := "127.0.0.1"
+	if network == TCP {
+		if h := strings.Split(address, ":")[0]; h != "" && h != "localhost" {
+			host = h
+		}
+	}
+	return net.JoinHostPort(host, defaultGRPCPort)
+}
@@ -0,0 +1,63 @@
+package address
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		addr    string
+		network string
+		address string
+	}{
+		{addr: "localhost:8080", network: TCP, address: "localhost:8080"},
+		{addr: "http://10.0.0.1:8080", network: TCP, address: "10.0.0.1:8080"},
+		{addr: "unix:/run/metrics.sock", network: Unix, address: "/run/metrics.sock"},
+		{addr: "unix:///run/metrics.sock", network: Unix, address: "/run/metrics.sock"},
+		{addr: "unix:metrics.sock", network: Unix, address: "metrics.sock"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			network, address := Parse(tt.addr)
+			assert.Equal(t, tt.network, network)
+			assert.Equal(t, tt.address, address)
+		})
+	}
+}
+
+func TestDefaultGRPC(t *testing.T) {
+	assert.Equal(t, "127.0.0.1:3200", DefaultGRPC("localhost:8080"))
+	assert.Equal(t, "127.0.0.1:3200", DefaultGRPC(":8080"))
+	assert.Equal(t, "10.0.0.1:3200", DefaultGRPC("http://10.0.0.1:8080"))
+	assert.Equal(t, "127.0.0.1:3200", DefaultGRPC("unix:/run/metrics.sock"))
+	assert.Equal(t, "unix:/run/grpc.sock", GRPCTarget("unix:///run/grpc.sock"))
+	assert.Equal(t, "127.0.0.1:3200", GRPCTarget("127.0.0.1:3200"))
+}
+
+func TestListen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.sock")
+	addr := "unix:" + path
+
+	l, err := Listen(addr)
+	require.NoError(t, err)
+	// Socket file of a crashed process is left behind, it must not prevent listening.
+	require.NoError(t, os.Rename(path, path+".old"))
+	require.NoError(t, l.Close())
+	require.NoError(t, os.Rename(path+".old", path))
+
+	l, err = Listen(addr)
+	require.NoError(t, err)
+	_, err = Listen(addr)
+	assert.ErrorContains(t, err, "address in use", "socket of a running listener is not removed")
+	require.NoError(t, l.Close())
+	assert.NoFileExists(t, path, "socket file is removed on close")
+
+	require.NoError(t, os.WriteFile(path, []byte("data"), 0o600))
+	_, err = Listen(addr)
+	assert.Error(t, err, "regular files are not removed")
+}
@@ -3,11 +3,9 @@ package agent
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/hmac"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
@@ -15,13 +13,17 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
-	"strings"
-
+	"errors"
 	"fmt"
 	mrand "math/rand"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
 	"runtime"
 	"strconv"
 	"sync"
+	"syscall"
 	"time"
 
 	"golang.org/x/sync/errgroup"
@@ -29,25 +31,82 @@ import (
 	"github.com/go-resty/resty/v2"
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/mem"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 
 	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/realip"
 	pb "github.com/vkupriya/go-metrics/internal/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
-	_ "google.golang.org/grpc/encoding/gzip"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/vkupriya/go-metrics/internal/address"
+	"github.com/vkupriya/go-metrics/internal/compression"
+	"github.com/vkupriya/go-metrics/internal/configfile"
+	"github.com/vkupriya/go-metrics/internal/logging"
+	"github.com/vkupriya/go-metrics/internal/requestid"
+	"github.com/vkupriya/go-metrics/internal/signing"
+	"github.com/vkupriya/go-metrics/internal/tracing"
+)
+
+// Headers identifying the tenant metrics are posted to, gRPC metadata uses the same keys.
+const (
+	tenantHeader      = "X-Tenant"
+	tenantTokenHeader = "X-Tenant-Token"
 )
 
+// updatesPath is the path of metric batch updates of the metric server.
+const updatesPath = "/updates/"
+
+// serviceName is the name of the agent in trace spans.
+const serviceName = "metric-agent"
+
+// tracingShutdownTimeout limits flushing of trace spans on exit.
+const tracingShutdownTimeout = 5 * time.Second
+
+// Collector collects metrics and sends them to the metric server. Its configuration can be reloaded
+// while it runs, see Reload.
 type Collector struct {
 	gauge        map[string]float64
+	gaugeSource  map[string]string // gauge name to the collector it is collected by
 	counter      map[string]int64
 	config       *Config
+	reloaded     chan struct{} // closed on reload of the configuration
 	connGRPC     *grpc.ClientConn
 	clientGRPC   pb.MetricsClient
 	gaugeMutex   sync.Mutex
 	counterMutex sync.Mutex
+	configMutex  sync.RWMutex // guards config, reloaded and gRPC client
+}
+
+// maxRetryAfter limits delays of retries requested by the server.
+const maxRetryAfter = time.Minute
+
+// sendError is a failed attempt to send a batch, retryAfter is the delay of the next attempt
+// requested by the server, zero if the server didn't request any.
+type sendError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *sendError) Error() string {
+	return e.err.Error()
+}
+
+func (e *sendError) Unwrap() error {
+	return e.err
+}
+
+// batch is a batch of metrics posted by the dispatcher to senders.
+type batch struct {
+	metrics []Metric
+	span    trace.SpanContext // span of the dispatch of the batch, sending continues its trace
 }
 
 type Metric struct {
@@ -59,9 +118,59 @@ type Metric struct {
 
 func NewCollector(cfg *Config) *Collector {
 	return &Collector{
-		gauge:   make(map[string]float64),
-		counter: make(map[string]int64),
-		config:  cfg,
+		gauge:       make(map[string]float64),
+		gaugeSource: make(map[string]string),
+		counter:     make(map[string]int64),
+		config:      cfg,
+		reloaded:    make(chan struct{}),
+	}
+}
+
+// cfg returns the current configuration, it must not be changed.
+func (c *Collector) cfg() *Config {
+	c.configMutex.RLock()
+	defer c.configMutex.RUnlock()
+	return c.config
+}
+
+// reloads returns channel which is closed on the next reload of the configuration.
+func (c *Collector) reloads() <-chan struct{} {
+	c.configMutex.RLock()
+	defer c.configMutex.RUnlock()
+	return c.reloaded
+}
+
+func (c *Collector) grpcClient() pb.MetricsClient {
+	c.configMutex.RLock()
+	defer c.configMutex.RUnlock()
+	return c.clientGRPC
+}
+
+// setGauges stores values of gauges collected by the collector.
+func (c *Collector) setGauges(collector string, values map[string]float64) {
+	c.gaugeMutex.Lock()
+	defer c.gaugeMutex.Unlock()
+	for name, v := range values {
+		c.gauge[name] = v
+		c.gaugeSource[name] = collector
+	}
+}
+
+// collect runs enabled collectors.
+func (c *Collector) collect() {
+	ctx, span := tracing.Tracer().Start(context.Background(), "collect")
+	defer span.End()
+
+	cfg := c.cfg()
+	if cfg.collects(CollectorRuntime) {
+		_, s := tracing.Tracer().Start(ctx, "collect "+CollectorRuntime)
+		c.collectMetrics()
+		s.End()
+	}
+	if cfg.collects(CollectorPsutil) {
+		_, s := tracing.Tracer().Start(ctx, "collect "+CollectorPsutil)
+		c.collectPsutilMetrics()
+		s.End()
 	}
 }
 
@@ -69,36 +178,36 @@ func (c *Collector) collectMetrics() {
 	var memStats runtime.MemStats
 	runtime.ReadMemStats(&memStats)
 
-	c.gaugeMutex.Lock()
-	c.gauge[`Alloc`] = float64(memStats.Alloc)
-	c.gauge[`BuckHashSys`] = float64(memStats.BuckHashSys)
-	c.gauge[`Frees`] = float64(memStats.Frees)
-	c.gauge[`GCCPUFraction`] = float64(memStats.GCCPUFraction)
-	c.gauge[`GCSys`] = float64(memStats.GCSys)
-	c.gauge[`HeapAlloc`] = float64(memStats.HeapAlloc)
-	c.gauge[`HeapIdle`] = float64(memStats.HeapIdle)
-	c.gauge[`HeapInuse`] = float64(memStats.HeapInuse)
-	c.gauge[`HeapReleased`] = float64(memStats.HeapReleased)
-	c.gauge[`HeapObjects`] = float64(memStats.HeapObjects)
-	c.gauge[`HeapSys`] = float64(memStats.HeapSys)
-	c.gauge[`LastGC`] = float64(memStats.LastGC)
-	c.gauge[`Lookups`] = float64(memStats.Lookups)
-	c.gauge[`MCacheInuse`] = float64(memStats.MCacheInuse)
-	c.gauge[`MCacheSys`] = float64(memStats.MCacheSys)
-	c.gauge[`MSpanInuse`] = float64(memStats.MSpanInuse)
-	c.gauge[`MSpanSys`] = float64(memStats.MSpanSys)
-	c.gauge[`Mallocs`] = float64(memStats.Mallocs)
-	c.gauge[`NextGC`] = float64(memStats.NextGC)
-	c.gauge[`NumForcedGC`] = float64(memStats.NumForcedGC)
-	c.gauge[`NumGC`] = float64(memStats.NumGC)
-	c.gauge[`OtherSys`] = float64(memStats.OtherSys)
-	c.gauge[`PauseTotalNs`] = float64(memStats.PauseTotalNs)
-	c.gauge[`StackInuse`] = float64(memStats.StackInuse)
-	c.gauge[`StackSys`] = float64(memStats.StackSys)
-	c.gauge[`Sys`] = float64(memStats.Sys)
-	c.gauge[`TotalAlloc`] = float64(memStats.TotalAlloc)
-	c.gauge[`RandomValue`] = mrand.Float64()
-	c.gaugeMutex.Unlock()
+	c.setGauges(CollectorRuntime, map[string]float64{
+		`Alloc`:         float64(memStats.Alloc),
+		`BuckHashSys`:   float64(memStats.BuckHashSys),
+		`Frees`:         float64(memStats.Frees),
+		`GCCPUFraction`: float64(memStats.GCCPUFraction),
+		`GCSys`:         float64(memStats.GCSys),
+		`HeapAlloc`:     float64(memStats.HeapAlloc),
+		`HeapIdle`:      float64(memStats.HeapIdle),
+		`HeapInuse`:     float64(memStats.HeapInuse),
+		`HeapReleased`:  float64(memStats.HeapReleased),
+		`HeapObjects`:   float64(memStats.HeapObjects),
+		`HeapSys`:       float64(memStats.HeapSys),
+		`LastGC`:        float64(memStats.LastGC),
+		`Lookups`:       float64(memStats.Lookups),
+		`MCacheInuse`:   float64(memStats.MCacheInuse),
+		`MCacheSys`:     float64(memStats.MCacheSys),
+		`MSpanInuse`:    float64(memStats.MSpanInuse),
+		`MSpanSys`:      float64(memStats.MSpanSys),
+		`Mallocs`:       float64(memStats.Mallocs),
+		`NextGC`:        float64(memStats.NextGC),
+		`NumForcedGC`:   float64(memStats.NumForcedGC),
+		`NumGC`:         float64(memStats.NumGC),
+		`OtherSys`:      float64(memStats.OtherSys),
+		`PauseTotalNs`:  float64(memStats.PauseTotalNs),
+		`StackInuse`:    float64(memStats.StackInuse),
+		`StackSys`:      float64(memStats.StackSys),
+		`Sys`:           float64(memStats.Sys),
+		`TotalAlloc`:    float64(memStats.TotalAlloc),
+		`RandomValue`:   mrand.Float64(),
+	})
 
 	c.counterMutex.Lock()
 	c.counter[`PollCount`]++
@@ -110,18 +219,19 @@ func (c *Collector) collectPsutilMetrics() {
 
 	cp, _ := cpu.Times(true)
 
-	c.gaugeMutex.Lock()
-	c.gauge[`TotalMemory`] = float64(v.Total)
-	c.gauge[`FreeMemory`] = float64(v.Free)
-	c.gaugeMutex.Unlock()
-
+	gauges := map[string]float64{
+		`TotalMemory`: float64(v.Total),
+		`FreeMemory`:  float64(v.Free),
+	}
 	for i := range len(cp) {
-		c.gauge[`CPUutilization`+strconv.Itoa(i)] = float64(cp[i].System)
+		gauges[`CPUutilization`+strconv.Itoa(i)] = float64(cp[i].System)
 	}
+	c.setGauges(CollectorPsutil, gauges)
 }
 
-func (c *Collector) startSender(ctx context.Context, ch chan []Metric) {
-	sendTicker := time.NewTicker(time.Duration(c.config.ReportInterval) * time.Second)
+func (c *Collector) startSender(ctx context.Context, ch chan batch) {
+	interval := c.cfg().ReportInterval
+	sendTicker := time.NewTicker(time.Duration(interval) * time.Second)
 	defer sendTicker.Stop()
 
 	for {
@@ -129,6 +239,11 @@ func (c *Collector) startSender(ctx context.Context, ch chan []Metric) {
 		case <-ctx.Done():
 			close(ch)
 			return
+		case <-c.reloads():
+			if next := c.cfg().ReportInterval; next != interval {
+				interval = next
+				sendTicker.Reset(time.Duration(interval) * time.Second)
+			}
 		case <-sendTicker.C:
 			c.dispatcher(ch)
 		}
@@ -136,23 +251,60 @@ func (c *Collector) startSender(ctx context.Context, ch chan []Metric) {
 }
 
 func (c *Collector) startCollector(ctx context.Context) {
-	collectTicker := time.NewTicker(time.Duration(c.config.PollInterval) * time.Second)
+	interval := c.cfg().PollInterval
+	collectTicker := time.NewTicker(time.Duration(interval) * time.Second)
 	defer collectTicker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-c.reloads():
+			if next := c.cfg().PollInterval; next != interval {
+				interval = next
+				collectTicker.Reset(time.Duration(interval) * time.Second)
+			}
 		case <-collectTicker.C:
-			c.collectMetrics()
-			c.collectPsutilMetrics()
+			c.collect()
+		}
+	}
+}
+
+// runSenders keeps as many workers sending batches as the rate limit allows until the context is cancelled.
+// Workers stopped on reload of a lower limit finish the batch they are sending.
+func (c *Collector) runSenders(ctx context.Context, eg *errgroup.Group, ch chan batch) {
+	var stops []context.CancelFunc
+	for {
+		limit := c.cfg().rateLimit
+		for len(stops) < limit {
+			workerCtx, stop := context.WithCancel(ctx)
+			stops = append(stops, stop)
+			eg.Go(func() error {
+				if err := c.sendMetrics(workerCtx, ch); err != nil {
+					return fmt.Errorf("failed to send metrics: %w", err)
+				}
+				return nil
+			})
+		}
+		for len(stops) > limit {
+			stops[len(stops)-1]()
+			stops = stops[:len(stops)-1]
+		}
+
+		select {
+		case <-ctx.Done():
+			for _, stop := range stops {
+				stop()
+			}
+			return
+		case <-c.reloads():
 		}
 	}
 }
 
 func (c *Collector) StartTickers(ctx context.Context) error {
 	// Start tickers
-	inputCh := make(chan []Metric, c.config.rateLimit)
+	inputCh := make(chan batch, c.cfg().rateLimit)
 
 	eg, egCtx := errgroup.WithContext(ctx)
 
@@ -160,14 +312,10 @@ func (c *Collector) StartTickers(ctx context.Context) error {
 
 	go c.startSender(ctx, inputCh)
 
-	for w := 1; w <= c.config.rateLimit; w++ {
-		eg.Go(func() error {
-			if err := c.sendMetrics(egCtx, inputCh); err != nil {
-				return fmt.Errorf("failed to send metrics: %w", err)
-			}
-			return nil
-		})
-	}
+	eg.Go(func() error {
+		c.runSenders(egCtx, eg, inputCh)
+		return nil
+	})
 
 	if err := eg.Wait(); err != nil {
 		return fmt.Errorf("failed to run collector/sender go routines: %w", err)
@@ -175,14 +323,22 @@ func (c *Collector) StartTickers(ctx context.Context) error {
 	return nil
 }
 
-func (c *Collector) dispatcher(ch chan []Metric) {
-	logger := c.config.Logger
+// dispatcher posts a batch of all collected metrics to the channel. Gauges of collectors disabled
+// by reload are sent for the last time and forgotten.
+func (c *Collector) dispatcher(ch chan batch) {
+	_, span := tracing.Tracer().Start(context.Background(), "dispatch")
+	defer span.End()
+
+	cfg := c.cfg()
+	logger := cfg.Logger.Named(logging.ComponentSender)
+	// Counters are taken by the batch, they are restored if the batch isn't accepted by the server.
 	c.counterMutex.Lock()
 	metrics := make([]Metric, 0)
 	for k, v := range c.counter {
 		mtype := "counter"
 		delta := v
 		metrics = append(metrics, Metric{ID: k, MType: mtype, Delta: &delta})
+		c.counter[k] = 0
 	}
 	c.counterMutex.Unlock()
 
@@ -192,86 +348,119 @@ func (c *Collector) dispatcher(ch chan []Metric) {
 		mtype := "gauge"
 		value := v
 		metrics = append(metrics, Metric{ID: k, MType: mtype, Value: &value})
+		if !cfg.collects(c.gaugeSource[k]) {
+			delete(c.gauge, k)
+			delete(c.gaugeSource, k)
+		}
 	}
 	c.gaugeMutex.Unlock()
 	logger.Sugar().Debug("Posting metrics to channel")
+	span.SetAttributes(attribute.Int("metrics", len(metrics)))
 
-	ch <- metrics
+	ch <- batch{metrics: metrics, span: span.SpanContext()}
 }
 
-func (c *Collector) sendMetrics(ctx context.Context, ch chan []Metric) error {
-	logger := c.config.Logger
-	// Sending counter metrics
+// sendMetrics sends batches of the channel until the context is cancelled. Failed attempts are retried
+// after the delay requested by the server or after 1s and 3s otherwise. Error is returned if a batch
+// isn't sent after all attempts, its counters are kept for the next batch.
+func (c *Collector) sendMetrics(ctx context.Context, ch chan batch) error {
+	logger := c.cfg().Logger.Named(logging.ComponentSender)
 	const (
-		retries    = 3
+		attempts   = 3
 		retryDelay = 2
 	)
-	var retry int
-	var metrics []Metric
 
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
-		case metrics = <-ch:
-			retry = 0
-			for retry <= retries {
-				if retry == retries {
-					return fmt.Errorf("failed to send metrics after %d", retries)
-				}
-				if c.config.EnableGRPC {
-					if err := c.metricPostGRPC(metrics); err != nil {
-						logger.Sugar().Errorf("failed to post metrics batch, retrying: %v\n", err)
-					} else {
-						break
-					}
+		case b := <-ch:
+			// Sending isn't cancelled with ctx, the batch is sent even if the sender is stopped.
+			sendCtx, span := tracing.Tracer().Start(trace.ContextWithSpanContext(context.Background(), b.span), "send",
+				trace.WithAttributes(attribute.Int("metrics", len(b.metrics))))
+			var err error
+			retry := 0
+			for ; ; retry++ {
+				// Every attempt is a request of its own, so it has its own ID in logs of the server.
+				id := requestid.New()
+				attemptCtx := requestid.NewContext(sendCtx, id)
+				if cfg := c.cfg(); cfg.EnableGRPC {
+					err = c.metricPostGRPC(attemptCtx, b.metrics)
 				} else {
-					if err := c.metricPost(metrics, c.config.MetricHost); err != nil {
-						logger.Sugar().Errorf("failed to post metrics batch, retrying: %v\n", err)
-					} else {
-						break
-					}
+					err = c.metricPost(attemptCtx, b.metrics, cfg.MetricHost)
+				}
+				if err == nil || retry == attempts-1 {
+					break
 				}
 
-				time.Sleep(time.Duration(1+(retry*retryDelay)) * time.Second)
-				retry++
+				delay := time.Duration(1+(retry*retryDelay)) * time.Second
+				var se *sendError
+				if errors.As(err, &se) && se.retryAfter > 0 {
+					delay = min(se.retryAfter, maxRetryAfter)
+				}
+				logger.Sugar().Errorw("failed to post metrics batch, retrying", requestid.LogKey, id,
+					"delay", delay, zap.Error(err))
+				time.Sleep(delay)
+			}
+			span.SetAttributes(attribute.Int("retries", retry))
+			if err != nil {
+				c.restoreCounters(b.metrics)
+				err = fmt.Errorf("failed to send metrics after %d attempts: %w", attempts, err)
+				tracing.End(span, err)
+				return err
 			}
-			// Resetting PollCount to 0 on successful Post
-			c.counterMutex.Lock()
-			c.counter["PollCount"] = 0
-			c.counterMutex.Unlock()
+			span.End()
 		}
 	}
 }
 
-func (c *Collector) metricPost(m []Metric, h string) error {
-	logger := c.config.Logger
+// restoreCounters adds deltas of counters of the unsent batch back to the collected counters.
+func (c *Collector) restoreCounters(metrics []Metric) {
+	c.counterMutex.Lock()
+	defer c.counterMutex.Unlock()
+	for _, m := range metrics {
+		if m.MType == "counter" && m.Delta != nil {
+			c.counter[m.ID] += *m.Delta
+		}
+	}
+}
+
+func (c *Collector) metricPost(ctx context.Context, m []Metric, h string) error {
 	const httpTimeout int = 30
 	var body []byte
 
+	cfg := c.cfg()
 	client := resty.New()
 	client.SetTimeout(time.Duration(httpTimeout) * time.Second)
-	client.SetHeader("X-Real-IP", c.config.OutboundIP.String())
+	client.SetHeader("X-Real-IP", cfg.OutboundIP.String())
+	if id := requestid.FromContext(ctx); id != "" {
+		client.SetHeader(requestid.Header, id)
+	}
+	if cfg.Tenant != "" {
+		client.SetHeader(tenantHeader, cfg.Tenant)
+	}
+	if cfg.TenantToken != "" {
+		client.SetHeader(tenantTokenHeader, cfg.TenantToken)
+	}
+	if cfg.AuthToken != "" {
+		client.SetAuthToken(cfg.AuthToken)
+	}
 
-	url := fmt.Sprintf("http://%s/updates/", h)
+	url := fmt.Sprintf("http://%s%s", serverHost(client, h), updatesPath)
+	client.SetTransport(otelhttp.NewTransport(client.GetClient().Transport))
 
 	b, err := json.Marshal(m)
 	if err != nil {
 		return fmt.Errorf("error encoding JSON response for metrics batch: %w", err)
 	}
 
-	var gz bytes.Buffer
-	w := gzip.NewWriter(&gz)
-	_, err = w.Write(b)
+	payload, err := compress(cfg, b)
 	if err != nil {
-		return fmt.Errorf("failed to write into gzip.NewWriter metrics batch: %w", err)
-	}
-	if err = w.Close(); err != nil {
-		return fmt.Errorf("failed to close gzip.NewWriter for metrics batch: %w", err)
+		return err
 	}
 
-	if len(c.config.SecretKey) != 0 {
-		block, err := aes.NewCipher(c.config.SecretKey)
+	if len(cfg.SecretKey) != 0 {
+		block, err := aes.NewCipher(cfg.SecretKey)
 		if err != nil {
 			panic(err.Error())
 		}
@@ -286,66 +475,189 @@ func (c *Collector) metricPost(m []Metric, h string) error {
 			fmt.Printf("error: %v\n", err)
 		}
 
-		body = aesgcm.Seal(nonce, nonce, gz.Bytes(), nil)
+		body = aesgcm.Seal(nonce, nonce, payload, nil)
 
 		bodyHex := make([]byte, hex.EncodedLen(len(body)))
 		hex.Encode(bodyHex, body)
 
-		if c.config.HashKey != "" {
-			c.hashHeader(client, bodyHex)
+		req := client.R().SetContext(ctx)
+		if err := hashHeader(req, cfg.HashKey, updatesPath, bodyHex); err != nil {
+			return err
 		}
-
-		resp, err := client.R().SetHeader("Content-Type", "application/json").
-			SetHeader("Content-Encoding", "gzip").
+		if cfg.Compression != "" {
+			req.SetHeader("Content-Encoding", cfg.Compression)
+		}
+		resp, err := req.SetHeader("Content-Type", "application/json").
 			SetBody(bodyHex).
 			Post(url)
 
-		if c.config.HashKey != "" {
-			c.hashHeader(client, body)
-		}
-
 		if err != nil {
 			return fmt.Errorf("error to do http post: %w", err)
 		}
 
-		logger.Sugar().Infof("sent metrics batch Status code: %d\n", resp.StatusCode())
+		c.logBatchResponse(resp)
 
-		return nil
+		return responseError(resp)
 	}
 
-	if c.config.HashKey != "" {
-		c.hashHeader(client, gz.Bytes())
+	req := client.R().SetContext(ctx)
+	if err := hashHeader(req, cfg.HashKey, updatesPath, payload); err != nil {
+		return err
 	}
-	resp, err := client.R().
+	if cfg.Compression != "" {
+		req.SetHeader("Content-Encoding", cfg.Compression)
+	}
+	resp, err := req.
 		SetHeader("Content-Type", "application/json").
-		SetHeader("Content-Encoding", "gzip").
-		SetBody(&gz).
+		SetBody(payload).
 		Post(url)
 
 	if err != nil {
 		return fmt.Errorf("error to do http post: %w", err)
 	}
 
-	logger.Sugar().Infof("sent metrics batch Status code: %d\n", resp.StatusCode())
+	c.logBatchResponse(resp)
 
-	return nil
+	return responseError(resp)
+}
+
+// responseError returns error for responses to batches which aren't accepted but may be accepted later:
+// rate limited, unauthorized and failed on the server. Other responses are final, rejected metrics
+// are logged by logBatchResponse.
+func responseError(resp *resty.Response) error {
+	code := resp.StatusCode()
+	if code != http.StatusTooManyRequests && code != http.StatusUnauthorized && code < http.StatusInternalServerError {
+		return nil
+	}
+	return &sendError{
+		err:        fmt.Errorf("metrics batch is not accepted, status code: %d", code),
+		retryAfter: parseRetryAfter(resp.Header().Get("Retry-After")),
+	}
+}
+
+// parseRetryAfter returns delay of Retry-After header in seconds or HTTP date, zero if it's not set.
+func parseRetryAfter(v string) time.Duration {
+	if seconds, err := strconv.ParseInt(v, 10, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+// grpcRetryDelay returns delay of RetryInfo detail of the status error, zero if it's not set.
+func grpcRetryDelay(err error) time.Duration {
+	st, ok := status.FromError(err)
+	if !ok {
+		return 0
+	}
+	for _, d := range st.Details() {
+		if ri, ok := d.(*errdetails.RetryInfo); ok {
+			return ri.GetRetryDelay().AsDuration()
+		}
+	}
+	return 0
+}
+
+// compress compresses the body with the codec of the configuration, the body is returned as is if
+// compression is disabled.
+func compress(cfg *Config, b []byte) ([]byte, error) {
+	codec, ok := compression.Lookup(cfg.Compression)
+	if !ok {
+		return b, nil
+	}
+	var buf bytes.Buffer
+	w, err := codec.NewWriter(&buf, cfg.CompressionLevel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress metrics batch: %w", err)
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, fmt.Errorf("failed to write %s metrics batch: %w", codec.Name(), err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close %s writer for metrics batch: %w", codec.Name(), err)
+	}
+	return buf.Bytes(), nil
 }
 
-func (c *Collector) metricPostGRPC(metrics []Metric) error {
-	logger := c.config.Logger
+// batchErrors is the part of server responses explaining why metrics of a batch were rejected,
+// it is present in problem details of failed requests and in bodies of partially accepted batches.
+type batchErrors struct {
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+	Errors []struct {
+		ID     string `json:"id"`
+		Code   string `json:"code"`
+		Detail string `json:"detail"`
+	} `json:"errors"`
+}
+
+// logBatchResponse logs status code of the posted batch and reasons of rejected metrics given by the server.
+func (c *Collector) logBatchResponse(resp *resty.Response) {
+	logger := c.cfg().Logger.Named(logging.ComponentSender).With(
+		zap.String(requestid.LogKey, resp.Header().Get(requestid.Header)))
+	if resp.StatusCode() == http.StatusOK {
+		logger.Sugar().Infof("sent metrics batch Status code: %d", resp.StatusCode())
+		return
+	}
+
+	var be batchErrors
+	if err := json.Unmarshal(resp.Body(), &be); err != nil {
+		logger.Sugar().Warnf("metrics batch is not accepted, status code: %d", resp.StatusCode())
+		return
+	}
+	if be.Code != "" {
+		logger.Sugar().Warnw("metrics batch is rejected", "status", resp.StatusCode(), "code", be.Code,
+			"detail", be.Detail)
+	} else {
+		logger.Sugar().Warnw("metrics batch is accepted partially", "status", resp.StatusCode(),
+			"rejected", len(be.Errors))
+	}
+	for _, e := range be.Errors {
+		logger.Sugar().Warnw("metric is rejected", "id", e.ID, "code", e.Code, "detail", e.Detail)
+	}
+}
+
+func (c *Collector) metricPostGRPC(ctx context.Context, metrics []Metric) error {
+	cfg := c.cfg()
+	logger := cfg.Logger.Named(logging.ComponentSender)
 	mb := make([]*pb.Metric, 0)
 	for _, metric := range metrics {
 		pbMetric, _ := MetricToProto(metric)
 		mb = append(mb, &pbMetric)
 	}
-	md := metadata.New(map[string]string{realip.XRealIp: c.config.OutboundIP.String()})
-	ctx := metadata.NewOutgoingContext(context.Background(), md)
-	resp, err := c.clientGRPC.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{
+	md := metadata.New(map[string]string{realip.XRealIp: cfg.OutboundIP.String()})
+	if id := requestid.FromContext(ctx); id != "" {
+		md.Set(requestid.MetadataKey, id)
+	}
+	if cfg.Tenant != "" {
+		md.Set(tenantHeader, cfg.Tenant)
+	}
+	if cfg.TenantToken != "" {
+		md.Set(tenantTokenHeader, cfg.TenantToken)
+	}
+	if cfg.AuthToken != "" {
+		md.Set("authorization", "Bearer "+cfg.AuthToken)
+	}
+	req := &pb.UpdateMetricsRequest{
 		Metric: mb,
-	}, grpc.UseCompressor("gzip"))
+	}
+	if err := signGRPC(md, cfg.HashKey, pb.Metrics_UpdateMetrics_FullMethodName, req); err != nil {
+		return err
+	}
+	ctx = metadata.NewOutgoingContext(ctx, md)
+	var opts []grpc.CallOption
+	if cfg.Compression != "" {
+		opts = append(opts, grpc.UseCompressor(cfg.Compression))
+	}
+	resp, err := c.grpcClient().UpdateMetrics(ctx, req, opts...)
 
 	if err != nil {
-		return fmt.Errorf("failed to send metric batch via grpc: %w", err)
+		return &sendError{
+			err:        fmt.Errorf("failed to send metric batch via grpc: %w", err),
+			retryAfter: grpcRetryDelay(err),
+		}
 	}
 
 	if resp.GetError() != "" {
@@ -373,7 +685,7 @@ func MetricToProto(metric Metric) (pb.Metric, error) {
 		}, nil
 	case "counter":
 		return pb.Metric{
-			Mtype: pb.Mtype_gauge,
+			Mtype: pb.Mtype_counter,
 			Id:    metric.ID,
 			Delta: *metric.Delta,
 		}, nil
@@ -381,20 +693,25 @@ func MetricToProto(metric Metric) (pb.Metric, error) {
 	return pb.Metric{}, nil
 }
 
-func (c *Collector) keyExchange(h string) error {
+// keyExchange sends a new secret key encrypted with the public key of the configuration to the metric server,
+// the secret key is stored in the configuration.
+func keyExchange(cfg *Config) error {
 	const httpTimeout int = 30
 	const secretKeyLength int = 32
 	const retryCount int = 3
 	const retryWaitTime time.Duration = 5 * time.Second
 	const retryMaxWaitTime time.Duration = 20 * time.Second
 
-	logger := c.config.Logger
+	logger := cfg.Logger
 
 	client := resty.New()
 	client.SetTimeout(time.Duration(httpTimeout) * time.Second)
 	client.SetRetryCount(retryCount).SetRetryWaitTime(retryWaitTime).SetRetryMaxWaitTime(retryMaxWaitTime)
+	if cfg.AuthToken != "" {
+		client.SetAuthToken(cfg.AuthToken)
+	}
 
-	url := fmt.Sprintf("http://%s/", h)
+	url := fmt.Sprintf("http://%s/", serverHost(client, cfg.MetricHost))
 
 	secretKey, err := generateRandom(secretKeyLength)
 
@@ -402,7 +719,7 @@ func (c *Collector) keyExchange(h string) error {
 		return fmt.Errorf("failed to generate secretKey: %w", err)
 	}
 
-	publicKeyBlock, _ := pem.Decode(c.config.CryptoKey)
+	publicKeyBlock, _ := pem.Decode(cfg.CryptoKey)
 	publicKey, err := x509.ParsePKIXPublicKey(publicKeyBlock.Bytes)
 	if err != nil {
 		return fmt.Errorf("failed to parse public key: %w", err)
@@ -424,7 +741,7 @@ func (c *Collector) keyExchange(h string) error {
 
 	logger.Sugar().Infof("sent symmetric key to server, status code: %d\n", resp.StatusCode())
 
-	c.config.SecretKey = secretKey
+	cfg.SecretKey = secretKey
 
 	return nil
 }
@@ -439,24 +756,62 @@ func generateRandom(size int) ([]byte, error) {
 	return b, nil
 }
 
-func (c *Collector) hashHeader(req *resty.Client, body []byte) {
-	h := hmac.New(sha256.New, []byte(c.config.HashKey))
-	h.Write(body)
-	hdst := h.Sum(nil)
+// hashHeader signs the request to path of the metric server if hash key is configured.
+func hashHeader(req *resty.Request, key, path string, body []byte) error {
+	if key == "" {
+		return nil
+	}
+	nonce, err := signing.NewNonce()
+	if err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
+	ts := time.Now().Unix()
+	req.SetHeader(signing.HeaderSignature, signing.Sign(key, http.MethodPost, path, ts, nonce, body))
+	req.SetHeader(signing.HeaderTimestamp, strconv.FormatInt(ts, 10))
+	req.SetHeader(signing.HeaderNonce, nonce)
+	return nil
+}
 
-	req.Header.Set(`HashSHA256`, hex.EncodeToString(hdst))
+// signGRPC adds signature of the call to outgoing metadata if hash key is configured.
+func signGRPC(md metadata.MD, key, method string, req proto.Message) error {
+	if key == "" {
+		return nil
+	}
+	body, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal grpc request for signing: %w", err)
+	}
+	nonce, err := signing.NewNonce()
+	if err != nil {
+		return fmt.Errorf("failed to sign grpc request: %w", err)
+	}
+	ts := time.Now().Unix()
+	md.Set(signing.HeaderSignature, signing.Sign(key, http.MethodPost, method, ts, nonce, body))
+	md.Set(signing.HeaderTimestamp, strconv.FormatInt(ts, 10))
+	md.Set(signing.HeaderNonce, nonce)
+	return nil
 }
 
-func NewGRPCClient(c *Collector) error {
-	hostport := strings.Replace(c.config.MetricHost, "http://", "", 1)
-	grpcHost := strings.Split(hostport, ":")[0]
-	if grpcHost == "" || grpcHost == "localhost" {
-		grpcHost = "127.0.0.1"
+// serverHost returns host of request URLs of the metric server address, the client is set to dial
+// the socket if the server listens on a Unix domain socket.
+func serverHost(client *resty.Client, h string) string {
+	network, addr := address.Parse(h)
+	if network != address.Unix {
+		return addr
 	}
-	grpcHost += ":3200"
-	conn, err := grpc.NewClient(grpcHost, grpc.WithTransportCredentials((insecure.NewCredentials())))
+	client.SetTransport(&http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, address.Unix, addr)
+		},
+	})
+	return "localhost"
+}
+
+func NewGRPCClient(c *Collector) error {
+	conn, err := dialGRPC(c.cfg().GRPCAddress)
 	if err != nil {
-		return fmt.Errorf("failed to connect to grpc server: %w", err)
+		return err
 	}
 
 	c.connGRPC = conn
@@ -464,15 +819,123 @@ func NewGRPCClient(c *Collector) error {
 	return nil
 }
 
+func dialGRPC(addr string) (*grpc.ClientConn, error) {
+	conn, err := grpc.NewClient(address.GRPCTarget(addr), grpc.WithTransportCredentials((insecure.NewCredentials())),
+		grpc.WithChainUnaryInterceptor(tracing.UnaryClientInterceptor()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to grpc server: %w", err)
+	}
+	return conn, nil
+}
+
+// Reload applies the new configuration to the running collector. Collected metrics and batches waiting
+// to be sent are kept, they are sent to the new server if it has changed. Secret key is exchanged again
+// if the server or the public key has changed. If reload fails the running configuration is kept.
+func (c *Collector) Reload(next *Config) error {
+	cur := c.cfg()
+
+	next.SecretKey = cur.SecretKey
+	switch {
+	case len(next.CryptoKey) == 0:
+		next.SecretKey = nil
+	case len(cur.SecretKey) == 0 || next.MetricHost != cur.MetricHost || !bytes.Equal(next.CryptoKey, cur.CryptoKey):
+		if err := keyExchange(next); err != nil {
+			return fmt.Errorf("failed to send secret to metric server: %w", err)
+		}
+	}
+
+	var conn *grpc.ClientConn
+	if next.EnableGRPC && (c.grpcClient() == nil || next.GRPCAddress != cur.GRPCAddress) {
+		var err error
+		if conn, err = dialGRPC(next.GRPCAddress); err != nil {
+			return err
+		}
+	}
+
+	compression.SetGRPCLevel(next.CompressionLevel)
+	next.levels = cur.levels
+	if next.levels != nil {
+		next.levels.Set(next.Log.Level, next.Log.ComponentLevels)
+	}
+
+	c.configMutex.Lock()
+	c.config = next
+	old := c.connGRPC
+	if conn != nil {
+		c.connGRPC, c.clientGRPC = conn, pb.NewMetricsClient(conn)
+	}
+	close(c.reloaded)
+	c.reloaded = make(chan struct{})
+	c.configMutex.Unlock()
+
+	if conn != nil && old != nil {
+		if err := old.Close(); err != nil {
+			next.Logger.Sugar().Warnw("failed to close connection to the previous grpc server", zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// watchReloads reloads configuration on SIGHUP until the context is cancelled.
+func (c *Collector) watchReloads(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			logger := c.cfg().Logger
+			next, _, err := loadConfig(logger)
+			if err == nil {
+				err = c.Reload(next)
+			}
+			if err != nil {
+				logger.Sugar().Errorw("failed to reload configuration, running configuration is kept", zap.Error(err))
+				continue
+			}
+			logger.Sugar().Infow("configuration reloaded", "address", next.MetricHost, "grpc", next.EnableGRPC,
+				"poll_interval", next.PollInterval, "report_interval", next.ReportInterval,
+				"rate_limit", next.rateLimit, collectorsOption, next.Collectors, compressionOption, next.Compression)
+		}
+	}
+}
+
 func Start(ctx context.Context) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	if parseFlags().printConfig {
+		_, settings, err := loadConfig(zap.NewNop())
+		if err != nil {
+			return fmt.Errorf("failed to initialize config: %w", err)
+		}
+		if err := configfile.Print(os.Stdout, settings); err != nil {
+			return fmt.Errorf("failed to print configuration: %w", err)
+		}
+		return nil
+	}
+
 	c, err := NewConfig()
 	if err != nil {
 		return fmt.Errorf("failed to initialize config: %w", err)
 	}
 
+	shutdownTracing, err := tracing.Start(ctx, &c.Tracing, serviceName, "")
+	if err != nil {
+		return fmt.Errorf("failed to initialize tracing: %w", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			c.Logger.Sugar().Errorw("failed to flush trace spans", zap.Error(err))
+		}
+	}()
+
+	compression.SetGRPCLevel(c.CompressionLevel)
 	collector := NewCollector(c)
 
 	if c.EnableGRPC {
@@ -483,11 +946,13 @@ func Start(ctx context.Context) error {
 	}
 
 	if len(c.CryptoKey) != 0 {
-		if err := collector.keyExchange(c.MetricHost); err != nil {
+		if err := keyExchange(c); err != nil {
 			return fmt.Errorf("failed to send secret to metric server: %w", err)
 		}
 	}
 
+	go collector.watchReloads(ctx)
+
 	if err := collector.StartTickers(ctx); err != nil {
 		fmt.Println("Error in Start Tickers")
 		return fmt.Errorf("failed to run tickers: %w", err)
@@ -1,10 +1,31 @@
 package agent
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/vkupriya/go-metrics/internal/server/auth"
+	grpcserver "github.com/vkupriya/go-metrics/internal/server/grpc"
+	"github.com/vkupriya/go-metrics/internal/server/health"
+	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/ratelimit"
+	"github.com/vkupriya/go-metrics/internal/server/storage"
+	"github.com/vkupriya/go-metrics/internal/server/validation"
 )
 
 func TestAbs(t *testing.T) {
@@ -84,11 +105,137 @@ func TestSendMetrics(t *testing.T) {
 	}
 
 	t.Run("test01", func(t *testing.T) {
-		err := collector.metricPost(metrics, "localhost:8080")
+		err := collector.metricPost(context.Background(), metrics, "localhost:8080")
 		require.Error(t, err)
 	})
 }
 
+func TestSendRetries(t *testing.T) {
+	var mu sync.Mutex
+	var codes []int // status codes of the following responses, 200 once they are used up
+	var received []time.Time
+	var polls int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, time.Now())
+		if len(codes) > 0 {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(codes[0])
+			codes = codes[1:]
+			return
+		}
+		var metrics []Metric
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&metrics))
+		for _, m := range metrics {
+			if m.ID == "PollCount" {
+				polls += *m.Delta
+			}
+		}
+	}))
+	defer ts.Close()
+
+	collector := NewCollector(&Config{Logger: zap.NewNop(), MetricHost: ts.Listener.Addr().String()})
+	// send sends the collected metrics, the server answers with the status codes before accepting them.
+	// Sending ends once the server receives the number of requests.
+	send := func(responses []int, requests int) error {
+		mu.Lock()
+		codes, received = responses, nil
+		mu.Unlock()
+		ch := make(chan batch, 1)
+		collector.dispatcher(ch)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		done := make(chan error, 1)
+		go func() { done <- collector.sendMetrics(ctx, ch) }()
+		assert.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(received) == requests
+		}, 10*time.Second, 10*time.Millisecond)
+		cancel()
+		return <-done
+	}
+
+	collector.counter["PollCount"] = 5
+	require.Error(t, send([]int{http.StatusServiceUnavailable, http.StatusUnauthorized, http.StatusTooManyRequests}, 3))
+	mu.Lock()
+	assert.GreaterOrEqual(t, received[1].Sub(received[0]), 2*time.Second, "Retry-After is honored")
+	assert.Zero(t, polls)
+	mu.Unlock()
+	assert.Equal(t, int64(5), collector.counter["PollCount"], "counters of unsent batches are kept")
+
+	collector.counter["PollCount"]++
+	require.NoError(t, send([]int{http.StatusTooManyRequests}, 2))
+	mu.Lock()
+	assert.Equal(t, int64(6), polls)
+	mu.Unlock()
+	assert.Zero(t, collector.counter["PollCount"], "counters of accepted batches are reset")
+}
+
+func TestGRPCRetryDelay(t *testing.T) {
+	st, err := status.New(grpccodes.ResourceExhausted, "rate limited").WithDetails(
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(3 * time.Second)})
+	require.NoError(t, err)
+	assert.Equal(t, 3*time.Second, grpcRetryDelay(st.Err()))
+	assert.Zero(t, grpcRetryDelay(status.Error(grpccodes.Unavailable, "unavailable")))
+	assert.Equal(t, 5*time.Second, parseRetryAfter("5"))
+	assert.Zero(t, parseRetryAfter(""))
+}
+
+func TestMetricPostGRPC(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "grpc.sock")
+	scfg := &models.Config{Logger: zap.NewNop(), GRPCAddress: "unix:" + socket, ContextTimeout: 3}
+	s, err := storage.NewMemStorage(scfg)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		stores := func(context.Context, string) (grpcserver.Storage, error) { return s, nil }
+		done <- grpcserver.Run(ctx, stores, scfg, auth.New(scfg, nil), ratelimit.New(scfg), validation.New(scfg),
+			nil, health.New())
+	}()
+	defer func() {
+		cancel()
+		assert.NoError(t, <-done)
+	}()
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(socket)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	collector := NewCollector(&Config{Logger: zap.NewNop(), GRPCAddress: scfg.GRPCAddress})
+	require.NoError(t, NewGRPCClient(collector))
+	defer func() {
+		assert.NoError(t, collector.connGRPC.Close())
+	}()
+
+	var d int64 = 3
+	var f = 27873.01
+	metrics := []Metric{
+		{ID: "PollCount", MType: "counter", Delta: &d},
+		{ID: "Alloc", MType: "gauge", Value: &f},
+	}
+	for range 2 {
+		require.NoError(t, collector.metricPostGRPC(context.Background(), metrics))
+	}
+
+	v, _, err := s.GetCounterMetric(scfg, "PollCount")
+	require.NoError(t, err, "counters are sent as counters")
+	assert.Equal(t, int64(6), v)
+	g, _, err := s.GetGaugeMetric(scfg, "Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, f, g)
+	mds, err := s.GetMetadata(scfg)
+	require.NoError(t, err)
+	types := make(map[string]string, len(mds))
+	for _, md := range mds {
+		types[md.Name] = md.MType
+	}
+	assert.Equal(t, map[string]string{"PollCount": "counter", "Alloc": "gauge"}, types)
+}
+
 func TestConfig(t *testing.T) {
 	t.Setenv("ADDRESS", "localhost:8443")
 	t.Setenv("RATE_LIMIT", "5")
@@ -96,6 +243,7 @@ func TestConfig(t *testing.T) {
 	t.Setenv("POLL_INTERVAL", "10")
 	t.Setenv("REPORT_INTERVAL", "20")
 	t.Setenv("CONFIG", "")
+	t.Setenv("GRPC_ADDRESS", "unix:/run/go-metrics/grpc.sock")
 
 	t.Run("test01", func(t *testing.T) {
 		c, err := NewConfig()
@@ -104,6 +252,7 @@ func TestConfig(t *testing.T) {
 		}
 
 		assert.Equal(t, c.MetricHost, "localhost:8443")
+		assert.Equal(t, "unix:/run/go-metrics/grpc.sock", c.GRPCAddress)
 		assert.Equal(t, c.rateLimit, 5)
 		assert.Equal(t, c.HashKey, "ksjdflksjdf")
 		assert.Equal(t, c.PollInterval, int64(10))
@@ -111,6 +260,40 @@ func TestConfig(t *testing.T) {
 	})
 }
 
+func TestReload(t *testing.T) {
+	logger := zap.NewNop()
+	collector := NewCollector(&Config{Logger: logger, PollInterval: 1, ReportInterval: 10, rateLimit: 1})
+	collector.collect()
+	reloads := collector.reloads()
+
+	next := &Config{Logger: logger, PollInterval: 5, ReportInterval: 20, rateLimit: 2,
+		Collectors: []string{CollectorRuntime}}
+	require.NoError(t, collector.Reload(next))
+	assert.Same(t, next, collector.cfg())
+	select {
+	case <-reloads:
+	default:
+		t.Error("reload is not signalled")
+	}
+
+	ch := make(chan batch, 2)
+	collector.dispatcher(ch)
+	ids := func(b batch) []string {
+		names := make([]string, 0, len(b.metrics))
+		for _, m := range b.metrics {
+			names = append(names, m.ID)
+		}
+		return names
+	}
+	assert.Contains(t, ids(<-ch), "TotalMemory", "collected metrics of disabled collectors are sent")
+	collector.collect()
+	collector.dispatcher(ch)
+	sent := ids(<-ch)
+	assert.NotContains(t, sent, "TotalMemory", "disabled collectors are not sent again")
+	assert.Contains(t, sent, "Alloc")
+	assert.Contains(t, sent, "PollCount")
+}
+
 func TestGenerateRandom(t *testing.T) {
 	res, err := generateRandom(32)
 	if err != nil {
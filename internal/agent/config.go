@@ -1,43 +1,84 @@
 package agent
 
 import (
-	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"net"
-	"strings"
-
 	"os"
-	"strconv"
+	"slices"
+	"strings"
+	"sync"
 
 	"go.uber.org/zap"
+
+	"github.com/vkupriya/go-metrics/internal/address"
+	"github.com/vkupriya/go-metrics/internal/compression"
+	"github.com/vkupriya/go-metrics/internal/configfile"
+	"github.com/vkupriya/go-metrics/internal/logging"
+	"github.com/vkupriya/go-metrics/internal/tracing"
 )
 
 type Config struct {
-	Logger         *zap.Logger
-	OutboundIP     net.IP
-	MetricHost     string `json:"address,omitempty"`
-	HashKey        string
-	CryptoKey      []byte `json:"crypto_key,omitempty"`
-	SecretKey      []byte
-	ReportInterval int64 `json:"report_interval,omitempty"`
-	PollInterval   int64 `json:"poll_interval,omitempty"`
-	httpTimeout    int64
-	rateLimit      int
-	EnableGRPC     bool
+	Logger           *zap.Logger
+	OutboundIP       net.IP
+	MetricHost       string `json:"address,omitempty"`
+	GRPCAddress      string
+	HashKey          string
+	Tenant           string
+	TenantToken      string
+	AuthToken        string
+	Compression      string // codec compressing posted metrics, empty if they aren't compressed
+	CryptoKey        []byte `json:"crypto_key,omitempty"`
+	SecretKey        []byte
+	Collectors       []string        // names of enabled collectors, all collectors are enabled if empty
+	levels           *logging.Levels // levels of Logger, they are switched by reload
+	Tracing          tracing.Config  // tracing is set up at start, it isn't reloadable
+	Log              logging.Config  // levels of logs are reloadable, other logging settings are not
+	ReportInterval   int64           `json:"report_interval,omitempty"`
+	PollInterval     int64           `json:"poll_interval,omitempty"`
+	httpTimeout      int64
+	CompressionLevel compression.Level
+	rateLimit        int
+	EnableGRPC       bool
+}
+
+// Collectors of metrics the agent can be configured with.
+const (
+	CollectorRuntime = "runtime" // Go runtime memory statistics, RandomValue and PollCount
+	CollectorPsutil  = "psutil"  // memory and CPU utilization of the host
+)
+
+// collects reports whether the collector is enabled.
+func (c *Config) collects(name string) bool {
+	return len(c.Collectors) == 0 || slices.Contains(c.Collectors, name)
 }
 
+// ConfigFile is the config file of the agent, it can set every option of flags and env vars.
 type ConfigFile struct {
-	MetricHost     string `json:"address,omitempty"`
-	CryptoKeyFile  string `json:"crypto_key,omitempty"`
-	ReportInterval int64  `json:"report_interval,omitempty"`
-	PollInterval   int64  `json:"poll_interval,omitempty"`
+	MetricHost       string   `json:"address,omitempty"`
+	GRPCAddress      string   `json:"grpc_address,omitempty"`
+	CryptoKeyFile    string   `json:"crypto_key,omitempty"`
+	Tenant           string   `json:"tenant,omitempty"`
+	TenantToken      string   `json:"tenant_token,omitempty"`
+	AuthToken        string   `json:"auth_token,omitempty"`
+	HashKey          string   `json:"hash_key,omitempty"`
+	Collectors       []string `json:"collectors,omitempty"`
+	Compression      string   `json:"compression,omitempty"`
+	CompressionLevel string   `json:"compression_level,omitempty"`
+	tracing.FileConfig
+	logging.FileOptions
+	ReportInterval configfile.Duration `json:"report_interval,omitempty"`
+	PollInterval   configfile.Duration `json:"poll_interval,omitempty"`
+	RateLimit      int64               `json:"rate_limit,omitempty"`
+	EnableGRPC     bool                `json:"grpc,omitempty"`
 }
 
 func findOutboundIP(l *zap.Logger, h string) (net.IP, error) {
-	// removing 'http://' if present
-	hostport := strings.Replace(h, "http://", "", 1)
+	network, hostport := address.Parse(h)
+	if network == address.Unix {
+		return net.ParseIP("127.0.0.1"), nil
+	}
 	host := strings.Split(hostport, ":")[0]
 	if host == "" || host == "localhost" {
 		host = "127.0.0.1"
@@ -63,133 +104,227 @@ func findOutboundIP(l *zap.Logger, h string) (net.IP, error) {
 	return localAddr.IP, nil
 }
 
+const (
+	pollIntDefault     int64 = 1
+	reportIntDefault   int64 = 10
+	httpTimeoutDefault int64 = 30
+	rateLimitDefault   int64 = 3
+	collectorsDefault        = CollectorRuntime + "," + CollectorPsutil
+	logLevelDefault          = "debug"
+	compressionDefault       = compression.Gzip
+	compressionNone          = "none"
+)
+
+// collectorsOption names the option of enabled collectors in config files, flags and logs.
+const collectorsOption = "collectors"
+
+// compressionOption names the option of the codec compressing posted metrics in config files, flags and logs.
+const compressionOption = "compression"
+
+// Options of the agent: names in config files, flags and env vars.
+var (
+	optConfig           = configfile.Option{Flag: "c", Env: "CONFIG"}
+	optAddress          = configfile.Option{Key: "address", Flag: "a", Env: "ADDRESS"}
+	optGRPCAddress      = configfile.Option{Key: "grpc_address", Flag: "grpc-address", Env: "GRPC_ADDRESS"}
+	optRateLimit        = configfile.Option{Key: "rate_limit", Flag: "l", Env: "RATE_LIMIT"}
+	optPollInterval     = configfile.Option{Key: "poll_interval", Flag: "p", Env: "POLL_INTERVAL"}
+	optReportInterval   = configfile.Option{Key: "report_interval", Flag: "r", Env: "REPORT_INTERVAL"}
+	optHashKey          = configfile.Option{Key: "hash_key", Flag: "k", Env: "KEY", Secret: true}
+	optCryptoKey        = configfile.Option{Key: "crypto_key", Flag: "crypto", Env: "CRYPTO_KEY"}
+	optGRPC             = configfile.Option{Key: "grpc", Flag: "g", Env: "GRPC"}
+	optTenant           = configfile.Option{Key: "tenant", Flag: "tenant", Env: "TENANT"}
+	optTenantToken      = configfile.Option{Key: "tenant_token", Flag: "tenant-token", Env: "TENANT_TOKEN", Secret: true}
+	optAuthToken        = configfile.Option{Key: "auth_token", Flag: "auth-token", Env: "AUTH_TOKEN", Secret: true}
+	optCollectors       = configfile.Option{Key: collectorsOption, Flag: collectorsOption, Env: "COLLECTORS"}
+	optCompression      = configfile.Option{Key: compressionOption, Flag: compressionOption, Env: "COMPRESSION"}
+	optCompressionLevel = configfile.Option{Key: "compression_level", Flag: "compression-level", Env: "COMPRESSION_LEVEL"}
+)
+
+// flagValues are values of command line flags. Flags are parsed once and configuration is built
+// from a copy of their values, so config file and env vars override them again on every reload.
+type flagValues struct {
+	metricHost, grpcAddress, configFile string
+	hashKey, cryptoKey                  string
+	tenant, tenantToken, authToken      string
+	collectors                          string
+	compression, compressionLevel       string
+	tracing                             tracing.Flags
+	log                                 logging.Flags
+	reportInterval, pollInterval        configfile.Duration
+	rateLimit                           int64
+	enableGRPC, printConfig             bool
+}
+
+// parseFlags defines and parses command line flags on the first call.
+var parseFlags = sync.OnceValue(func() flagValues {
+	f := flagValues{
+		reportInterval: configfile.Seconds(reportIntDefault),
+		pollInterval:   configfile.Seconds(pollIntDefault),
+	}
+	flag.StringVar(&f.metricHost, "a", "localhost:8080",
+		"Address and port of the metric server or unix:path of Unix socket.")
+	flag.StringVar(&f.grpcAddress, "grpc-address", "",
+		"gRPC server address, port 3200 on the metric server host if empty.")
+	flag.Var(&f.reportInterval, "r", "Metrics report interval.")
+	flag.Var(&f.pollInterval, "p", "Metric collection interval.")
+	flag.Int64Var(&f.rateLimit, "l", rateLimitDefault, "Rate Limit for concurrent server requests.")
+	flag.StringVar(&f.hashKey, "k", "", "Hash key")
+	flag.StringVar(&f.cryptoKey, "crypto", "", "Path to public key for asymmetric encryption.")
+	flag.StringVar(&f.configFile, "c", "", "Path to JSON, YAML or TOML config file, format is chosen by extension.")
+	flag.BoolVar(&f.printConfig, "print-config", false,
+		"Print effective configuration and sources of its values and exit.")
+	flag.BoolVar(&f.enableGRPC, "g", false, "Post metrics via GRPC.")
+	flag.StringVar(&f.tenant, "tenant", "", "Tenant to post metrics to, ignored by server if tenant token is set.")
+	flag.StringVar(&f.tenantToken, "tenant-token", "", "API token of the tenant to post metrics to.")
+	flag.StringVar(&f.authToken, "auth-token", "", "Bearer token with write role, required if server uses API tokens.")
+	flag.StringVar(&f.collectors, collectorsOption, collectorsDefault, "Comma separated collectors: runtime, psutil.")
+	flag.StringVar(&f.compression, compressionOption, compressionDefault,
+		"Codec compressing posted metrics: "+strings.Join(compression.Names(), ", ")+" or none.")
+	flag.StringVar(&f.compressionLevel, "compression-level", compression.LevelDefault.String(),
+		"Compression level: fastest, default, better or best.")
+	logging.AddFlags(&f.log, logLevelDefault)
+	tracing.AddFlags(&f.tracing)
+	flag.Parse()
+	return f
+})
+
+// NewConfig loads configuration and initializes logger of the agent configured by it.
 func NewConfig() (*Config, error) {
-	const (
-		pollIntDefault   int64 = 1
-		reportIntDefault int64 = 10
-		httpTimeout      int64 = 30
-		rateLimitDefault int   = 3
-	)
+	c, _, err := loadConfig(zap.NewNop())
+	if err != nil {
+		return nil, err
+	}
+	if c.Logger, c.levels, err = logging.New(&c.Log); err != nil {
+		return nil, fmt.Errorf("failed to initialize Logger: %w", err)
+	}
+	return c, nil
+}
+
+// loadConfig loads configuration from flags, config file and env vars, in order of precedence.
+// Settings of every option with the source of its value are returned too.
+func loadConfig(logger *zap.Logger) (*Config, []configfile.Setting, error) {
 	var certPEM []byte
 	var secretKey []byte
 	var err error
 	cfg := ConfigFile{}
 
-	logConfig := zap.NewDevelopmentConfig()
-	logger, err := logConfig.Build()
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize Logger: %w", err)
-	}
-
-	metricHost := flag.String("a", "localhost:8080", "Address and port of the metric server.")
-	reportInterval := flag.Int64("r", reportIntDefault, "Metrics report interval in seconds.")
-	pollInterval := flag.Int64("p", pollIntDefault, "Metric collection interval in seconds")
-	rateLimit := flag.Int("l", rateLimitDefault, "Rate Limit for concurrent server requests.")
-	hashKey := flag.String("k", "", "Hash key")
-	cryptoKey := flag.String("crypto", "", "Path to public key for asymmetric encryption.")
-	configFile := flag.String("c", "", "Path to json config file.")
-	enableGRPC := flag.Bool("g", false, "Post metrics via GRPC.")
-	flag.Parse()
+	f := parseFlags()
+	l := configfile.NewLoader(flag.CommandLine)
+	collectors := splitCollectors(f.collectors)
 
-	if envConfig, ok := os.LookupEnv("CONFIG"); ok {
-		configFile = &envConfig
+	if err := configfile.Resolve(l, optConfig, &f.configFile, "", configfile.String); err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve config file: %w", err)
 	}
-
-	if *configFile != "" {
-		fcontent, err := os.ReadFile(*configFile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to open config file %s: %w", *configFile, err)
-		}
-
-		err = json.Unmarshal(fcontent, &cfg)
-		if err != nil {
-			return nil, fmt.Errorf("failed to unmarshal config file: %w", err)
+	if f.configFile != "" {
+		if err := l.Load(f.configFile, &cfg); err != nil {
+			return nil, nil, fmt.Errorf("failed to load config file: %w", err)
 		}
 	}
 
-	if cfg.MetricHost != "" {
-		metricHost = &cfg.MetricHost
+	err = errors.Join(
+		configfile.Resolve(l, optAddress, &f.metricHost, cfg.MetricHost, configfile.String),
+		configfile.Resolve(l, optGRPCAddress, &f.grpcAddress, cfg.GRPCAddress, configfile.String),
+		configfile.Resolve(l, optRateLimit, &f.rateLimit, cfg.RateLimit, configfile.Int),
+		configfile.Resolve(l, optPollInterval, &f.pollInterval, cfg.PollInterval, configfile.ParseDuration),
+		configfile.Resolve(l, optReportInterval, &f.reportInterval, cfg.ReportInterval, configfile.ParseDuration),
+		configfile.Resolve(l, optHashKey, &f.hashKey, cfg.HashKey, configfile.String),
+		configfile.Resolve(l, optCryptoKey, &f.cryptoKey, cfg.CryptoKeyFile, configfile.String),
+		configfile.Resolve(l, optGRPC, &f.enableGRPC, cfg.EnableGRPC, configfile.Bool),
+		configfile.Resolve(l, optTenant, &f.tenant, cfg.Tenant, configfile.String),
+		configfile.Resolve(l, optTenantToken, &f.tenantToken, cfg.TenantToken, configfile.String),
+		configfile.Resolve(l, optAuthToken, &f.authToken, cfg.AuthToken, configfile.String),
+		configfile.Resolve(l, optCollectors, &collectors, cfg.Collectors, func(s string) ([]string, error) {
+			return splitCollectors(s), nil
+		}),
+		configfile.Resolve(l, optCompression, &f.compression, cfg.Compression, configfile.String),
+		configfile.Resolve(l, optCompressionLevel, &f.compressionLevel, cfg.CompressionLevel, configfile.String),
+	)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if envAddr, ok := os.LookupEnv("ADDRESS"); ok {
-		metricHost = &envAddr
+	logConfig, err := logging.Resolve(l, &f.log, &cfg.FileOptions)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to configure logging: %w", err)
 	}
-
-	outboundIP, err := findOutboundIP(logger, *metricHost)
+	tracingConfig, err := tracing.Resolve(l, &f.tracing, &cfg.FileConfig)
 	if err != nil {
-		return nil, fmt.Errorf("failed in findOutboundIP function: %w", err)
+		return nil, nil, fmt.Errorf("failed to configure tracing: %w", err)
 	}
 
-	if envRateLimit, ok := os.LookupEnv("RATE_LIMIT"); ok {
-		envRateLimit, err := strconv.Atoi(envRateLimit)
-		if err != nil {
-			return nil, errors.New("failed to convert RATE_LIMIT to integer")
-		}
-		rateLimit = &envRateLimit
+	if f.grpcAddress == "" {
+		f.grpcAddress = address.DefaultGRPC(f.metricHost)
+		l.Update(optGRPCAddress, f.grpcAddress)
 	}
 
-	if cfg.PollInterval != 0 {
-		pollInterval = &cfg.PollInterval
+	outboundIP, err := findOutboundIP(logger, f.metricHost)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed in findOutboundIP function: %w", err)
 	}
 
-	if envPoll, ok := os.LookupEnv("POLL_INTERVAL"); ok {
-		envPollInt, err := strconv.ParseInt(envPoll, 10, 64)
+	if f.cryptoKey != "" {
+		certPEM, err = os.ReadFile(f.cryptoKey)
 		if err != nil {
-			return nil, errors.New("failed to convert POLL_INTERVAL to integer")
+			return nil, nil, fmt.Errorf("failed to read public key file %s: %w", f.cryptoKey, err)
 		}
-		pollInterval = &envPollInt
-	}
-
-	if cfg.ReportInterval != 0 {
-		reportInterval = &cfg.ReportInterval
 	}
 
-	if envReport, ok := os.LookupEnv("REPORT_INTERVAL"); ok {
-		envReportInt, err := strconv.ParseInt(envReport, 10, 64)
-		if err != nil {
-			return nil, errors.New("failed to convert REPORT_INTERVAL to integer")
+	for _, name := range collectors {
+		if name != CollectorRuntime && name != CollectorPsutil {
+			return nil, nil, fmt.Errorf("unknown collector '%s', expected runtime or psutil", name)
 		}
-		reportInterval = &envReportInt
 	}
 
-	if envKey, ok := os.LookupEnv("KEY"); ok {
-		hashKey = &envKey
+	if f.pollInterval <= 0 || f.reportInterval <= 0 {
+		return nil, nil, errors.New("poll and report intervals must be positive")
 	}
 
-	if cfg.CryptoKeyFile != "" {
-		cryptoKey = &cfg.CryptoKeyFile
+	if f.rateLimit < 1 {
+		return nil, nil, errors.New("rate limit must be positive")
 	}
 
-	if envCryptoKey, ok := os.LookupEnv("CRYPTO_KEY"); ok {
-		cryptoKey = &envCryptoKey
+	codec := strings.ToLower(strings.TrimSpace(f.compression))
+	if codec == compressionNone || codec == compression.Identity {
+		codec = ""
 	}
-
-	if *cryptoKey != "" {
-		certPEM, err = os.ReadFile(*cryptoKey)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read public key file %s: %w", *cryptoKey, err)
-		}
+	if _, ok := compression.Lookup(codec); codec != "" && !ok {
+		return nil, nil, fmt.Errorf("unknown compression '%s', expected %s or none", f.compression,
+			strings.Join(compression.Names(), ", "))
 	}
-
-	if envGRPC, ok := os.LookupEnv("GRPC"); ok {
-		envGRPC, err := strconv.ParseBool(envGRPC)
-		if err != nil {
-			return nil, errors.New("failed to parse GRPC env setting, expected true or false")
-		}
-		enableGRPC = &envGRPC
+	level, err := compression.ParseLevel(f.compressionLevel)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse compression level: %w", err)
 	}
 
 	return &Config{
-		MetricHost:     *metricHost,
-		ReportInterval: *reportInterval,
-		PollInterval:   *pollInterval,
-		httpTimeout:    httpTimeout,
-		rateLimit:      *rateLimit,
-		Logger:         logger,
-		HashKey:        *hashKey,
-		CryptoKey:      certPEM,
-		SecretKey:      secretKey,
-		OutboundIP:     outboundIP,
-		EnableGRPC:     *enableGRPC,
-	}, nil
+		Collectors:       collectors,
+		MetricHost:       f.metricHost,
+		GRPCAddress:      f.grpcAddress,
+		ReportInterval:   f.reportInterval.Seconds(),
+		PollInterval:     f.pollInterval.Seconds(),
+		httpTimeout:      httpTimeoutDefault,
+		rateLimit:        int(f.rateLimit),
+		Logger:           logger,
+		HashKey:          f.hashKey,
+		CryptoKey:        certPEM,
+		SecretKey:        secretKey,
+		OutboundIP:       outboundIP,
+		EnableGRPC:       f.enableGRPC,
+		Tenant:           f.tenant,
+		Compression:      codec,
+		CompressionLevel: level,
+		TenantToken:      f.tenantToken,
+		AuthToken:        f.authToken,
+		Log:              *logConfig,
+		Tracing:          *tracingConfig,
+	}, l.Settings(), nil
+}
+
+// splitCollectors splits comma separated names of collectors.
+func splitCollectors(s string) []string {
+	collectors := strings.Split(s, ",")
+	for i, name := range collectors {
+		collectors[i] = strings.TrimSpace(name)
+	}
+	return collectors
 }
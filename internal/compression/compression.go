@@ -0,0 +1,287 @@
+// Package compression provides codecs compressing bodies of requests and responses of the metric server
+// and agent: gzip, zstd, snappy and brotli.
+//
+// Codecs are registered by names of their HTTP content codings, the same names select gRPC compressors.
+// Snappy uses the framed stream format. Compression levels are named and mapped to levels of each codec,
+// snappy has a single level.
+//
+// Negotiate chooses the codec of a response from Accept-Encoding header of the request honouring q-values,
+// codecs of equal q-value are preferred in order of Names.
+package compression
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc/encoding"
+)
+
+// Names of codecs and content codings.
+const (
+	Zstd     = "zstd"
+	Brotli   = "br"
+	Gzip     = "gzip"
+	Snappy   = "snappy"
+	Identity = "identity" // no compression
+)
+
+// Level is a compression level, the zero Level is the default level of codecs.
+type Level int
+
+// Compression levels, from the fastest one to the smallest output.
+const (
+	LevelDefault Level = iota
+	LevelFastest
+	LevelBetter
+	LevelBest
+)
+
+var levelNames = map[Level]string{
+	LevelDefault: "default",
+	LevelFastest: "fastest",
+	LevelBetter:  "better",
+	LevelBest:    "best",
+}
+
+// ParseLevel parses name of a compression level: fastest, default, better or best.
+func ParseLevel(s string) (Level, error) {
+	for l, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return l, nil
+		}
+	}
+	return LevelDefault, fmt.Errorf("unknown compression level '%s', expected fastest, default, better or best", s)
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// Codec compresses and decompresses bodies in a content coding.
+type Codec interface {
+	// Name returns name of the content coding.
+	Name() string
+	// NewWriter returns writer compressing into w, the compressed stream is complete after Close.
+	NewWriter(w io.Writer, level Level) (io.WriteCloser, error)
+	// NewReader returns reader decompressing r.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+var (
+	mu     sync.RWMutex
+	codecs = make(map[string]Codec)
+	names  []string // in order of preference
+)
+
+// Built-in codecs, the gzip codec replaces gRPC compressor of google.golang.org/grpc/encoding/gzip.
+func init() {
+	Register(zstdCodec{})
+	Register(brotliCodec{})
+	Register(gzipCodec{})
+	Register(snappyCodec{})
+}
+
+// Register adds the codec to the registry and registers it as a gRPC compressor, it replaces a registered
+// codec of the same name. Codecs registered earlier are preferred by Negotiate. Like gRPC compressors,
+// codecs must be registered at initialization.
+func Register(c Codec) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := codecs[c.Name()]; !ok {
+		names = append(names, c.Name())
+	}
+	codecs[c.Name()] = c
+	encoding.RegisterCompressor(grpcCompressor{codec: c})
+}
+
+// Lookup returns the codec of the content coding, case of the name is ignored.
+func Lookup(name string) (Codec, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	c, ok := codecs[strings.ToLower(strings.TrimSpace(name))]
+	return c, ok
+}
+
+// Names returns names of registered codecs in order of preference.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return slices.Clone(names)
+}
+
+// Negotiate returns name of the codec of a response to a request with the Accept-Encoding header.
+// The codec with the highest q-value is chosen, '*' matches codecs which aren't listed. Empty string is
+// returned if the response should not be compressed.
+func Negotiate(acceptEncoding string) string {
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		accepted[coding] = q
+	}
+
+	var best string
+	var bestQ float64
+	for _, name := range Names() {
+		q, ok := accepted[name]
+		if !ok {
+			q = accepted["*"]
+		}
+		if q > bestQ {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// Levels of codecs between their default and best levels, libraries don't name them.
+const (
+	gzipLevelBetter   = 7
+	brotliLevelBetter = 9
+)
+
+// gzipCodec is gzip of the standard library.
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string {
+	return Gzip
+}
+
+func (gzipCodec) NewWriter(w io.Writer, level Level) (io.WriteCloser, error) {
+	levels := map[Level]int{
+		LevelDefault: gzip.DefaultCompression,
+		LevelFastest: gzip.BestSpeed,
+		LevelBetter:  gzipLevelBetter,
+		LevelBest:    gzip.BestCompression,
+	}
+	gw, err := gzip.NewWriterLevel(w, levels[level])
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gzip writer: %w", err)
+	}
+	return gw, nil
+}
+
+func (gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gzip header: %w", err)
+	}
+	return gr, nil
+}
+
+// zstdCodec is Zstandard, encoders are expensive to create, so they are reused.
+type zstdCodec struct{}
+
+// maxDecoderMemory limits memory of zstd decoders, so a small body can't make the server allocate gigabytes.
+const maxDecoderMemory = 64 << 20
+
+var zstdEncoders [LevelBest + 1]sync.Pool
+
+func (zstdCodec) Name() string {
+	return Zstd
+}
+
+func (zstdCodec) NewWriter(w io.Writer, level Level) (io.WriteCloser, error) {
+	if level < LevelDefault || level > LevelBest {
+		level = LevelDefault
+	}
+	pool := &zstdEncoders[level]
+	if e, ok := pool.Get().(*zstd.Encoder); ok {
+		e.Reset(w)
+		return &zstdWriter{Encoder: e, pool: pool}, nil
+	}
+	levels := map[Level]zstd.EncoderLevel{
+		LevelDefault: zstd.SpeedDefault,
+		LevelFastest: zstd.SpeedFastest,
+		LevelBetter:  zstd.SpeedBetterCompression,
+		LevelBest:    zstd.SpeedBestCompression,
+	}
+	e, err := zstd.NewWriter(w, zstd.WithEncoderLevel(levels[level]), zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd writer: %w", err)
+	}
+	return &zstdWriter{Encoder: e, pool: pool}, nil
+}
+
+func (zstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxDecoderMemory))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd reader: %w", err)
+	}
+	return d.IOReadCloser(), nil
+}
+
+// zstdWriter returns the encoder to the pool on Close.
+type zstdWriter struct {
+	*zstd.Encoder
+	pool *sync.Pool
+}
+
+func (w *zstdWriter) Close() error {
+	if w.Encoder == nil {
+		return errors.New("zstd writer is closed")
+	}
+	err := w.Encoder.Close()
+	w.Reset(nil)
+	w.pool.Put(w.Encoder)
+	w.Encoder = nil
+	if err != nil {
+		return fmt.Errorf("failed to close zstd writer: %w", err)
+	}
+	return nil
+}
+
+// brotliCodec is Brotli.
+type brotliCodec struct{}
+
+func (brotliCodec) Name() string {
+	return Brotli
+}
+
+func (brotliCodec) NewWriter(w io.Writer, level Level) (io.WriteCloser, error) {
+	levels := map[Level]int{
+		LevelDefault: brotli.DefaultCompression,
+		LevelFastest: brotli.BestSpeed,
+		LevelBetter:  brotliLevelBetter,
+		LevelBest:    brotli.BestCompression,
+	}
+	return brotli.NewWriterLevel(w, levels[level]), nil
+}
+
+func (brotliCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(brotli.NewReader(r)), nil
+}
+
+// snappyCodec is Snappy in the framed stream format.
+type snappyCodec struct{}
+
+func (snappyCodec) Name() string {
+	return Snappy
+}
+
+func (snappyCodec) NewWriter(w io.Writer, _ Level) (io.WriteCloser, error) {
+	return snappy.NewBufferedWriter(w), nil
+}
+
+func (snappyCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(snappy.NewReader(r)), nil
+}
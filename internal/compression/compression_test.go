@@ -0,0 +1,122 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/encoding"
+)
+
+func TestCodecs(t *testing.T) {
+	body := []byte(strings.Repeat(`{"id":"Alloc","type":"gauge","value":1234.5},`, 100))
+	assert.Equal(t, []string{Zstd, Brotli, Gzip, Snappy}, Names())
+
+	for _, name := range Names() {
+		for _, level := range []Level{LevelFastest, LevelDefault, LevelBetter, LevelBest} {
+			t.Run(name+"_"+level.String(), func(t *testing.T) {
+				codec, ok := Lookup(name)
+				require.True(t, ok)
+				assert.Equal(t, name, codec.Name())
+
+				// Writers are created twice, so pooled zstd encoders are reused.
+				for range 2 {
+					var buf bytes.Buffer
+					w, err := codec.NewWriter(&buf, level)
+					require.NoError(t, err)
+					_, err = w.Write(body)
+					require.NoError(t, err)
+					require.NoError(t, w.Close())
+					assert.Less(t, buf.Len(), len(body))
+
+					r, err := codec.NewReader(&buf)
+					require.NoError(t, err)
+					got, err := io.ReadAll(r)
+					require.NoError(t, err)
+					require.NoError(t, r.Close())
+					assert.Equal(t, body, got)
+				}
+			})
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	codec, ok := Lookup(" ZSTD ")
+	require.True(t, ok)
+	assert.Equal(t, Zstd, codec.Name())
+
+	_, ok = Lookup("deflate")
+	assert.False(t, ok)
+	_, ok = Lookup("")
+	assert.False(t, ok)
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, name := range []string{"fastest", "default", "better", "best"} {
+		level, err := ParseLevel(name)
+		require.NoError(t, err)
+		assert.Equal(t, name, level.String())
+	}
+	level, err := ParseLevel("Best")
+	require.NoError(t, err)
+	assert.Equal(t, LevelBest, level)
+
+	_, err = ParseLevel("9")
+	assert.Error(t, err)
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		want           string
+	}{
+		{name: "empty", acceptEncoding: "", want: ""},
+		{name: "gzip", acceptEncoding: "gzip", want: Gzip},
+		{name: "preferred_of_equal", acceptEncoding: "gzip, deflate, br, zstd", want: Zstd},
+		{name: "q_values", acceptEncoding: "zstd;q=0.5, gzip;q=0.8, br;q=0.1", want: Gzip},
+		{name: "case_and_spaces", acceptEncoding: " GZIP ; q=0.9 , Snappy;q=1", want: Snappy},
+		{name: "rejected", acceptEncoding: "gzip;q=0", want: ""},
+		{name: "wildcard", acceptEncoding: "*", want: Zstd},
+		{name: "wildcard_excludes_listed", acceptEncoding: "zstd;q=0, br;q=0, *;q=0.5", want: Gzip},
+		{name: "identity_only", acceptEncoding: "identity", want: ""},
+		{name: "unknown", acceptEncoding: "deflate, compress", want: ""},
+		{name: "invalid_q", acceptEncoding: "zstd;q=high, gzip", want: Gzip},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Negotiate(tt.acceptEncoding))
+		})
+	}
+}
+
+func TestGRPCCompressors(t *testing.T) {
+	msg := []byte(strings.Repeat("metric batch ", 100))
+	SetGRPCLevel(LevelBest)
+	defer SetGRPCLevel(LevelDefault)
+
+	for _, name := range Names() {
+		t.Run(name, func(t *testing.T) {
+			c := encoding.GetCompressor(name)
+			require.NotNil(t, c)
+			assert.Equal(t, name, c.Name())
+
+			var buf bytes.Buffer
+			w, err := c.Compress(&buf)
+			require.NoError(t, err)
+			_, err = w.Write(msg)
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+
+			r, err := c.Decompress(&buf)
+			require.NoError(t, err)
+			got, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, msg, got)
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package compression
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// grpcLevel is the compression level of gRPC messages, see SetGRPCLevel.
+var grpcLevel atomic.Int64
+
+// SetGRPCLevel sets compression level of gRPC messages sent by the process.
+func SetGRPCLevel(l Level) {
+	grpcLevel.Store(int64(l))
+}
+
+// grpcCompressor adapts a codec to gRPC encoding.Compressor.
+type grpcCompressor struct {
+	codec Codec
+}
+
+func (c grpcCompressor) Name() string {
+	return c.codec.Name()
+}
+
+func (c grpcCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	cw, err := c.codec.NewWriter(w, Level(grpcLevel.Load()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress gRPC message: %w", err)
+	}
+	return cw, nil
+}
+
+func (c grpcCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	cr, err := c.codec.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress gRPC message: %w", err)
+	}
+	return &closingReader{ReadCloser: cr}, nil
+}
+
+// closingReader closes the reader at the end of the message, gRPC doesn't close decompressing readers.
+type closingReader struct {
+	io.ReadCloser
+}
+
+func (r *closingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err == io.EOF {
+		_ = r.Close()
+	}
+	return n, err //nolint:wrapcheck // io.EOF must not be wrapped
+}
@@ -0,0 +1,203 @@
+// Package configfile loads configuration of the metric server and agent from command line flags,
+// config files and env vars.
+//
+// Config files are JSON, YAML or TOML, the format is chosen by the file extension. Options of a file are
+// validated strictly: unknown options and values of wrong types are rejected with the name of the option.
+// Durations are Go duration strings like "10s" or integer numbers of seconds.
+//
+// Values of options are taken from defaults, flags, config file and env vars, each one overriding the
+// previous. Loader records where each value came from, so the effective configuration can be printed.
+package configfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// maxSuggestionDistance limits edit distance of suggested option names.
+const maxSuggestionDistance = 3
+
+var ErrInvalid = errors.New("invalid config file")
+
+// Keys are names of options set in a config file.
+type Keys map[string]bool
+
+// Decode reads the config file into dst, a pointer to struct with json tags naming the options.
+// Options set in the file are returned, so options set to zero values can be told from missing ones.
+func Decode(path string, dst any) (Keys, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config file %s: %w", path, err)
+	}
+
+	raw := make(map[string]any)
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &raw)
+	case ".toml":
+		err = toml.Unmarshal(b, &raw)
+	case ".json", "":
+		err = withLine(b, json.Unmarshal(b, &raw))
+	default:
+		return nil, fmt.Errorf("%w %s: unknown format '%s', expected .json, .yaml, .yml or .toml", ErrInvalid, path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: %w", ErrInvalid, path, err)
+	}
+
+	fields := options(reflect.TypeOf(dst).Elem())
+	v := reflect.ValueOf(dst).Elem()
+	keys := make(Keys, len(raw))
+	for key, value := range raw {
+		index, ok := fields[key]
+		if !ok {
+			return nil, fmt.Errorf("%w %s: %w", ErrInvalid, path, unknownOption(key, fields))
+		}
+		// Values of all formats are decoded as JSON, so the same tags and value types serve all of them.
+		field := v.FieldByIndex(index)
+		b, err := json.Marshal(value)
+		if err == nil {
+			err = json.Unmarshal(b, field.Addr().Interface())
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w %s: %w", ErrInvalid, path, optionError(key, field.Type(), value, err))
+		}
+		keys[key] = true
+	}
+	return keys, nil
+}
+
+// options returns indexes of fields of the struct type by names of options in their json tags.
+// Options of embedded structs are options of the struct, like in JSON.
+func options(t reflect.Type) map[string][]int {
+	fields := make(map[string][]int, t.NumField())
+	for _, f := range reflect.VisibleFields(t) {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name != "" && name != "-" && !f.Anonymous {
+			fields[name] = f.Index
+		}
+	}
+	return fields
+}
+
+// unknownOption returns error of the unknown option suggesting the closest known one.
+func unknownOption(key string, fields map[string][]int) error {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	best, bestDistance := "", maxSuggestionDistance+1
+	for _, name := range names {
+		if d := distance(key, name); d < bestDistance {
+			best, bestDistance = name, d
+		}
+	}
+	if best != "" {
+		return fmt.Errorf("unknown option '%s', did you mean '%s'?", key, best)
+	}
+	return fmt.Errorf("unknown option '%s', known options are: %s", key, strings.Join(names, ", "))
+}
+
+// distance returns Levenshtein distance of the strings.
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// optionError describes invalid value of the option of type t.
+func optionError(key string, t reflect.Type, value any, err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return fmt.Errorf("option '%s' must be %s, got %s", key, typeName(t), valueName(value))
+	}
+	return fmt.Errorf("option '%s': %w", key, err)
+}
+
+// typeName returns description of values of the type for errors.
+func typeName(t reflect.Type) string {
+	if t == reflect.TypeOf(Duration(0)) {
+		return `a duration like "10s" or a number of seconds`
+	}
+	switch t.Kind() {
+	case reflect.Map:
+		return "a table of " + kindName(t.Elem()) + " values"
+	case reflect.Slice:
+		return "a list of " + kindName(t.Elem()) + " values"
+	default:
+		name := kindName(t)
+		if strings.ContainsRune("aeiou", rune(name[0])) {
+			return "an " + name
+		}
+		return "a " + name
+	}
+}
+
+func kindName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int64:
+		return "integer"
+	case reflect.Float64:
+		return "number"
+	case reflect.Bool:
+		return "boolean"
+	default:
+		return t.Kind().String()
+	}
+}
+
+// valueName returns description of the decoded value for errors.
+func valueName(value any) string {
+	switch v := value.(type) {
+	case map[string]any:
+		return "a table"
+	case []any:
+		return "a list"
+	case string:
+		return fmt.Sprintf("string %q", v)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// withLine adds line and column of a JSON syntax error.
+func withLine(b []byte, err error) error {
+	var syntaxErr *json.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		return err
+	}
+	// Offset is the number of bytes read including the invalid character.
+	before := b[:max(syntaxErr.Offset-1, 0)]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return fmt.Errorf("line %d, column %d: %w", line, column, err)
+}
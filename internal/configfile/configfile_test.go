@@ -0,0 +1,215 @@
+package configfile
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testConfig struct {
+	Tokens   map[string]string `json:"tokens,omitempty"`
+	Address  string            `json:"address,omitempty"`
+	Names    []string          `json:"names,omitempty"`
+	Interval Duration          `json:"interval,omitempty"`
+	Limit    int64             `json:"limit,omitempty"`
+	Restore  bool              `json:"restore,omitempty"`
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestDecode(t *testing.T) {
+	expected := testConfig{
+		Tokens:   map[string]string{"token-a": "team-a"},
+		Address:  "localhost:8080",
+		Names:    []string{"runtime", "psutil"},
+		Interval: Seconds(90),
+		Limit:    10,
+	}
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{
+			name: "json: OK",
+			file: "config.json",
+			content: `{"address": "localhost:8080", "interval": "1m30s", "limit": 10, "restore": false,
+				"names": ["runtime", "psutil"], "tokens": {"token-a": "team-a"}}`,
+		},
+		{
+			name: "yaml: OK",
+			file: "config.yaml",
+			content: "address: localhost:8080\ninterval: 1m30s\nlimit: 10\nrestore: false\n" +
+				"names: [runtime, psutil]\ntokens:\n  token-a: team-a\n",
+		},
+		{
+			name: "toml: OK",
+			file: "config.toml",
+			content: "address = \"localhost:8080\"\ninterval = 90\nlimit = 10\nrestore = false\n" +
+				"names = [\"runtime\", \"psutil\"]\n[tokens]\ntoken-a = \"team-a\"\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c testConfig
+			keys, err := Decode(writeFile(t, tt.file, tt.content), &c)
+			require.NoError(t, err)
+			assert.Equal(t, expected, c)
+			assert.True(t, keys["restore"], "options set to zero values are reported")
+			assert.False(t, keys["missing"])
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "unknown_option: FAIL",
+			file:    "config.yaml",
+			content: "adress: localhost:8080\n",
+			wantErr: "unknown option 'adress', did you mean 'address'?",
+		},
+		{
+			name:    "unknown_option_without_suggestion: FAIL",
+			file:    "config.toml",
+			content: "storage = \"/tmp\"\n",
+			wantErr: "unknown option 'storage', known options are: address, interval, limit, names, restore, tokens",
+		},
+		{
+			name:    "wrong_type: FAIL",
+			file:    "config.json",
+			content: `{"limit": "ten"}`,
+			wantErr: `option 'limit' must be an integer, got string "ten"`,
+		},
+		{
+			name:    "wrong_list_type: FAIL",
+			file:    "config.yaml",
+			content: "names: runtime\n",
+			wantErr: `option 'names' must be a list of string values, got string "runtime"`,
+		},
+		{
+			name:    "invalid_duration: FAIL",
+			file:    "config.yaml",
+			content: "interval: 10 seconds\n",
+			wantErr: `option 'interval': invalid duration '10 seconds'`,
+		},
+		{
+			name:    "fractional_duration: FAIL",
+			file:    "config.toml",
+			content: "interval = \"1500ms\"\n",
+			wantErr: "option 'interval': invalid duration '1500ms', expected whole seconds",
+		},
+		{
+			name:    "json_syntax: FAIL",
+			file:    "config.json",
+			content: "{\n  \"address\": \"localhost:8080\",\n}",
+			wantErr: "line 3, column 1",
+		},
+		{
+			name:    "unknown_format: FAIL",
+			file:    "config.ini",
+			content: "address=localhost:8080",
+			wantErr: "unknown format '.ini'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c testConfig
+			_, err := Decode(writeFile(t, tt.file, tt.content), &c)
+			require.ErrorIs(t, err, ErrInvalid)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected time.Duration
+		wantErr  bool
+	}{
+		{value: "300", expected: 5 * time.Minute},
+		{value: "10s", expected: 10 * time.Second},
+		{value: "1h30m", expected: 90 * time.Minute},
+		{value: "0", expected: 0},
+		{value: "-5s", expected: -5 * time.Second},
+		{value: "500ms", wantErr: true},
+		{value: "ten", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			d, err := ParseDuration(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, time.Duration(d))
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	address := fs.String("a", "localhost:8080", "")
+	limit := fs.Int64("l", 1, "")
+	interval := Seconds(10)
+	fs.Var(&interval, "i", "")
+	restore := fs.Bool("r", true, "")
+	key := fs.String("k", "", "")
+	require.NoError(t, fs.Parse([]string{"-a", "localhost:9090", "-l", "5", "-k", "secret"}))
+
+	path := writeFile(t, "config.yaml", "limit: 7\nrestore: false\n")
+	t.Setenv("INTERVAL", "1m")
+
+	l := NewLoader(fs)
+	var c testConfig
+	require.NoError(t, l.Load(path, &c))
+	require.NoError(t, errors.Join(
+		Resolve(l, Option{Key: "address", Flag: "a", Env: "ADDRESS"}, address, c.Address, String),
+		Resolve(l, Option{Key: "limit", Flag: "l", Env: "LIMIT"}, limit, c.Limit, Int),
+		Resolve(l, Option{Key: "interval", Flag: "i", Env: "INTERVAL"}, &interval, c.Interval, ParseDuration),
+		Resolve(l, Option{Key: "restore", Flag: "r", Env: "RESTORE"}, restore, c.Restore, Bool),
+		Resolve(l, Option{Flag: "k", Env: "KEY", Secret: true}, key, "", String),
+	))
+
+	assert.Equal(t, "localhost:9090", *address)
+	assert.Equal(t, int64(7), *limit)
+	assert.Equal(t, time.Minute, time.Duration(interval))
+	assert.False(t, *restore, "false in the file overrides true flag default")
+	assert.Equal(t, []Setting{
+		{Key: "address", Value: "localhost:9090", Source: "flag -a"},
+		{Key: "limit", Value: "7", Source: "file " + path},
+		{Key: "interval", Value: "1m0s", Source: "env INTERVAL"},
+		{Key: "restore", Value: "false", Source: "file " + path},
+		{Key: "-k", Value: "<hidden>", Source: "flag -k"},
+	}, l.Settings())
+
+	var b bytes.Buffer
+	require.NoError(t, Print(&b, l.Settings()))
+	assert.Contains(t, b.String(), "OPTION    VALUE")
+	assert.Contains(t, b.String(), "interval  1m0s            env INTERVAL")
+
+	t.Run("invalid_env: FAIL", func(t *testing.T) {
+		t.Setenv("LIMIT", "many")
+		err := Resolve(l, Option{Key: "limit", Flag: "l", Env: "LIMIT"}, limit, c.Limit, Int)
+		assert.EqualError(t, err, "failed to parse env var LIMIT: expected an integer, got 'many'")
+	})
+}
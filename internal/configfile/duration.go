@@ -0,0 +1,71 @@
+package configfile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var errDuration = errors.New(`expected a duration like "10s" or a number of seconds`)
+
+// Duration is a duration option. In config files, flags and env vars it is a Go duration string like "10s"
+// or an integer number of seconds. Durations are whole seconds, settings keep them as seconds.
+type Duration time.Duration
+
+// Seconds returns duration of n seconds.
+func Seconds(n int64) Duration {
+	return Duration(time.Duration(n) * time.Second)
+}
+
+// ParseDuration parses duration string or integer number of seconds.
+func ParseDuration(s string) (Duration, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return Seconds(n), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration '%s', %w", s, errDuration)
+	}
+	if d%time.Second != 0 {
+		return 0, fmt.Errorf("invalid duration '%s', expected whole seconds", s)
+	}
+	return Duration(d), nil
+}
+
+// Seconds returns the duration in seconds.
+func (d Duration) Seconds() int64 {
+	return int64(time.Duration(d) / time.Second)
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// Set parses value of a flag.
+func (d *Duration) Set(s string) error {
+	v, err := ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(d.String())), nil
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var n int64
+	if err := json.Unmarshal(b, &n); err == nil {
+		*d = Seconds(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errDuration
+	}
+	return d.Set(s)
+}
@@ -0,0 +1,164 @@
+package configfile
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+// SourceDefault is the source of options which aren't set by flags, config file or env vars.
+const SourceDefault = "default"
+
+// columnPadding separates columns of printed settings.
+const columnPadding = 2
+
+// hidden replaces values of secret options in printed configuration.
+const hidden = "<hidden>"
+
+// Option names an option in config files, command line flags and env vars.
+type Option struct {
+	Key    string // name in config files, empty if the option can't be set in a file
+	Flag   string
+	Env    string
+	Secret bool // value isn't printed
+}
+
+// Setting is the effective value of an option and where it came from.
+type Setting struct {
+	Key    string
+	Value  string
+	Source string
+}
+
+// Loader resolves values of options from flags, config file and env vars and records their sources.
+type Loader struct {
+	flags    map[string]bool // flags set on the command line
+	keys     Keys
+	file     string
+	settings []Setting
+}
+
+// NewLoader returns loader of options, flags of fs must be parsed.
+func NewLoader(fs *flag.FlagSet) *Loader {
+	l := &Loader{flags: make(map[string]bool)}
+	fs.Visit(func(f *flag.Flag) {
+		l.flags[f.Name] = true
+	})
+	return l
+}
+
+// Load decodes the config file into dst, options set in the file override flags.
+func (l *Loader) Load(path string, dst any) error {
+	keys, err := Decode(path, dst)
+	if err != nil {
+		return err
+	}
+	l.file, l.keys = path, keys
+	return nil
+}
+
+// Resolve sets v, holding the default or flag value of the option, to the value of the option in the config file
+// and then of the env var, if they are set. Values of env vars are converted by parse.
+func Resolve[T any](l *Loader, o Option, v *T, file T, parse func(string) (T, error)) error {
+	source := SourceDefault
+	if l.flags[o.Flag] {
+		source = "flag -" + o.Flag
+	}
+	if o.Key != "" && l.keys[o.Key] {
+		*v, source = file, "file "+l.file
+	}
+	if s, ok := os.LookupEnv(o.Env); o.Env != "" && ok {
+		value, err := parse(s)
+		if err != nil {
+			return fmt.Errorf("failed to parse env var %s: %w", o.Env, err)
+		}
+		*v, source = value, "env "+o.Env
+	}
+	l.record(o, *v, source)
+	return nil
+}
+
+// Update replaces recorded value of the option derived from other options, source of the value is kept.
+func (l *Loader) Update(o Option, v any) {
+	for i := range l.settings {
+		if l.settings[i].Key == name(o) {
+			l.settings[i].Value = format(o, v)
+		}
+	}
+}
+
+// Settings returns effective values of resolved options in order of resolution.
+func (l *Loader) Settings() []Setting {
+	return l.settings
+}
+
+func (l *Loader) record(o Option, v any, source string) {
+	l.settings = append(l.settings, Setting{Key: name(o), Value: format(o, v), Source: source})
+}
+
+// name returns name of the option in printed configuration.
+func name(o Option) string {
+	if o.Key != "" {
+		return o.Key
+	}
+	return "-" + o.Flag
+}
+
+func format(o Option, v any) string {
+	s := fmt.Sprint(v)
+	switch {
+	case o.Secret && s != "" && s != "map[]":
+		return hidden
+	case s == "":
+		return `""`
+	}
+	return s
+}
+
+// Print writes settings as a table.
+func Print(w io.Writer, settings []Setting) error {
+	tw := tabwriter.NewWriter(w, 0, 0, columnPadding, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "OPTION\tVALUE\tSOURCE")
+	for _, s := range settings {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Key, s.Value, s.Source)
+	}
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("failed to write settings: %w", err)
+	}
+	return nil
+}
+
+// String returns the value of a string env var.
+func String(s string) (string, error) {
+	return s, nil
+}
+
+// Int parses value of an integer env var.
+func Int(s string) (int64, error) {
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("expected an integer, got '%s'", s)
+	}
+	return v, nil
+}
+
+// Float parses value of a number env var.
+func Float(s string) (float64, error) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("expected a number, got '%s'", s)
+	}
+	return v, nil
+}
+
+// Bool parses value of a boolean env var.
+func Bool(s string) (bool, error) {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("expected true or false, got '%s'", s)
+	}
+	return v, nil
+}
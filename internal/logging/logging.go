@@ -0,0 +1,170 @@
+// Package logging builds zap loggers of the metric server and agent from logging options.
+//
+// Logs are written as JSON or console text to stderr or to a log file rotated by size. Components log through
+// loggers named after them, see Component constants, and each component can have its own level. Levels can
+// be changed while loggers are used, e.g. when configuration is reloaded.
+package logging
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Components with their own log levels, loggers of components are named after them.
+const (
+	ComponentHTTP    = "http"
+	ComponentGRPC    = "grpc"
+	ComponentStorage = "storage"
+	ComponentSender  = "sender"
+)
+
+// Components are names of all components.
+var Components = []string{ComponentHTTP, ComponentGRPC, ComponentStorage, ComponentSender}
+
+// Formats of logs.
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
+)
+
+// Sampling keeps first samplingFirst entries with the same level and message each samplingTick
+// and every samplingThereafter entry after them.
+const (
+	samplingTick       = time.Second
+	samplingFirst      = 100
+	samplingThereafter = 100
+)
+
+const hoursPerDay = 24
+
+// Config defines levels, format, destination and sampling of logs.
+type Config struct {
+	ComponentLevels map[string]zapcore.Level // components without level log at Level
+	Format          string
+	File            string // path of the log file, logs are written to stderr if empty
+	MaxSize         int64  // megabytes of the log file before it is rotated
+	MaxBackups      int64  // rotated log files to keep, 0 keeps all
+	MaxAge          int64  // days to keep rotated log files, 0 keeps them forever
+	Level           zapcore.Level
+	Sampling        bool
+}
+
+// Levels are levels of a logger and its components, they can be changed while the logger is used.
+type Levels struct {
+	components map[string]zapcore.Level
+	level      zapcore.Level
+	mu         sync.RWMutex
+}
+
+// Set replaces levels of the logger and its components.
+func (l *Levels) Set(level zapcore.Level, components map[string]zapcore.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level, l.components = level, components
+}
+
+// Enabled reports whether entries of the level are logged by the named logger.
+func (l *Levels) Enabled(name string, level zapcore.Level) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	component, _, _ := strings.Cut(name, ".")
+	if cl, ok := l.components[component]; ok {
+		return cl.Enabled(level)
+	}
+	return l.level.Enabled(level)
+}
+
+// lowest returns the most verbose of the levels.
+func (l *Levels) lowest() zapcore.Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	lowest := l.level
+	for _, cl := range l.components {
+		lowest = min(lowest, cl)
+	}
+	return lowest
+}
+
+// New returns logger configured by c and its levels.
+func New(c *Config) (*zap.Logger, *Levels, error) {
+	var encoder zapcore.Encoder
+	switch c.Format {
+	case FormatJSON:
+		encoder = zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	case FormatConsole, "":
+		encoder = zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+	default:
+		return nil, nil, fmt.Errorf("unknown log format '%s', expected json or console", c.Format)
+	}
+
+	out := zapcore.Lock(os.Stderr)
+	if c.File != "" {
+		out = zapcore.AddSync(&lumberjack.Logger{
+			Filename:   c.File,
+			MaxSize:    int(c.MaxSize),
+			MaxBackups: int(c.MaxBackups),
+			MaxAge:     int(c.MaxAge),
+		})
+	}
+
+	core := zapcore.NewCore(encoder, out, zapcore.DebugLevel)
+	if c.Sampling {
+		core = zapcore.NewSamplerWithOptions(core, samplingTick, samplingFirst, samplingThereafter)
+	}
+	levels := &Levels{level: c.Level, components: c.ComponentLevels}
+	core = &levelCore{Core: core, levels: levels}
+	return zap.New(core, zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel)), levels, nil
+}
+
+// ParseLevels parses levels of components, names of components are checked.
+func ParseLevels(levels map[string]string) (map[string]zapcore.Level, error) {
+	parsed := make(map[string]zapcore.Level, len(levels))
+	for component, level := range levels {
+		if !slices.Contains(Components, component) {
+			return nil, fmt.Errorf("unknown log component '%s', expected one of: %s",
+				component, strings.Join(Components, ", "))
+		}
+		l, err := zapcore.ParseLevel(level)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log level of component '%s': %w", component, err)
+		}
+		parsed[component] = l
+	}
+	return parsed, nil
+}
+
+// Days returns whole days of the duration rounded up.
+func Days(d time.Duration) int64 {
+	const day = hoursPerDay * time.Hour
+	return int64((d + day - 1) / day)
+}
+
+// levelCore filters entries by levels of their loggers.
+type levelCore struct {
+	zapcore.Core
+	levels *Levels
+}
+
+func (c *levelCore) Enabled(level zapcore.Level) bool {
+	return c.levels.lowest().Enabled(level)
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), levels: c.levels}
+}
+
+//nolint:gocritic // signature of zapcore.Core
+func (c *levelCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.levels.Enabled(e.LoggerName, e.Level) {
+		return ce
+	}
+	return c.Core.Check(e, ce)
+}
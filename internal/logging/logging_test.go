@@ -0,0 +1,80 @@
+package logging
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestNew(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.log")
+	logger, levels, err := New(&Config{
+		ComponentLevels: map[string]zapcore.Level{
+			ComponentHTTP:    zapcore.DebugLevel,
+			ComponentStorage: zapcore.ErrorLevel,
+		},
+		Format:  FormatJSON,
+		File:    path,
+		MaxSize: 1,
+		Level:   zapcore.InfoLevel,
+	})
+	require.NoError(t, err)
+
+	logger.Debug("root debug")
+	logger.Info("root info")
+	logger.Named(ComponentHTTP).Debug("http debug")
+	logger.Named(ComponentStorage).Warn("storage warn")
+	logger.Named(ComponentStorage).Named("file").Error("storage error")
+
+	levels.Set(zapcore.WarnLevel, nil)
+	logger.Info("root info after reload")
+	logger.Named(ComponentHTTP).Debug("http debug after reload")
+	logger.Warn("root warn after reload")
+	require.NoError(t, logger.Sync())
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	messages := make([]string, 0, len(lines))
+	for _, line := range lines {
+		var entry struct {
+			Msg string `json:"msg"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry), "entries are JSON")
+		messages = append(messages, entry.Msg)
+	}
+	assert.Equal(t, []string{"root info", "http debug", "storage error", "root warn after reload"}, messages)
+
+	t.Run("unknown_format: FAIL", func(t *testing.T) {
+		_, _, err := New(&Config{Format: "xml"})
+		assert.Error(t, err)
+	})
+}
+
+func TestParseLevels(t *testing.T) {
+	levels, err := ParseLevels(map[string]string{ComponentGRPC: "warn", ComponentSender: "ERROR"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]zapcore.Level{
+		ComponentGRPC:   zapcore.WarnLevel,
+		ComponentSender: zapcore.ErrorLevel,
+	}, levels)
+
+	_, err = ParseLevels(map[string]string{"dispatcher": "warn"})
+	assert.ErrorContains(t, err, "unknown log component 'dispatcher'")
+
+	_, err = ParseLevels(map[string]string{ComponentHTTP: "loud"})
+	assert.ErrorContains(t, err, "invalid log level of component 'http'")
+}
+
+func TestDays(t *testing.T) {
+	assert.Equal(t, int64(0), Days(0))
+	assert.Equal(t, int64(1), Days(time.Hour))
+	assert.Equal(t, int64(7), Days(7*24*time.Hour))
+}
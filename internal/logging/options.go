@@ -0,0 +1,131 @@
+package logging
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+
+	"github.com/vkupriya/go-metrics/internal/configfile"
+)
+
+const (
+	defaultMaxSize    int64 = 100
+	defaultMaxBackups int64 = 5
+)
+
+// Options of logging: names in config files, flags and env vars.
+var (
+	optLevel      = configfile.Option{Key: "log_level", Flag: "log-level", Env: "LOG_LEVEL"}
+	optLevels     = configfile.Option{Key: "log_levels", Flag: "log-levels", Env: "LOG_LEVELS"}
+	optFormat     = configfile.Option{Key: "log_format", Flag: "log-format", Env: "LOG_FORMAT"}
+	optFile       = configfile.Option{Key: "log_file", Flag: "log-file", Env: "LOG_FILE"}
+	optMaxSize    = configfile.Option{Key: "log_max_size", Flag: "log-max-size", Env: "LOG_MAX_SIZE"}
+	optMaxBackups = configfile.Option{Key: "log_max_backups", Flag: "log-max-backups", Env: "LOG_MAX_BACKUPS"}
+	optMaxAge     = configfile.Option{Key: "log_max_age", Flag: "log-max-age", Env: "LOG_MAX_AGE"}
+	optSampling   = configfile.Option{Key: "log_sampling", Flag: "log-sampling", Env: "LOG_SAMPLING"}
+)
+
+// Flags are values of logging flags defined by AddFlags.
+type Flags struct {
+	level, levels, format, file string
+	maxSize, maxBackups         int64
+	maxAge                      configfile.Duration
+	sampling                    bool
+}
+
+// FileOptions are logging options of config files, config files of the server and agent embed them.
+type FileOptions struct {
+	Levels     map[string]string   `json:"log_levels,omitempty"`
+	Level      string              `json:"log_level,omitempty"`
+	Format     string              `json:"log_format,omitempty"`
+	File       string              `json:"log_file,omitempty"`
+	MaxAge     configfile.Duration `json:"log_max_age,omitempty"`
+	MaxSize    int64               `json:"log_max_size,omitempty"`
+	MaxBackups int64               `json:"log_max_backups,omitempty"`
+	Sampling   bool                `json:"log_sampling,omitempty"`
+}
+
+// AddFlags defines logging flags of the default flag set, level is the default log level.
+func AddFlags(f *Flags, level string) {
+	flag.StringVar(&f.level, optLevel.Flag, level, "Log level: debug, info, warn or error.")
+	flag.StringVar(&f.levels, optLevels.Flag, "",
+		"Comma separated component=level pairs of log levels of components: "+strings.Join(Components, ", ")+".")
+	flag.StringVar(&f.format, optFormat.Flag, FormatConsole, "Log format: json or console.")
+	flag.StringVar(&f.file, optFile.Flag, "", "Path of the log file, logs are written to stderr if empty.")
+	flag.Int64Var(&f.maxSize, optMaxSize.Flag, defaultMaxSize, "Size of the log file in megabytes before rotation.")
+	flag.Int64Var(&f.maxBackups, optMaxBackups.Flag, defaultMaxBackups, "Rotated log files to keep, 0 keeps all.")
+	flag.Var(&f.maxAge, optMaxAge.Flag, "Age of rotated log files to remove them, 0 keeps them forever.")
+	flag.BoolVar(&f.sampling, optSampling.Flag, false, "Sample repeated log entries.")
+}
+
+// Resolve resolves logging options from values of flags f, the config file and env vars.
+// Values of f are replaced by the resolved ones.
+func Resolve(l *configfile.Loader, f *Flags, file *FileOptions) (*Config, error) {
+	levels, err := parsePairs(f.levels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse log levels: %w", err)
+	}
+
+	err = errors.Join(
+		configfile.Resolve(l, optLevel, &f.level, file.Level, configfile.String),
+		configfile.Resolve(l, optLevels, &levels, file.Levels, parsePairs),
+		configfile.Resolve(l, optFormat, &f.format, file.Format, configfile.String),
+		configfile.Resolve(l, optFile, &f.file, file.File, configfile.String),
+		configfile.Resolve(l, optMaxSize, &f.maxSize, file.MaxSize, configfile.Int),
+		configfile.Resolve(l, optMaxBackups, &f.maxBackups, file.MaxBackups, configfile.Int),
+		configfile.Resolve(l, optMaxAge, &f.maxAge, file.MaxAge, configfile.ParseDuration),
+		configfile.Resolve(l, optSampling, &f.sampling, file.Sampling, configfile.Bool),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve logging options: %w", err)
+	}
+
+	level, err := zapcore.ParseLevel(f.level)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level: %w", err)
+	}
+	componentLevels, err := ParseLevels(levels)
+	if err != nil {
+		return nil, err
+	}
+	if f.format != FormatJSON && f.format != FormatConsole {
+		return nil, fmt.Errorf("unknown log format '%s', expected json or console", f.format)
+	}
+	if f.maxSize <= 0 {
+		return nil, errors.New("size of the log file must be positive")
+	}
+	if f.maxBackups < 0 || f.maxAge < 0 {
+		return nil, errors.New("rotated log files to keep and their age must not be negative")
+	}
+
+	return &Config{
+		ComponentLevels: componentLevels,
+		Format:          f.format,
+		File:            f.file,
+		MaxSize:         f.maxSize,
+		MaxBackups:      f.maxBackups,
+		MaxAge:          Days(time.Duration(f.maxAge)),
+		Level:           level,
+		Sampling:        f.sampling,
+	}, nil
+}
+
+// parsePairs parses comma separated component=level pairs.
+func parsePairs(s string) (map[string]string, error) {
+	pairs := make(map[string]string)
+	if s == "" {
+		return pairs, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || k == "" || v == "" {
+			return nil, fmt.Errorf("expected component=level pair, got '%s'", pair)
+		}
+		pairs[k] = v
+	}
+	return pairs, nil
+}
@@ -21,6 +21,9 @@ const _ = grpc.SupportPackageIsVersion9
 const (
 	Metrics_UpdateMetric_FullMethodName  = "/metricserver.protobuf.Metrics/UpdateMetric"
 	Metrics_UpdateMetrics_FullMethodName = "/metricserver.protobuf.Metrics/UpdateMetrics"
+	Metrics_DeleteMetric_FullMethodName  = "/metricserver.protobuf.Metrics/DeleteMetric"
+	Metrics_ResetCounter_FullMethodName  = "/metricserver.protobuf.Metrics/ResetCounter"
+	Metrics_DeleteMetrics_FullMethodName = "/metricserver.protobuf.Metrics/DeleteMetrics"
 )
 
 // MetricsClient is the client API for Metrics service.
@@ -29,6 +32,9 @@ const (
 type MetricsClient interface {
 	UpdateMetric(ctx context.Context, in *UpdateMetricRequest, opts ...grpc.CallOption) (*UpdateMetricResponse, error)
 	UpdateMetrics(ctx context.Context, in *UpdateMetricsRequest, opts ...grpc.CallOption) (*UpdateMetricsResponse, error)
+	DeleteMetric(ctx context.Context, in *DeleteMetricRequest, opts ...grpc.CallOption) (*DeleteMetricResponse, error)
+	ResetCounter(ctx context.Context, in *ResetCounterRequest, opts ...grpc.CallOption) (*ResetCounterResponse, error)
+	DeleteMetrics(ctx context.Context, in *DeleteMetricsRequest, opts ...grpc.CallOption) (*DeleteMetricsResponse, error)
 }
 
 type metricsClient struct {
@@ -59,12 +65,45 @@ func (c *metricsClient) UpdateMetrics(ctx context.Context, in *UpdateMetricsRequ
 	return out, nil
 }
 
+func (c *metricsClient) DeleteMetric(ctx context.Context, in *DeleteMetricRequest, opts ...grpc.CallOption) (*DeleteMetricResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteMetricResponse)
+	err := c.cc.Invoke(ctx, Metrics_DeleteMetric_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsClient) ResetCounter(ctx context.Context, in *ResetCounterRequest, opts ...grpc.CallOption) (*ResetCounterResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ResetCounterResponse)
+	err := c.cc.Invoke(ctx, Metrics_ResetCounter_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsClient) DeleteMetrics(ctx context.Context, in *DeleteMetricsRequest, opts ...grpc.CallOption) (*DeleteMetricsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteMetricsResponse)
+	err := c.cc.Invoke(ctx, Metrics_DeleteMetrics_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MetricsServer is the server API for Metrics service.
 // All implementations must embed UnimplementedMetricsServer
 // for forward compatibility.
 type MetricsServer interface {
 	UpdateMetric(context.Context, *UpdateMetricRequest) (*UpdateMetricResponse, error)
 	UpdateMetrics(context.Context, *UpdateMetricsRequest) (*UpdateMetricsResponse, error)
+	DeleteMetric(context.Context, *DeleteMetricRequest) (*DeleteMetricResponse, error)
+	ResetCounter(context.Context, *ResetCounterRequest) (*ResetCounterResponse, error)
+	DeleteMetrics(context.Context, *DeleteMetricsRequest) (*DeleteMetricsResponse, error)
 	mustEmbedUnimplementedMetricsServer()
 }
 
@@ -81,6 +120,15 @@ func (UnimplementedMetricsServer) UpdateMetric(context.Context, *UpdateMetricReq
 func (UnimplementedMetricsServer) UpdateMetrics(context.Context, *UpdateMetricsRequest) (*UpdateMetricsResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method UpdateMetrics not implemented")
 }
+func (UnimplementedMetricsServer) DeleteMetric(context.Context, *DeleteMetricRequest) (*DeleteMetricResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteMetric not implemented")
+}
+func (UnimplementedMetricsServer) ResetCounter(context.Context, *ResetCounterRequest) (*ResetCounterResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ResetCounter not implemented")
+}
+func (UnimplementedMetricsServer) DeleteMetrics(context.Context, *DeleteMetricsRequest) (*DeleteMetricsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteMetrics not implemented")
+}
 func (UnimplementedMetricsServer) mustEmbedUnimplementedMetricsServer() {}
 func (UnimplementedMetricsServer) testEmbeddedByValue()                 {}
 
@@ -138,6 +186,60 @@ func _Metrics_UpdateMetrics_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Metrics_DeleteMetric_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteMetricRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).DeleteMetric(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Metrics_DeleteMetric_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).DeleteMetric(ctx, req.(*DeleteMetricRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Metrics_ResetCounter_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResetCounterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).ResetCounter(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Metrics_ResetCounter_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).ResetCounter(ctx, req.(*ResetCounterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Metrics_DeleteMetrics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteMetricsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).DeleteMetrics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Metrics_DeleteMetrics_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).DeleteMetrics(ctx, req.(*DeleteMetricsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Metrics_ServiceDesc is the grpc.ServiceDesc for Metrics service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -153,6 +255,18 @@ var Metrics_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "UpdateMetrics",
 			Handler:    _Metrics_UpdateMetrics_Handler,
 		},
+		{
+			MethodName: "DeleteMetric",
+			Handler:    _Metrics_DeleteMetric_Handler,
+		},
+		{
+			MethodName: "ResetCounter",
+			Handler:    _Metrics_ResetCounter_Handler,
+		},
+		{
+			MethodName: "DeleteMetrics",
+			Handler:    _Metrics_DeleteMetrics_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "metricserver.proto",
@@ -0,0 +1,72 @@
+// Package requestid identifies requests of metric agents and API clients across the agent and the server.
+//
+// Clients send request ID in X-Request-ID header or x-request-id gRPC metadata, the server generates one
+// for requests without a valid ID. The server echoes the ID in responses and logs it with the request,
+// so a failed request reported by a client can be found in logs and the audit log of the server.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+const (
+	// Header carries request ID of HTTP requests and responses.
+	Header = "X-Request-ID"
+	// MetadataKey carries request ID of gRPC calls in metadata and response headers.
+	MetadataKey = "x-request-id"
+	// LogKey is the field of request ID in log lines.
+	LogKey = "request_id"
+	// MaxLength is the maximum length of request IDs accepted from clients.
+	MaxLength = 128
+)
+
+// size is the number of random bytes of generated IDs.
+const size = 16
+
+// New returns a random request ID of 32 hex characters.
+func New() string {
+	b := make([]byte, size)
+	// Reading from crypto/rand doesn't fail on supported platforms.
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Valid reports whether id can be accepted from a client: IDs are logged and echoed in headers, so only
+// up to MaxLength letters, digits and '-', '_', '.', ':' are allowed.
+func Valid(id string) bool {
+	if id == "" || len(id) > MaxLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Resolve returns id if it is valid, otherwise a new request ID.
+func Resolve(id string) string {
+	if Valid(id) {
+		return id
+	}
+	return New()
+}
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying the request ID.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns the request ID carried by ctx, empty string is returned if ctx has no ID.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
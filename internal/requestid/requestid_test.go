@@ -0,0 +1,44 @@
+package requestid
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		name  string
+		id    string
+		valid bool
+	}{
+		{name: "generated", id: New(), valid: true},
+		{name: "uuid", id: "0b7f5a2e-4c1d-4e8a-9f3b-2d6c8e1a7b90", valid: true},
+		{name: "dotted", id: "agent-1:batch.42_a", valid: true},
+		{name: "empty: FAIL", id: ""},
+		{name: "too_long: FAIL", id: strings.Repeat("a", MaxLength+1)},
+		{name: "newline: FAIL", id: "abc\ninjected"},
+		{name: "space: FAIL", id: "abc def"},
+		{name: "non_ascii: FAIL", id: "идентификатор"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.valid, Valid(tt.id))
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	assert.Equal(t, "req-1", Resolve("req-1"), "valid IDs of clients are kept")
+
+	id := Resolve("bad id")
+	assert.Len(t, id, 2*size, "invalid IDs are replaced")
+	assert.NotEqual(t, id, Resolve(""), "generated IDs are unique")
+}
+
+func TestContext(t *testing.T) {
+	assert.Empty(t, FromContext(context.Background()))
+	assert.Equal(t, "req-1", FromContext(NewContext(context.Background(), "req-1")))
+}
@@ -0,0 +1,145 @@
+// Package audit records write and admin operations of the metric server in an append-only audit log.
+//
+// Every authorized operation is written as a JSON line telling who did it, from which IP, how many metrics
+// it touched and how it ended. Deletes and resets record names of metrics too. Middleware of the REST API
+// and interceptors of the gRPC API start entries of audited requests and record them when requests end,
+// handlers add touched metrics to the entry of the request context. The log is opened in append mode and
+// is never truncated or rotated by the server.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Operations recorded in the audit log.
+const (
+	OpUpdate        = "update"
+	OpUpdateBatch   = "update_batch"
+	OpDeleteMetric  = "delete_metric"
+	OpResetCounter  = "reset_counter"
+	OpDeleteMetrics = "delete_metrics"
+	OpKeyExchange   = "key_exchange"
+)
+
+// Protocols of recorded operations.
+const (
+	ProtocolHTTP = "http"
+	ProtocolGRPC = "grpc"
+)
+
+// Results of recorded operations.
+const (
+	ResultOK       = "ok"
+	ResultRejected = "rejected" // request of the client is rejected, e.g. metric not found or rate limited
+	ResultFailed   = "failed"   // operation failed on the server
+)
+
+// Entry is a line of the audit log.
+type Entry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+	Operation string    `json:"operation"`        // one of Op constants
+	Protocol  string    `json:"protocol"`         // http or grpc
+	Tenant    string    `json:"tenant,omitempty"` // empty for the default tenant
+	Principal string    `json:"principal"`        // token:name, cert:CN or ip:address of anonymous clients
+	IP        string    `json:"ip"`               // address of the connection
+	RealIP    string    `json:"real_ip,omitempty"`
+	Prefix    string    `json:"prefix,omitempty"` // name prefix of deleted metrics
+	Status    string    `json:"status"`           // HTTP status code or gRPC status code name
+	Result    string    `json:"result"`           // one of Result constants
+	Names     []string  `json:"names,omitempty"`  // deleted or reset metrics
+	Metrics   int64     `json:"metrics"`          // number of written, deleted or reset metrics
+}
+
+// Add adds n touched metrics to the entry, names are recorded for deleted and reset metrics.
+// Nothing is done for a nil entry of a request which isn't audited.
+func (e *Entry) Add(n int64, names ...string) {
+	if e == nil {
+		return
+	}
+	e.Metrics += n
+	e.Names = append(e.Names, names...)
+}
+
+// SetPrefix sets name prefix of deleted metrics, nothing is done for a nil entry.
+func (e *Entry) SetPrefix(prefix string) {
+	if e == nil {
+		return
+	}
+	e.Prefix = prefix
+}
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying the entry of the audited request.
+func NewContext(ctx context.Context, e *Entry) context.Context {
+	return context.WithValue(ctx, ctxKey{}, e)
+}
+
+// FromContext returns the entry carried by ctx, nil is returned if the request isn't audited.
+func FromContext(ctx context.Context) *Entry {
+	e, _ := ctx.Value(ctxKey{}).(*Entry)
+	return e
+}
+
+// Log is an append-only audit log file. Methods of a nil Log do nothing, so audit is optional.
+type Log struct {
+	f  *os.File
+	mu sync.Mutex // serializes lines of concurrent requests
+}
+
+// Open opens the audit log at path for appending, the file is created if it doesn't exist.
+func Open(path string) (*Log, error) {
+	// Audit log tells who accessed which metrics, so it is readable by the owner only.
+	const perm = 0o600
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, perm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log %s: %w", path, err)
+	}
+	return &Log{f: f}, nil
+}
+
+// Enabled reports whether operations are recorded.
+func (l *Log) Enabled() bool {
+	return l != nil
+}
+
+// Record appends the entry to the log, time of the entry is set if it is zero.
+func (l *Log) Record(e *Entry) error {
+	if l == nil {
+		return nil
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	b = append(b, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.f.Write(b); err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	return nil
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.f.Close(); err != nil {
+		return fmt.Errorf("failed to close audit log: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	require.NoError(t, os.WriteFile(path, []byte("{\"operation\":\"earlier\"}\n"), 0o600))
+
+	l, err := Open(path)
+	require.NoError(t, err)
+	assert.True(t, l.Enabled())
+
+	const writers = 10
+	var wg sync.WaitGroup
+	for range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, l.Record(&Entry{Operation: OpResetCounter, Names: []string{"PollCount"}, Metrics: 1}))
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, l.Close())
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	require.Len(t, lines, writers+1, "entries are appended")
+	assert.Equal(t, `{"operation":"earlier"}`, lines[0])
+	for _, line := range lines[1:] {
+		var e Entry
+		require.NoError(t, json.Unmarshal([]byte(line), &e), "entries are whole JSON lines")
+		assert.Equal(t, OpResetCounter, e.Operation)
+		assert.False(t, e.Time.IsZero(), "time is set")
+	}
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	t.Run("open_missing_dir: FAIL", func(t *testing.T) {
+		_, err := Open(filepath.Join(t.TempDir(), "missing", "audit.log"))
+		assert.Error(t, err)
+	})
+}
+
+func TestDisabled(t *testing.T) {
+	var l *Log
+	assert.False(t, l.Enabled())
+	assert.NoError(t, l.Record(&Entry{Operation: OpUpdate}))
+	assert.NoError(t, l.Close())
+
+	e := FromContext(context.Background())
+	assert.Nil(t, e, "requests aren't audited without entry")
+	e.Add(1, "PollCount")
+	e.SetPrefix("test.")
+}
+
+func TestEntry(t *testing.T) {
+	e := &Entry{Operation: OpDeleteMetric}
+	ctx := NewContext(context.Background(), e)
+	FromContext(ctx).Add(1, "a")
+	FromContext(ctx).Add(1, "b")
+	FromContext(ctx).SetPrefix("p")
+	assert.Equal(t, int64(2), e.Metrics)
+	assert.Equal(t, []string{"a", "b"}, e.Names)
+	assert.Equal(t, "p", e.Prefix)
+}
@@ -0,0 +1,236 @@
+// Package auth authenticates API tokens of metric server clients and authorizes their requests.
+//
+// Tokens are loaded from a JSON file holding SHA-256 hashes of token secrets, never the secrets:
+//
+//	{
+//	  "tokens": [
+//	    {"name": "agents", "secret_sha256": "9f86d08...", "roles": ["write"]},
+//	    {"name": "dashboard", "secret_sha256": "60303ae...", "roles": ["read"], "prefixes": ["cpu.", "mem."]},
+//	    {"name": "ops", "secret_sha256": "fd61a03...", "roles": ["admin"]}
+//	  ]
+//	}
+//
+// Hash of a secret is printed by 'printf %s "$TOKEN" | sha256sum'. Clients present tokens
+// as 'Authorization: Bearer <token>' header or gRPC metadata, browsers may send the token as
+// the password of basic authentication.
+//
+// Role 'read' allows reading metrics, 'write' allows posting them and 'admin' allows everything
+// including admin API and profiler. Tokens with prefixes can only access metrics with names
+// starting with one of the prefixes.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/vkupriya/go-metrics/internal/server/models"
+)
+
+// Role is a set of operations allowed to a token.
+type Role string
+
+const (
+	RoleRead  Role = "read"
+	RoleWrite Role = "write"
+	RoleAdmin Role = "admin"
+)
+
+// adminTokenName is the name of the principal authenticated by the admin token of server configuration.
+const adminTokenName = "admin"
+
+var (
+	ErrInvalidToken = errors.New("invalid API token")
+	ErrForbidden    = errors.New("access is forbidden")
+)
+
+// File is a token file.
+type File struct {
+	Tokens []Token `json:"tokens"`
+}
+
+// Token is an API token, only SHA-256 hash of its secret is stored.
+type Token struct {
+	Name         string   `json:"name"`
+	SecretSHA256 string   `json:"secret_sha256"`
+	Roles        []Role   `json:"roles"`
+	Prefixes     []string `json:"prefixes,omitempty"` // metric name prefixes the token is limited to
+}
+
+// Load reads and validates token file.
+func Load(path string) (*File, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token file %s: %w", path, err)
+	}
+	var f File
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal token file %s: %w", path, err)
+	}
+	if err := f.validate(); err != nil {
+		return nil, fmt.Errorf("invalid token file %s: %w", path, err)
+	}
+	return &f, nil
+}
+
+func (f *File) validate() error {
+	names := make(map[string]bool, len(f.Tokens))
+	hashes := make(map[string]bool, len(f.Tokens))
+	for i := range f.Tokens {
+		t := &f.Tokens[i]
+		if t.Name == "" {
+			return fmt.Errorf("token #%d has no name", i+1)
+		}
+		if names[t.Name] {
+			return fmt.Errorf("duplicate token '%s'", t.Name)
+		}
+		names[t.Name] = true
+
+		h, err := hex.DecodeString(t.SecretSHA256)
+		if err != nil || len(h) != sha256.Size {
+			return fmt.Errorf("secret_sha256 of token '%s' is not a hex encoded SHA-256 hash", t.Name)
+		}
+		t.SecretSHA256 = strings.ToLower(t.SecretSHA256)
+		if hashes[t.SecretSHA256] {
+			return fmt.Errorf("token '%s' has the same secret as another token", t.Name)
+		}
+		hashes[t.SecretSHA256] = true
+
+		if len(t.Roles) == 0 {
+			return fmt.Errorf("token '%s' has no roles", t.Name)
+		}
+		for _, r := range t.Roles {
+			switch r {
+			case RoleRead, RoleWrite, RoleAdmin:
+			default:
+				return fmt.Errorf("unknown role '%s' of token '%s'", r, t.Name)
+			}
+		}
+		if slices.Contains(t.Prefixes, "") {
+			return fmt.Errorf("token '%s' has an empty prefix", t.Name)
+		}
+	}
+	return nil
+}
+
+// Principal is an authenticated client.
+type Principal struct {
+	Name     string
+	Roles    []Role
+	Prefixes []string
+}
+
+// Has reports whether the principal has the role, admins have all roles.
+func (p *Principal) Has(role Role) bool {
+	return slices.Contains(p.Roles, role) || slices.Contains(p.Roles, RoleAdmin)
+}
+
+// Allowed reports whether the principal may access the metric.
+func (p *Principal) Allowed(name string) bool {
+	if len(p.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range p.Prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Restricted reports whether the principal is limited to metrics with some name prefixes.
+func (p *Principal) Restricted() bool {
+	return len(p.Prefixes) > 0
+}
+
+// Authenticator authenticates tokens of the token file and the admin token of server configuration.
+type Authenticator struct {
+	principals map[string]*Principal // hex encoded SHA-256 hash of the secret to principal
+	enabled    bool
+}
+
+// New returns authenticator of tokens of the file, f may be nil if no token file is configured.
+// Without token file all clients are allowed to read and write metrics and only the admin token
+// grants admin role.
+func New(c *models.Config, f *File) *Authenticator {
+	a := &Authenticator{principals: make(map[string]*Principal)}
+	if c.AdminToken != "" {
+		a.principals[hash(c.AdminToken)] = &Principal{Name: adminTokenName, Roles: []Role{RoleAdmin}}
+	}
+	if f != nil {
+		a.enabled = true
+		for _, t := range f.Tokens {
+			a.principals[t.SecretSHA256] = &Principal{Name: t.Name, Roles: t.Roles, Prefixes: t.Prefixes}
+		}
+	}
+	return a
+}
+
+// Enabled reports whether tokens are required to read and write metrics.
+func (a *Authenticator) Enabled() bool {
+	return a.enabled
+}
+
+// Admins reports whether any token grants admin role, admin API is disabled otherwise.
+func (a *Authenticator) Admins() bool {
+	for _, p := range a.principals {
+		if p.Has(RoleAdmin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticate returns principal of the token.
+// Secrets are compared by their hashes, so lookup time doesn't depend on how much of the secret is correct.
+func (a *Authenticator) Authenticate(token string) (*Principal, error) {
+	p, ok := a.principals[hash(token)]
+	if !ok || token == "" {
+		return nil, ErrInvalidToken
+	}
+	return p, nil
+}
+
+// Authorize returns nil if the principal may perform operations of the role, p is nil for anonymous clients.
+func (a *Authenticator) Authorize(p *Principal, role Role) error {
+	if p == nil {
+		if a.enabled || role == RoleAdmin {
+			return ErrInvalidToken
+		}
+		return nil
+	}
+	if !p.Has(role) {
+		return fmt.Errorf("%w: token '%s' has no %s role", ErrForbidden, p.Name, role)
+	}
+	return nil
+}
+
+func hash(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying the principal.
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, ctxKey{}, p)
+}
+
+// FromContext returns the principal carried by ctx, nil is returned for anonymous clients.
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(ctxKey{}).(*Principal)
+	return p
+}
+
+// Allowed reports whether the client of ctx may access the metric.
+func Allowed(ctx context.Context, name string) bool {
+	p := FromContext(ctx)
+	return p == nil || p.Allowed(name)
+}
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vkupriya/go-metrics/internal/server/models"
+)
+
+// Hash of the secret "reader".
+const readerHash = "3d0941964aa3ebdcb00ccef58b1bb399f9f898465e9886d5aec7f31090a0fb30"
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{
+			name: "tokens: OK",
+			content: `{"tokens": [{"name": "dashboard", "secret_sha256": "` + strings.ToUpper(readerHash) + `",
+				"roles": ["read"], "prefixes": ["cpu."]}]}`,
+		},
+		{name: "empty: OK", content: `{}`},
+		{name: "malformed_json: FAIL", content: `{"tokens": [`, wantErr: true},
+		{
+			name:    "missing_name: FAIL",
+			content: `{"tokens": [{"secret_sha256": "` + readerHash + `", "roles": ["read"]}]}`,
+			wantErr: true,
+		},
+		{
+			name: "duplicate_name: FAIL",
+			content: `{"tokens": [{"name": "a", "secret_sha256": "` + readerHash + `", "roles": ["read"]},
+				{"name": "a", "secret_sha256": "` + strings.Repeat("0", 64) + `", "roles": ["read"]}]}`,
+			wantErr: true,
+		},
+		{
+			name: "duplicate_secret: FAIL",
+			content: `{"tokens": [{"name": "a", "secret_sha256": "` + readerHash + `", "roles": ["read"]},
+				{"name": "b", "secret_sha256": "` + readerHash + `", "roles": ["write"]}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "plain_secret: FAIL",
+			content: `{"tokens": [{"name": "a", "secret_sha256": "reader", "roles": ["read"]}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "no_roles: FAIL",
+			content: `{"tokens": [{"name": "a", "secret_sha256": "` + readerHash + `"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown_role: FAIL",
+			content: `{"tokens": [{"name": "a", "secret_sha256": "` + readerHash + `", "roles": ["owner"]}]}`,
+			wantErr: true,
+		},
+		{
+			name: "empty_prefix: FAIL",
+			content: `{"tokens": [{"name": "a", "secret_sha256": "` + readerHash + `", "roles": ["read"],
+				"prefixes": [""]}]}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tokens.json")
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0o600))
+
+			_, err := Load(path)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestAuthenticator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	content := `{"tokens": [{"name": "dashboard", "secret_sha256": "` + strings.ToUpper(readerHash) + `",
+		"roles": ["read"], "prefixes": ["cpu."]}]}`
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	f, err := Load(path)
+	require.NoError(t, err)
+
+	a := New(&models.Config{AdminToken: "secret"}, f)
+	assert.True(t, a.Enabled())
+	assert.True(t, a.Admins())
+
+	reader, err := a.Authenticate("reader")
+	require.NoError(t, err)
+	assert.Equal(t, "dashboard", reader.Name)
+	assert.NoError(t, a.Authorize(reader, RoleRead))
+	assert.ErrorIs(t, a.Authorize(reader, RoleWrite), ErrForbidden)
+	assert.True(t, reader.Allowed("cpu.user"))
+	assert.False(t, reader.Allowed("mem.free"))
+
+	admin, err := a.Authenticate("secret")
+	require.NoError(t, err)
+	for _, role := range []Role{RoleRead, RoleWrite, RoleAdmin} {
+		assert.NoError(t, a.Authorize(admin, role))
+	}
+	assert.True(t, admin.Allowed("mem.free"))
+
+	_, err = a.Authenticate("guess")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = a.Authenticate("")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.ErrorIs(t, a.Authorize(nil, RoleRead), ErrInvalidToken)
+
+	t.Run("context: OK", func(t *testing.T) {
+		assert.True(t, Allowed(context.Background(), "mem.free"))
+		ctx := NewContext(context.Background(), reader)
+		assert.Equal(t, reader, FromContext(ctx))
+		assert.False(t, Allowed(ctx, "mem.free"))
+	})
+
+	t.Run("without_tokens: OK", func(t *testing.T) {
+		a := New(&models.Config{}, nil)
+		assert.False(t, a.Enabled())
+		assert.False(t, a.Admins())
+		assert.NoError(t, a.Authorize(nil, RoleRead))
+		assert.NoError(t, a.Authorize(nil, RoleWrite))
+		assert.True(t, errors.Is(a.Authorize(nil, RoleAdmin), ErrInvalidToken))
+	})
+}
@@ -1,161 +1,528 @@
-// Package config - initialises metric server configuration through flags and env vars, and default settings.
+// Package config - initialises metric server configuration through flags, config file and env vars,
+// and default settings.
 // It instantiatiates zap logger.
 package config
 
 import (
-	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"net"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
+	"sync"
 
+	"github.com/vkupriya/go-metrics/internal/address"
+	"github.com/vkupriya/go-metrics/internal/compression"
+	"github.com/vkupriya/go-metrics/internal/configfile"
+	"github.com/vkupriya/go-metrics/internal/logging"
 	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/tenant"
+	"github.com/vkupriya/go-metrics/internal/server/validation"
+	"github.com/vkupriya/go-metrics/internal/tracing"
 )
 
+// ConfigFile is the config file of the server, it can set every option of flags and env vars.
 type ConfigFile struct {
-	Address         string `json:"address,omitempty"`
-	CryptoKeyFile   string `json:"crypto_key,omitempty"`
-	FileStoragePath string `json:"store_file,omitempty"`
-	PostgresDSN     string `json:"database_dsn,omitempty"`
-	TrustedSubnet   string `json:"trusted_subnet,omitempty"`
-	RestoreMetrics  bool   `json:"restore,omitempty"`
-	StoreInterval   int64  `json:"store_interval,omitempty"`
+	TenantTokens     map[string]string `json:"tenant_tokens,omitempty"`
+	TenantQuotas     map[string]int64  `json:"tenant_quotas,omitempty"`
+	Address          string            `json:"address,omitempty"`
+	GRPCAddress      string            `json:"grpc_address,omitempty"`
+	CryptoKeyFile    string            `json:"crypto_key,omitempty"`
+	HashKey          string            `json:"hash_key,omitempty"`
+	FileStoragePath  string            `json:"store_file,omitempty"`
+	PostgresDSN      string            `json:"database_dsn,omitempty"`
+	TrustedSubnet    string            `json:"trusted_subnet,omitempty"`
+	TrustedProxies   string            `json:"trusted_proxies,omitempty"`
+	AdminToken       string            `json:"admin_token,omitempty"`
+	RulesFile        string            `json:"rules_file,omitempty"`
+	TokensFile       string            `json:"tokens_file,omitempty"`
+	AuditLog         string            `json:"audit_log,omitempty"`
+	NamePattern      string            `json:"name_pattern,omitempty"`
+	SeriesOverflow   string            `json:"series_overflow,omitempty"`
+	CompressionLevel string            `json:"compression_level,omitempty"`
+	tracing.FileConfig
+	logging.FileOptions
+	StoreInterval      configfile.Duration `json:"store_interval,omitempty"`
+	RollupInterval     configfile.Duration `json:"rollup_interval,omitempty"`
+	RulesInterval      configfile.Duration `json:"rules_interval,omitempty"`
+	RetentionRaw       configfile.Duration `json:"retention_raw,omitempty"`
+	Retention1m        configfile.Duration `json:"retention_1m,omitempty"`
+	Retention1h        configfile.Duration `json:"retention_1h,omitempty"`
+	Retention1d        configfile.Duration `json:"retention_1d,omitempty"`
+	SignMaxAge         configfile.Duration `json:"sign_max_age,omitempty"`
+	SelfInterval       configfile.Duration `json:"self_metrics_interval,omitempty"`
+	ConfigWatch        configfile.Duration `json:"config_watch_interval,omitempty"`
+	TenantMaxSeries    int64               `json:"tenant_max_series,omitempty"`
+	MaxTenants         int64               `json:"max_tenants,omitempty"`
+	RateLimit          float64             `json:"rate_limit,omitempty"`
+	RateBurst          int64               `json:"rate_burst,omitempty"`
+	MaxBatchSize       int64               `json:"max_batch_size,omitempty"`
+	AgentMaxSeries     int64               `json:"agent_max_series,omitempty"`
+	MaxNameLength      int64               `json:"max_name_length,omitempty"`
+	MaxSeries          int64               `json:"max_series,omitempty"`
+	CompressionMinSize int64               `json:"compression_min_size,omitempty"`
+	RestoreMetrics     bool                `json:"restore,omitempty"`
+	SignStrict         bool                `json:"sign_strict,omitempty"`
+	GRPCDisabled       bool                `json:"grpc_disabled,omitempty"`
+	TenantDefault      bool                `json:"tenant_default_access,omitempty"`
 }
 
 const (
 	defaultStoreInterval  int64 = 300
 	defaultContextTimeout int64 = 3
+	defaultRollupInterval int64 = 60
+	defaultRulesInterval  int64 = 30
+	defaultSignMaxAge     int64 = 300
+	defaultRateBurst      int64 = 10
+	defaultSelfInterval   int64 = 10
+	defaultCompressionMin int64 = 1024
+	defaultMaxTenants     int64 = 100
+	defaultLogLevel             = "debug"
+	defaultRetentionRaw   int64 = 6 * 60 * 60
+	defaultRetention1m    int64 = 7 * 24 * 60 * 60
+	defaultRetention1h    int64 = 90 * 24 * 60 * 60
+	defaultRetention1d    int64 = 730 * 24 * 60 * 60
 )
 
+// Options of the server: names in config files, flags and env vars.
+var (
+	optConfig          = configfile.Option{Flag: "c", Env: "CONFIG"}
+	optAddress         = configfile.Option{Key: "address", Flag: "a", Env: "ADDRESS"}
+	optGRPCAddress     = configfile.Option{Key: "grpc_address", Flag: "grpc-address", Env: "GRPC_ADDRESS"}
+	optGRPCDisabled    = configfile.Option{Key: "grpc_disabled", Flag: "grpc-disabled", Env: "GRPC_DISABLED"}
+	optStoreInterval   = configfile.Option{Key: "store_interval", Flag: "i", Env: "STORE_INTERVAL"}
+	optStoreFile       = configfile.Option{Key: "store_file", Flag: "f", Env: "FILE_STORAGE_PATH"}
+	optRestore         = configfile.Option{Key: "restore", Flag: "r", Env: "RESTORE"}
+	optDatabaseDSN     = configfile.Option{Key: "database_dsn", Flag: "d", Env: "DATABASE_DSN", Secret: true}
+	optHashKey         = configfile.Option{Key: "hash_key", Flag: "k", Env: "KEY", Secret: true}
+	optCryptoKey       = configfile.Option{Key: "crypto_key", Flag: "cr", Env: "CRYPTO_KEY"}
+	optTrustedSubnet   = configfile.Option{Key: "trusted_subnet", Flag: "t", Env: "TRUSTED_SUBNET"}
+	optTrustedProxies  = configfile.Option{Key: "trusted_proxies", Flag: "trusted-proxies", Env: "TRUSTED_PROXIES"}
+	optAdminToken      = configfile.Option{Key: "admin_token", Flag: "admin-token", Env: "ADMIN_TOKEN", Secret: true}
+	optRollupInterval  = configfile.Option{Key: "rollup_interval", Flag: "rollup-interval", Env: "ROLLUP_INTERVAL"}
+	optRetentionRaw    = configfile.Option{Key: "retention_raw", Flag: "retention-raw", Env: "RETENTION_RAW"}
+	optRetention1m     = configfile.Option{Key: "retention_1m", Flag: "retention-1m", Env: "RETENTION_1M"}
+	optRetention1h     = configfile.Option{Key: "retention_1h", Flag: "retention-1h", Env: "RETENTION_1H"}
+	optRetention1d     = configfile.Option{Key: "retention_1d", Flag: "retention-1d", Env: "RETENTION_1D"}
+	optTenantTokens    = configfile.Option{Key: "tenant_tokens", Flag: "tenant-tokens", Env: "TENANT_TOKENS", Secret: true}
+	optTenantQuotas    = configfile.Option{Key: "tenant_quotas", Flag: "tenant-quotas", Env: "TENANT_QUOTAS"}
+	optTenantMaxSeries = configfile.Option{Key: "tenant_max_series", Flag: "tenant-max-series", Env: "TENANT_MAX_SERIES"}
+	optMaxTenants      = configfile.Option{Key: "max_tenants", Flag: "max-tenants", Env: "MAX_TENANTS"}
+	optRulesFile       = configfile.Option{Key: "rules_file", Flag: "rules", Env: "RULES_FILE"}
+	optRulesInterval   = configfile.Option{Key: "rules_interval", Flag: "rules-interval", Env: "RULES_INTERVAL"}
+	optTokensFile      = configfile.Option{Key: "tokens_file", Flag: "tokens", Env: "TOKENS_FILE"}
+	optAuditLog        = configfile.Option{Key: "audit_log", Flag: "audit-log", Env: "AUDIT_LOG"}
+	optSignStrict      = configfile.Option{Key: "sign_strict", Flag: "sign-strict", Env: "SIGN_STRICT"}
+	optSignMaxAge      = configfile.Option{Key: "sign_max_age", Flag: "sign-max-age", Env: "SIGN_MAX_AGE"}
+	optRateLimit       = configfile.Option{Key: "rate_limit", Flag: "rate-limit", Env: "RATE_LIMIT"}
+	optRateBurst       = configfile.Option{Key: "rate_burst", Flag: "rate-burst", Env: "RATE_BURST"}
+	optMaxBatchSize    = configfile.Option{Key: "max_batch_size", Flag: "max-batch-size", Env: "MAX_BATCH_SIZE"}
+	optAgentMaxSeries  = configfile.Option{Key: "agent_max_series", Flag: "agent-max-series", Env: "AGENT_MAX_SERIES"}
+	optNamePattern     = configfile.Option{Key: "name_pattern", Flag: "name-pattern", Env: "NAME_PATTERN"}
+	optMaxNameLength   = configfile.Option{Key: "max_name_length", Flag: "max-name-length", Env: "MAX_NAME_LENGTH"}
+	optMaxSeries       = configfile.Option{Key: "max_series", Flag: "max-series", Env: "MAX_SERIES"}
+	optSeriesOverflow  = configfile.Option{Key: "series_overflow", Flag: "series-overflow", Env: "SERIES_OVERFLOW"}
+	optSelfInterval    = configfile.Option{
+		Key: "self_metrics_interval", Flag: "self-metrics-interval", Env: "SELF_METRICS_INTERVAL",
+	}
+	optConfigWatch = configfile.Option{
+		Key: "config_watch_interval", Flag: "config-watch-interval", Env: "CONFIG_WATCH_INTERVAL",
+	}
+	optTenantDefault = configfile.Option{
+		Key: "tenant_default_access", Flag: "tenant-default-access", Env: "TENANT_DEFAULT_ACCESS",
+	}
+	optCompressionLevel = configfile.Option{
+		Key: "compression_level", Flag: "compression-level", Env: "COMPRESSION_LEVEL",
+	}
+	optCompressionMinSize = configfile.Option{
+		Key: "compression_min_size", Flag: "compression-min-size", Env: "COMPRESSION_MIN_SIZE",
+	}
+)
+
+// flagValues are values of command line flags. Flags are parsed once and configuration is built
+// from a copy of their values, so config file and env vars override them again on every reload.
+type flagValues struct {
+	a, ga, p, d, k, cr, t, tp, configFile, at, tt, tq, rf, tf, np, so, al, cl string
+	tracing                                                                   tracing.Flags
+	log                                                                       logging.Flags
+	i, ri, rr, rm, rh, rd, rli, sma, si, cw                                   configfile.Duration
+	tm, rb, mbs, ams, mnl, ms, cms, mt                                        int64
+	rl                                                                        float64
+	gd, r, ss, pc, tda                                                        bool
+}
+
+// parseFlags defines and parses command line flags on the first call.
+var parseFlags = sync.OnceValue(func() flagValues {
+	f := flagValues{
+		i:   configfile.Seconds(defaultStoreInterval),
+		ri:  configfile.Seconds(defaultRollupInterval),
+		rr:  configfile.Seconds(defaultRetentionRaw),
+		rm:  configfile.Seconds(defaultRetention1m),
+		rh:  configfile.Seconds(defaultRetention1h),
+		rd:  configfile.Seconds(defaultRetention1d),
+		rli: configfile.Seconds(defaultRulesInterval),
+		sma: configfile.Seconds(defaultSignMaxAge),
+		mt:  defaultMaxTenants,
+		si:  configfile.Seconds(defaultSelfInterval),
+	}
+	flag.StringVar(&f.a, "a", "localhost:8080", "Metric server host address and port or unix:path of Unix socket.")
+	flag.StringVar(&f.ga, "grpc-address", "", "gRPC server address, port 3200 on the host of HTTP address if empty.")
+	flag.BoolVar(&f.gd, "grpc-disabled", false, "Disable gRPC server.")
+	flag.Var(&f.i, "i", "Store interval, 0 sets it to synchronous.")
+	flag.StringVar(&f.p, "f", "/tmp/metrics-db.json", "File storage path.")
+	flag.BoolVar(&f.r, "r", true, "Restore in memory DB at start up.")
+	flag.StringVar(&f.d, "d", "", "PostgreSQL DSN")
+	flag.StringVar(&f.k, "k", "", "Key for HMAC signature.")
+	flag.StringVar(&f.cr, "cr", "", "Path to assymetric crypto private key.")
+	flag.StringVar(&f.t, "t", "", "Accepting metrics from Trusted IP CIDR only.")
+	flag.StringVar(&f.tp, "trusted-proxies", "",
+		"Comma separated CIDRs of proxies whose X-Real-IP header identifies agents.")
+	flag.StringVar(&f.configFile, "c", "", "Path to JSON, YAML or TOML config file, format is chosen by extension.")
+	flag.BoolVar(&f.pc, "print-config", false, "Print effective configuration and sources of its values and exit.")
+	flag.StringVar(&f.at, "admin-token", "", "Bearer token for admin API, admin API is disabled if empty.")
+	flag.Var(&f.ri, "rollup-interval", "History rollup interval, 0 disables rollups.")
+	flag.Var(&f.rr, "retention-raw", "Retention of raw history samples, 0 keeps forever.")
+	flag.Var(&f.rm, "retention-1m", "Retention of 1m history buckets, 0 keeps forever.")
+	flag.Var(&f.rh, "retention-1h", "Retention of 1h history buckets, 0 keeps forever.")
+	flag.Var(&f.rd, "retention-1d", "Retention of 1d history buckets, 0 keeps forever.")
+	flag.StringVar(&f.tt, "tenant-tokens", "", "Comma separated token=tenant pairs of tenant API tokens.")
+	flag.StringVar(&f.tq, "tenant-quotas", "", "Comma separated tenant=count pairs of per tenant series quotas.")
+	flag.Int64Var(&f.tm, "tenant-max-series", 0, "Series quota of tenants without own quota, 0 disables the quota.")
+	flag.Int64Var(&f.mt, "max-tenants", defaultMaxTenants,
+		"Maximum number of tenants besides the default one, 0 disables the limit.")
+	flag.BoolVar(&f.tda, "tenant-default-access", false,
+		"Allow requests without tenant token to the default tenant when tenant tokens are set.")
+	flag.StringVar(&f.rf, "rules", "", "Path to json file of alerting and recording rules, rules are disabled if empty.")
+	flag.Var(&f.rli, "rules-interval", "Rules evaluation interval.")
+	flag.StringVar(&f.tf, "tokens", "", "Path to json file of API tokens, tokens are not required if empty.")
+	flag.StringVar(&f.al, "audit-log", "", "Path to append-only audit log of write and admin operations, "+
+		"operations aren't audited if empty.")
+	flag.BoolVar(&f.ss, "sign-strict", false, "Require HMAC signatures with timestamp and nonce, needs key for HMAC.")
+	flag.Var(&f.sma, "sign-max-age", "Allowed age of request signatures.")
+	flag.Float64Var(&f.rl, "rate-limit", 0, "Write requests per second allowed to each agent, 0 disables the limit.")
+	flag.Int64Var(&f.rb, "rate-burst", defaultRateBurst, "Write requests each agent may send at once.")
+	flag.Int64Var(&f.mbs, "max-batch-size", 0, "Maximum number of metrics in a batch, 0 disables the limit.")
+	flag.Int64Var(&f.ams, "agent-max-series", 0, "Maximum number of series written by each agent, 0 disables the limit.")
+	flag.StringVar(&f.np, "name-pattern", validation.DefaultNamePattern, "Regular expression metric names must match.")
+	flag.Int64Var(&f.mnl, "max-name-length", validation.DefaultMaxNameLength, "Maximum length of metric names in bytes.")
+	flag.Int64Var(&f.ms, "max-series", 0, "Maximum number of series of all tenants, 0 disables the limit.")
+	flag.StringVar(&f.so, "series-overflow", validation.OverflowReject,
+		"Policy of series over max-series: reject or drop.")
+	flag.Var(&f.si, "self-metrics-interval", "Interval of writing self-metrics, 0 disables self-metrics.")
+	flag.StringVar(&f.cl, "compression-level", compression.LevelFastest.String(),
+		"Compression level of responses: fastest, default, better or best.")
+	flag.Int64Var(&f.cms, "compression-min-size", defaultCompressionMin,
+		"Responses smaller than this number of bytes aren't compressed.")
+	logging.AddFlags(&f.log, defaultLogLevel)
+	tracing.AddFlags(&f.tracing)
+	flag.Var(&f.cw, "config-watch-interval", "Interval of checking the config file for changes, 0 disables watching.")
+	flag.Parse()
+	return f
+})
+
+// PrintConfig reports whether the effective configuration should be printed instead of starting the server.
+func PrintConfig() bool {
+	return parseFlags().pc
+}
+
+// NewConfig loads configuration from flags, config file and env vars, in order of precedence.
 func NewConfig() (*models.Config, error) {
+	c, _, err := Load()
+	return c, err
+}
+
+// Load loads configuration like NewConfig and returns settings of every option with the source of its value.
+func Load() (*models.Config, []configfile.Setting, error) {
 	var err error
 	var trustedSubnet *net.IPNet
 
-	a := flag.String("a", "localhost:8080", "Metric server host address and port.")
-	i := flag.Int64("i", defaultStoreInterval, "Store interval in seconds, 0 sets it to synchronous.")
-	p := flag.String("f", "/tmp/metrics-db.json", "File storage path.")
-	r := flag.Bool("r", true, "Restore in memory DB at start up.")
-	d := flag.String("d", "", "PostgreSQL DSN")
-	k := flag.String("k", "", "Key for HMAC signature.")
-	cr := flag.String("cr", "", "Path to assymetric crypto private key.")
-	t := flag.String("t", "", "Accepting metrics from Trusted IP CIDR only.")
-	configFile := flag.String("c", "", "Path to json config file.")
-	flag.Parse()
+	f := parseFlags()
+	l := configfile.NewLoader(flag.CommandLine)
+
+	tenantTokens, err := parsePairs(f.tt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse tenant tokens: %w", err)
+	}
+	tenantQuotas, err := parseQuotas(f.tq)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse tenant quotas: %w", err)
+	}
 
 	cfg := ConfigFile{}
 	privatePEM := make([]byte, 0)
 	secretKey := make([]byte, 0)
 
-	if envConfig, ok := os.LookupEnv("CONFIG"); ok {
-		configFile = &envConfig
+	if err := configfile.Resolve(l, optConfig, &f.configFile, "", configfile.String); err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve config file: %w", err)
+	}
+	if f.configFile != "" {
+		if err := l.Load(f.configFile, &cfg); err != nil {
+			return nil, nil, fmt.Errorf("failed to load config file: %w", err)
+		}
+	}
+
+	err = errors.Join(
+		configfile.Resolve(l, optAddress, &f.a, cfg.Address, configfile.String),
+		configfile.Resolve(l, optGRPCAddress, &f.ga, cfg.GRPCAddress, configfile.String),
+		configfile.Resolve(l, optGRPCDisabled, &f.gd, cfg.GRPCDisabled, configfile.Bool),
+		configfile.Resolve(l, optDatabaseDSN, &f.d, cfg.PostgresDSN, configfile.String),
+		configfile.Resolve(l, optStoreInterval, &f.i, cfg.StoreInterval, configfile.ParseDuration),
+		configfile.Resolve(l, optStoreFile, &f.p, cfg.FileStoragePath, configfile.String),
+		configfile.Resolve(l, optRestore, &f.r, cfg.RestoreMetrics, configfile.Bool),
+		configfile.Resolve(l, optHashKey, &f.k, cfg.HashKey, configfile.String),
+		configfile.Resolve(l, optCryptoKey, &f.cr, cfg.CryptoKeyFile, configfile.String),
+		configfile.Resolve(l, optTrustedSubnet, &f.t, cfg.TrustedSubnet, configfile.String),
+		configfile.Resolve(l, optTrustedProxies, &f.tp, cfg.TrustedProxies, configfile.String),
+		configfile.Resolve(l, optAdminToken, &f.at, cfg.AdminToken, configfile.String),
+		configfile.Resolve(l, optRulesFile, &f.rf, cfg.RulesFile, configfile.String),
+		configfile.Resolve(l, optRulesInterval, &f.rli, cfg.RulesInterval, configfile.ParseDuration),
+		configfile.Resolve(l, optTokensFile, &f.tf, cfg.TokensFile, configfile.String),
+		configfile.Resolve(l, optAuditLog, &f.al, cfg.AuditLog, configfile.String),
+		configfile.Resolve(l, optSignStrict, &f.ss, cfg.SignStrict, configfile.Bool),
+		configfile.Resolve(l, optSignMaxAge, &f.sma, cfg.SignMaxAge, configfile.ParseDuration),
+		configfile.Resolve(l, optRateLimit, &f.rl, cfg.RateLimit, configfile.Float),
+		configfile.Resolve(l, optRateBurst, &f.rb, cfg.RateBurst, configfile.Int),
+		configfile.Resolve(l, optMaxBatchSize, &f.mbs, cfg.MaxBatchSize, configfile.Int),
+		configfile.Resolve(l, optAgentMaxSeries, &f.ams, cfg.AgentMaxSeries, configfile.Int),
+		configfile.Resolve(l, optMaxNameLength, &f.mnl, cfg.MaxNameLength, configfile.Int),
+		configfile.Resolve(l, optMaxSeries, &f.ms, cfg.MaxSeries, configfile.Int),
+		configfile.Resolve(l, optSelfInterval, &f.si, cfg.SelfInterval, configfile.ParseDuration),
+		configfile.Resolve(l, optConfigWatch, &f.cw, cfg.ConfigWatch, configfile.ParseDuration),
+		configfile.Resolve(l, optNamePattern, &f.np, cfg.NamePattern, configfile.String),
+		configfile.Resolve(l, optSeriesOverflow, &f.so, cfg.SeriesOverflow, configfile.String),
+		configfile.Resolve(l, optRollupInterval, &f.ri, cfg.RollupInterval, configfile.ParseDuration),
+		configfile.Resolve(l, optRetentionRaw, &f.rr, cfg.RetentionRaw, configfile.ParseDuration),
+		configfile.Resolve(l, optRetention1m, &f.rm, cfg.Retention1m, configfile.ParseDuration),
+		configfile.Resolve(l, optRetention1h, &f.rh, cfg.Retention1h, configfile.ParseDuration),
+		configfile.Resolve(l, optRetention1d, &f.rd, cfg.Retention1d, configfile.ParseDuration),
+		configfile.Resolve(l, optTenantTokens, &tenantTokens, cfg.TenantTokens, parsePairs),
+		configfile.Resolve(l, optTenantQuotas, &tenantQuotas, cfg.TenantQuotas, parseQuotas),
+		configfile.Resolve(l, optTenantMaxSeries, &f.tm, cfg.TenantMaxSeries, configfile.Int),
+		configfile.Resolve(l, optMaxTenants, &f.mt, cfg.MaxTenants, configfile.Int),
+		configfile.Resolve(l, optTenantDefault, &f.tda, cfg.TenantDefault, configfile.Bool),
+		configfile.Resolve(l, optCompressionLevel, &f.cl, cfg.CompressionLevel, configfile.String),
+		configfile.Resolve(l, optCompressionMinSize, &f.cms, cfg.CompressionMinSize, configfile.Int),
+	)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if *configFile != "" {
-		fcontent, err := os.ReadFile(*configFile)
+	if f.ga == "" {
+		f.ga = address.DefaultGRPC(f.a)
+		l.Update(optGRPCAddress, f.ga)
+	}
+
+	if f.cr != "" {
+		privatePEM, err = os.ReadFile(f.cr)
 		if err != nil {
-			return nil, fmt.Errorf("failed to open config file %s: %w", *configFile, err)
+			return nil, nil, fmt.Errorf("failed to read private key file %s: %w", f.cr, err)
 		}
+	}
 
-		err = json.Unmarshal(fcontent, &cfg)
+	if f.t != "" {
+		_, trustedSubnet, err = net.ParseCIDR(f.t)
 		if err != nil {
-			return nil, fmt.Errorf("failed to unmarshal config file: %w", err)
+			return nil, nil, errors.New("trusted subnet is incorrect format, expected 1.2.3.4/24")
 		}
 	}
 
-	if cfg.Address != "" {
-		a = &cfg.Address
+	trustedProxies, err := parseCIDRs(f.tp)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse trusted proxies: %w", err)
 	}
 
-	if envAddr, ok := os.LookupEnv("ADDRESS"); ok {
-		a = &envAddr
+	if f.ss && f.k == "" {
+		return nil, nil, errors.New("strict signing requires key for HMAC signature")
 	}
 
-	if cfg.PostgresDSN != "" && *d == "" {
-		d = &cfg.PostgresDSN
+	if f.sma <= 0 {
+		return nil, nil, errors.New("allowed age of request signatures must be positive")
 	}
 
-	if envDSN, ok := os.LookupEnv("DATABASE_DSN"); ok {
-		d = &envDSN
+	if f.rl < 0 || f.mbs < 0 || f.ams < 0 || f.ms < 0 || f.si < 0 {
+		return nil, nil, errors.New(
+			"rate limit, batch size, series limits and self-metrics interval must not be negative")
 	}
 
-	if cfg.StoreInterval != 0 {
-		i = &cfg.StoreInterval
+	if f.cw < 0 {
+		return nil, nil, errors.New("config watch interval must not be negative")
 	}
 
-	if envStoreInterval, ok := os.LookupEnv("STORE_INTERVAL"); ok {
-		envStoreInterval, err := strconv.ParseInt(envStoreInterval, 10, 64)
-		if err != nil {
-			return nil, errors.New("failed to convert env var STORE_INTERVAL to integer")
-		}
-		i = &envStoreInterval
+	if f.mt < 0 {
+		return nil, nil, errors.New("maximum number of tenants must not be negative")
 	}
 
-	if cfg.FileStoragePath != "" {
-		p = &cfg.FileStoragePath
+	if f.cms < 0 {
+		return nil, nil, errors.New("minimum compression size must not be negative")
 	}
 
-	if envFileStoragePath, ok := os.LookupEnv("FILE_STORAGE_PATH"); ok {
-		p = &envFileStoragePath
+	compressionLevel, err := compression.ParseLevel(f.cl)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse compression level: %w", err)
 	}
 
-	if cfg.RestoreMetrics != *r {
-		r = &cfg.RestoreMetrics
+	if f.mnl <= 0 {
+		return nil, nil, errors.New("maximum length of metric names must be positive")
 	}
 
-	if envRestore, ok := os.LookupEnv("RESTORE"); ok {
-		envRestore, err := strconv.ParseBool(envRestore)
-		if err != nil {
-			return nil, errors.New("failed to convert env var RESTORE to bool")
-		}
-		r = &envRestore
+	namePattern, err := regexp.Compile(f.np)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid metric name pattern: %w", err)
 	}
 
-	if envKey, ok := os.LookupEnv("KEY"); ok {
-		k = &envKey
+	if f.so != validation.OverflowReject && f.so != validation.OverflowDrop {
+		return nil, nil, fmt.Errorf("unknown series overflow policy '%s', expected reject or drop", f.so)
 	}
 
-	if cfg.CryptoKeyFile != "" {
-		cr = &cfg.CryptoKeyFile
+	if f.rl > 0 && f.rb < 1 {
+		return nil, nil, errors.New("rate burst must be positive if rate limit is set")
 	}
 
-	if envCryptoKey, ok := os.LookupEnv("CRYPTO_KEY"); ok {
-		cr = &envCryptoKey
+	if f.rf != "" && f.rli <= 0 {
+		return nil, nil, errors.New("rules evaluation interval must be positive")
 	}
 
-	if *cr != "" {
-		privatePEM, err = os.ReadFile(*cr)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read private key file %s: %w", *cr, err)
+	for _, name := range tenantTokens {
+		if !tenant.Valid(name) {
+			return nil, nil, fmt.Errorf(
+				"invalid tenant name '%s', expected up to 64 letters, digits, '_' or '-'", name)
 		}
 	}
 
-	if cfg.TrustedSubnet != "" {
-		t = &cfg.TrustedSubnet
+	logConfig, err := logging.Resolve(l, &f.log, &cfg.FileOptions)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to configure logging: %w", err)
+	}
+	tracingConfig, err := tracing.Resolve(l, &f.tracing, &cfg.FileConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to configure tracing: %w", err)
 	}
 
-	if envTrustedSubnet, ok := os.LookupEnv("TRUSTED_SUBNET"); ok {
-		t = &envTrustedSubnet
+	retention := models.Retention{
+		Raw: f.rr.Seconds(), Minute: f.rm.Seconds(), Hour: f.rh.Seconds(), Day: f.rd.Seconds(),
+	}
+	if err := validateRetention(retention); err != nil {
+		return nil, nil, err
+	}
+
+	return &models.Config{
+		Address:            f.a,
+		GRPCAddress:        f.ga,
+		GRPCDisabled:       f.gd,
+		StoreInterval:      f.i.Seconds(),
+		FileStoragePath:    f.p,
+		RestoreMetrics:     f.r,
+		PostgresDSN:        f.d,
+		ContextTimeout:     defaultContextTimeout,
+		HashKey:            f.k,
+		AdminToken:         f.at,
+		CryptoKey:          privatePEM,
+		SecretKey:          secretKey,
+		TrustedSubnet:      trustedSubnet,
+		TrustedProxies:     trustedProxies,
+		RollupInterval:     f.ri.Seconds(),
+		Retention:          retention,
+		TenantTokens:       tenantTokens,
+		TenantQuotas:       tenantQuotas,
+		TenantMaxSeries:    f.tm,
+		MaxTenants:         f.mt,
+		DefaultAccess:      f.tda,
+		RulesFile:          f.rf,
+		RulesInterval:      f.rli.Seconds(),
+		TokensFile:         f.tf,
+		AuditLog:           f.al,
+		SignStrict:         f.ss,
+		SignMaxAge:         f.sma.Seconds(),
+		RateLimit:          f.rl,
+		RateBurst:          f.rb,
+		MaxBatchSize:       f.mbs,
+		AgentMaxSeries:     f.ams,
+		NamePattern:        namePattern,
+		MaxNameLength:      f.mnl,
+		MaxSeries:          f.ms,
+		SeriesOverflow:     f.so,
+		SelfInterval:       f.si.Seconds(),
+		Log:                *logConfig,
+		Tracing:            *tracingConfig,
+		ConfigFile:         f.configFile,
+		ConfigWatch:        f.cw.Seconds(),
+		CompressionLevel:   compressionLevel,
+		CompressionMinSize: f.cms,
+	}, l.Settings(), nil
+}
+
+// parsePairs parses comma separated key=value pairs, empty string results in empty map.
+func parsePairs(s string) (map[string]string, error) {
+	pairs := make(map[string]string)
+	if s == "" {
+		return pairs, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || k == "" || v == "" {
+			return nil, fmt.Errorf("expected key=value pair, got '%s'", pair)
+		}
+		pairs[k] = v
 	}
+	return pairs, nil
+}
 
-	if *t != "" {
-		_, trustedSubnet, err = net.ParseCIDR(*t)
+// parseCIDRs parses comma separated CIDRs, empty string results in no networks.
+func parseCIDRs(s string) ([]*net.IPNet, error) {
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.Split(s, ",")
+	networks := make([]*net.IPNet, 0, len(parts))
+	for _, cidr := range parts {
+		_, n, err := net.ParseCIDR(strings.TrimSpace(cidr))
 		if err != nil {
-			return nil, errors.New("trusted subnet is incorrect format, expected 1.2.3.4/24")
+			return nil, fmt.Errorf("expected CIDR like 10.0.0.0/8, got '%s'", cidr)
 		}
+		networks = append(networks, n)
 	}
+	return networks, nil
+}
 
-	return &models.Config{
-		Address:         *a,
-		StoreInterval:   *i,
-		FileStoragePath: *p,
-		RestoreMetrics:  *r,
-		PostgresDSN:     *d,
-		ContextTimeout:  defaultContextTimeout,
-		HashKey:         *k,
-		CryptoKey:       privatePEM,
-		SecretKey:       secretKey,
-		TrustedSubnet:   trustedSubnet,
-	}, nil
+// parseQuotas parses comma separated tenant=count pairs.
+func parseQuotas(s string) (map[string]int64, error) {
+	pairs, err := parsePairs(s)
+	if err != nil {
+		return nil, err
+	}
+	quotas := make(map[string]int64, len(pairs))
+	for name, v := range pairs {
+		if quotas[name], err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("failed to convert series quota of tenant '%s' to integer", name)
+		}
+	}
+	return quotas, nil
+}
+
+// validateRetention checks that every resolution is kept at least as long as a bucket of the next one,
+// otherwise the latest coarser bucket can't be recalculated from its source.
+func validateRetention(r models.Retention) error {
+	const (
+		minute int64 = 60
+		hour   int64 = 60 * minute
+		day    int64 = 24 * hour
+	)
+	checks := []struct {
+		name  string
+		value int64
+		min   int64
+	}{
+		{"raw", r.Raw, minute},
+		{"1m", r.Minute, hour},
+		{"1h", r.Hour, day},
+	}
+	for _, c := range checks {
+		if c.value != 0 && c.value < c.min {
+			return fmt.Errorf("retention of %s history must be at least %d seconds", c.name, c.min)
+		}
+	}
+	return nil
 }
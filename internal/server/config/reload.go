@@ -0,0 +1,66 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+
+	"github.com/vkupriya/go-metrics/internal/logging"
+	"github.com/vkupriya/go-metrics/internal/server/models"
+)
+
+// Reload loads configuration again from flags, config file and env vars and applies its reloadable
+// settings to the running configuration c at once. If the new configuration is invalid c isn't changed.
+// Names of other settings which have changed are returned, they take effect after restart only.
+func Reload(c *models.Config) ([]string, error) {
+	next, err := NewConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	r := next.Reloadable()
+	c.Reload(&r)
+	return unreloadable(c, next), nil
+}
+
+// unreloadable returns names of settings which differ in the configurations and can't be reloaded.
+func unreloadable(current, next *models.Config) []string {
+	reloadable := reflect.TypeOf(models.Reloadable{})
+	cv, nv := reflect.ValueOf(current).Elem(), reflect.ValueOf(next).Elem()
+
+	var names []string
+	for i := range cv.NumField() {
+		field := cv.Type().Field(i)
+		if !field.IsExported() || field.Name == "Logger" {
+			continue
+		}
+		if _, ok := reloadable.FieldByName(field.Name); ok {
+			continue
+		}
+		a, b := cv.Field(i).Interface(), nv.Field(i).Interface()
+		if field.Name == "Log" {
+			a, b = withoutLevels(current.Log), withoutLevels(next.Log)
+		}
+		if !equal(a, b) {
+			names = append(names, field.Name)
+		}
+	}
+	return names
+}
+
+// withoutLevels returns logging settings without levels of logs, which are reloadable.
+func withoutLevels(c logging.Config) logging.Config {
+	c.Level, c.ComponentLevels = 0, nil
+	return c
+}
+
+// equal compares values of settings, patterns are equal if they have the same source.
+func equal(a, b any) bool {
+	if pa, ok := a.(*regexp.Regexp); ok {
+		pb, _ := b.(*regexp.Regexp)
+		if pa == nil || pb == nil {
+			return pa == pb
+		}
+		return pa.String() == pb.String()
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,127 @@
+// Package dump exports and imports metrics of any metric storage using a versioned dump format.
+//
+// A dump is a JSON document holding current values of gauge and counter metrics of a single tenant:
+//
+//	{
+//	  "version": 1,
+//	  "created_at": "2024-05-01T10:00:00Z",
+//	  "tenant": "team-a",
+//	  "gauges": {"Alloc": 1234.5},
+//	  "counters": {"PollCount": 42},
+//	  "metadata": [{"name": "Alloc", "type": "gauge", "unit": "bytes", "created_at": "2024-05-01T09:00:00Z"}]
+//	}
+//
+// Field "metadata" is optional, units, descriptions and labels listed there are recorded on import.
+// Field "version" is mandatory and is incremented on incompatible changes of the format,
+// dumps of unknown versions are rejected. Field "tenant" is informational, it is empty for the default tenant.
+// Metric history is not included in dumps.
+package dump
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/vkupriya/go-metrics/internal/server/models"
+)
+
+// Version of the dump format written by Write.
+const Version = 1
+
+// Storage is a metric storage metrics are exported from and imported into.
+type Storage interface {
+	GetAllMetrics(c *models.Config) (map[string]float64, map[string]int64, error)
+	UpdateBatch(c *models.Config, g models.Metrics, cr models.Metrics) error
+	GetMetadata(c *models.Config) ([]models.MetricMetadata, error)
+}
+
+// Dump holds metrics of a single tenant.
+type Dump struct {
+	CreatedAt time.Time               `json:"created_at"`
+	Gauges    map[string]float64      `json:"gauges"`
+	Counters  map[string]int64        `json:"counters"`
+	Tenant    string                  `json:"tenant"`
+	Metadata  []models.MetricMetadata `json:"metadata,omitempty"`
+	Version   int                     `json:"version"`
+}
+
+// Export reads all metrics of the storage into a dump.
+func Export(c *models.Config, s Storage, tenant string) (*Dump, error) {
+	gauges, counters, err := s.GetAllMetrics(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metrics: %w", err)
+	}
+	metadata, err := s.GetMetadata(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metric metadata: %w", err)
+	}
+	return &Dump{
+		Version:   Version,
+		CreatedAt: time.Now().UTC(),
+		Tenant:    tenant,
+		Gauges:    gauges,
+		Counters:  counters,
+		Metadata:  metadata,
+	}, nil
+}
+
+// Import writes metrics of the dump into the storage and returns number of imported metrics.
+// Counters are set to dumped values, unless merge is true and dumped values are added to existing counters.
+func Import(c *models.Config, s Storage, d *Dump, merge bool) (int, error) {
+	var current map[string]int64
+	if !merge {
+		var err error
+		if _, current, err = s.GetAllMetrics(c); err != nil {
+			return 0, fmt.Errorf("failed to read existing counters: %w", err)
+		}
+	}
+
+	metadata := make(map[string]models.MetricMetadata, len(d.Metadata))
+	for _, md := range d.Metadata {
+		metadata[md.Name] = md
+	}
+
+	gauges := make(models.Metrics, 0, len(d.Gauges))
+	for name, value := range d.Gauges {
+		md := metadata[name]
+		gauges = append(gauges, models.Metric{
+			ID: name, MType: "gauge", Value: &value, Unit: md.Unit, Description: md.Description, Labels: md.Labels,
+		})
+	}
+	counters := make(models.Metrics, 0, len(d.Counters))
+	for name, value := range d.Counters {
+		delta := value - current[name]
+		md := metadata[name]
+		counters = append(counters, models.Metric{
+			ID: name, MType: "counter", Delta: &delta, Unit: md.Unit, Description: md.Description, Labels: md.Labels,
+		})
+	}
+
+	if err := s.UpdateBatch(c, gauges, counters); err != nil {
+		return 0, fmt.Errorf("failed to write metrics: %w", err)
+	}
+	return len(gauges) + len(counters), nil
+}
+
+// Write encodes the dump as JSON.
+func (d *Dump) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(d); err != nil {
+		return fmt.Errorf("failed to encode dump: %w", err)
+	}
+	return nil
+}
+
+// Read decodes a dump, dumps of unsupported versions are rejected.
+func Read(r io.Reader) (*Dump, error) {
+	var d Dump
+	if err := json.NewDecoder(r).Decode(&d); err != nil {
+		return nil, fmt.Errorf("failed to decode dump: %w", err)
+	}
+	if d.Version != Version {
+		return nil, fmt.Errorf("unsupported dump version %d, expected %d", d.Version, Version)
+	}
+	return &d, nil
+}
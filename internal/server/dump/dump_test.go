@@ -0,0 +1,97 @@
+package dump
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/storage"
+)
+
+func TestExportImport(t *testing.T) {
+	cfg := &models.Config{
+		Logger:         zap.NewNop(),
+		ContextTimeout: 3,
+	}
+
+	src, err := storage.NewMemStorage(cfg)
+	require.NoError(t, err)
+	_, err = src.UpdateGaugeMetric(cfg, "Alloc", 1.5)
+	require.NoError(t, err)
+	_, err = src.UpdateCounterMetric(cfg, "PollCount", 7)
+	require.NoError(t, err)
+	require.NoError(t, src.SetMetadata(cfg, &models.MetricMetadata{Name: "Alloc", MType: "gauge", Unit: "bytes"}))
+
+	d, err := Export(cfg, src, "")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, d.Write(&buf))
+	d, err = Read(&buf)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		existing     int64
+		expected     int64
+		merge        bool
+		expectedSize int
+	}{
+		{name: "import_into_empty: OK", expected: 7, expectedSize: 2},
+		{name: "replace_counter: OK", existing: 5, expected: 7, expectedSize: 2},
+		{name: "merge_counter: OK", existing: 5, merge: true, expected: 12, expectedSize: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst, err := storage.NewMemStorage(cfg)
+			require.NoError(t, err)
+			if tt.existing != 0 {
+				_, err = dst.UpdateCounterMetric(cfg, "PollCount", tt.existing)
+				require.NoError(t, err)
+			}
+
+			n, err := Import(cfg, dst, d, tt.merge)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedSize, n)
+
+			v, _, err := dst.GetCounterMetric(cfg, "PollCount")
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, v)
+			g, _, err := dst.GetGaugeMetric(cfg, "Alloc")
+			require.NoError(t, err)
+			assert.Equal(t, 1.5, g)
+			mds, err := dst.GetMetadata(cfg)
+			require.NoError(t, err)
+			require.Len(t, mds, 2)
+			assert.Equal(t, "bytes", mds[0].Unit)
+		})
+	}
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr bool
+	}{
+		{name: "version_1: OK", body: `{"version": 1, "gauges": {"Alloc": 1}, "counters": {}}`},
+		{name: "unknown_version: FAIL", body: `{"version": 2}`, wantErr: true},
+		{name: "missing_version: FAIL", body: `{"gauges": {}}`, wantErr: true},
+		{name: "malformed: FAIL", body: `{"version": 1`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Read(strings.NewReader(tt.body))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
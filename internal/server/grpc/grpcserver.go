@@ -4,18 +4,35 @@ import (
 	// ...
 
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"net"
-	"strings"
+	"math"
 	"sync"
+	"time"
 
 	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/logging"
+	"github.com/vkupriya/go-metrics/internal/address"
+	logs "github.com/vkupriya/go-metrics/internal/logging"
 	pb "github.com/vkupriya/go-metrics/internal/proto"
+	"github.com/vkupriya/go-metrics/internal/requestid"
+	"github.com/vkupriya/go-metrics/internal/server/audit"
+	"github.com/vkupriya/go-metrics/internal/server/auth"
 	ic "github.com/vkupriya/go-metrics/internal/server/grpc/interceptors"
-	_ "google.golang.org/grpc/encoding/gzip"
+	"github.com/vkupriya/go-metrics/internal/server/health"
+	"github.com/vkupriya/go-metrics/internal/server/ratelimit"
+	"github.com/vkupriya/go-metrics/internal/server/selfmetrics"
+	"github.com/vkupriya/go-metrics/internal/server/storage"
+	"github.com/vkupriya/go-metrics/internal/server/tenant"
+	"github.com/vkupriya/go-metrics/internal/server/validation"
+	"github.com/vkupriya/go-metrics/internal/tracing"
 
+	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 
 	"github.com/vkupriya/go-metrics/internal/server/models"
 )
@@ -24,27 +41,152 @@ type Storage interface {
 	UpdateGaugeMetric(c *models.Config, name string, value float64) (float64, error)
 	UpdateCounterMetric(c *models.Config, name string, value int64) (int64, error)
 	UpdateBatch(c *models.Config, g models.Metrics, cr models.Metrics) error
+	DeleteMetric(c *models.Config, mtype, name string) (bool, error)
+	ResetCounter(c *models.Config, name string) (bool, error)
+	DeleteMetrics(c *models.Config, prefix string) (int64, error)
+	SetMetadata(c *models.Config, md *models.MetricMetadata) error
 	Close()
 }
 
+// HealthComponent is the name of the gRPC server in health reports.
+const HealthComponent = "grpc"
+
+// healthInterval is the interval of updating serving status of the gRPC health service.
+const healthInterval = 5 * time.Second
+
+var (
+	errNotListening = errors.New("gRPC server is not listening")
+	errStopped      = errors.New("gRPC server is stopped")
+)
+
+// StorageProvider returns metric storage of the tenant for the call of the context.
+type StorageProvider func(ctx context.Context, tenant string) (Storage, error)
+
 type MetricServer struct {
 	pb.UnimplementedMetricsServer
-	Store  Storage
-	config *models.Config
+	Stores    StorageProvider
+	limiter   *ratelimit.Limiter
+	validator *validation.Validator
+	config    *models.Config
+}
+
+// logger returns logger of the gRPC server, log lines carry ID of the call.
+func (m *MetricServer) logger(ctx context.Context) *zap.Logger {
+	logger := m.config.Logger.Named(logs.ComponentGRPC)
+	if id := requestid.FromContext(ctx); id != "" {
+		return logger.With(zap.String(requestid.LogKey, id))
+	}
+	return logger
+}
+
+// store returns storage of the call tenant.
+func (m *MetricServer) store(ctx context.Context) (Storage, error) {
+	s, err := m.Stores(ctx, tenant.FromContext(ctx))
+	if err != nil {
+		m.logger(ctx).Sugar().Errorf("grpc: failed to get tenant storage: %v", err)
+		if errors.Is(err, tenant.ErrTooManyTenants) {
+			return nil, status.Errorf(codes.ResourceExhausted, "tenant storage is not available: %v", err)
+		}
+		return nil, status.Errorf(codes.NotFound, "tenant storage is not available: %v", err)
+	}
+	return s, nil
+}
+
+// checkAccess returns PermissionDenied status if API token of the call has no access to the metric.
+func checkAccess(ctx context.Context, name string) error {
+	if !auth.Allowed(ctx, name) {
+		return status.Errorf(codes.PermissionDenied, "API token has no access to metric '%s'", name)
+	}
+	return nil
+}
+
+// admitSeries checks that writing the series keeps the agent and the server within their series limits.
+// ResourceExhausted status is returned for a rejected series, dropped is true for a series dropped
+// by the overflow policy of the server. The returned function stops counting the series if it's new,
+// it's called if the write fails.
+func (m *MetricServer) admitSeries(ctx context.Context, mtype, name string) (release func(), dropped bool,
+	err error) {
+	tenantName := tenant.FromContext(ctx)
+	agent, key := ic.AgentIdentity(ctx, m.config), ratelimit.SeriesKey(tenantName, mtype, name)
+	agentAdded, err := m.limiter.AdmitSeries(agent, key)
+	if err != nil {
+		return nil, false, status.Errorf(codes.ResourceExhausted, "failed to update metric: %v", err)
+	}
+	releaseAgent := func() {
+		if agentAdded {
+			m.limiter.ForgetSeries(agent, key)
+		}
+	}
+	added, err := m.validator.Admit(tenantName, mtype, name)
+	if err != nil {
+		releaseAgent()
+	}
+	switch {
+	case errors.Is(err, validation.ErrDropped):
+		return nil, true, nil
+	case err != nil:
+		return nil, false, status.Errorf(codes.ResourceExhausted, "failed to update metric: %v", err)
+	}
+	return func() {
+		releaseAgent()
+		if added {
+			m.validator.Forget(tenantName, mtype, name)
+		}
+	}, false, nil
+}
+
+// updateError converts metric update error into call status.
+func updateError(err error, msg string) error {
+	switch {
+	case errors.Is(err, storage.ErrSeriesQuota):
+		return status.Errorf(codes.ResourceExhausted, "%s: %v", msg, err)
+	case errors.Is(err, storage.ErrTypeConflict):
+		return status.Errorf(codes.FailedPrecondition, "%s: %v", msg, err)
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
+
+// rejected reports whether the metric update was rejected rather than failed.
+func rejected(err error) bool {
+	return errors.Is(err, storage.ErrSeriesQuota) || errors.Is(err, storage.ErrTypeConflict)
 }
 
 func (m *MetricServer) UpdateMetric(ctx context.Context, in *pb.UpdateMetricRequest) (*pb.UpdateMetricResponse, error) {
+	store, err := m.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var response pb.UpdateMetricResponse
 
-	modelMetric, err := protoToMetric(in.GetMetric())
+	if err := checkAccess(ctx, in.GetMetric().GetId()); err != nil {
+		return nil, err
+	}
+
+	modelMetric, err := m.protoToMetric(in.GetMetric())
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert proto Metric into model Metric: %w", err)
+		return nil, err
+	}
+	release, dropped, err := m.admitSeries(ctx, modelMetric.MType, modelMetric.ID)
+	if err != nil {
+		return nil, err
+	}
+	if dropped {
+		return &pb.UpdateMetricResponse{Metric: in.GetMetric()}, nil
 	}
 	switch modelMetric.MType {
 	case "gauge":
-		gaugeValue, err := m.Store.UpdateGaugeMetric(m.config, in.GetMetric().GetId(), in.GetMetric().GetGauge())
+		gaugeValue, err := store.UpdateGaugeMetric(m.config, in.GetMetric().GetId(), in.GetMetric().GetGauge())
+		if err != nil {
+			release()
+		}
+		if rejected(err) {
+			return nil, updateError(err, "failed to update gauge metric")
+		}
 		if err != nil {
 			response.Error = "failed to update gauge metric: " + in.GetMetric().GetId()
+		} else {
+			audit.FromContext(ctx).Add(1)
 		}
 		response.Metric = &pb.Metric{
 			Id:    in.GetMetric().GetId(),
@@ -55,9 +197,17 @@ func (m *MetricServer) UpdateMetric(ctx context.Context, in *pb.UpdateMetricRequ
 		return &response, nil
 
 	case "counter":
-		counterValue, err := m.Store.UpdateCounterMetric(m.config, in.GetMetric().GetId(), in.GetMetric().GetDelta())
+		counterValue, err := store.UpdateCounterMetric(m.config, in.GetMetric().GetId(), in.GetMetric().GetDelta())
+		if err != nil {
+			release()
+		}
+		if rejected(err) {
+			return nil, updateError(err, "failed to update counter metric")
+		}
 		if err != nil {
 			response.Error = "failed to update counter metric: " + in.GetMetric().GetId()
+		} else {
+			audit.FromContext(ctx).Add(1)
 		}
 		response.Metric = &pb.Metric{
 			Id:    in.GetMetric().GetId(),
@@ -65,12 +215,31 @@ func (m *MetricServer) UpdateMetric(ctx context.Context, in *pb.UpdateMetricRequ
 			Delta: counterValue,
 		}
 	}
+	if response.GetError() == "" && (modelMetric.Unit != "" || modelMetric.Description != "" ||
+		len(modelMetric.Labels) > 0) {
+		md := models.MetricMetadata{
+			Name:        modelMetric.ID,
+			MType:       modelMetric.MType,
+			Unit:        modelMetric.Unit,
+			Description: modelMetric.Description,
+			Labels:      modelMetric.Labels,
+		}
+		if err := store.SetMetadata(m.config, &md); err != nil {
+			m.logger(ctx).Sugar().Errorf("grpc: failed to set metric metadata: %v", err)
+			return nil, updateError(err, "failed to set metric metadata")
+		}
+	}
 	return &response, nil
 }
 
 func (m *MetricServer) UpdateMetrics(ctx context.Context, in *pb.UpdateMetricsRequest) (*pb.UpdateMetricsResponse,
 	error) {
-	logger := m.config.Logger
+	store, err := m.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := m.logger(ctx)
 	var response pb.UpdateMetricsResponse
 
 	var (
@@ -78,11 +247,38 @@ func (m *MetricServer) UpdateMetrics(ctx context.Context, in *pb.UpdateMetricsRe
 		counter models.Metrics
 	)
 
+	selfmetrics.ObserveSize("grpc.batch_size", len(in.GetMetric()))
+	if err := m.limiter.CheckBatch(len(in.GetMetric())); err != nil {
+		return nil, status.Errorf(codes.ResourceExhausted, "failed to update metric batch: %v", err)
+	}
+
+	// Series admitted for the batch are released unless the batch is stored.
+	var releases []func()
+	stored := false
+	defer func() {
+		if !stored {
+			for _, release := range releases {
+				release()
+			}
+		}
+	}()
+
 	for _, metric := range in.GetMetric() {
-		modelMetric, err := protoToMetric(metric)
+		if err := checkAccess(ctx, metric.GetId()); err != nil {
+			return nil, err
+		}
+		modelMetric, err := m.protoToMetric(metric)
+		if err != nil {
+			return nil, err
+		}
+		release, dropped, err := m.admitSeries(ctx, modelMetric.MType, modelMetric.ID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to convert proto Metric into model Metric: %w", err)
+			return nil, err
+		}
+		if dropped {
+			continue
 		}
+		releases = append(releases, release)
 		switch modelMetric.MType {
 		case "gauge":
 			gauge = append(gauge, modelMetric)
@@ -91,79 +287,220 @@ func (m *MetricServer) UpdateMetrics(ctx context.Context, in *pb.UpdateMetricsRe
 		}
 	}
 
-	err := m.Store.UpdateBatch(m.config, gauge, counter)
+	err = store.UpdateBatch(m.config, gauge, counter)
 	if err != nil {
 		logger.Sugar().Error("grpc: failed to update metric batch")
-		return nil, fmt.Errorf("failed to update metric batch: %w", err)
+		return nil, updateError(err, "failed to update metric batch")
 	}
+	stored = true
+	audit.FromContext(ctx).Add(int64(len(gauge) + len(counter)))
 
 	return &response, nil
 }
 
-func protoToMetric(pm *pb.Metric) (models.Metric, error) {
-	var mtype string
-	switch pm.GetMtype() {
+func (m *MetricServer) DeleteMetric(ctx context.Context, in *pb.DeleteMetricRequest) (*pb.DeleteMetricResponse,
+	error) {
+	store, err := m.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := m.logger(ctx)
+
+	mtype, err := protoToType(in.GetMtype())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid metric type: %v", err)
+	}
+	if err := checkAccess(ctx, in.GetId()); err != nil {
+		return nil, err
+	}
+
+	ok, err := store.DeleteMetric(m.config, mtype, in.GetId())
+	if err != nil {
+		logger.Sugar().Errorf("grpc: failed to delete metric: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to delete %s metric: %s", mtype, in.GetId())
+	}
+	if ok {
+		m.validator.Forget(tenant.FromContext(ctx), mtype, in.GetId())
+		audit.FromContext(ctx).Add(1, in.GetId())
+		logger.Sugar().Infow("grpc: metric deleted", "type", mtype, "name", in.GetId())
+	}
+
+	return &pb.DeleteMetricResponse{Deleted: ok}, nil
+}
+
+func (m *MetricServer) ResetCounter(ctx context.Context, in *pb.ResetCounterRequest) (*pb.ResetCounterResponse,
+	error) {
+	store, err := m.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := m.logger(ctx)
+
+	if err := checkAccess(ctx, in.GetId()); err != nil {
+		return nil, err
+	}
+
+	ok, err := store.ResetCounter(m.config, in.GetId())
+	if err != nil {
+		logger.Sugar().Errorf("grpc: failed to reset counter metric: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to reset counter metric: %s", in.GetId())
+	}
+	if ok {
+		audit.FromContext(ctx).Add(1, in.GetId())
+		logger.Sugar().Infow("grpc: counter metric reset", "name", in.GetId())
+	}
+
+	return &pb.ResetCounterResponse{Reset_: ok}, nil
+}
+
+func (m *MetricServer) DeleteMetrics(ctx context.Context, in *pb.DeleteMetricsRequest) (*pb.DeleteMetricsResponse,
+	error) {
+	store, err := m.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := m.logger(ctx)
+
+	if in.GetPrefix() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "missing metric name prefix")
+	}
+	if err := checkAccess(ctx, in.GetPrefix()); err != nil {
+		return nil, err
+	}
+	audit.FromContext(ctx).SetPrefix(in.GetPrefix())
+
+	n, err := store.DeleteMetrics(m.config, in.GetPrefix())
+	if err != nil {
+		logger.Sugar().Errorf("grpc: failed to delete metrics: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to delete metrics with prefix: %s", in.GetPrefix())
+	}
+	m.validator.ForgetPrefix(tenant.FromContext(ctx), in.GetPrefix())
+	audit.FromContext(ctx).Add(n)
+	logger.Sugar().Infow("grpc: metrics deleted", "prefix", in.GetPrefix(), "count", n)
+
+	return &pb.DeleteMetricsResponse{Deleted: n}, nil
+}
+
+func protoToType(t pb.Mtype) (string, error) {
+	switch t {
 	case pb.Mtype_gauge:
-		mtype = "gauge"
+		return "gauge", nil
 	case pb.Mtype_counter:
-		mtype = "counter"
+		return "counter", nil
 	default:
-		return models.Metric{}, fmt.Errorf("unknown metric type: %s", pm.GetMtype())
+		return "", fmt.Errorf("unknown metric type: %s", t)
+	}
+}
+
+// protoToMetric returns model of the metric, InvalidArgument status is returned for invalid metrics.
+func (m *MetricServer) protoToMetric(pm *pb.Metric) (models.Metric, error) {
+	mtype, err := protoToType(pm.GetMtype())
+	if err != nil {
+		return models.Metric{}, status.Errorf(codes.InvalidArgument, "invalid metric type: %v", err)
+	}
+	if err := m.validator.Name(pm.GetId()); err != nil {
+		return models.Metric{}, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	if err := m.validator.Metadata(pm.GetUnit(), pm.GetDescription(), pm.GetLabels()); err != nil {
+		return models.Metric{}, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	if pm.GetMtype() == pb.Mtype_gauge && (math.IsNaN(pm.GetGauge()) || math.IsInf(pm.GetGauge(), 0)) {
+		return models.Metric{}, status.Errorf(codes.InvalidArgument, "gauge value of metric '%s' must be a finite number",
+			pm.GetId())
 	}
 
 	return models.Metric{
-		Delta: &pm.Delta,
-		Value: &pm.Gauge,
-		ID:    pm.GetId(),
-		MType: mtype,
+		Delta:       &pm.Delta,
+		Value:       &pm.Gauge,
+		ID:          pm.GetId(),
+		MType:       mtype,
+		Unit:        pm.GetUnit(),
+		Description: pm.GetDescription(),
+		Labels:      pm.GetLabels(),
 	}, nil
 }
 
-func Run(ctx context.Context, s Storage, c *models.Config) error {
-	logger := c.Logger
-	hostport := strings.Replace(c.Address, "http://", "", 1)
-	grpcHost := strings.Split(hostport, ":")[0]
-	if grpcHost == "" || grpcHost == "localhost" {
-		grpcHost = "127.0.0.1"
-	}
-	grpcHost += ":3200"
-	listen, err := net.Listen("tcp", grpcHost)
+// Run serves gRPC API until the context is cancelled, API tokens are authenticated by a, ingestion
+// of agents is limited by l, written metrics are validated by v and write and admin calls are recorded
+// in audit log al. State of the server is reported to h and grpc.health.v1 service reports readiness
+// of all components of h.
+func Run(ctx context.Context, s StorageProvider, c *models.Config, a *auth.Authenticator,
+	l *ratelimit.Limiter, v *validation.Validator, al *audit.Log, h *health.Health) error {
+	logger := c.Logger.Named(logs.ComponentGRPC)
+	h.Set(HealthComponent, "", errNotListening)
+	listen, err := address.Listen(c.GRPCAddress)
 	if err != nil {
-		return fmt.Errorf("failed to set up listener on port 3200: %w", err)
+		err = fmt.Errorf("failed to set up gRPC listener: %w", err)
+		h.Set(HealthComponent, "", err)
+		return err
 	}
 
 	loggerOpts := []logging.Option{
 		logging.WithLogOnEvents(logging.StartCall, logging.FinishCall),
+		logging.WithFieldsFromContext(ic.RequestIDFields),
 	}
 
 	interceptors := make([]grpc.ServerOption, 0)
 
 	interceptors = append(interceptors, grpc.ChainUnaryInterceptor(
-		ic.TrustedSubnetInterceptor(c.TrustedSubnet),
+		tracing.UnaryServerInterceptor(),
+		ic.RequestIDInterceptor(),
+		ic.InstrumentInterceptor(),
+		ic.TrustedSubnetInterceptor(c),
+		ic.SignatureInterceptor(c, pb.Metrics_UpdateMetrics_FullMethodName),
+		ic.TenantInterceptor(c, healthpb.Health_Check_FullMethodName),
+		ic.AuthInterceptor(a, map[string]auth.Role{
+			pb.Metrics_UpdateMetric_FullMethodName:  auth.RoleWrite,
+			pb.Metrics_UpdateMetrics_FullMethodName: auth.RoleWrite,
+			pb.Metrics_DeleteMetric_FullMethodName:  auth.RoleAdmin,
+			pb.Metrics_ResetCounter_FullMethodName:  auth.RoleAdmin,
+			pb.Metrics_DeleteMetrics_FullMethodName: auth.RoleAdmin,
+			healthpb.Health_Check_FullMethodName:    ic.Public,
+		}),
+		ic.AuditInterceptor(c, al, logger, map[string]string{
+			pb.Metrics_UpdateMetric_FullMethodName:  audit.OpUpdate,
+			pb.Metrics_UpdateMetrics_FullMethodName: audit.OpUpdateBatch,
+			pb.Metrics_DeleteMetric_FullMethodName:  audit.OpDeleteMetric,
+			pb.Metrics_ResetCounter_FullMethodName:  audit.OpResetCounter,
+			pb.Metrics_DeleteMetrics_FullMethodName: audit.OpDeleteMetrics,
+		}),
+		ic.RateLimitInterceptor(c, l, pb.Metrics_UpdateMetric_FullMethodName, pb.Metrics_UpdateMetrics_FullMethodName),
 		logging.UnaryServerInterceptor(ic.InterceptorLogger(logger), loggerOpts...),
 	))
 
 	srv := grpc.NewServer(interceptors...)
 
 	pb.RegisterMetricsServer(srv, &MetricServer{
-		Store:  s,
-		config: c,
+		Stores:    s,
+		limiter:   l,
+		validator: v,
+		config:    c,
 	})
 
+	hs := grpchealth.NewServer()
+	healthpb.RegisterHealthServer(srv, hs)
+
 	wg := sync.WaitGroup{}
 	wg.Add(1)
 
 	go func() {
 		<-ctx.Done()
 
-		log.Printf("got signal %v, attempting graceful shutdown", s)
+		log.Printf("got signal %v, attempting graceful shutdown", context.Cause(ctx))
 
+		h.Set(HealthComponent, "", errStopped)
+		hs.Shutdown()
 		srv.GracefulStop()
 
 		wg.Done()
 	}()
 
-	logger.Sugar().Infow("gRPC server is starting", "Address", grpcHost)
+	logger.Sugar().Infow("gRPC server is starting", "Address", c.GRPCAddress)
+	h.Set(HealthComponent, "listening on "+c.GRPCAddress, nil)
+	go watchHealth(ctx, h, hs)
 
 	if err := srv.Serve(listen); err != nil {
 		logger.Sugar().Fatal(err)
@@ -171,3 +508,25 @@ func Run(ctx context.Context, s Storage, c *models.Config) error {
 	}
 	return nil
 }
+
+// watchHealth sets serving status of the gRPC health service from readiness of components until
+// the context is cancelled.
+func watchHealth(ctx context.Context, h *health.Health, hs *grpchealth.Server) {
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+
+	for {
+		servingStatus := healthpb.HealthCheckResponse_SERVING
+		if report := h.Check(ctx); !report.Ready() {
+			servingStatus = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		hs.SetServingStatus("", servingStatus)
+		hs.SetServingStatus(pb.Metrics_ServiceDesc.ServiceName, servingStatus)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package interceptors
+
+import (
+	"context"
+	"net"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/realip"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/vkupriya/go-metrics/internal/requestid"
+	"github.com/vkupriya/go-metrics/internal/server/audit"
+	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/tenant"
+)
+
+// AuditInterceptor records calls of the methods in the audit log when they end, methods map full method
+// names to audited operations. Handlers add touched metrics to the entry of the call context.
+// Calls aren't audited if the audit log isn't configured.
+func AuditInterceptor(c *models.Config, l *audit.Log, logger *zap.Logger,
+	methods map[string]string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		op, ok := methods[info.FullMethod]
+		if !ok || !l.Enabled() {
+			return handler(ctx, req)
+		}
+
+		e := &audit.Entry{
+			RequestID: requestid.FromContext(ctx),
+			Operation: op,
+			Protocol:  audit.ProtocolGRPC,
+			Tenant:    tenant.FromContext(ctx),
+			Principal: AgentIdentity(ctx, c),
+		}
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			e.RealIP = firstValue(md, realip.XRealIp)
+		}
+		if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+			e.IP = p.Addr.String()
+			if host, _, err := net.SplitHostPort(e.IP); err == nil {
+				e.IP = host
+			}
+		}
+
+		resp, err := handler(audit.NewContext(ctx, e), req)
+
+		code := status.Code(err)
+		e.Status = code.String()
+		switch code {
+		case codes.OK:
+			e.Result = audit.ResultOK
+		case codes.Internal, codes.Unknown, codes.Unavailable, codes.DataLoss:
+			e.Result = audit.ResultFailed
+		default:
+			e.Result = audit.ResultRejected
+		}
+		if err := l.Record(e); err != nil {
+			logger.Error("failed to record audit entry", zap.String(requestid.LogKey, e.RequestID), zap.Error(err))
+		}
+		return resp, err
+	}
+}
@@ -0,0 +1,54 @@
+package interceptors
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/vkupriya/go-metrics/internal/server/auth"
+)
+
+// Public is the role of methods which don't require authentication, e.g. health checks.
+const Public auth.Role = ""
+
+// AuthInterceptor authenticates 'authorization: Bearer <token>' metadata and requires the role of the called
+// method from roles, methods missing in roles require admin role. Authenticated principal is stored in call context.
+func AuthInterceptor(a *auth.Authenticator, roles map[string]auth.Role) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		var p *auth.Principal
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if header := firstValue(md, "authorization"); header != "" {
+				token, _ := strings.CutPrefix(header, "Bearer ")
+				var err error
+				if p, err = a.Authenticate(token); err != nil {
+					return nil, status.Error(codes.Unauthenticated, err.Error())
+				}
+				ctx = auth.NewContext(ctx, p)
+			}
+		}
+
+		role, ok := roles[info.FullMethod]
+		if !ok {
+			role = auth.RoleAdmin
+		}
+		if role == Public {
+			return handler(ctx, req)
+		}
+		if role == auth.RoleAdmin && !a.Admins() {
+			return nil, status.Error(codes.PermissionDenied, "admin API is disabled")
+		}
+		if err := a.Authorize(p, role); err != nil {
+			if errors.Is(err, auth.ErrForbidden) {
+				return nil, status.Error(codes.PermissionDenied, err.Error())
+			}
+			return nil, status.Error(codes.Unauthenticated, "API token is required")
+		}
+
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,27 @@
+package interceptors
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	"github.com/vkupriya/go-metrics/internal/server/selfmetrics"
+)
+
+// InstrumentInterceptor records calls in self-metrics per RPC: grpc.<method>.requests,
+// grpc.<method>.code_<code> and latency histogram grpc.<method>.duration.
+func InstrumentInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+
+		name := "grpc." + path.Base(info.FullMethod)
+		selfmetrics.Inc(name + ".requests")
+		selfmetrics.Inc(name + ".code_" + status.Code(err).String())
+		selfmetrics.ObserveDuration(name+".duration", time.Since(start))
+		return resp, err
+	}
+}
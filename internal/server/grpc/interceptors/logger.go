@@ -2,22 +2,36 @@ package interceptors
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/logging"
 	"go.uber.org/zap"
 )
 
+// InterceptorLogger adapts zap logger to the logging interceptor, fields of the interceptor are logged
+// as structured fields.
 func InterceptorLogger(l *zap.Logger) logging.Logger {
 	return logging.LoggerFunc(func(ctx context.Context, lev logging.Level, msg string, fields ...any) {
+		// Fields are key and value pairs.
+		const pair = 2
+		f := make([]zap.Field, 0, len(fields)/pair)
+		for i := 0; i+1 < len(fields); i += pair {
+			key, ok := fields[i].(string)
+			if !ok {
+				key = fmt.Sprint(fields[i])
+			}
+			f = append(f, zap.Any(key, fields[i+1]))
+		}
+
 		switch lev {
 		case logging.LevelDebug:
-			l.Debug(msg)
+			l.Debug(msg, f...)
 		case logging.LevelInfo:
-			l.Info(msg)
+			l.Info(msg, f...)
 		case logging.LevelWarn:
-			l.Warn(msg)
+			l.Warn(msg, f...)
 		case logging.LevelError:
-			l.Error(msg)
+			l.Error(msg, f...)
 		default:
 			l.Sugar().Errorf("grpc logger: unknown level %v", lev)
 		}
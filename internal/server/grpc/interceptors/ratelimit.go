@@ -0,0 +1,60 @@
+package interceptors
+
+import (
+	"context"
+	"crypto/tls"
+	"slices"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/realip"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/vkupriya/go-metrics/internal/server/auth"
+	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/ratelimit"
+)
+
+// RateLimitInterceptor rejects calls of the methods by agents exceeding their request rate with
+// ResourceExhausted status, RetryInfo detail of the status tells when the agent may call again.
+func RateLimitInterceptor(c *models.Config, l *ratelimit.Limiter, methods ...string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !slices.Contains(methods, info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		delay, err := l.Allow(AgentIdentity(ctx, c))
+		if err != nil {
+			st := status.New(codes.ResourceExhausted, err.Error())
+			if ds, derr := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(delay)}); derr == nil {
+				st = ds
+			}
+			return nil, st.Err()
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// AgentIdentity returns identity of the calling agent, see ratelimit.Identity.
+func AgentIdentity(ctx context.Context, c *models.Config) string {
+	var realIP, addr string
+	var state *tls.ConnectionState
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		realIP = firstValue(md, realip.XRealIp)
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if p.Addr != nil {
+			addr = p.Addr.String()
+		}
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			state = &tlsInfo.State
+		}
+	}
+	return ratelimit.Identity(c, auth.FromContext(ctx), state, realIP, addr)
+}
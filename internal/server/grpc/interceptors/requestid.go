@@ -0,0 +1,38 @@
+package interceptors
+
+import (
+	"context"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/logging"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/vkupriya/go-metrics/internal/requestid"
+)
+
+// RequestIDInterceptor identifies the call by valid x-request-id metadata of the client or by a new ID,
+// stores it in call context and echoes it in x-request-id response header.
+func RequestIDInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		var id string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			id = firstValue(md, requestid.MetadataKey)
+		}
+		id = requestid.Resolve(id)
+		// Header can't be set outside of a call of the server, e.g. in tests calling the interceptor.
+		_ = grpc.SetHeader(ctx, metadata.Pairs(requestid.MetadataKey, id))
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("request.id", id))
+
+		return handler(requestid.NewContext(ctx, id), req)
+	}
+}
+
+// RequestIDFields returns request ID of the call as fields of the logging interceptor.
+func RequestIDFields(ctx context.Context) logging.Fields {
+	if id := requestid.FromContext(ctx); id != "" {
+		return logging.Fields{requestid.LogKey, id}
+	}
+	return nil
+}
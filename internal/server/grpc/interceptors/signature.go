@@ -0,0 +1,60 @@
+package interceptors
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/selfmetrics"
+	"github.com/vkupriya/go-metrics/internal/signing"
+)
+
+// SignatureInterceptor verifies HMAC signatures of calls of the methods, see package signing.
+// Unsigned calls are accepted unless strict signing is configured.
+func SignatureInterceptor(c *models.Config, methods ...string) grpc.UnaryServerInterceptor {
+	verifier := signing.NewVerifier(c.SignMaxAge)
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		key := c.Reloadable().HashKey
+		if key == "" || !slices.Contains(methods, info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		var sig, timestamp, nonce string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			sig = firstValue(md, strings.ToLower(signing.HeaderSignature))
+			timestamp = firstValue(md, strings.ToLower(signing.HeaderTimestamp))
+			nonce = firstValue(md, strings.ToLower(signing.HeaderNonce))
+		}
+		if sig == "" && !c.SignStrict {
+			return handler(ctx, req)
+		}
+
+		msg, ok := req.(proto.Message)
+		if !ok {
+			return nil, status.Error(codes.Internal, "request is not a protobuf message")
+		}
+		body, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+		if err != nil {
+			return nil, status.Error(codes.Internal, "failed to marshal request")
+		}
+
+		if err := verifier.Verify(key, http.MethodPost, info.FullMethod, sig, timestamp, nonce, body); err != nil {
+			selfmetrics.Inc("rejected.signature." + signing.Reason(err))
+			if errors.Is(err, signing.ErrInvalid) {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,49 @@
+package interceptors
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/tenant"
+)
+
+// TenantInterceptor identifies call tenant by x-tenant-token or x-tenant metadata and stores it in call context.
+// Calls without tenant metadata belong to the default tenant, see tenant.Resolve. Tenant isn't resolved
+// for public methods, e.g. health checks, which don't access metrics.
+func TenantInterceptor(c *models.Config, public ...string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if slices.Contains(public, info.FullMethod) {
+			return handler(ctx, req)
+		}
+		var token, name string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			token = firstValue(md, tenant.TokenHeader)
+			name = firstValue(md, tenant.Header)
+		}
+
+		t, err := tenant.Resolve(c, token, name)
+		if err != nil {
+			if errors.Is(err, tenant.ErrInvalidName) {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+
+		return handler(tenant.NewContext(ctx, t), req)
+	}
+}
+
+func firstValue(md metadata.MD, key string) string {
+	values := md.Get(key)
+	if len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
@@ -10,10 +10,15 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+
+	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/selfmetrics"
 )
 
-func TrustedSubnetInterceptor(subnet *net.IPNet) grpc.UnaryServerInterceptor {
+// TrustedSubnetInterceptor rejects calls with X-Real-IP outside of the trusted subnet of the configuration.
+func TrustedSubnetInterceptor(c *models.Config) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		subnet := c.Reloadable().TrustedSubnet
 		if subnet == nil {
 			return handler(ctx, req)
 		}
@@ -27,6 +32,7 @@ func TrustedSubnetInterceptor(subnet *net.IPNet) grpc.UnaryServerInterceptor {
 		}
 		ip := net.ParseIP(remoteAddr)
 		if ip == nil || !subnet.Contains(ip) {
+			selfmetrics.Inc("rejected.ip")
 			msg := fmt.Sprintf("the request from ip %s has been rejected", remoteAddr)
 			return nil, status.Error(codes.PermissionDenied, msg)
 		}
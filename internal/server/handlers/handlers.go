@@ -2,43 +2,46 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
 	"crypto"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
-	"html/template"
 	"io"
+	"math"
 	"sync"
 
 	"net/http"
+	"sort"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"go.uber.org/zap"
 
+	"github.com/vkupriya/go-metrics/internal/logging"
+	"github.com/vkupriya/go-metrics/internal/requestid"
+	"github.com/vkupriya/go-metrics/internal/server/audit"
+	"github.com/vkupriya/go-metrics/internal/server/auth"
+	"github.com/vkupriya/go-metrics/internal/server/health"
 	mw "github.com/vkupriya/go-metrics/internal/server/middleware"
 	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/problem"
+	"github.com/vkupriya/go-metrics/internal/server/query"
+	"github.com/vkupriya/go-metrics/internal/server/ratelimit"
+	"github.com/vkupriya/go-metrics/internal/server/rules"
+	"github.com/vkupriya/go-metrics/internal/server/selfmetrics"
 	"github.com/vkupriya/go-metrics/internal/server/storage"
+	"github.com/vkupriya/go-metrics/internal/server/tenant"
+	"github.com/vkupriya/go-metrics/internal/server/validation"
 )
 
-const tmpl string = `
-	<!doctype html>
-
-	<body>
-		<ul>
-		{{ range $key, $value := . }}
-			<li><b>{{ $key }}</b>: {{ $value }}</li>
-		{{ end }}
-		</ul>
-	</body>
-
-	</html>
-`
-
 // Storage interface implements CRUD operations with metrics store.
 type Storage interface {
 	UpdateGaugeMetric(c *models.Config, name string, value float64) (float64, error)
@@ -47,18 +50,48 @@ type Storage interface {
 	GetGaugeMetric(c *models.Config, name string) (float64, bool, error)
 	GetAllMetrics(c *models.Config) (map[string]float64, map[string]int64, error)
 	UpdateBatch(c *models.Config, g models.Metrics, cr models.Metrics) error
+	GetMetricHistory(c *models.Config, mtype, name string, from, to time.Time, step time.Duration) (
+		[]models.HistoryPoint, error)
+	RollupHistory(c *models.Config) error
+	DeleteMetric(c *models.Config, mtype, name string) (bool, error)
+	ResetCounter(c *models.Config, name string) (bool, error)
+	DeleteMetrics(c *models.Config, prefix string) (int64, error)
+	SetMetadata(c *models.Config, md *models.MetricMetadata) error
+	GetMetadata(c *models.Config) ([]models.MetricMetadata, error)
 	PingStore(c *models.Config) error
 	Close()
 }
 
 const (
-	counter string = "counter"
-	gauge   string = "gauge"
+	counter         string = "counter"
+	gauge           string = "gauge"
+	contentType     string = "Content-Type"
+	metricTypeParam string = "metricType"
+	metricNameParam string = "metricName"
+	decodeErrorMsg  string = "cannot decode request JSON body"
+	typeErrorMsg    string = "metric type must be 'gauge' or 'counter'"
+	notFoundMsg     string = "metric not found"
+	notFiniteMsg    string = "gauge value must be a finite number"
+
+	keyExchangeFailures = "key_exchange.failures"
 )
 
+// Rules provides status of rules evaluated over metrics of the tenant and alerts they raised.
+type Rules interface {
+	Rules(tenant string) []rules.RuleStatus
+	Alerts(tenant string) []rules.Alert
+}
+
 type MetricResource struct {
-	Store  Storage
-	config *models.Config
+	Store     Storage // storage of the default tenant
+	rules     Rules
+	auth      *auth.Authenticator
+	audit     *audit.Log
+	limiter   *ratelimit.Limiter
+	validator *validation.Validator
+	health    *health.Health
+	tenants   *Tenants
+	config    *models.Config
 }
 
 var pool = sync.Pool{
@@ -67,12 +100,67 @@ var pool = sync.Pool{
 	},
 }
 
-// NewMetricResource initializes MetricResource type.
+// NewMetricResource initializes MetricResource type serving the default tenant only.
 func NewMetricResource(store Storage, cfg *models.Config) *MetricResource {
-	return &MetricResource{
-		Store:  store,
-		config: cfg,
+	return NewTenantMetricResource(NewTenants(store, nil), cfg)
+}
+
+// NewTenantMetricResource initializes MetricResource type serving all tenants.
+func NewTenantMetricResource(t *Tenants, cfg *models.Config) *MetricResource {
+	mr := &MetricResource{
+		Store:     t.Default(),
+		auth:      auth.New(cfg, nil),
+		limiter:   ratelimit.New(cfg),
+		validator: validation.New(cfg),
+		tenants:   t,
+		config:    cfg,
+	}
+	mr.SetHealth(health.New())
+	return mr
+}
+
+// logger returns logger of HTTP handlers, log lines carry ID of the request.
+func (mr *MetricResource) logger(r *http.Request) *zap.Logger {
+	logger := mr.config.Logger.Named(logging.ComponentHTTP)
+	if id := requestid.FromContext(r.Context()); id != "" {
+		return logger.With(zap.String(requestid.LogKey, id))
 	}
+	return logger
+}
+
+// SetRules sets rules served by the rules and alerts API, no rules are served if it isn't set.
+func (mr *MetricResource) SetRules(rs Rules) {
+	mr.rules = rs
+}
+
+// SetHealth sets registry of component checks reported by /readyz, checks of storages and key exchange
+// are added to it.
+func (mr *MetricResource) SetHealth(h *health.Health) {
+	mr.tenants.RegisterChecks(h, mr.config)
+	h.AddCheck("key_exchange", mr.checkKeyExchange)
+	mr.health = h
+}
+
+// SetAuth sets authenticator of API tokens, only the admin token of configuration is accepted if it isn't set.
+func (mr *MetricResource) SetAuth(a *auth.Authenticator) {
+	mr.auth = a
+}
+
+// SetValidator sets validator of written metrics, it is shared with the gRPC server to count series
+// written through both APIs.
+func (mr *MetricResource) SetValidator(v *validation.Validator) {
+	mr.validator = v
+}
+
+// SetAudit sets audit log of write and admin operations, operations aren't audited if it isn't set.
+func (mr *MetricResource) SetAudit(l *audit.Log) {
+	mr.audit = l
+}
+
+// SetLimiter sets limiter of agent ingestion, it is shared with the gRPC server to apply the same limits
+// to both APIs.
+func (mr *MetricResource) SetLimiter(l *ratelimit.Limiter) {
+	mr.limiter = l
 }
 
 // NewStore instantiates metric store based on configuration parameters.
@@ -106,214 +194,317 @@ func NewMetricRouter(mr *MetricResource) chi.Router {
 
 	ml := mw.NewMiddlewareLogger(mr.config)
 	mh := mw.NewMiddlewareHash(mr.config)
-	mg := mw.NewMiddlewareGzip(mr.config)
+	mg := mw.NewMiddlewareCompress(mr.config)
 	md := mw.NewMiddlewareDecrypt(mr.config)
 	mi := mw.NewMiddlewareIPCheck(mr.config)
+	ma := mw.NewMiddlewareAuth(mr.config, mr.auth)
+	mt := mw.NewMiddlewareTenant(mr.config)
+	mrl := mw.NewMiddlewareRateLimit(mr.config, mr.limiter)
+	mins := mw.NewMiddlewareInstrument(mr.config)
+	mtr := mw.NewMiddlewareTrace(mr.config)
+	mid := mw.NewMiddlewareRequestID(mr.config)
+	mau := mw.NewMiddlewareAudit(mr.config, mr.audit)
 
+	r.Use(mtr.Trace)
+	r.Use(mid.RequestID)
+	r.Use(mins.Instrument)
 	r.Use(ml.Logging)
-	r.Post("/", mr.KeyExchange)
+	r.Use(ma.Authenticate)
+	r.With(ma.Require(auth.RoleWrite), mau.Audit(audit.OpKeyExchange), mtr.Handler).Post("/", mr.KeyExchange)
 
 	r.Group(func(r chi.Router) {
 		r.Use(mh.HashSend)
-		r.Use(mg.GzipHandle)
-		r.Get("/", mr.GetAllMetrics)
+		r.Use(mg.CompressHandle)
+		r.Use(mtr.Handler)
+		r.Handle("/ui/static/*", uiStatic())
 		r.Get("/ping", mr.PingStore)
+		r.Get("/healthz", mr.Healthz)
+		r.Get("/readyz", mr.Readyz)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(mt.TenantHandle)
+		r.Use(ma.Require(auth.RoleRead))
+		r.Use(mh.HashSend)
+		r.Use(mg.CompressHandle)
+		r.Use(mtr.Handler)
+		r.Get("/", mr.GetAllMetrics)
+		r.Get("/ui/metrics/{metricType}/{metricName}", mr.MetricPage)
 		r.Get("/value/{metricType}/{metricName}", mr.GetMetric)
+		r.Get("/history/{metricType}/{metricName}", mr.GetMetricHistory)
+		r.Get("/api/v1/metadata", mr.GetMetadata)
+		r.Get("/api/v1/metrics", mr.ListMetrics)
+		r.Get("/api/v1/query", mr.Query)
+		r.Get("/api/v1/alerts", mr.GetAlerts)
+		r.Get("/api/v1/rules", mr.GetRules)
 	})
 
 	r.Group(func(r chi.Router) {
+		r.Use(mt.TenantHandle)
+		r.Use(ma.Require(auth.RoleWrite))
+		r.Use(mau.Audit(audit.OpUpdateBatch))
 		r.Use(mi.IPCheckHandle)
+		r.Use(mrl.RateLimitHandle)
 		r.Use(mh.HashCheck)
 		r.Use(md.DecryptHandle)
-		r.Use(mg.GzipHandle)
+		r.Use(mg.CompressHandle)
+		r.Use(mtr.Handler)
 		r.Post("/updates/", mr.UpdateBatchJSON)
 	})
 
 	r.Group(func(r chi.Router) {
+		r.Use(mt.TenantHandle)
+		r.Use(ma.Require(auth.RoleRead))
 		r.Use(mh.HashCheck)
-		r.Use(mg.GzipHandle)
+		r.Use(mg.CompressHandle)
+		r.Use(mtr.Handler)
 		r.Post("/value/", mr.GetMetricJSON)
+		r.Post("/values/", mr.GetMetricsJSON)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(mt.TenantHandle)
+		r.Use(ma.Require(auth.RoleWrite))
+		r.Use(mau.Audit(audit.OpUpdate))
+		r.Use(mrl.RateLimitHandle)
+		r.Use(mh.HashCheck)
+		r.Use(mg.CompressHandle)
+		r.Use(mtr.Handler)
 		r.Post("/update/", mr.UpdateMetricJSON)
 		r.Post("/update/{metricType}/{metricName}/{metricValue}", mr.UpdateMetric)
 	})
 
-	r.Mount("/debug", middleware.Profiler())
+	r.Group(func(r chi.Router) {
+		r.Use(mt.TenantHandle)
+		r.Use(ma.Require(auth.RoleAdmin))
+		r.Use(mtr.Handler)
+		r.With(mau.Audit(audit.OpDeleteMetric)).Delete("/value/{metricType}/{metricName}", mr.DeleteMetric)
+		r.With(mau.Audit(audit.OpResetCounter)).Post("/admin/reset/{metricName}", mr.ResetCounter)
+		r.With(mau.Audit(audit.OpDeleteMetrics)).Delete("/admin/metrics", mr.DeleteMetrics)
+		r.Mount("/debug", middleware.Profiler())
+	})
 
 	return r
 }
 
 func (mr *MetricResource) KeyExchange(rw http.ResponseWriter, r *http.Request) {
-	logger := mr.config.Logger
-	if len(mr.config.CryptoKey) != 0 {
+	logger := mr.logger(r)
+	if cryptoKey := mr.config.Reloadable().CryptoKey; len(cryptoKey) != 0 {
+		selfmetrics.Inc("key_exchange.attempts")
 		b, err := io.ReadAll(r.Body)
 		if err != nil {
-			rw.WriteHeader(http.StatusBadRequest)
+			selfmetrics.Inc(keyExchangeFailures)
+			problem.Write(rw, r, http.StatusBadRequest, problem.InvalidBody, "failed to read request body")
 			return
 		}
 		b, _ = hex.DecodeString(string(b))
-		privateKeyBlock, _ := pem.Decode(mr.config.CryptoKey)
+		privateKeyBlock, _ := pem.Decode(cryptoKey)
+		if privateKeyBlock == nil {
+			logger.Sugar().Error("private key is not PEM encoded")
+			selfmetrics.Inc(keyExchangeFailures)
+			problem.WriteInternal(rw, r)
+			return
+		}
 		privateKey, err := x509.ParsePKCS1PrivateKey(privateKeyBlock.Bytes)
 		if err != nil {
-			rw.WriteHeader(http.StatusBadRequest)
+			logger.Sugar().Error("failed to parse private key", zap.Error(err))
+			selfmetrics.Inc(keyExchangeFailures)
+			problem.WriteInternal(rw, r)
 			return
 		}
 
 		secret, err := privateKey.Decrypt(nil, b, &rsa.OAEPOptions{Hash: crypto.SHA256})
 		if err != nil {
 			logger.Sugar().Errorf("failed to decrypt body with private key: %w", err)
-			rw.WriteHeader(http.StatusInternalServerError)
+			selfmetrics.Inc(keyExchangeFailures)
+			problem.Write(rw, r, http.StatusBadRequest, problem.InvalidBody, "failed to decrypt secret key")
 			return
 		}
 
-		mr.config.SecretKey = secret
+		mr.config.SetSecretKey(secret)
 	}
 }
 
 // UpdateMetric is an endpoint to update individual metric of gauge or counter type via url.
 func (mr *MetricResource) UpdateMetric(rw http.ResponseWriter, r *http.Request) {
-	logger := mr.config.Logger
+	logger := mr.logger(r)
+
+	store, ok := mr.tenantStore(rw, r)
+	if !ok {
+		return
+	}
 
-	mtype := chi.URLParam(r, "metricType")
-	mname := chi.URLParam(r, "metricName")
+	mtype := chi.URLParam(r, metricTypeParam)
+	mname := chi.URLParam(r, metricNameParam)
 	mvalue := chi.URLParam(r, "metricValue")
 
 	if mtype != gauge && mtype != counter {
-		rw.WriteHeader(http.StatusBadRequest)
+		problem.Write(rw, r, http.StatusBadRequest, problem.InvalidMetricType, typeErrorMsg)
 		return
 	}
 
 	if mname == "" {
-		rw.WriteHeader(http.StatusNotFound)
+		problem.Write(rw, r, http.StatusNotFound, problem.InvalidMetricID, "metric name is empty")
+		return
+	}
+
+	if err := mr.validator.Name(mname); err != nil {
+		writeItemProblem(rw, r, http.StatusBadRequest, problem.InvalidMetricID, err.Error(), mname)
 		return
 	}
 
 	if mvalue == "" {
-		rw.WriteHeader(http.StatusBadRequest)
+		writeItemProblem(rw, r, http.StatusBadRequest, problem.InvalidValue, "metric value is empty", mname)
+		return
 	}
 
-	if mtype != "" && mname != "" && mvalue != "" {
-		switch {
-		case mtype == gauge:
-			mv, err := strconv.ParseFloat(mvalue, 64)
-			if err != nil {
-				rw.WriteHeader(http.StatusBadRequest)
-				return
-			}
-			_, err = mr.Store.UpdateGaugeMetric(mr.config, mname, mv)
-			if err != nil {
-				logger.Sugar().Error("failed to update gauge metric", zap.Error(err))
-				rw.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-			rw.WriteHeader(http.StatusOK)
+	switch {
+	case mtype == gauge:
+		mv, err := strconv.ParseFloat(mvalue, 64)
+		if err != nil || !finite(mv) {
+			writeItemProblem(rw, r, http.StatusBadRequest, problem.InvalidValue,
+				fmt.Sprintf("gauge value '%s' is not a finite number", mvalue), mname)
+			return
+		}
+		release, ok := mr.admitSeries(rw, r, mtype, mname)
+		if !ok {
+			return
+		}
+		_, err = store.UpdateGaugeMetric(mr.config, mname, mv)
+		if err != nil {
+			release()
+			logger.Sugar().Error("failed to update gauge metric", zap.Error(err))
+			writeStoreError(rw, r, err, mname)
+			return
+		}
+		audit.FromContext(r.Context()).Add(1)
+		rw.WriteHeader(http.StatusOK)
 
-		case mtype == counter:
-			mv, err := strconv.ParseInt(mvalue, 10, 64)
-			if err != nil {
-				rw.WriteHeader(http.StatusBadRequest)
-				return
-			}
-			_, err = mr.Store.UpdateCounterMetric(mr.config, mname, mv)
-			if err != nil {
-				logger.Sugar().Error("failed to update counter metric", zap.Error(err))
-				rw.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-			rw.WriteHeader(http.StatusOK)
+	case mtype == counter:
+		mv, err := strconv.ParseInt(mvalue, 10, 64)
+		if err != nil {
+			writeItemProblem(rw, r, http.StatusBadRequest, problem.InvalidValue,
+				fmt.Sprintf("counter value '%s' is not an integer", mvalue), mname)
+			return
 		}
-		return
+		release, ok := mr.admitSeries(rw, r, mtype, mname)
+		if !ok {
+			return
+		}
+		_, err = store.UpdateCounterMetric(mr.config, mname, mv)
+		if err != nil {
+			release()
+			logger.Sugar().Error("failed to update counter metric", zap.Error(err))
+			writeStoreError(rw, r, err, mname)
+			return
+		}
+		audit.FromContext(r.Context()).Add(1)
+		rw.WriteHeader(http.StatusOK)
 	}
 }
 
 // UpdateMetricJSON endpoint to update individual metric of gauge or counter type via JSON body.
 func (mr *MetricResource) UpdateMetricJSON(rw http.ResponseWriter, r *http.Request) {
 	var req models.Metric
-	logger := mr.config.Logger
+	logger := mr.logger(r)
+
+	store, ok := mr.tenantStore(rw, r)
+	if !ok {
+		return
+	}
 
-	dec := json.NewDecoder(r.Body)
-	if err := dec.Decode(&req); err != nil {
-		logger.Sugar().Debug("cannot decode request JSON body", zap.Error(err))
-		rw.WriteHeader(http.StatusInternalServerError)
+	if !decodeJSON(rw, r, logger, &req) {
 		return
 	}
 
-	if req.MType != "counter" && req.MType != "gauge" {
-		rw.WriteHeader(http.StatusBadRequest)
+	if p := mr.validateMetric(&req); p != nil {
+		p.Write(rw, r)
 		return
 	}
 	mtype := req.MType
 	mname := req.ID
 
-	rw.Header().Set("Content-Type", "application/json")
+	release, ok := mr.admitSeries(rw, r, mtype, mname)
+	if !ok {
+		return
+	}
 
 	switch {
 	case mtype == gauge:
-		if req.Value == nil {
-			rw.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		rv, err := mr.Store.UpdateGaugeMetric(mr.config, mname, *req.Value)
+		rv, err := store.UpdateGaugeMetric(mr.config, mname, *req.Value)
 		if err != nil {
+			release()
 			logger.Sugar().Error("failed to update gauge metric", zap.Error(err))
-			rw.WriteHeader(http.StatusInternalServerError)
+			writeStoreError(rw, r, err, mname)
 			return
 		}
 		*req.Value = rv
 
 	case mtype == counter:
-		if req.Delta == nil {
-			rw.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		rd, err := mr.Store.UpdateCounterMetric(mr.config, mname, *req.Delta)
+		rd, err := store.UpdateCounterMetric(mr.config, mname, *req.Delta)
 		if err != nil {
+			release()
 			logger.Sugar().Error("failed to update counter metric", zap.Error(err))
-			rw.WriteHeader(http.StatusInternalServerError)
+			writeStoreError(rw, r, err, mname)
 			return
 		}
 		*req.Delta = rd
 	}
-	enc := json.NewEncoder(rw)
-	if err := enc.Encode(req); err != nil {
-		logger.Sugar().Debug("error encoding JSON response", zap.Error(err))
-		return
+	audit.FromContext(r.Context()).Add(1)
+	if req.Unit != "" || req.Description != "" || len(req.Labels) > 0 {
+		md := models.MetricMetadata{
+			Name:        mname,
+			MType:       mtype,
+			Unit:        req.Unit,
+			Description: req.Description,
+			Labels:      req.Labels,
+		}
+		if err := store.SetMetadata(mr.config, &md); err != nil {
+			logger.Sugar().Error("failed to set metric metadata", zap.Error(err))
+			writeStoreError(rw, r, err, mname)
+			return
+		}
 	}
+	writeJSON(rw, r, logger, req)
 }
 
 // GetMetric endpoint returns gauge or counter metric value via URL parameters.
 func (mr *MetricResource) GetMetric(rw http.ResponseWriter, r *http.Request) {
-	logger := mr.config.Logger
+	logger := mr.logger(r)
+
+	store, ok := mr.tenantStore(rw, r)
+	if !ok {
+		return
+	}
 
-	mtype := chi.URLParam(r, "metricType")
-	mname := chi.URLParam(r, "metricName")
+	mtype := chi.URLParam(r, metricTypeParam)
+	mname := chi.URLParam(r, metricNameParam)
 
 	if mtype != gauge && mtype != counter {
-		rw.WriteHeader(http.StatusBadRequest)
+		problem.Write(rw, r, http.StatusBadRequest, problem.InvalidMetricType, typeErrorMsg)
 		return
 	}
 
 	switch {
 	case mtype == gauge:
-		v, _, err := mr.Store.GetGaugeMetric(mr.config, mname)
+		v, _, err := store.GetGaugeMetric(mr.config, mname)
 		if err != nil {
-			rw.WriteHeader(http.StatusNotFound)
+			writeItemProblem(rw, r, http.StatusNotFound, problem.NotFound, notFoundMsg, mname)
 			return
 		}
 		if _, err := rw.Write([]byte(strconv.FormatFloat(v, 'f', -1, 64))); err != nil {
 			logger.Sugar().Errorf("failed to write into response writer value for metric %s", mname, zap.Error(err))
-			http.Error(rw, "", http.StatusInternalServerError)
 			return
 		}
 
 	case mtype == counter:
-		v, _, err := mr.Store.GetCounterMetric(mr.config, mname)
+		v, _, err := store.GetCounterMetric(mr.config, mname)
 		if err != nil {
-			rw.WriteHeader(http.StatusNotFound)
+			writeItemProblem(rw, r, http.StatusNotFound, problem.NotFound, notFoundMsg, mname)
 			return
 		}
 		if _, err := rw.Write([]byte(strconv.FormatInt(v, 10))); err != nil {
 			logger.Sugar().Errorf("failed to write into response writer value for metric %s: %v", mname, zap.Error(err))
-			http.Error(rw, "", http.StatusInternalServerError)
 			return
 		}
 	}
@@ -322,152 +513,705 @@ func (mr *MetricResource) GetMetric(rw http.ResponseWriter, r *http.Request) {
 // GetMetricJSON endpoint returns requested gauge or counter metric value in JSON.
 func (mr *MetricResource) GetMetricJSON(rw http.ResponseWriter, r *http.Request) {
 	var req models.Metric
-	logger := mr.config.Logger
+	logger := mr.logger(r)
 
-	dec := json.NewDecoder(r.Body)
-	if err := dec.Decode(&req); err != nil {
-		logger.Sugar().Error("cannot decode request JSON body", zap.Error(err))
-		rw.WriteHeader(http.StatusInternalServerError)
+	store, ok := mr.tenantStore(rw, r)
+	if !ok {
+		return
+	}
+
+	if !decodeJSON(rw, r, logger, &req) {
 		return
 	}
 
 	if req.MType != "counter" && req.MType != "gauge" {
 		logger.Sugar().Error("unsupported metric type", zap.String("type", req.MType))
-		rw.WriteHeader(http.StatusBadRequest)
+		writeItemProblem(rw, r, http.StatusBadRequest, problem.InvalidMetricType, typeErrorMsg, req.ID)
 		return
 	}
 	mtype := req.MType
 	mname := req.ID
 
-	rw.Header().Set("Content-Type", "application/json")
-
 	switch {
 	case mtype == gauge:
-		v, _, err := mr.Store.GetGaugeMetric(mr.config, mname)
+		v, _, err := store.GetGaugeMetric(mr.config, mname)
 		if err != nil {
-			rw.WriteHeader(http.StatusNotFound)
+			writeItemProblem(rw, r, http.StatusNotFound, problem.NotFound, notFoundMsg, mname)
 			return
 		}
 		req.Value = &v
 
 	case mtype == counter:
-		v, _, err := mr.Store.GetCounterMetric(mr.config, mname)
+		v, _, err := store.GetCounterMetric(mr.config, mname)
 		if err != nil {
-			rw.WriteHeader(http.StatusNotFound)
+			writeItemProblem(rw, r, http.StatusNotFound, problem.NotFound, notFoundMsg, mname)
 			return
 		}
-		fmt.Println(v)
 		req.Delta = &v
 	}
 
-	rw.WriteHeader(http.StatusOK)
-	enc := json.NewEncoder(rw)
-	if err := enc.Encode(req); err != nil {
-		logger.Sugar().Debug("error encoding JSON response", zap.Error(err))
-		rw.WriteHeader(http.StatusInternalServerError)
+	writeJSON(rw, r, logger, req)
+}
+
+// GetMetricHistory endpoint returns history of gauge or counter metric in JSON.
+// Query parameters 'from' and 'to' accept RFC 3339 time or unix seconds and default to the last hour,
+// 'step' is a duration like '5m' to aggregate history into, raw samples are returned without it.
+func (mr *MetricResource) GetMetricHistory(rw http.ResponseWriter, r *http.Request) {
+	logger := mr.logger(r)
+
+	store, ok := mr.tenantStore(rw, r)
+	if !ok {
+		return
+	}
+
+	mtype := chi.URLParam(r, metricTypeParam)
+	mname := chi.URLParam(r, metricNameParam)
+
+	if mtype != gauge && mtype != counter {
+		problem.Write(rw, r, http.StatusBadRequest, problem.InvalidMetricType, typeErrorMsg)
+		return
+	}
+
+	q := r.URL.Query()
+	to, err := parseTime(q.Get("to"), time.Now())
+	if err != nil {
+		problem.Write(rw, r, http.StatusBadRequest, problem.InvalidParameter, "invalid 'to' parameter")
+		return
+	}
+	from, err := parseTime(q.Get("from"), to.Add(-time.Hour))
+	if err != nil {
+		problem.Write(rw, r, http.StatusBadRequest, problem.InvalidParameter, "invalid 'from' parameter")
+		return
+	}
+	var step time.Duration
+	if s := q.Get("step"); s != "" {
+		step, err = time.ParseDuration(s)
+		if err != nil || step < 0 {
+			problem.Write(rw, r, http.StatusBadRequest, problem.InvalidParameter, "invalid 'step' parameter")
+			return
+		}
+	}
+
+	points, err := store.GetMetricHistory(mr.config, mtype, mname, from, to, step)
+	if err != nil {
+		logger.Sugar().Error("failed to get metric history", zap.Error(err))
+		writeStoreError(rw, r, err, mname)
 		return
 	}
+
+	writeJSON(rw, r, logger, points)
 }
 
-// GetAllMetrics returns all stored metrics as HTML page.
-func (mr *MetricResource) GetAllMetrics(rw http.ResponseWriter, r *http.Request) {
-	logger := mr.config.Logger
+// GetMetadata endpoint returns type, unit and description of metrics in JSON.
+// Query parameter 'name' limits the response to a single metric.
+func (mr *MetricResource) GetMetadata(rw http.ResponseWriter, r *http.Request) {
+	logger := mr.logger(r)
+
+	store, ok := mr.tenantStore(rw, r)
+	if !ok {
+		return
+	}
 
-	gauge, counter, err := mr.Store.GetAllMetrics(mr.config)
+	mds, err := store.GetMetadata(mr.config)
 	if err != nil {
-		logger.Sugar().Debug("failed to get all metrics", zap.Error(err))
-		http.Error(rw, "", http.StatusInternalServerError)
+		logger.Sugar().Error("failed to get metric metadata", zap.Error(err))
+		problem.WriteInternal(rw, r)
 		return
 	}
 
-	allMetrics := make(map[string]any)
+	if name := r.URL.Query().Get(nameKey); name != "" {
+		filtered := make([]models.MetricMetadata, 0, 1)
+		for _, md := range mds {
+			if md.Name == name {
+				filtered = append(filtered, md)
+			}
+		}
+		mds = filtered
+	}
+
+	writeJSON(rw, r, logger, mds)
+}
+
+// Query endpoint evaluates an expression of the query language and returns the result in JSON.
+// Query parameter 'query' is the expression, 'time' accepts RFC 3339 time or unix seconds
+// and sets the end of range function windows, it defaults to the current time.
+func (mr *MetricResource) Query(rw http.ResponseWriter, r *http.Request) {
+	logger := mr.logger(r)
 
-	for name, value := range gauge {
-		allMetrics[name] = value
+	store, ok := mr.tenantStore(rw, r)
+	if !ok {
+		return
 	}
 
-	for name, value := range counter {
-		allMetrics[name] = value
+	q := r.URL.Query()
+	expr := q.Get("query")
+	if expr == "" {
+		problem.Write(rw, r, http.StatusBadRequest, problem.InvalidParameter, "missing 'query' parameter")
+		return
+	}
+	ts, err := parseTime(q.Get("time"), time.Now())
+	if err != nil {
+		problem.Write(rw, r, http.StatusBadRequest, problem.InvalidParameter, "invalid 'time' parameter")
+		return
 	}
 
-	t, err := template.New("tmpl").Parse(tmpl)
+	res, err := query.NewEngine(mr.config, store).Query(expr, ts)
 	if err != nil {
-		logger.Sugar().Errorf("failed to load http template.", zap.Error(err))
-		http.Error(rw, "", http.StatusInternalServerError)
+		if errors.Is(err, query.ErrInvalidQuery) {
+			problem.Write(rw, r, http.StatusBadRequest, problem.InvalidQuery, err.Error())
+			return
+		}
+		logger.Sugar().Error("failed to evaluate query", zap.Error(err))
+		problem.WriteInternal(rw, r)
+		return
+	}
+
+	writeJSON(rw, r, logger, res)
+}
+
+// GetAlerts returns alerts of the request tenant, optionally filtered by ?state=pending|firing|resolved.
+func (mr *MetricResource) GetAlerts(rw http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	switch state {
+	case "", rules.StatePending, rules.StateFiring, rules.StateResolved:
+	default:
+		problem.Write(rw, r, http.StatusBadRequest, problem.InvalidParameter, fmt.Sprintf("unknown alert state '%s'", state))
 		return
 	}
 
-	rw.Header().Set("Content-Type", "text/html")
-	if err := t.Execute(rw, allMetrics); err != nil {
-		logger.Sugar().Errorf("failed to execute http template.", zap.Error(err))
-		http.Error(rw, "", http.StatusInternalServerError)
+	alerts := make([]rules.Alert, 0)
+	if mr.rules != nil {
+		for _, a := range mr.rules.Alerts(tenant.FromContext(r.Context())) {
+			if state == "" || a.State == state {
+				alerts = append(alerts, a)
+			}
+		}
+	}
+
+	writeJSON(rw, r, mr.logger(r), map[string][]rules.Alert{"alerts": alerts})
+}
+
+// GetRules returns status of rules of the request tenant, optionally filtered by ?type=alerting|recording.
+func (mr *MetricResource) GetRules(rw http.ResponseWriter, r *http.Request) {
+	typ := r.URL.Query().Get(typeKey)
+	switch typ {
+	case "", rules.TypeAlerting, rules.TypeRecording:
+	default:
+		problem.Write(rw, r, http.StatusBadRequest, problem.InvalidParameter, fmt.Sprintf("unknown rule type '%s'", typ))
 		return
 	}
+
+	rs := make([]rules.RuleStatus, 0)
+	if mr.rules != nil {
+		for _, rule := range mr.rules.Rules(tenant.FromContext(r.Context())) {
+			if typ == "" || rule.Type == typ {
+				rs = append(rs, rule)
+			}
+		}
+	}
+
+	writeJSON(rw, r, mr.logger(r), map[string][]rules.RuleStatus{"rules": rs})
+}
+
+// tenantStore returns storage of the request tenant, error response is written if it isn't available.
+// Storage is limited to metrics accessible by the API token of the request.
+func (mr *MetricResource) tenantStore(rw http.ResponseWriter, r *http.Request) (Storage, bool) {
+	name := tenant.FromContext(r.Context())
+	store, err := mr.tenants.StoreContext(r.Context(), name)
+	if err != nil {
+		if errors.Is(err, ErrUnknownTenant) {
+			problem.Write(rw, r, http.StatusNotFound, problem.UnknownTenant, err.Error())
+			return nil, false
+		}
+		if errors.Is(err, tenant.ErrTooManyTenants) {
+			problem.Write(rw, r, http.StatusTooManyRequests, problem.QuotaExceeded, err.Error())
+			return nil, false
+		}
+		mr.logger(r).Sugar().Error("failed to get tenant storage", zap.Error(err))
+		problem.WriteInternal(rw, r)
+		return nil, false
+	}
+	if p := auth.FromContext(r.Context()); p != nil && p.Restricted() {
+		return &restrictedStore{Storage: store, principal: p}, true
+	}
+	return store, true
+}
+
+// writeStoreError writes response of a failed storage operation on the metric, client errors are explained
+// in the body.
+func writeStoreError(rw http.ResponseWriter, r *http.Request, err error, id string) {
+	switch {
+	case errors.Is(err, auth.ErrForbidden):
+		writeItemProblem(rw, r, http.StatusForbidden, problem.Forbidden, err.Error(), id)
+	case errors.Is(err, storage.ErrSeriesQuota), errors.Is(err, ratelimit.ErrSeriesLimit),
+		errors.Is(err, validation.ErrSeriesLimit):
+		writeItemProblem(rw, r, http.StatusTooManyRequests, problem.QuotaExceeded, err.Error(), id)
+	case errors.Is(err, storage.ErrTypeConflict):
+		writeItemProblem(rw, r, http.StatusConflict, problem.TypeConflict, err.Error(), id)
+	default:
+		problem.WriteInternal(rw, r)
+	}
+}
+
+// admitSeries checks that writing the series keeps the agent and the server within their series limits.
+// If the series can't be stored, the response is written and false is returned: status code 429 for
+// a rejected series and status code 200 for a series dropped by the overflow policy of the server.
+// The returned function stops counting the series, it's called if the write fails.
+func (mr *MetricResource) admitSeries(rw http.ResponseWriter, r *http.Request, mtype, name string) (func(), bool) {
+	release, err := mr.admitBatchSeries(r, ratelimit.FromRequest(mr.config, r), mtype, name)
+	switch {
+	case err == nil:
+		return release, true
+	case errors.Is(err, validation.ErrDropped):
+		rw.WriteHeader(http.StatusOK)
+	default:
+		mr.logger(r).Sugar().Warn(err)
+		writeStoreError(rw, r, err, name)
+	}
+	return nil, false
+}
+
+// admitBatchSeries checks series limits of the agent and the server for a series of the request.
+// The returned function stops counting the series if it's new, so series of failed writes don't
+// use up the limits.
+func (mr *MetricResource) admitBatchSeries(r *http.Request, agent, mtype, name string) (func(), error) {
+	tenantName := tenant.FromContext(r.Context())
+	key := ratelimit.SeriesKey(tenantName, mtype, name)
+	agentAdded, err := mr.limiter.AdmitSeries(agent, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to admit series: %w", err)
+	}
+	releaseAgent := func() {
+		if agentAdded {
+			mr.limiter.ForgetSeries(agent, key)
+		}
+	}
+	added, err := mr.validator.Admit(tenantName, mtype, name)
+	if err != nil {
+		releaseAgent()
+		return nil, fmt.Errorf("failed to admit series: %w", err)
+	}
+	return func() {
+		releaseAgent()
+		if added {
+			mr.validator.Forget(tenantName, mtype, name)
+		}
+	}, nil
+}
+
+// writeItemProblem writes problem details of a request for the metric id.
+func writeItemProblem(rw http.ResponseWriter, r *http.Request, status int, code, detail, id string) {
+	p := problem.New(status, code, detail)
+	p.MetricID = id
+	p.Write(rw, r)
+}
+
+// decodeJSON decodes request body into v, status code 400 is written if the body isn't valid JSON.
+func decodeJSON(rw http.ResponseWriter, r *http.Request, logger *zap.Logger, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		logger.Sugar().Debug(decodeErrorMsg, zap.Error(err))
+		problem.Write(rw, r, http.StatusBadRequest, problem.InvalidJSON, fmt.Sprintf("%s: %v", decodeErrorMsg, err))
+		return false
+	}
+	return true
+}
+
+// validateMetric returns problem details if the metric can't be stored, nil otherwise.
+func (mr *MetricResource) validateMetric(m *models.Metric) *problem.Details {
+	var p *problem.Details
+	nameErr := mr.validator.Name(m.ID)
+	metaErr := mr.validator.Metadata(m.Unit, m.Description, m.Labels)
+	switch {
+	case nameErr != nil:
+		p = problem.New(http.StatusBadRequest, problem.InvalidMetricID, nameErr.Error())
+	case metaErr != nil:
+		p = problem.New(http.StatusBadRequest, problem.InvalidMetadata, metaErr.Error())
+	case m.MType != gauge && m.MType != counter:
+		p = problem.New(http.StatusBadRequest, problem.InvalidMetricType, typeErrorMsg)
+	case m.MType == gauge && m.Value == nil:
+		p = problem.New(http.StatusBadRequest, problem.InvalidValue, "missing value of gauge metric")
+	case m.MType == gauge && !finite(*m.Value):
+		p = problem.New(http.StatusBadRequest, problem.InvalidValue, notFiniteMsg)
+	case m.MType == counter && m.Delta == nil:
+		p = problem.New(http.StatusBadRequest, problem.InvalidValue, "missing delta of counter metric")
+	default:
+		return nil
+	}
+	p.MetricID = m.ID
+	return p
+}
+
+// finite reports whether gauge value v can be stored, NaN and infinities can't be encoded into JSON.
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// writeJSON sets JSON content type and encodes v into response body. The response is encoded before
+// anything is written, so status code 500 is returned if v can't be encoded.
+func writeJSON(rw http.ResponseWriter, r *http.Request, logger *zap.Logger, v any) {
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(v); err != nil {
+		logger.Sugar().Error("error encoding JSON response", zap.Error(err))
+		problem.WriteInternal(rw, r)
+		return
+	}
+	rw.Header().Set(contentType, "application/json")
+	if _, err := b.WriteTo(rw); err != nil {
+		logger.Sugar().Debug("error writing JSON response", zap.Error(err))
+	}
+}
+
+// parseTime parses RFC 3339 time or unix seconds, def is returned for empty string.
+func parseTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, fmt.Errorf("failed to parse time %s: %w", s, err)
+	}
+	return t, nil
+}
+
+// GetAllMetrics renders web UI dashboard of all stored metrics.
+func (mr *MetricResource) GetAllMetrics(rw http.ResponseWriter, r *http.Request) {
+	logger := mr.logger(r)
+
+	store, ok := mr.tenantStore(rw, r)
+	if !ok {
+		return
+	}
+
+	gauge, counter, err := store.GetAllMetrics(mr.config)
+	if err != nil {
+		logger.Sugar().Debug("failed to get all metrics", zap.Error(err))
+		problem.WriteInternal(rw, r)
+		return
+	}
+
+	renderPage(rw, r, logger, "dashboard.html", map[string]any{"Groups": dashboardGroups(gauge, counter)})
 }
 
 // PingStore endpoint returns 200 OK if metric store is available, otherwise status code 500.
 func (mr *MetricResource) PingStore(rw http.ResponseWriter, r *http.Request) {
-	logger := mr.config.Logger
+	logger := mr.logger(r)
 	if err := mr.Store.PingStore(mr.config); err != nil {
 		logger.Sugar().Errorf("failed to connect to store.", zap.Error(err))
-		http.Error(rw, "", http.StatusInternalServerError)
+		problem.Write(rw, r, http.StatusInternalServerError, problem.Unavailable, "metric store is not available")
 		return
 	}
 	rw.WriteHeader(http.StatusOK)
 }
 
-// UpdateBatchJSON endpoint updates all metrics in a batch.
+// Healthz reports liveness of the process, it succeeds as long as the server handles requests.
+func (mr *MetricResource) Healthz(rw http.ResponseWriter, r *http.Request) {
+	writeJSON(rw, r, mr.logger(r), map[string]string{"status": health.StatusOK})
+}
+
+// Readyz reports status of every component, status code 503 is returned if a component is failing.
+func (mr *MetricResource) Readyz(rw http.ResponseWriter, r *http.Request) {
+	logger := mr.logger(r)
+
+	report := mr.health.Check(r.Context())
+	rw.Header().Set(contentType, "application/json")
+	if !report.Ready() {
+		logger.Sugar().Warnw("server is not ready", "components", report.Components)
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(rw).Encode(report); err != nil {
+		logger.Sugar().Debug("error encoding JSON response", zap.Error(err))
+	}
+}
+
+// checkKeyExchange reports whether private key of the key exchange is configured and valid.
+func (mr *MetricResource) checkKeyExchange(context.Context) (string, error) {
+	keys := mr.config.Reloadable()
+	if len(keys.CryptoKey) == 0 {
+		return "", fmt.Errorf("%w: private key is not configured", health.ErrDisabled)
+	}
+	block, _ := pem.Decode(keys.CryptoKey)
+	if block == nil {
+		return "", errors.New("private key is not PEM encoded")
+	}
+	if _, err := x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+		return "", fmt.Errorf("failed to parse private key: %w", err)
+	}
+	if len(keys.SecretKey) == 0 {
+		return "private key is loaded, no secret key has been exchanged yet", nil
+	}
+	return "private key is loaded, secret key has been exchanged", nil
+}
+
+// batchResult is the response of a batch with rejected items.
+type batchResult struct {
+	Errors   []problem.Item `json:"errors"`
+	Accepted int            `json:"accepted"`
+	Rejected int            `json:"rejected"`
+}
+
+// UpdateBatchJSON endpoint updates metrics in a batch. Invalid items and items conflicting with
+// registered metric types are rejected while the rest of the batch is stored: status code 207 is returned
+// with the rejected items listed in the body, problem details are returned if all items are rejected.
 func (mr *MetricResource) UpdateBatchJSON(rw http.ResponseWriter, r *http.Request) {
-	logger := mr.config.Logger
+	logger := mr.logger(r)
+
+	store, ok := mr.tenantStore(rw, r)
+	if !ok {
+		return
+	}
 
 	req, ok := pool.Get().(*models.Metrics)
 	if !ok {
 		logger.Sugar().Debugf("cannot get temporary metrics data structure from the pool")
-		rw.WriteHeader(http.StatusInternalServerError)
+		problem.WriteInternal(rw, r)
+		return
 	}
+	defer pool.Put(req)
+	// Decoding reuses elements of the slice, they are zeroed to not inherit fields of a previous batch.
+	clear((*req)[:cap(*req)])
+	*req = (*req)[:0]
 
-	var (
-		gauge   models.Metrics
-		counter models.Metrics
-	)
-
-	dec := json.NewDecoder(r.Body)
-	if err := dec.Decode(&req); err != nil {
-		logger.Sugar().Debugf("cannot decode request JSON body", err)
-		rw.WriteHeader(http.StatusInternalServerError)
+	if !decodeJSON(rw, r, logger, req) {
+		return
+	}
+	selfmetrics.ObserveSize("http.batch_size", len(*req))
+	if err := mr.limiter.CheckBatch(len(*req)); err != nil {
+		problem.Write(rw, r, http.StatusRequestEntityTooLarge, problem.BatchTooLarge, err.Error())
 		return
 	}
 
-	for _, metric := range *req {
-		switch metric.MType {
-		case "gauge":
-			if metric.Value != nil {
-				gauge = append(gauge, metric)
-			} else {
-				logger.Sugar().Errorf("Missing value for gauge metric '%s'.", metric.ID)
-				rw.WriteHeader(http.StatusBadRequest)
-				return
+	agent := ratelimit.FromRequest(mr.config, r)
+	var items []problem.Item
+	var dropped int
+	valid := make(map[int]models.Metric, len(*req))
+	releases := make(map[int]func(), len(*req))
+	types := make(map[string]string, len(*req))
+	for i, metric := range *req {
+		if p := mr.validateMetric(&metric); p != nil {
+			items = append(items, problem.Item{Index: i, ID: metric.ID, Code: p.Code, Detail: p.Detail})
+			continue
+		}
+		if !auth.Allowed(r.Context(), metric.ID) {
+			items = append(items, problem.Item{
+				Index: i, ID: metric.ID, Code: problem.Forbidden, Detail: "API token has no access to the metric",
+			})
+			continue
+		}
+		if t, ok := types[metric.ID]; ok && t != metric.MType {
+			items = append(items, conflictItem(i, &metric, t))
+			continue
+		}
+		types[metric.ID] = metric.MType
+		release, err := mr.admitBatchSeries(r, agent, metric.MType, metric.ID)
+		if err != nil {
+			if errors.Is(err, validation.ErrDropped) {
+				dropped++
+				continue
+			}
+			items = append(items, problem.Item{Index: i, ID: metric.ID, Code: problem.QuotaExceeded, Detail: err.Error()})
+			continue
+		}
+		valid[i] = metric
+		releases[i] = release
+	}
+
+	err := updateBatch(mr.config, store, valid)
+	if errors.Is(err, storage.ErrTypeConflict) {
+		// Conflicts with registered types are rare, so metadata is only read to find the conflicting items.
+		var mds []models.MetricMetadata
+		if mds, err = store.GetMetadata(mr.config); err == nil {
+			for _, md := range mds {
+				types[md.Name] = md.MType
 			}
-		case "counter":
-			if metric.Delta != nil {
-				counter = append(counter, metric)
-			} else {
-				logger.Sugar().Errorf("Missing delta for counter metric '%s'.", metric.ID)
-				rw.WriteHeader(http.StatusBadRequest)
-				return
+			for i, metric := range valid {
+				if types[metric.ID] != metric.MType {
+					items = append(items, conflictItem(i, &metric, types[metric.ID]))
+					delete(valid, i)
+					releases[i]()
+				}
 			}
-		default:
-			logger.Sugar().Errorf("wrong metric type '%s'", metric.MType)
-			rw.WriteHeader(http.StatusBadRequest)
-			return
+			err = updateBatch(mr.config, store, valid)
 		}
 	}
-	pool.Put(req)
-	err := mr.Store.UpdateBatch(mr.config, gauge, counter)
 	if err != nil {
+		for i := range valid {
+			releases[i]()
+		}
 		logger.Sugar().Error(zap.Error(err))
-		rw.WriteHeader(http.StatusInternalServerError)
+		writeStoreError(rw, r, err, "")
+		return
+	}
+	audit.FromContext(r.Context()).Add(int64(len(valid)))
+
+	sort.Slice(items, func(i, j int) bool { return items[i].Index < items[j].Index })
+	for _, item := range items {
+		logger.Sugar().Debugw("batch item rejected", "index", item.Index, "id", item.ID, "detail", item.Detail)
+	}
+
+	switch {
+	case len(items) == 0:
+		rw.WriteHeader(http.StatusOK)
+	case len(valid) == 0 && dropped == 0:
+		writeBatchRejected(rw, r, items)
+	default:
+		rw.Header().Set(contentType, "application/json")
+		rw.WriteHeader(http.StatusMultiStatus)
+		res := batchResult{Accepted: len(valid), Rejected: len(items), Errors: items}
+		if err := json.NewEncoder(rw).Encode(res); err != nil {
+			logger.Sugar().Debug("error encoding JSON response", zap.Error(err))
+		}
+	}
+}
+
+// updateBatch stores valid metrics of a batch, nothing is done for an empty batch.
+func updateBatch(c *models.Config, store Storage, valid map[int]models.Metric) error {
+	if len(valid) == 0 {
+		return nil
+	}
+	idx := make([]int, 0, len(valid))
+	for i := range valid {
+		idx = append(idx, i)
+	}
+	sort.Ints(idx)
+
+	var gauges, counters models.Metrics
+	for _, i := range idx {
+		if valid[i].MType == gauge {
+			gauges = append(gauges, valid[i])
+		} else {
+			counters = append(counters, valid[i])
+		}
+	}
+	if err := store.UpdateBatch(c, gauges, counters); err != nil {
+		return fmt.Errorf("failed to update batch of metrics: %w", err)
+	}
+	return nil
+}
+
+// conflictItem returns rejected item of a metric conflicting with the registered type.
+func conflictItem(i int, m *models.Metric, registered string) problem.Item {
+	return problem.Item{
+		Index:  i,
+		ID:     m.ID,
+		Code:   problem.TypeConflict,
+		Detail: fmt.Sprintf("metric '%s' is registered as %s, not %s", m.ID, registered, m.MType),
+	}
+}
+
+// writeBatchRejected writes problem details of a batch with all items rejected. If all of them are rejected
+// for a type conflict, for the API token or for a series limit, status code 409, 403 or 429 is written,
+// otherwise status code 400.
+func writeBatchRejected(rw http.ResponseWriter, r *http.Request, items []problem.Item) {
+	status, code := http.StatusBadRequest, problem.BatchRejected
+	switch items[0].Code {
+	case problem.TypeConflict:
+		status, code = http.StatusConflict, problem.TypeConflict
+	case problem.Forbidden:
+		status, code = http.StatusForbidden, problem.Forbidden
+	case problem.QuotaExceeded:
+		status, code = http.StatusTooManyRequests, problem.QuotaExceeded
+	}
+	for _, item := range items {
+		if item.Code != code {
+			status, code = http.StatusBadRequest, problem.BatchRejected
+			break
+		}
+	}
+	p := problem.New(status, code, fmt.Sprintf("all %d metrics of the batch are rejected", len(items)))
+	p.Errors = items
+	p.Write(rw, r)
+}
+
+// DeleteMetric endpoint removes gauge or counter metric, status code 404 is returned if it doesn't exist.
+func (mr *MetricResource) DeleteMetric(rw http.ResponseWriter, r *http.Request) {
+	logger := mr.logger(r)
+
+	store, ok := mr.tenantStore(rw, r)
+	if !ok {
 		return
 	}
+
+	mtype := chi.URLParam(r, metricTypeParam)
+	mname := chi.URLParam(r, metricNameParam)
+
+	if mtype != gauge && mtype != counter {
+		problem.Write(rw, r, http.StatusBadRequest, problem.InvalidMetricType, typeErrorMsg)
+		return
+	}
+
+	ok, err := store.DeleteMetric(mr.config, mtype, mname)
+	if err != nil {
+		logger.Sugar().Error("failed to delete metric", zap.Error(err))
+		writeStoreError(rw, r, err, mname)
+		return
+	}
+	if !ok {
+		writeItemProblem(rw, r, http.StatusNotFound, problem.NotFound, notFoundMsg, mname)
+		return
+	}
+	mr.validator.Forget(tenant.FromContext(r.Context()), mtype, mname)
+	audit.FromContext(r.Context()).Add(1, mname)
+	logger.Sugar().Infow("metric deleted", "type", mtype, "name", mname)
 	rw.WriteHeader(http.StatusOK)
 }
+
+// ResetCounter endpoint sets counter metric to zero, status code 404 is returned if it doesn't exist.
+func (mr *MetricResource) ResetCounter(rw http.ResponseWriter, r *http.Request) {
+	logger := mr.logger(r)
+
+	store, ok := mr.tenantStore(rw, r)
+	if !ok {
+		return
+	}
+
+	mname := chi.URLParam(r, metricNameParam)
+
+	ok, err := store.ResetCounter(mr.config, mname)
+	if err != nil {
+		logger.Sugar().Error("failed to reset counter metric", zap.Error(err))
+		writeStoreError(rw, r, err, mname)
+		return
+	}
+	if !ok {
+		writeItemProblem(rw, r, http.StatusNotFound, problem.NotFound, "counter metric not found", mname)
+		return
+	}
+	audit.FromContext(r.Context()).Add(1, mname)
+	logger.Sugar().Infow("counter metric reset", "name", mname)
+	rw.WriteHeader(http.StatusOK)
+}
+
+// DeleteMetrics endpoint removes all metrics with names starting with the mandatory 'prefix' query parameter
+// and returns number of deleted metrics in JSON.
+func (mr *MetricResource) DeleteMetrics(rw http.ResponseWriter, r *http.Request) {
+	logger := mr.logger(r)
+
+	store, ok := mr.tenantStore(rw, r)
+	if !ok {
+		return
+	}
+
+	prefix := r.URL.Query().Get("prefix")
+	if prefix == "" {
+		problem.Write(rw, r, http.StatusBadRequest, problem.InvalidParameter, "missing 'prefix' parameter")
+		return
+	}
+	audit.FromContext(r.Context()).SetPrefix(prefix)
+
+	n, err := store.DeleteMetrics(mr.config, prefix)
+	if err != nil {
+		logger.Sugar().Error("failed to delete metrics", zap.Error(err))
+		writeStoreError(rw, r, err, "")
+		return
+	}
+	mr.validator.ForgetPrefix(tenant.FromContext(r.Context()), prefix)
+	audit.FromContext(r.Context()).Add(n)
+	logger.Sugar().Infow("metrics deleted", "prefix", prefix, "count", n)
+
+	writeJSON(rw, r, logger, struct {
+		Deleted int64 `json:"deleted"`
+	}{Deleted: n})
+}
@@ -1,11 +1,25 @@
 package handlers
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"io"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -13,12 +27,24 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/vkupriya/go-metrics/internal/compression"
+	"github.com/vkupriya/go-metrics/internal/requestid"
+	"github.com/vkupriya/go-metrics/internal/server/audit"
+	"github.com/vkupriya/go-metrics/internal/server/auth"
 	"github.com/vkupriya/go-metrics/internal/server/config"
 	mock_handlers "github.com/vkupriya/go-metrics/internal/server/handlers/mocks"
 	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/problem"
+	"github.com/vkupriya/go-metrics/internal/server/rules"
+	"github.com/vkupriya/go-metrics/internal/server/selfmetrics"
 	"github.com/vkupriya/go-metrics/internal/server/storage"
+	"github.com/vkupriya/go-metrics/internal/server/validation"
+	"github.com/vkupriya/go-metrics/internal/signing"
 )
 
+// loopback is the network of test clients, they are trusted as proxies so X-Real-IP identifies agents.
+var loopback = []*net.IPNet{{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}}
+
 func testRequest(t *testing.T, ts *httptest.Server, method, path string, body string) *http.Response {
 	t.Helper()
 
@@ -220,7 +246,7 @@ func TestUpdateAndGetMetricsMemStore(t *testing.T) {
 			method:       http.MethodPost,
 			path:         "/value/",
 			body:         `{ "id": "test", "type": "gauge", "delta": 20.0}`,
-			expectedCode: 500,
+			expectedCode: 400,
 			expectedBody: `{ "id": "test", "type": "gauge", "value": 20.0}`,
 		},
 		{
@@ -228,34 +254,34 @@ func TestUpdateAndGetMetricsMemStore(t *testing.T) {
 			method:       http.MethodPost,
 			path:         "/value/",
 			body:         `{ "id": "test", "type": "gauge", "delta": 20.0}`,
-			expectedCode: 500,
+			expectedCode: 400,
 			expectedBody: `{ "id": "test", "type": "gauge", "value": 20.0}`,
 		},
 		{
 			name:         "get_counter_metric: FAIL",
 			method:       http.MethodGet,
-			path:         "/value/counter/test",
+			path:         "/value/counter/count",
 			body:         "",
 			expectedCode: 404,
 		},
 		{
 			name:         "update_counter_metric_wrongvalue: FAIL",
 			method:       http.MethodPost,
-			path:         "/update/counter/test/string",
+			path:         "/update/counter/count/string",
 			body:         "",
 			expectedCode: 400,
 		},
 		{
 			name:         "update_counter_metric: OK",
 			method:       http.MethodPost,
-			path:         "/update/counter/test/20",
+			path:         "/update/counter/count/20",
 			body:         "",
 			expectedCode: 200,
 		},
 		{
 			name:         "get_counter_metric: OK",
 			method:       http.MethodGet,
-			path:         "/value/counter/test",
+			path:         "/value/counter/count",
 			body:         "",
 			expectedCode: 200,
 			expectedBody: "20",
@@ -264,7 +290,7 @@ func TestUpdateAndGetMetricsMemStore(t *testing.T) {
 			name:         "update_batch_metric: OK",
 			method:       http.MethodPost,
 			path:         "/updates/",
-			body:         `[{ "id": "test", "type": "gauge", "value": 20.0}, { "id": "test", "type": "counter", "delta": 20}]`,
+			body:         `[{ "id": "test", "type": "gauge", "value": 20.0}, { "id": "count", "type": "counter", "delta": 20}]`,
 			expectedCode: 200,
 		},
 		{
@@ -362,201 +388,2256 @@ func TestUpdateMetricFileStore(t *testing.T) {
 			method:       http.MethodPost,
 			path:         "/value/",
 			body:         `{ "id": "test"`,
-			expectedCode: 500,
+			expectedCode: 400,
 		},
 		{
 			name:         "update_gauge_metric_JSON_novalue: FAIL",
 			method:       http.MethodPost,
 			path:         "/update/",
 			body:         `{ "id": "test", "type"gauge"}`,
-			expectedCode: 500,
+			expectedCode: 400,
+		},
+		{
+			name:         "get_counter_metric: FAIL",
+			method:       http.MethodGet,
+			path:         "/value/counter/test45",
+			body:         "",
+			expectedCode: 404,
+		},
+		{
+			name:         "update_counter_metric: OK",
+			method:       http.MethodPost,
+			path:         "/update/counter/count/20",
+			body:         "",
+			expectedCode: 200,
+		},
+		{
+			name:         "get_counter_metric: OK",
+			method:       http.MethodGet,
+			path:         "/value/counter/count",
+			body:         "",
+			expectedCode: 200,
+			expectedBody: "20",
+		},
+		{
+			name:         "get_counter_metric_JSON: OK",
+			method:       http.MethodPost,
+			path:         "/value/",
+			body:         `{ "id": "count", "type": "counter", "delta": 20}`,
+			expectedCode: 200,
+		},
+		{
+			name:         "update_batch_metric: OK",
+			method:       http.MethodPost,
+			path:         "/updates/",
+			body:         `[{ "id": "test", "type": "gauge", "value": 20.0}, { "id": "count", "type": "counter", "delta": 20}]`,
+			expectedCode: 200,
+		},
+		{
+			name:         "update_batch_metric_wrong_JSON: FAIL",
+			method:       http.MethodPost,
+			path:         "/updates/",
+			body:         `[{ "id": "test", "type": "gauge", "value": 20.0}, { "id": "count", "type": "counter", "delta": 20}`,
+			expectedCode: 400,
+		},
+		{
+			name:         "get_all_metrics: OK",
+			method:       http.MethodGet,
+			path:         "/",
+			body:         "",
+			expectedCode: 200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := testRequest(t, ts, tt.method, tt.path, tt.body)
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+			if err := resp.Body.Close(); err != nil {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestUpdateMetricFileStoreTicker(t *testing.T) {
+	logConfig := zap.NewDevelopmentConfig()
+	logger, err := logConfig.Build()
+	if err != nil {
+		t.Error("failed to initialize Logger: %w", err)
+	}
+
+	cfg := &models.Config{
+		Address:         "http://localhost:8080",
+		StoreInterval:   5,
+		FileStoragePath: "/tmp/metrics-db.json",
+		RestoreMetrics:  false,
+		Logger:          logger,
+		PostgresDSN:     "",
+		ContextTimeout:  3,
+		HashKey:         "",
+	}
+	s, err := NewStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := NewMetricResource(s, cfg)
+
+	ts := httptest.NewServer(NewMetricRouter(mr))
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		body         string
+		expectedBody string
+		expectedCode int
+	}{
+		{
+			name:         "ping_filestore: OK",
+			method:       http.MethodGet,
+			path:         "/ping",
+			body:         "",
+			expectedCode: 200,
+			expectedBody: "",
+		},
+		{
+			name:         "get_gauge_metric: FAIL",
+			method:       http.MethodGet,
+			path:         "/value/gauge/test",
+			body:         "",
+			expectedCode: 404,
+			expectedBody: "",
+		},
+		{
+			name:         "update_gauge_metric: OK",
+			method:       http.MethodPost,
+			path:         "/update/gauge/test/20.0",
+			body:         "",
+			expectedCode: 200,
+		},
+		{
+			name:         "get_gauge_metric: OK",
+			method:       http.MethodGet,
+			path:         "/value/gauge/test",
+			body:         "",
+			expectedCode: 200,
+			expectedBody: "20.0",
+		},
+		{
+			name:         "get_gauge_metric_incorrect_JSON: OK",
+			method:       http.MethodPost,
+			path:         "/value/",
+			body:         `{ "id": "test"`,
+			expectedCode: 400,
+		},
+		{
+			name:         "update_gauge_metric_JSON_novalue: FAIL",
+			method:       http.MethodPost,
+			path:         "/update/",
+			body:         `{ "id": "test", "type"gauge"}`,
+			expectedCode: 400,
+		},
+		{
+			name:         "get_counter_metric: FAIL",
+			method:       http.MethodGet,
+			path:         "/value/counter/count",
+			body:         "",
+			expectedCode: 404,
+		},
+		{
+			name:         "update_counter_metric: OK",
+			method:       http.MethodPost,
+			path:         "/update/counter/count/20",
+			body:         "",
+			expectedCode: 200,
+		},
+		{
+			name:         "get_counter_metric: OK",
+			method:       http.MethodGet,
+			path:         "/value/counter/count",
+			body:         "",
+			expectedCode: 200,
+			expectedBody: "20",
+		},
+		{
+			name:         "update_batch_metric_wrong_JSON: FAIL",
+			method:       http.MethodPost,
+			path:         "/updates/",
+			body:         `[{ "id": "test", "type": "gauge", "value": 20.0}, { "id": "count", "type": "counter", "delta": 20}`,
+			expectedCode: 400,
+		},
+		{
+			name:         "get_all_metrics: OK",
+			method:       http.MethodGet,
+			path:         "/",
+			body:         "",
+			expectedCode: 200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := testRequest(t, ts, tt.method, tt.path, "")
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+			if err := resp.Body.Close(); err != nil {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestSaveMetricsTickerRecovery(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	require.NoError(t, os.Mkdir(dir, 0o700))
+	cfg := &models.Config{
+		StoreInterval:   1,
+		FileStoragePath: filepath.Join(dir, "metrics-db.json"),
+		Logger:          zap.NewNop(),
+		ContextTimeout:  3,
+	}
+	s, err := storage.NewFileStorage(cfg)
+	require.NoError(t, err)
+	s.SaveMetricsTicker(cfg)
+
+	// Saves fail while the directory of the file is missing.
+	require.NoError(t, os.RemoveAll(dir))
+	var failedAt time.Time
+	require.Eventually(t, func() bool {
+		at, err := s.LastSave()
+		failedAt = at
+		return err != nil
+	}, 5*time.Second, 100*time.Millisecond)
+
+	require.NoError(t, os.Mkdir(dir, 0o700))
+	assert.Eventually(t, func() bool {
+		at, err := s.LastSave()
+		return err == nil && at.After(failedAt)
+	}, 5*time.Second, 100*time.Millisecond)
+}
+
+func TestGetMetricHistory(t *testing.T) {
+	logConfig := zap.NewDevelopmentConfig()
+	logger, err := logConfig.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &models.Config{
+		Address:        "http://localhost:8080",
+		StoreInterval:  300,
+		Logger:         logger,
+		ContextTimeout: 3,
+	}
+	s, err := storage.NewMemStorage(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []float64{10, 30, 20} {
+		_, err := s.UpdateGaugeMetric(cfg, "HeapAlloc", v)
+		require.NoError(t, err)
+	}
+	for _, d := range []int64{2, 3} {
+		_, err := s.UpdateCounterMetric(cfg, "PollCount", d)
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.RollupHistory(cfg))
+
+	mr := NewMetricResource(s, cfg)
+	ts := httptest.NewServer(NewMetricRouter(mr))
+	defer ts.Close()
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+		expectedLen  int
+	}{
+		{
+			name:         "raw_gauge_history: OK",
+			path:         "/history/gauge/HeapAlloc",
+			expectedCode: 200,
+			expectedLen:  3,
+		},
+		{
+			name:         "minute_gauge_history: OK",
+			path:         "/history/gauge/HeapAlloc?step=1m",
+			expectedCode: 200,
+			expectedLen:  1,
+		},
+		{
+			name:         "hour_counter_history: OK",
+			path:         "/history/counter/PollCount?step=1h",
+			expectedCode: 200,
+			expectedLen:  1,
+		},
+		{
+			name:         "unknown_metric_history: OK",
+			path:         "/history/gauge/Unknown?step=1m",
+			expectedCode: 200,
+			expectedLen:  0,
+		},
+		{
+			name:         "wrong_type_history: FAIL",
+			path:         "/history/histogram/HeapAlloc",
+			expectedCode: 400,
+		},
+		{
+			name:         "wrong_step_history: FAIL",
+			path:         "/history/gauge/HeapAlloc?step=minute",
+			expectedCode: 400,
+		},
+		{
+			name:         "wrong_from_history: FAIL",
+			path:         "/history/gauge/HeapAlloc?from=yesterday",
+			expectedCode: 400,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := ts.Client().Get(ts.URL + tt.path)
+			require.NoError(t, err)
+			defer func() {
+				if err := resp.Body.Close(); err != nil {
+					assert.Error(t, err)
+				}
+			}()
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+			if tt.expectedCode != http.StatusOK {
+				return
+			}
+			var points []models.HistoryPoint
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&points))
+			assert.Len(t, points, tt.expectedLen)
+		})
+	}
+
+	t.Run("aggregated_values: OK", func(t *testing.T) {
+		points, err := s.GetMetricHistory(cfg, "gauge", "HeapAlloc", time.Now().Add(-time.Hour), time.Now(), time.Hour)
+		require.NoError(t, err)
+		require.Len(t, points, 1)
+		assert.Equal(t, float64(10), points[0].Min)
+		assert.Equal(t, float64(30), points[0].Max)
+		assert.Equal(t, float64(20), points[0].Avg)
+		assert.Equal(t, float64(20), points[0].Last)
+		assert.Equal(t, int64(3), points[0].Count)
+
+		points, err = s.GetMetricHistory(cfg, "counter", "PollCount", time.Now().Add(-time.Hour), time.Now(), time.Hour)
+		require.NoError(t, err)
+		require.Len(t, points, 1)
+		require.NotNil(t, points[0].Increase)
+		assert.Equal(t, int64(5), *points[0].Increase)
+		assert.Equal(t, float64(5), points[0].Last)
+	})
+
+	t.Run("raw_retention_without_rollups: OK", func(t *testing.T) {
+		rc := &models.Config{Logger: logger, ContextTimeout: 3, Retention: models.Retention{Raw: 1}}
+		rs, err := storage.NewMemStorage(rc)
+		require.NoError(t, err)
+		_, err = rs.UpdateGaugeMetric(rc, "HeapAlloc", 1)
+		require.NoError(t, err)
+		time.Sleep(1100 * time.Millisecond)
+		_, err = rs.UpdateGaugeMetric(rc, "HeapAlloc", 2)
+		require.NoError(t, err)
+
+		points, err := rs.GetMetricHistory(rc, "gauge", "HeapAlloc", time.Now().Add(-time.Hour), time.Now(), 0)
+		require.NoError(t, err)
+		require.Len(t, points, 1, "expired raw samples are dropped on append")
+		assert.Equal(t, float64(2), points[0].Last)
+	})
+}
+
+func TestAdminAPI(t *testing.T) {
+	logConfig := zap.NewDevelopmentConfig()
+	logger, err := logConfig.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &models.Config{
+		Address:        "http://localhost:8080",
+		StoreInterval:  300,
+		Logger:         logger,
+		ContextTimeout: 3,
+		AdminToken:     "secret",
+	}
+	s, err := storage.NewMemStorage(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"HeapAlloc", "test.one", "test.two"} {
+		_, err := s.UpdateGaugeMetric(cfg, name, 1)
+		require.NoError(t, err)
+	}
+	_, err = s.UpdateCounterMetric(cfg, "PollCount", 5)
+	require.NoError(t, err)
+
+	mr := NewMetricResource(s, cfg)
+	ts := httptest.NewServer(NewMetricRouter(mr))
+	defer ts.Close()
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		token        string
+		expectedBody string
+		expectedCode int
+	}{
+		{
+			name:         "no_token: FAIL",
+			method:       http.MethodDelete,
+			path:         "/value/gauge/HeapAlloc",
+			expectedCode: 401,
+		},
+		{
+			name:         "wrong_token: FAIL",
+			method:       http.MethodDelete,
+			path:         "/value/gauge/HeapAlloc",
+			token:        "guess",
+			expectedCode: 401,
+		},
+		{
+			name:         "delete_gauge: OK",
+			method:       http.MethodDelete,
+			path:         "/value/gauge/HeapAlloc",
+			token:        "secret",
+			expectedCode: 200,
+		},
+		{
+			name:         "delete_deleted_gauge: FAIL",
+			method:       http.MethodDelete,
+			path:         "/value/gauge/HeapAlloc",
+			token:        "secret",
+			expectedCode: 404,
+		},
+		{
+			name:         "delete_wrong_type: FAIL",
+			method:       http.MethodDelete,
+			path:         "/value/histogram/HeapAlloc",
+			token:        "secret",
+			expectedCode: 400,
+		},
+		{
+			name:         "reset_counter: OK",
+			method:       http.MethodPost,
+			path:         "/admin/reset/PollCount",
+			token:        "secret",
+			expectedCode: 200,
+		},
+		{
+			name:         "get_reset_counter: OK",
+			method:       http.MethodGet,
+			path:         "/value/counter/PollCount",
+			expectedCode: 200,
+			expectedBody: "0",
+		},
+		{
+			name:         "reset_unknown_counter: FAIL",
+			method:       http.MethodPost,
+			path:         "/admin/reset/Unknown",
+			token:        "secret",
+			expectedCode: 404,
+		},
+		{
+			name:         "delete_by_prefix: OK",
+			method:       http.MethodDelete,
+			path:         "/admin/metrics?prefix=test.",
+			token:        "secret",
+			expectedCode: 200,
+			expectedBody: `{"deleted":2}`,
+		},
+		{
+			name:         "delete_without_prefix: FAIL",
+			method:       http.MethodDelete,
+			path:         "/admin/metrics",
+			token:        "secret",
+			expectedCode: 400,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.path, http.NoBody)
+			require.NoError(t, err)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer func() {
+				if err := resp.Body.Close(); err != nil {
+					assert.Error(t, err)
+				}
+			}()
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+			if tt.expectedBody != "" {
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectedBody, strings.TrimSpace(string(body)))
+			}
+		})
+	}
+
+	t.Run("admin_disabled: FAIL", func(t *testing.T) {
+		mr := NewMetricResource(s, &models.Config{Logger: logger, ContextTimeout: 3})
+		ts := httptest.NewServer(NewMetricRouter(mr))
+		defer ts.Close()
+
+		resp := testRequest(t, ts, http.MethodPost, "/admin/reset/PollCount", "")
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		if err := resp.Body.Close(); err != nil {
+			assert.Error(t, err)
+		}
+	})
+}
+
+func TestAuth(t *testing.T) {
+	logConfig := zap.NewDevelopmentConfig()
+	logger, err := logConfig.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &models.Config{
+		Address:        "http://localhost:8080",
+		StoreInterval:  300,
+		Logger:         logger,
+		ContextTimeout: 3,
+	}
+	s, err := storage.NewMemStorage(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"cpu.user", "mem.free"} {
+		_, err := s.UpdateGaugeMetric(cfg, name, 1)
+		require.NoError(t, err)
+	}
+
+	hash := func(secret string) string {
+		h := sha256.Sum256([]byte(secret))
+		return hex.EncodeToString(h[:])
+	}
+	mr := NewMetricResource(s, cfg)
+	mr.SetAuth(auth.New(cfg, &auth.File{Tokens: []auth.Token{
+		{Name: "dashboard", SecretSHA256: hash("reader"), Roles: []auth.Role{auth.RoleRead}},
+		{Name: "agent", SecretSHA256: hash("writer"), Roles: []auth.Role{auth.RoleWrite}},
+		{Name: "cpu", SecretSHA256: hash("cpu"), Roles: []auth.Role{auth.RoleRead, auth.RoleWrite},
+			Prefixes: []string{"cpu."}},
+		{Name: "ops", SecretSHA256: hash("admin"), Roles: []auth.Role{auth.RoleAdmin}},
+	}}))
+	ts := httptest.NewServer(NewMetricRouter(mr))
+	defer ts.Close()
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		body         string
+		token        string
+		expectedBody string
+		expectedCode int
+		basic        bool
+	}{
+		{name: "anonymous_read: FAIL", method: http.MethodGet, path: "/value/gauge/cpu.user", expectedCode: 401},
+		{name: "anonymous_write: FAIL", method: http.MethodPost, path: "/update/gauge/cpu.user/2", expectedCode: 401},
+		{name: "anonymous_ping: OK", method: http.MethodGet, path: "/ping", expectedCode: 200},
+		{
+			name:         "invalid_token: FAIL",
+			method:       http.MethodGet,
+			path:         "/ping",
+			token:        "guess",
+			expectedCode: 401,
+		},
+		{
+			name:         "reader_read: OK",
+			method:       http.MethodGet,
+			path:         "/value/gauge/mem.free",
+			token:        "reader",
+			expectedCode: 200,
+			expectedBody: "1",
+		},
+		{
+			name:         "reader_basic_auth: OK",
+			method:       http.MethodGet,
+			path:         "/value/gauge/mem.free",
+			token:        "reader",
+			basic:        true,
+			expectedCode: 200,
+		},
+		{
+			name:         "reader_write: FAIL",
+			method:       http.MethodPost,
+			path:         "/update/gauge/mem.free/2",
+			token:        "reader",
+			expectedCode: 403,
+		},
+		{
+			name:         "writer_write: OK",
+			method:       http.MethodPost,
+			path:         "/update/gauge/mem.free/2",
+			token:        "writer",
+			expectedCode: 200,
+		},
+		{
+			name:         "writer_read: FAIL",
+			method:       http.MethodGet,
+			path:         "/value/gauge/mem.free",
+			token:        "writer",
+			expectedCode: 403,
+		},
+		{
+			name:         "writer_admin: FAIL",
+			method:       http.MethodPost,
+			path:         "/admin/reset/PollCount",
+			token:        "writer",
+			expectedCode: 403,
+		},
+		{
+			name:         "prefix_read: OK",
+			method:       http.MethodGet,
+			path:         "/value/gauge/cpu.user",
+			token:        "cpu",
+			expectedCode: 200,
+		},
+		{
+			name:         "prefix_read_other: FAIL",
+			method:       http.MethodGet,
+			path:         "/value/gauge/mem.free",
+			token:        "cpu",
+			expectedCode: 404,
+		},
+		{
+			name:         "prefix_write_other: FAIL",
+			method:       http.MethodPost,
+			path:         "/update/gauge/mem.free/3",
+			token:        "cpu",
+			expectedCode: 403,
+		},
+		{
+			name:         "prefix_list: OK",
+			method:       http.MethodGet,
+			path:         "/api/v1/metrics?fields=id",
+			token:        "cpu",
+			expectedCode: 200,
+			expectedBody: `{"metrics":[{"id":"cpu.user"}]}`,
+		},
+		{
+			name:         "prefix_batch: PARTIAL",
+			method:       http.MethodPost,
+			path:         "/updates/",
+			body:         `[{"id":"cpu.system","type":"gauge","value":1},{"id":"mem.used","type":"gauge","value":1}]`,
+			token:        "cpu",
+			expectedCode: 207,
+		},
+		{
+			name:         "prefix_batch_other: FAIL",
+			method:       http.MethodPost,
+			path:         "/updates/",
+			body:         `[{"id":"mem.used","type":"gauge","value":1}]`,
+			token:        "cpu",
+			expectedCode: 403,
+		},
+		{
+			name:         "reader_debug: FAIL",
+			method:       http.MethodGet,
+			path:         "/debug/pprof/cmdline",
+			token:        "reader",
+			expectedCode: 403,
+		},
+		{
+			name:         "admin_debug: OK",
+			method:       http.MethodGet,
+			path:         "/debug/pprof/cmdline",
+			token:        "admin",
+			expectedCode: 200,
+		},
+		{
+			name:         "admin_read: OK",
+			method:       http.MethodGet,
+			path:         "/value/gauge/mem.free",
+			token:        "admin",
+			expectedCode: 200,
+			expectedBody: "2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader(tt.body))
+			require.NoError(t, err)
+			switch {
+			case tt.basic:
+				req.SetBasicAuth("user", tt.token)
+			case tt.token != "":
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer func() {
+				if err := resp.Body.Close(); err != nil {
+					assert.Error(t, err)
+				}
+			}()
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+			if tt.expectedCode == http.StatusUnauthorized {
+				assert.NotEmpty(t, resp.Header.Get("WWW-Authenticate"))
+			}
+			if tt.expectedBody != "" {
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectedBody, strings.TrimSpace(string(body)))
+			}
+		})
+	}
+}
+
+func TestSignature(t *testing.T) {
+	logConfig := zap.NewDevelopmentConfig()
+	logger, err := logConfig.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const key = "signkey"
+	cfg := &models.Config{
+		Address:        "http://localhost:8080",
+		StoreInterval:  300,
+		Logger:         logger,
+		ContextTimeout: 3,
+		HashKey:        key,
+		SignStrict:     true,
+		SignMaxAge:     300,
+	}
+	s, err := storage.NewMemStorage(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(NewMetricRouter(NewMetricResource(s, cfg)))
+	defer ts.Close()
+
+	body := `[{"id":"PollCount","type":"counter","delta":1}]`
+	legacy := hmac.New(sha256.New, []byte(key))
+	_, err = legacy.Write([]byte(body))
+	require.NoError(t, err)
+
+	now := time.Now().Unix()
+	tests := []struct {
+		headers      map[string]string
+		name         string
+		path         string
+		expectedCode string
+	}{
+		{
+			name: "signed: OK",
+			path: "/updates/",
+			headers: map[string]string{
+				signing.HeaderSignature: signing.Sign(key, http.MethodPost, "/updates/", now, "n1", []byte(body)),
+				signing.HeaderTimestamp: strconv.FormatInt(now, 10),
+				signing.HeaderNonce:     "n1",
+			},
+		},
+		{
+			name: "replayed: FAIL",
+			path: "/updates/",
+			headers: map[string]string{
+				signing.HeaderSignature: signing.Sign(key, http.MethodPost, "/updates/", now, "n1", []byte(body)),
+				signing.HeaderTimestamp: strconv.FormatInt(now, 10),
+				signing.HeaderNonce:     "n1",
+			},
+			expectedCode: problem.ReplayedRequest,
+		},
+		{
+			name: "stale: FAIL",
+			path: "/updates/",
+			headers: map[string]string{
+				signing.HeaderSignature: signing.Sign(key, http.MethodPost, "/updates/", now-600, "n2", []byte(body)),
+				signing.HeaderTimestamp: strconv.FormatInt(now-600, 10),
+				signing.HeaderNonce:     "n2",
+			},
+			expectedCode: problem.StaleSignature,
+		},
+		{
+			name: "other_path: FAIL",
+			path: "/update/",
+			headers: map[string]string{
+				signing.HeaderSignature: signing.Sign(key, http.MethodPost, "/updates/", now, "n3", []byte(body)),
+				signing.HeaderTimestamp: strconv.FormatInt(now, 10),
+				signing.HeaderNonce:     "n3",
+			},
+			expectedCode: problem.InvalidSignature,
+		},
+		{
+			name:         "legacy: FAIL",
+			path:         "/updates/",
+			headers:      map[string]string{signing.HeaderSignature: hex.EncodeToString(legacy.Sum(nil))},
+			expectedCode: problem.SignatureRequired,
+		},
+		{name: "unsigned: FAIL", path: "/updates/", expectedCode: problem.SignatureRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+tt.path, strings.NewReader(body))
+			require.NoError(t, err)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer func() {
+				if err := resp.Body.Close(); err != nil {
+					assert.Error(t, err)
+				}
+			}()
+			if tt.expectedCode == "" {
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+				return
+			}
+			var pd problem.Details
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&pd))
+			assert.Equal(t, tt.expectedCode, pd.Code)
+		})
+	}
+
+	t.Run("rotated_key: OK", func(t *testing.T) {
+		const rotated = "rotatedkey"
+		r := cfg.Reloadable()
+		r.HashKey = rotated
+		cfg.Reload(&r)
+
+		post := func(key, nonce string) int {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/updates/", strings.NewReader(body))
+			require.NoError(t, err)
+			req.Header.Set(signing.HeaderSignature, signing.Sign(key, http.MethodPost, "/updates/", now, nonce, []byte(body)))
+			req.Header.Set(signing.HeaderTimestamp, strconv.FormatInt(now, 10))
+			req.Header.Set(signing.HeaderNonce, nonce)
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			return resp.StatusCode
+		}
+		assert.Equal(t, http.StatusBadRequest, post(key, "n4"), "old key is rejected")
+		assert.Equal(t, http.StatusOK, post(rotated, "n5"))
+	})
+
+	counter, _, err := s.GetCounterMetric(cfg, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), counter)
+}
+
+func TestRateLimit(t *testing.T) {
+	logConfig := zap.NewDevelopmentConfig()
+	logger, err := logConfig.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &models.Config{
+		Address:        "http://localhost:8080",
+		StoreInterval:  300,
+		Logger:         logger,
+		ContextTimeout: 3,
+		RateLimit:      0.001,
+		RateBurst:      4,
+		MaxBatchSize:   3,
+		AgentMaxSeries: 2,
+		TrustedProxies: loopback,
+	}
+	s, err := storage.NewMemStorage(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(NewMetricRouter(NewMetricResource(s, cfg)))
+	defer ts.Close()
+
+	tests := []struct {
+		name         string
+		path         string
+		body         string
+		realIP       string
+		expectedCode int
+		retryAfter   bool
+	}{
+		{
+			name:         "batch: OK",
+			path:         "/updates/",
+			body:         `[{"id":"Alloc","type":"gauge","value":1}]`,
+			realIP:       "10.0.0.1",
+			expectedCode: 200,
+		},
+		{
+			name: "batch_too_large: FAIL",
+			path: "/updates/",
+			body: `[{"id":"Alloc","type":"gauge","value":1},{"id":"Alloc","type":"gauge","value":2},
+				{"id":"Alloc","type":"gauge","value":3},{"id":"Alloc","type":"gauge","value":4}]`,
+			realIP:       "10.0.0.1",
+			expectedCode: 413,
+		},
+		{
+			name:         "series_limit: PARTIAL",
+			path:         "/updates/",
+			body:         `[{"id":"Frees","type":"gauge","value":1},{"id":"Mallocs","type":"gauge","value":1}]`,
+			realIP:       "10.0.0.1",
+			expectedCode: 207,
+		},
+		{
+			name:         "series_limit: FAIL",
+			path:         "/update/gauge/Mallocs/1",
+			realIP:       "10.0.0.1",
+			expectedCode: 429,
+		},
+		{
+			name:         "rate_limit: FAIL",
+			path:         "/update/gauge/Alloc/1",
+			realIP:       "10.0.0.1",
+			expectedCode: 429,
+			retryAfter:   true,
+		},
+		{
+			name:         "other_agent: OK",
+			path:         "/update/gauge/Mallocs/1",
+			realIP:       "10.0.0.2",
+			expectedCode: 200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+tt.path, strings.NewReader(tt.body))
+			require.NoError(t, err)
+			req.Header.Set("X-Real-IP", tt.realIP)
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			if err := resp.Body.Close(); err != nil {
+				assert.Error(t, err)
+			}
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+			assert.Equal(t, tt.retryAfter, resp.Header.Get("Retry-After") != "")
+		})
+	}
+
+	t.Run("rejected_series_are_released: OK", func(t *testing.T) {
+		cfg := &models.Config{Logger: logger, ContextTimeout: 3, AgentMaxSeries: 1, MaxSeries: 1, TrustedProxies: loopback}
+		s := mock_handlers.NewMockStorage(gomock.NewController(t))
+		s.EXPECT().UpdateGaugeMetric(gomock.Any(), "Alloc", 1.0).Return(0.0, errors.New("connection refused"))
+		s.EXPECT().UpdateGaugeMetric(gomock.Any(), "Frees", 1.0).Return(1.0, nil).Times(2)
+		ts := httptest.NewServer(NewMetricRouter(NewMetricResource(s, cfg)))
+		defer ts.Close()
+
+		post := func(path, realIP string) int {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+path, http.NoBody)
+			require.NoError(t, err)
+			req.Header.Set("X-Real-IP", realIP)
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			if err := resp.Body.Close(); err != nil {
+				assert.Error(t, err)
+			}
+			return resp.StatusCode
+		}
+		assert.Equal(t, 500, post("/update/gauge/Alloc/1", "10.0.0.1"))
+		assert.Equal(t, 200, post("/update/gauge/Frees/1", "10.0.0.1"), "series of failed writes aren't counted")
+		assert.Equal(t, 429, post("/update/gauge/Sys/1", "10.0.0.2"), "server is limited to one series")
+		assert.Equal(t, 200, post("/update/gauge/Frees/1", "10.0.0.2"), "series rejected by the server aren't counted")
+	})
+}
+
+func TestValidation(t *testing.T) {
+	logConfig := zap.NewDevelopmentConfig()
+	logger, err := logConfig.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, overflow := range []string{validation.OverflowReject, validation.OverflowDrop} {
+		cfg := &models.Config{
+			Address:        "http://localhost:8080",
+			StoreInterval:  300,
+			Logger:         logger,
+			ContextTimeout: 3,
+			MaxNameLength:  16,
+			MaxSeries:      2,
+			SeriesOverflow: overflow,
+		}
+		s, err := storage.NewMemStorage(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts := httptest.NewServer(NewMetricRouter(NewMetricResource(s, cfg)))
+
+		overflowCode := 429
+		if overflow == validation.OverflowDrop {
+			overflowCode = 200
+		}
+		tests := []struct {
+			name         string
+			path         string
+			body         string
+			expectedCode int
+		}{
+			{name: "valid: OK", path: "/update/gauge/Alloc/1", expectedCode: 200},
+			{name: "pattern: FAIL", path: "/update/gauge/1Alloc/1", expectedCode: 400},
+			{name: "too_long: FAIL", path: "/update/gauge/AllocAllocAllocAlloc/1", expectedCode: 400},
+			{name: "reserved: FAIL", path: "/update/counter/gometrics.requests/1", expectedCode: 400},
+			{
+				name:         "batch_invalid_name: PARTIAL",
+				path:         "/updates/",
+				body:         `[{"id":"Frees","type":"gauge","value":1},{"id":"Fr ees","type":"gauge","value":1}]`,
+				expectedCode: 207,
+			},
+			{name: "known_series: OK", path: "/update/gauge/Frees/2", expectedCode: 200},
+			{name: "series_overflow: " + overflow, path: "/update/gauge/Mallocs/1", expectedCode: overflowCode},
+		}
+		for _, tt := range tests {
+			t.Run(overflow+"/"+tt.name, func(t *testing.T) {
+				resp, err := ts.Client().Post(ts.URL+tt.path, "application/json", strings.NewReader(tt.body))
+				require.NoError(t, err)
+				if err := resp.Body.Close(); err != nil {
+					assert.Error(t, err)
+				}
+				assert.Equal(t, tt.expectedCode, resp.StatusCode)
+			})
+		}
+
+		_, _, err = s.GetGaugeMetric(cfg, "Mallocs")
+		assert.Error(t, err, "series over the limit are not stored")
+		ts.Close()
+	}
+
+	t.Run("failed_write_releases_series: OK", func(t *testing.T) {
+		cfg := &models.Config{Logger: logger, ContextTimeout: 3, MaxSeries: 1}
+		s := mock_handlers.NewMockStorage(gomock.NewController(t))
+		s.EXPECT().UpdateGaugeMetric(gomock.Any(), "Alloc", 1.0).Return(0.0, errors.New("connection refused"))
+		s.EXPECT().UpdateBatch(gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("connection refused"))
+		s.EXPECT().UpdateGaugeMetric(gomock.Any(), "Mallocs", 1.0).Return(1.0, nil)
+		ts := httptest.NewServer(NewMetricRouter(NewMetricResource(s, cfg)))
+		defer ts.Close()
+
+		post := func(path, body string) int {
+			resp, err := ts.Client().Post(ts.URL+path, "application/json", strings.NewReader(body))
+			require.NoError(t, err)
+			if err := resp.Body.Close(); err != nil {
+				assert.Error(t, err)
+			}
+			return resp.StatusCode
+		}
+		assert.Equal(t, 500, post("/update/gauge/Alloc/1", ""))
+		assert.Equal(t, 500, post("/updates/", `[{"id":"Frees","type":"gauge","value":1}]`))
+		assert.Equal(t, 200, post("/update/gauge/Mallocs/1", ""), "series of failed writes aren't counted")
+	})
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	cfg := &models.Config{
+		Logger:          zap.NewNop(),
+		ContextTimeout:  3,
+		FileStoragePath: filepath.Join(t.TempDir(), "metrics.json"),
+	}
+	mem, err := storage.NewMemStorage(cfg)
+	require.NoError(t, err)
+	file, err := storage.NewFileStorage(cfg)
+	require.NoError(t, err)
+
+	for name, s := range map[string]Storage{"mem": mem, "file": file} {
+		t.Run(name+": OK", func(t *testing.T) {
+			ts := httptest.NewServer(NewMetricRouter(NewMetricResource(s, cfg)))
+			defer ts.Close()
+
+			// Background writers like self-metrics and recording rules write while requests are served.
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range 100 {
+					v := float64(i)
+					g := models.Metrics{{ID: "Rule" + strconv.Itoa(i), MType: "gauge", Value: &v}}
+					assert.NoError(t, s.UpdateBatch(cfg, g, nil))
+				}
+			}()
+			for i := range 20 {
+				resp, err := ts.Client().Post(ts.URL+"/update/counter/PollCount"+strconv.Itoa(i)+"/1", "text/plain", nil)
+				require.NoError(t, err)
+				if err := resp.Body.Close(); err != nil {
+					assert.Error(t, err)
+				}
+				resp, err = ts.Client().Get(ts.URL + "/")
+				require.NoError(t, err)
+				if err := resp.Body.Close(); err != nil {
+					assert.Error(t, err)
+				}
+			}
+			wg.Wait()
+
+			gauges, counters, err := s.GetAllMetrics(cfg)
+			require.NoError(t, err)
+			assert.Len(t, gauges, 100)
+			assert.Len(t, counters, 20)
+		})
+	}
+}
+
+func TestSelfMetrics(t *testing.T) {
+	logConfig := zap.NewDevelopmentConfig()
+	logger, err := logConfig.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &models.Config{
+		Address:        "http://localhost:8080",
+		StoreInterval:  300,
+		Logger:         logger,
+		ContextTimeout: 3,
+	}
+	tenants, err := NewTenantStores(cfg)
+	require.NoError(t, err)
+	ts := httptest.NewServer(NewMetricRouter(NewTenantMetricResource(tenants, cfg)))
+	defer ts.Close()
+
+	const route = "http.update.metricType.metricName.metricValue.post"
+	counters := []string{
+		route + ".requests",
+		route + ".status_2xx",
+		route + ".status_4xx",
+		route + ".duration.count",
+		"http.updates.post.requests",
+		"http.batch_size.le_10",
+		"http.unmatched.get.status_4xx",
+		"http.unmatched.other.requests",
+		"storage.memory.update_gauge.duration.count",
+		"storage.memory.update_batch.duration.count",
+	}
+	before := make(map[string]int64, len(counters))
+	for _, name := range counters {
+		before[name] = selfmetrics.Value(name)
+	}
+
+	requests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{method: http.MethodPost, path: "/update/gauge/Alloc/1"},
+		{method: http.MethodPost, path: "/update/gauge/Alloc/none"},
+		{method: http.MethodPost, path: "/updates/", body: `[{"id":"PollCount","type":"counter","delta":1}]`},
+		{method: http.MethodGet, path: "/unknown/path"},
+		{method: "BREW", path: "/unknown/path"},
+		{method: "BREW", path: "/update/gauge/Alloc/1"},
+	}
+	for _, tr := range requests {
+		req, err := http.NewRequest(tr.method, ts.URL+tr.path, strings.NewReader(tr.body))
+		require.NoError(t, err)
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		if err := resp.Body.Close(); err != nil {
+			assert.Error(t, err)
+		}
+	}
+
+	want := map[string]int64{route + ".requests": 2, route + ".duration.count": 2, "http.unmatched.other.requests": 2}
+	for _, name := range counters {
+		delta, ok := want[name]
+		if !ok {
+			delta = 1
+		}
+		assert.Equal(t, before[name]+delta, selfmetrics.Value(name), name)
+	}
+	assert.Zero(t, selfmetrics.Value("http.unmatched.brew.requests"))
+}
+
+func TestHealth(t *testing.T) {
+	logConfig := zap.NewDevelopmentConfig()
+	logger, err := logConfig.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	cfg := &models.Config{
+		Address:         "http://localhost:8080",
+		FileStoragePath: filepath.Join(dir, "metrics.json"),
+		Logger:          logger,
+		ContextTimeout:  3,
+	}
+	tenants, err := NewTenantStores(cfg)
+	require.NoError(t, err)
+	defer tenants.Close()
+	ts := httptest.NewServer(NewMetricRouter(NewTenantMetricResource(tenants, cfg)))
+	defer ts.Close()
+
+	get := func(path string) (int, map[string]any) {
+		resp, err := ts.Client().Get(ts.URL + path)
+		require.NoError(t, err)
+		defer func() {
+			if err := resp.Body.Close(); err != nil {
+				assert.Error(t, err)
+			}
+		}()
+		var body map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return resp.StatusCode, body
+	}
+	components := func(body map[string]any) map[string]string {
+		statuses := make(map[string]string)
+		list, _ := body["components"].([]any)
+		for _, c := range list {
+			c, _ := c.(map[string]any)
+			name, _ := c["name"].(string)
+			statuses[name], _ = c["status"].(string)
+		}
+		return statuses
+	}
+
+	code, body := get("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", body["status"])
+
+	code, body = get("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]string{
+		"storage":      "ok",
+		"storage_save": "ok",
+		"migrations":   "disabled",
+		"key_exchange": "disabled",
+	}, components(body))
+
+	// Saves fail once the directory of the storage file is gone.
+	require.NoError(t, os.RemoveAll(dir))
+	resp, err := ts.Client().Post(ts.URL+"/update/gauge/Alloc/1", "text/plain", http.NoBody)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	code, body = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "failing", body["status"])
+	assert.Equal(t, "failing", components(body)["storage_save"])
+
+	code, _ = get("/healthz")
+	assert.Equal(t, http.StatusOK, code, "failing components don't affect liveness")
+}
+
+func TestMetadata(t *testing.T) {
+	logConfig := zap.NewDevelopmentConfig()
+	logger, err := logConfig.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &models.Config{
+		Address:        "http://localhost:8080",
+		StoreInterval:  300,
+		Logger:         logger,
+		ContextTimeout: 3,
+	}
+	s, err := storage.NewMemStorage(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mr := NewMetricResource(s, cfg)
+	ts := httptest.NewServer(NewMetricRouter(mr))
+	defer ts.Close()
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		body         string
+		expectedCode int
+	}{
+		{
+			name:         "update_gauge_with_unit: OK",
+			method:       http.MethodPost,
+			path:         "/update/",
+			body:         `{"id":"Alloc","type":"gauge","value":1,"unit":"bytes","description":"Allocated heap"}`,
+			expectedCode: 200,
+		},
+		{
+			name:         "update_gauge_again: OK",
+			method:       http.MethodPost,
+			path:         "/update/gauge/Alloc/2",
+			expectedCode: 200,
+		},
+		{
+			name:         "update_as_counter: FAIL",
+			method:       http.MethodPost,
+			path:         "/update/counter/Alloc/1",
+			expectedCode: 409,
+		},
+		{
+			name:         "update_json_as_counter: FAIL",
+			method:       http.MethodPost,
+			path:         "/update/",
+			body:         `{"id":"Alloc","type":"counter","delta":1}`,
+			expectedCode: 409,
+		},
+		{
+			name:         "batch_with_conflict: PARTIAL",
+			method:       http.MethodPost,
+			path:         "/updates/",
+			body:         `[{"id":"PollCount","type":"counter","delta":1},{"id":"Alloc","type":"counter","delta":1}]`,
+			expectedCode: 207,
+		},
+		{
+			name:         "batch_all_conflict: FAIL",
+			method:       http.MethodPost,
+			path:         "/updates/",
+			body:         `[{"id":"Alloc","type":"counter","delta":1},{"id":"Alloc","type":"counter","delta":2}]`,
+			expectedCode: 409,
+		},
+		{
+			name:         "batch_both_types: PARTIAL",
+			method:       http.MethodPost,
+			path:         "/updates/",
+			body:         `[{"id":"Sys","type":"counter","delta":1},{"id":"Sys","type":"gauge","value":1}]`,
+			expectedCode: 207,
+		},
+		{
+			name:         "batch: OK",
+			method:       http.MethodPost,
+			path:         "/updates/",
+			body:         `[{"id":"PollCount","type":"counter","delta":1,"unit":"polls"}]`,
+			expectedCode: 200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := testRequest(t, ts, tt.method, tt.path, tt.body)
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+			if err := resp.Body.Close(); err != nil {
+				assert.Error(t, err)
+			}
+		})
+	}
+
+	t.Run("partial_batch: OK", func(t *testing.T) {
+		_, _, err := s.GetGaugeMetric(cfg, "Sys")
+		assert.Error(t, err)
+		v, _, err := s.GetCounterMetric(cfg, "Sys")
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), v)
+		v, _, err = s.GetCounterMetric(cfg, "PollCount")
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), v)
+	})
+
+	t.Run("get_metadata: OK", func(t *testing.T) {
+		resp, err := ts.Client().Get(ts.URL + "/api/v1/metadata")
+		require.NoError(t, err)
+		defer func() {
+			if err := resp.Body.Close(); err != nil {
+				assert.Error(t, err)
+			}
+		}()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var mds []models.MetricMetadata
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&mds))
+		require.Len(t, mds, 3)
+		assert.Equal(t, "Alloc", mds[0].Name)
+		assert.Equal(t, "gauge", mds[0].MType)
+		assert.Equal(t, "bytes", mds[0].Unit)
+		assert.Equal(t, "Allocated heap", mds[0].Description)
+		assert.False(t, mds[0].CreatedAt.IsZero())
+		assert.Equal(t, "PollCount", mds[1].Name)
+		assert.Equal(t, "counter", mds[1].MType)
+		assert.Equal(t, "polls", mds[1].Unit)
+		assert.Equal(t, "Sys", mds[2].Name)
+		assert.Equal(t, "counter", mds[2].MType)
+	})
+
+	t.Run("get_metadata_by_name: OK", func(t *testing.T) {
+		resp, err := ts.Client().Get(ts.URL + "/api/v1/metadata?name=PollCount")
+		require.NoError(t, err)
+		defer func() {
+			if err := resp.Body.Close(); err != nil {
+				assert.Error(t, err)
+			}
+		}()
+
+		var mds []models.MetricMetadata
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&mds))
+		require.Len(t, mds, 1)
+		assert.Equal(t, "PollCount", mds[0].Name)
+	})
+
+	t.Run("delete_forgets_type: OK", func(t *testing.T) {
+		_, err := s.DeleteMetric(cfg, "gauge", "Alloc")
+		require.NoError(t, err)
+		_, err = s.UpdateCounterMetric(cfg, "Alloc", 1)
+		assert.NoError(t, err)
+	})
+}
+
+func TestProblemDetails(t *testing.T) {
+	logConfig := zap.NewDevelopmentConfig()
+	logger, err := logConfig.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &models.Config{
+		Address:        "http://localhost:8080",
+		StoreInterval:  300,
+		Logger:         logger,
+		ContextTimeout: 3,
+	}
+	s, err := storage.NewMemStorage(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.UpdateGaugeMetric(cfg, "Alloc", 1)
+	require.NoError(t, err)
+
+	mr := NewMetricResource(s, cfg)
+	ts := httptest.NewServer(NewMetricRouter(mr))
+	defer ts.Close()
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		body         string
+		expectedCode string
+		expectedID   string
+		expectedErrs []problem.Item
+		expectedStat int
+	}{
+		{
+			name:         "malformed_json: FAIL",
+			method:       http.MethodPost,
+			path:         "/update/",
+			body:         `{"id":"Alloc",`,
+			expectedStat: http.StatusBadRequest,
+			expectedCode: problem.InvalidJSON,
+		},
+		{
+			name:         "malformed_batch_json: FAIL",
+			method:       http.MethodPost,
+			path:         "/updates/",
+			body:         `[{"id":"Alloc"`,
+			expectedStat: http.StatusBadRequest,
+			expectedCode: problem.InvalidJSON,
+		},
+		{
+			name:         "invalid_value: FAIL",
+			method:       http.MethodPost,
+			path:         "/update/gauge/Sys/abc",
+			expectedStat: http.StatusBadRequest,
+			expectedCode: problem.InvalidValue,
+			expectedID:   "Sys",
+		},
+		{
+			name:         "nan_value: FAIL",
+			method:       http.MethodPost,
+			path:         "/update/gauge/Sys/NaN",
+			expectedStat: http.StatusBadRequest,
+			expectedCode: problem.InvalidValue,
+			expectedID:   "Sys",
+		},
+		{
+			name:         "inf_value: FAIL",
+			method:       http.MethodPost,
+			path:         "/update/gauge/Sys/-Inf",
+			expectedStat: http.StatusBadRequest,
+			expectedCode: problem.InvalidValue,
+			expectedID:   "Sys",
+		},
+		{
+			name:         "missing_value: FAIL",
+			method:       http.MethodPost,
+			path:         "/update/",
+			body:         `{"id":"Sys","type":"gauge"}`,
+			expectedStat: http.StatusBadRequest,
+			expectedCode: problem.InvalidValue,
+			expectedID:   "Sys",
+		},
+		{
+			name:         "long_unit: FAIL",
+			method:       http.MethodPost,
+			path:         "/update/",
+			body:         `{"id":"Sys","type":"gauge","value":1,"unit":"` + strings.Repeat("b", 65) + `"}`,
+			expectedStat: http.StatusBadRequest,
+			expectedCode: problem.InvalidMetadata,
+			expectedID:   "Sys",
+		},
+		{
+			name:         "unknown_type: FAIL",
+			method:       http.MethodGet,
+			path:         "/value/histogram/Alloc",
+			expectedStat: http.StatusBadRequest,
+			expectedCode: problem.InvalidMetricType,
+		},
+		{
+			name:         "not_found: FAIL",
+			method:       http.MethodGet,
+			path:         "/value/gauge/Unknown",
+			expectedStat: http.StatusNotFound,
+			expectedCode: problem.NotFound,
+			expectedID:   "Unknown",
+		},
+		{
+			name:         "type_conflict: FAIL",
+			method:       http.MethodPost,
+			path:         "/update/counter/Alloc/1",
+			expectedStat: http.StatusConflict,
+			expectedCode: problem.TypeConflict,
+			expectedID:   "Alloc",
+		},
+		{
+			name:         "invalid_query: FAIL",
+			method:       http.MethodGet,
+			path:         "/api/v1/query?query=sum(",
+			expectedStat: http.StatusBadRequest,
+			expectedCode: problem.InvalidQuery,
+		},
+		{
+			name:         "batch_rejected: FAIL",
+			method:       http.MethodPost,
+			path:         "/updates/",
+			body:         `[{"id":"Alloc","type":"counter","delta":1},{"id":"Sys","type":"histogram"}]`,
+			expectedStat: http.StatusBadRequest,
+			expectedCode: problem.BatchRejected,
+			expectedErrs: []problem.Item{
+				{Index: 0, ID: "Alloc", Code: problem.TypeConflict},
+				{Index: 1, ID: "Sys", Code: problem.InvalidMetricType},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader(tt.body))
+			require.NoError(t, err)
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer func() {
+				if err := resp.Body.Close(); err != nil {
+					assert.Error(t, err)
+				}
+			}()
+
+			assert.Equal(t, tt.expectedStat, resp.StatusCode)
+			assert.Equal(t, problem.ContentType, resp.Header.Get(contentType))
+
+			var p problem.Details
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&p))
+			assert.Equal(t, tt.expectedStat, p.Status)
+			assert.Equal(t, http.StatusText(tt.expectedStat), p.Title)
+			assert.Equal(t, tt.expectedCode, p.Code)
+			assert.Equal(t, tt.expectedID, p.MetricID)
+			assert.Equal(t, req.URL.Path, p.Instance)
+			require.Len(t, p.Errors, len(tt.expectedErrs))
+			for i, item := range tt.expectedErrs {
+				assert.Equal(t, item.Index, p.Errors[i].Index)
+				assert.Equal(t, item.ID, p.Errors[i].ID)
+				assert.Equal(t, item.Code, p.Errors[i].Code)
+				assert.NotEmpty(t, p.Errors[i].Detail)
+			}
+		})
+	}
+
+	t.Run("partial_batch: OK", func(t *testing.T) {
+		body := `[{"id":"Sys","type":"gauge","value":1},{"id":"Alloc","type":"counter","delta":1},` +
+			`{"id":"PollCount","type":"counter"},{"id":"PollCount","type":"counter","delta":2}]`
+		resp, err := ts.Client().Post(ts.URL+"/updates/", "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		defer func() {
+			if err := resp.Body.Close(); err != nil {
+				assert.Error(t, err)
+			}
+		}()
+		assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+
+		var res batchResult
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		assert.Equal(t, 2, res.Accepted)
+		assert.Equal(t, 2, res.Rejected)
+		require.Len(t, res.Errors, 2)
+		assert.Equal(t, problem.Item{Index: 1, ID: "Alloc", Code: problem.TypeConflict,
+			Detail: "metric 'Alloc' is registered as gauge, not counter"}, res.Errors[0])
+		assert.Equal(t, 2, res.Errors[1].Index)
+		assert.Equal(t, problem.InvalidValue, res.Errors[1].Code)
+
+		v, _, err := s.GetGaugeMetric(cfg, "Sys")
+		require.NoError(t, err)
+		assert.Equal(t, float64(1), v)
+		d, _, err := s.GetCounterMetric(cfg, "PollCount")
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), d)
+	})
+
+	t.Run("unencodable_response: FAIL", func(t *testing.T) {
+		// NaN gauges are rejected by the API, the value is written to the storage directly.
+		ns, err := storage.NewMemStorage(cfg)
+		require.NoError(t, err)
+		_, err = ns.UpdateGaugeMetric(cfg, "Broken", math.NaN())
+		require.NoError(t, err)
+		nts := httptest.NewServer(NewMetricRouter(NewMetricResource(ns, cfg)))
+		defer nts.Close()
+
+		for _, req := range []struct{ method, path, body string }{
+			{http.MethodGet, "/api/v1/metrics", ""},
+			{http.MethodPost, "/values/", `[{"id":"Broken","type":"gauge"}]`},
+		} {
+			r, err := http.NewRequest(req.method, nts.URL+req.path, strings.NewReader(req.body))
+			require.NoError(t, err)
+			resp, err := nts.Client().Do(r)
+			require.NoError(t, err)
+			var p problem.Details
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&p))
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, http.StatusInternalServerError, resp.StatusCode, req.path)
+			assert.Equal(t, problem.Internal, p.Code, req.path)
+		}
+	})
+}
+
+func TestListMetrics(t *testing.T) {
+	logConfig := zap.NewDevelopmentConfig()
+	logger, err := logConfig.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &models.Config{
+		Address:        "http://localhost:8080",
+		StoreInterval:  300,
+		Logger:         logger,
+		ContextTimeout: 3,
+	}
+	s, err := storage.NewMemStorage(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := []float64{1, 5, 3}
+	delta := int64(2)
+	prod := map[string]string{"env": "prod"}
+	require.NoError(t, s.UpdateBatch(cfg, models.Metrics{
+		{ID: "a.one", MType: "gauge", Value: &values[0], Labels: prod},
+		{ID: "a.two", MType: "gauge", Value: &values[1]},
+		{ID: "b.one", MType: "gauge", Value: &values[2], Unit: "bytes"},
+	}, models.Metrics{
+		{ID: "c.one", MType: "counter", Delta: &delta, Labels: prod},
+	}))
+
+	mr := NewMetricResource(s, cfg)
+	ts := httptest.NewServer(NewMetricRouter(mr))
+	defer ts.Close()
+
+	type page struct {
+		NextCursor string           `json:"next_cursor"`
+		Metrics    []map[string]any `json:"metrics"`
+	}
+	list := func(t *testing.T, query string) (page, int) {
+		t.Helper()
+		resp, err := ts.Client().Get(ts.URL + "/api/v1/metrics" + query)
+		require.NoError(t, err)
+		defer func() {
+			if err := resp.Body.Close(); err != nil {
+				assert.Error(t, err)
+			}
+		}()
+		var p page
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&p))
+		}
+		return p, resp.StatusCode
+	}
+	ids := func(p page) []string {
+		res := make([]string, 0, len(p.Metrics))
+		for _, m := range p.Metrics {
+			id, _ := m["id"].(string)
+			res = append(res, id)
+		}
+		return res
+	}
+
+	tests := []struct {
+		name         string
+		query        string
+		expectedIDs  []string
+		expectedCode int
+	}{
+		{name: "all: OK", query: "", expectedIDs: []string{"a.one", "a.two", "b.one", "c.one"}, expectedCode: 200},
+		{name: "type: OK", query: "?type=counter", expectedIDs: []string{"c.one"}, expectedCode: 200},
+		{name: "glob: OK", query: "?name=a.*", expectedIDs: []string{"a.one", "a.two"}, expectedCode: 200},
+		{name: "regex: OK", query: "?name_regex=one$", expectedIDs: []string{"a.one", "b.one", "c.one"}, expectedCode: 200},
+		{name: "label: OK", query: "?label=env=prod&type=gauge", expectedIDs: []string{"a.one"}, expectedCode: 200},
+		{name: "sort_value: OK", query: "?sort=-value", expectedIDs: []string{"a.two", "b.one", "c.one", "a.one"},
+			expectedCode: 200},
+		{name: "sort_name_desc: OK", query: "?sort=-name", expectedIDs: []string{"c.one", "b.one", "a.two", "a.one"},
+			expectedCode: 200},
+		{name: "wrong_type: FAIL", query: "?type=histogram", expectedCode: 400},
+		{name: "wrong_regex: FAIL", query: "?name_regex=(", expectedCode: 400},
+		{name: "wrong_sort: FAIL", query: "?sort=unit", expectedCode: 400},
+		{name: "wrong_limit: FAIL", query: "?limit=0", expectedCode: 400},
+		{name: "wrong_cursor: FAIL", query: "?cursor=abc", expectedCode: 400},
+		{name: "wrong_field: FAIL", query: "?fields=id,color", expectedCode: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, code := list(t, tt.query)
+			assert.Equal(t, tt.expectedCode, code)
+			if tt.expectedCode == http.StatusOK {
+				assert.Equal(t, tt.expectedIDs, ids(p))
+			}
+		})
+	}
+
+	t.Run("pagination: OK", func(t *testing.T) {
+		var all []string
+		params := "?sort=-value&limit=3"
+		for range 3 {
+			p, code := list(t, params)
+			require.Equal(t, http.StatusOK, code)
+			all = append(all, ids(p)...)
+			if p.NextCursor == "" {
+				break
+			}
+			params = "?sort=-value&limit=3&cursor=" + p.NextCursor
+		}
+		assert.Equal(t, []string{"a.two", "b.one", "c.one", "a.one"}, all)
+
+		p, _ := list(t, "?limit=3")
+		_, code := list(t, "?sort=value&cursor="+p.NextCursor)
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("fields: OK", func(t *testing.T) {
+		p, code := list(t, "?name=b.one&fields=id,unit,value")
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, p.Metrics, 1)
+		assert.Equal(t, map[string]any{"id": "b.one", "unit": "bytes", "value": 3.0}, p.Metrics[0])
+	})
+
+	t.Run("get_values: OK", func(t *testing.T) {
+		body := `[{"id":"a.two","type":"gauge"},{"id":"c.one","type":"counter"},{"id":"unknown","type":"gauge"}]`
+		resp, err := ts.Client().Post(ts.URL+"/values/", "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		defer func() {
+			if err := resp.Body.Close(); err != nil {
+				assert.Error(t, err)
+			}
+		}()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.JSONEq(t, `[{"id":"a.two","type":"gauge","value":5},{"id":"c.one","type":"counter","delta":2}]`, string(b))
+	})
+
+	t.Run("query: OK", func(t *testing.T) {
+		resp, err := ts.Client().Get(ts.URL + "/api/v1/query?query=" + url.QueryEscape(`sum({env="prod"})`))
+		require.NoError(t, err)
+		defer func() {
+			if err := resp.Body.Close(); err != nil {
+				assert.Error(t, err)
+			}
+		}()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"type":"vector","vector":[{"value":3}]}`, string(b))
+	})
+
+	t.Run("query_malformed: FAIL", func(t *testing.T) {
+		resp := testRequest(t, ts, http.MethodGet, "/api/v1/query?query="+url.QueryEscape("sum("), "")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		if err := resp.Body.Close(); err != nil {
+			assert.Error(t, err)
+		}
+	})
+
+	t.Run("get_values_wrong_type: FAIL", func(t *testing.T) {
+		resp := testRequest(t, ts, http.MethodPost, "/values/", `[{"id":"a.two","type":"histogram"}]`)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		if err := resp.Body.Close(); err != nil {
+			assert.Error(t, err)
+		}
+	})
+}
+
+func TestRules(t *testing.T) {
+	cfg := &models.Config{
+		Logger:         zap.NewNop(),
+		ContextTimeout: 3,
+	}
+	s, err := storage.NewMemStorage(cfg)
+	require.NoError(t, err)
+	_, err = s.UpdateGaugeMetric(cfg, "FreeMemory", 10)
+	require.NoError(t, err)
+	_, err = s.UpdateGaugeMetric(cfg, "HeapAlloc", 10)
+	require.NoError(t, err)
+
+	m, err := rules.NewManager(cfg, &rules.File{AlertingRules: []rules.AlertingRule{
+		{Name: "LowFreeMemory", Expr: "FreeMemory", Op: "<", Threshold: 100},
+		{Name: "HighHeapAlloc", Expr: "HeapAlloc", Op: ">", Threshold: 1, For: rules.Duration{Duration: time.Hour}},
+	}}, func(string) (rules.Storage, error) { return s, nil }, validation.New(cfg))
+	require.NoError(t, err)
+	m.Evaluate(time.Now())
+
+	mr := NewMetricResource(s, cfg)
+	ts := httptest.NewServer(NewMetricRouter(mr))
+	defer ts.Close()
+
+	t.Run("no_rules: OK", func(t *testing.T) {
+		resp := testRequest(t, ts, http.MethodGet, "/api/v1/alerts", "")
+		if err := resp.Body.Close(); err != nil {
+			assert.Error(t, err)
+		}
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	mr.SetRules(m)
+
+	tests := []struct {
+		name           string
+		path           string
+		expectedAlerts []string
+		expectedCode   int
+	}{
+		{
+			name:           "all: OK",
+			path:           "/api/v1/alerts",
+			expectedAlerts: []string{"HighHeapAlloc", "LowFreeMemory"},
+			expectedCode:   200,
+		},
+		{
+			name:           "firing: OK",
+			path:           "/api/v1/alerts?state=firing",
+			expectedAlerts: []string{"LowFreeMemory"},
+			expectedCode:   200,
 		},
 		{
-			name:         "get_counter_metric: FAIL",
-			method:       http.MethodGet,
-			path:         "/value/counter/test45",
-			body:         "",
-			expectedCode: 404,
+			name:           "pending: OK",
+			path:           "/api/v1/alerts?state=pending",
+			expectedAlerts: []string{"HighHeapAlloc"},
+			expectedCode:   200,
 		},
+		{name: "resolved: OK", path: "/api/v1/alerts?state=resolved", expectedAlerts: []string{}, expectedCode: 200},
+		{name: "unknown_state: FAIL", path: "/api/v1/alerts?state=silenced", expectedCode: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := ts.Client().Get(ts.URL + tt.path)
+			require.NoError(t, err)
+			defer func() {
+				if err := resp.Body.Close(); err != nil {
+					assert.Error(t, err)
+				}
+			}()
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+			if tt.expectedCode != http.StatusOK {
+				return
+			}
+
+			var body struct {
+				Alerts []rules.Alert `json:"alerts"`
+			}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+			names := make([]string, 0, len(body.Alerts))
+			for _, a := range body.Alerts {
+				names = append(names, a.Rule)
+			}
+			assert.Equal(t, tt.expectedAlerts, names)
+		})
+	}
+
+	t.Run("get_rules: OK", func(t *testing.T) {
+		resp, err := ts.Client().Get(ts.URL + "/api/v1/rules?type=alerting")
+		require.NoError(t, err)
+		defer func() {
+			if err := resp.Body.Close(); err != nil {
+				assert.Error(t, err)
+			}
+		}()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var body struct {
+			Rules []rules.RuleStatus `json:"rules"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		require.Len(t, body.Rules, 2)
+		assert.Equal(t, "LowFreeMemory", body.Rules[0].Name)
+		assert.Equal(t, "ok", body.Rules[0].Health)
+		assert.NotNil(t, body.Rules[0].LastEvaluation)
+	})
+
+	t.Run("get_rules_unknown_type: FAIL", func(t *testing.T) {
+		resp := testRequest(t, ts, http.MethodGet, "/api/v1/rules?type=other", "")
+		if err := resp.Body.Close(); err != nil {
+			assert.Error(t, err)
+		}
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func TestUI(t *testing.T) {
+	cfg := &models.Config{
+		Logger:         zap.NewNop(),
+		ContextTimeout: 3,
+	}
+	s, err := storage.NewMemStorage(cfg)
+	require.NoError(t, err)
+	free := 1024.5
+	require.NoError(t, s.UpdateBatch(cfg, models.Metrics{{
+		ID: "FreeMemory", MType: "gauge", Value: &free, Unit: "bytes", Description: "Free <b>memory</b>",
+		Labels: map[string]string{"host": "a"},
+	}}, nil))
+	_, err = s.UpdateCounterMetric(cfg, "PollCount", 7)
+	require.NoError(t, err)
+
+	mr := NewMetricResource(s, cfg)
+	ts := httptest.NewServer(NewMetricRouter(mr))
+	defer ts.Close()
+
+	tests := []struct {
+		name         string
+		path         string
+		contentType  string
+		expectedBody []string
+		expectedCode int
+	}{
 		{
-			name:         "update_counter_metric: OK",
-			method:       http.MethodPost,
-			path:         "/update/counter/test/20",
-			body:         "",
+			name:         "dashboard: OK",
+			path:         "/",
+			contentType:  "text/html; charset=utf-8",
+			expectedBody: []string{`href="/ui/metrics/gauge/FreeMemory"`, "1024.5", `href="/ui/metrics/counter/PollCount"`},
 			expectedCode: 200,
 		},
 		{
-			name:         "get_counter_metric: OK",
-			method:       http.MethodGet,
-			path:         "/value/counter/test",
-			body:         "",
+			name:         "metric_page: OK",
+			path:         "/ui/metrics/gauge/FreeMemory",
+			contentType:  "text/html; charset=utf-8",
+			expectedBody: []string{`data-name="FreeMemory"`, "bytes", "Free &lt;b&gt;memory&lt;/b&gt;", "host=a"},
 			expectedCode: 200,
-			expectedBody: "20",
 		},
 		{
-			name:         "get_counter_metric_JSON: OK",
-			method:       http.MethodPost,
-			path:         "/value/",
-			body:         `{ "id": "test", "type": "counter", "delta": 20}`,
+			name:         "counter_page: OK",
+			path:         "/ui/metrics/counter/PollCount",
+			expectedBody: []string{`data-type="counter"`, ">7<"},
 			expectedCode: 200,
 		},
+		{name: "unknown_metric_page: FAIL", path: "/ui/metrics/gauge/Unknown", expectedCode: 404},
+		{name: "wrong_type_page: FAIL", path: "/ui/metrics/counter/FreeMemory", expectedCode: 404},
+		{name: "unknown_type_page: FAIL", path: "/ui/metrics/summary/FreeMemory", expectedCode: 400},
 		{
-			name:         "update_batch_metric: OK",
-			method:       http.MethodPost,
-			path:         "/updates/",
-			body:         `[{ "id": "test", "type": "gauge", "value": 20.0}, { "id": "test", "type": "counter", "delta": 20}]`,
+			name:         "static_js: OK",
+			path:         "/ui/static/dashboard.js",
+			contentType:  "text/javascript; charset=utf-8",
+			expectedBody: []string{"/api/v1/metrics"},
 			expectedCode: 200,
 		},
+		{name: "static_css: OK", path: "/ui/static/style.css", contentType: "text/css; charset=utf-8", expectedCode: 200},
+		{name: "static_unknown: FAIL", path: "/ui/static/unknown.js", expectedCode: 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := ts.Client().Get(ts.URL + tt.path)
+			require.NoError(t, err)
+			defer func() {
+				if err := resp.Body.Close(); err != nil {
+					assert.Error(t, err)
+				}
+			}()
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+			if tt.contentType != "" {
+				assert.Equal(t, tt.contentType, resp.Header.Get("Content-Type"))
+			}
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			for _, part := range tt.expectedBody {
+				assert.Contains(t, string(body), part)
+			}
+		})
+	}
+
+	t.Run("no_external_assets: OK", func(t *testing.T) {
+		for _, path := range []string{"/", "/ui/metrics/gauge/FreeMemory"} {
+			resp, err := ts.Client().Get(ts.URL + path)
+			require.NoError(t, err)
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			if err := resp.Body.Close(); err != nil {
+				assert.Error(t, err)
+			}
+			assert.NotRegexp(t, `(src|href)="(https?:)?//`, string(body))
+		}
+	})
+
+	storeTests := []struct {
+		mockStore    func(*gomock.Controller) *mock_handlers.MockStorage
+		name         string
+		path         string
+		expectedCode int
+	}{
+		{
+			mockStore: func(c *gomock.Controller) *mock_handlers.MockStorage {
+				s := mock_handlers.NewMockStorage(c)
+				s.EXPECT().GetGaugeMetric(gomock.Any(), gomock.Any()).Return(0.0, false, nil)
+				return s
+			},
+			name:         "gauge_page_not_found: FAIL",
+			path:         "/ui/metrics/gauge/Unknown",
+			expectedCode: 404,
+		},
 		{
-			name:         "update_batch_metric_wrong_JSON: FAIL",
-			method:       http.MethodPost,
-			path:         "/updates/",
-			body:         `[{ "id": "test", "type": "gauge", "value": 20.0}, { "id": "test", "type": "counter", "delta": 20}`,
-			expectedCode: 500,
+			mockStore: func(c *gomock.Controller) *mock_handlers.MockStorage {
+				s := mock_handlers.NewMockStorage(c)
+				s.EXPECT().GetCounterMetric(gomock.Any(), gomock.Any()).Return(int64(0), false, nil)
+				return s
+			},
+			name:         "counter_page_not_found: FAIL",
+			path:         "/ui/metrics/counter/Unknown",
+			expectedCode: 404,
 		},
 		{
-			name:         "get_all_metrics: OK",
-			method:       http.MethodGet,
-			path:         "/",
-			body:         "",
-			expectedCode: 200,
+			mockStore: func(c *gomock.Controller) *mock_handlers.MockStorage {
+				s := mock_handlers.NewMockStorage(c)
+				s.EXPECT().GetGaugeMetric(gomock.Any(), gomock.Any()).Return(0.0, false, errors.New("connection refused"))
+				return s
+			},
+			name:         "gauge_page_store_error: FAIL",
+			path:         "/ui/metrics/gauge/Alloc",
+			expectedCode: 500,
 		},
 	}
-	for _, tt := range tests {
+	for _, tt := range storeTests {
 		t.Run(tt.name, func(t *testing.T) {
-			resp := testRequest(t, ts, tt.method, tt.path, tt.body)
-			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+			ts := httptest.NewServer(NewMetricRouter(NewMetricResource(tt.mockStore(gomock.NewController(t)), cfg)))
+			defer ts.Close()
+			resp, err := ts.Client().Get(ts.URL + tt.path)
+			require.NoError(t, err)
 			if err := resp.Body.Close(); err != nil {
 				assert.Error(t, err)
 			}
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
 		})
 	}
 }
 
-func TestUpdateMetricFileStoreTicker(t *testing.T) {
+func TestTenants(t *testing.T) {
 	logConfig := zap.NewDevelopmentConfig()
 	logger, err := logConfig.Build()
 	if err != nil {
-		t.Error("failed to initialize Logger: %w", err)
+		t.Fatal(err)
 	}
 
 	cfg := &models.Config{
 		Address:         "http://localhost:8080",
-		StoreInterval:   5,
-		FileStoragePath: "/tmp/metrics-db.json",
-		RestoreMetrics:  false,
+		StoreInterval:   300,
 		Logger:          logger,
-		PostgresDSN:     "",
 		ContextTimeout:  3,
-		HashKey:         "",
+		TenantTokens:    map[string]string{"token-a": "team-a", "token-b": "team-b"},
+		TenantQuotas:    map[string]int64{"team-b": 1},
+		TenantMaxSeries: 10,
 	}
-	s, err := NewStore(cfg)
-	if err != nil {
-		t.Fatal(err)
-	}
-	mr := NewMetricResource(s, cfg)
+	tenants, err := NewTenantStores(cfg)
+	require.NoError(t, err)
 
+	mr := NewTenantMetricResource(tenants, cfg)
 	ts := httptest.NewServer(NewMetricRouter(mr))
+	defer ts.Close()
 
 	tests := []struct {
 		name         string
 		method       string
 		path         string
-		body         string
+		header       string
+		value        string
 		expectedBody string
 		expectedCode int
 	}{
 		{
-			name:         "ping_filestore: OK",
-			method:       http.MethodGet,
-			path:         "/ping",
-			body:         "",
+			name:         "update_team_a: OK",
+			method:       http.MethodPost,
+			path:         "/update/gauge/Alloc/1",
+			header:       "X-Tenant-Token",
+			value:        "token-a",
 			expectedCode: 200,
-			expectedBody: "",
 		},
 		{
-			name:         "get_gauge_metric: FAIL",
-			method:       http.MethodGet,
-			path:         "/value/gauge/test",
-			body:         "",
-			expectedCode: 404,
-			expectedBody: "",
+			name:         "update_team_b: OK",
+			method:       http.MethodPost,
+			path:         "/update/gauge/Alloc/2",
+			header:       "X-Tenant-Token",
+			value:        "token-b",
+			expectedCode: 200,
 		},
 		{
-			name:         "update_gauge_metric: OK",
+			name:         "update_default_without_token: FAIL",
 			method:       http.MethodPost,
-			path:         "/update/gauge/test/20.0",
-			body:         "",
-			expectedCode: 200,
+			path:         "/update/gauge/Alloc/3",
+			expectedCode: 401,
 		},
 		{
-			name:         "get_gauge_metric: OK",
+			name:         "get_team_a: OK",
 			method:       http.MethodGet,
-			path:         "/value/gauge/test",
-			body:         "",
+			path:         "/value/gauge/Alloc",
+			header:       "X-Tenant-Token",
+			value:        "token-a",
 			expectedCode: 200,
-			expectedBody: "20.0",
+			expectedBody: "1",
 		},
 		{
-			name:         "get_gauge_metric_incorrect_JSON: OK",
-			method:       http.MethodPost,
-			path:         "/value/",
-			body:         `{ "id": "test"`,
-			expectedCode: 500,
+			name:         "get_team_b: OK",
+			method:       http.MethodGet,
+			path:         "/value/gauge/Alloc",
+			header:       "X-Tenant-Token",
+			value:        "token-b",
+			expectedCode: 200,
+			expectedBody: "2",
 		},
 		{
-			name:         "update_gauge_metric_JSON_novalue: FAIL",
-			method:       http.MethodPost,
-			path:         "/update/",
-			body:         `{ "id": "test", "type"gauge"}`,
-			expectedCode: 500,
+			name:         "get_default_without_token: FAIL",
+			method:       http.MethodGet,
+			path:         "/value/gauge/Alloc",
+			expectedCode: 401,
 		},
 		{
-			name:         "get_counter_metric: FAIL",
+			name:         "health_without_token: OK",
 			method:       http.MethodGet,
-			path:         "/value/counter/test",
-			body:         "",
-			expectedCode: 404,
+			path:         "/healthz",
+			expectedCode: 200,
 		},
 		{
-			name:         "update_counter_metric: OK",
-			method:       http.MethodPost,
-			path:         "/update/counter/test/20",
-			body:         "",
-			expectedCode: 200,
+			name:         "wrong_token: FAIL",
+			method:       http.MethodGet,
+			path:         "/value/gauge/Alloc",
+			header:       "X-Tenant-Token",
+			value:        "token-c",
+			expectedCode: 401,
 		},
 		{
-			name:         "get_counter_metric: OK",
+			name:         "tenant_header_without_token: FAIL",
 			method:       http.MethodGet,
-			path:         "/value/counter/test",
-			body:         "",
-			expectedCode: 200,
-			expectedBody: "20",
+			path:         "/value/gauge/Alloc",
+			header:       "X-Tenant",
+			value:        "team-a",
+			expectedCode: 401,
 		},
 		{
-			name:         "update_batch_metric_wrong_JSON: FAIL",
+			name:         "team_b_quota_exceeded: FAIL",
 			method:       http.MethodPost,
-			path:         "/updates/",
-			body:         `[{ "id": "test", "type": "gauge", "value": 20.0}, { "id": "test", "type": "counter", "delta": 20}`,
-			expectedCode: 500,
+			path:         "/update/counter/PollCount/1",
+			header:       "X-Tenant-Token",
+			value:        "token-b",
+			expectedCode: 429,
 		},
 		{
-			name:         "get_all_metrics: OK",
-			method:       http.MethodGet,
-			path:         "/",
-			body:         "",
+			name:         "team_b_existing_series: OK",
+			method:       http.MethodPost,
+			path:         "/update/gauge/Alloc/4",
+			header:       "X-Tenant-Token",
+			value:        "token-b",
+			expectedCode: 200,
+		},
+		{
+			name:         "team_a_within_quota: OK",
+			method:       http.MethodPost,
+			path:         "/update/counter/PollCount/1",
+			header:       "X-Tenant-Token",
+			value:        "token-a",
 			expectedCode: 200,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			resp := testRequest(t, ts, tt.method, tt.path, "")
+			req, err := http.NewRequest(tt.method, ts.URL+tt.path, http.NoBody)
+			require.NoError(t, err)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer func() {
+				if err := resp.Body.Close(); err != nil {
+					assert.Error(t, err)
+				}
+			}()
 			assert.Equal(t, tt.expectedCode, resp.StatusCode)
-			if err := resp.Body.Close(); err != nil {
-				assert.Error(t, err)
+			if tt.expectedBody != "" {
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectedBody, string(body))
 			}
 		})
 	}
+
+	t.Run("tenant_header_without_tokens: OK", func(t *testing.T) {
+		cfg := &models.Config{Logger: logger, ContextTimeout: 3}
+		tenants, err := NewTenantStores(cfg)
+		require.NoError(t, err)
+		ts := httptest.NewServer(NewMetricRouter(NewTenantMetricResource(tenants, cfg)))
+		defer ts.Close()
+
+		for _, tenant := range []string{"team-a", "team/b"} {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/update/gauge/Alloc/1", http.NoBody)
+			require.NoError(t, err)
+			req.Header.Set("X-Tenant", tenant)
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			if tenant == "team-a" {
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+			} else {
+				assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			}
+		}
+		s, err := tenants.Store("team-a")
+		require.NoError(t, err)
+		_, ok, _ := s.GetGaugeMetric(cfg, "Alloc")
+		assert.True(t, ok)
+		_, ok, _ = tenants.Default().GetGaugeMetric(cfg, "Alloc")
+		assert.False(t, ok)
+	})
+
+	t.Run("default_access: OK", func(t *testing.T) {
+		cfg := &models.Config{
+			Logger:         logger,
+			ContextTimeout: 3,
+			TenantTokens:   map[string]string{"token-a": "team-a"},
+			DefaultAccess:  true,
+		}
+		tenants, err := NewTenantStores(cfg)
+		require.NoError(t, err)
+		ts := httptest.NewServer(NewMetricRouter(NewTenantMetricResource(tenants, cfg)))
+		defer ts.Close()
+
+		for header, code := range map[string]int{"": http.StatusOK, "team-a": http.StatusUnauthorized} {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/update/gauge/Alloc/1", http.NoBody)
+			require.NoError(t, err)
+			req.Header.Set("X-Tenant", header)
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, code, resp.StatusCode, "X-Tenant: %s", header)
+		}
+		_, ok, _ := tenants.Default().GetGaugeMetric(cfg, "Alloc")
+		assert.True(t, ok)
+	})
+
+	t.Run("max_tenants: FAIL", func(t *testing.T) {
+		cfg := &models.Config{Logger: logger, ContextTimeout: 3, MaxTenants: 2}
+		tenants, err := NewTenantStores(cfg)
+		require.NoError(t, err)
+		ts := httptest.NewServer(NewMetricRouter(NewTenantMetricResource(tenants, cfg)))
+		defer ts.Close()
+
+		for _, tt := range []struct {
+			tenant string
+			code   int
+		}{
+			{tenant: "team-a", code: http.StatusOK},
+			{tenant: "team-b", code: http.StatusOK},
+			{tenant: "team-c", code: http.StatusTooManyRequests},
+			{tenant: "team-a", code: http.StatusOK},
+			{tenant: "", code: http.StatusOK},
+		} {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/update/gauge/Alloc/1", http.NoBody)
+			require.NoError(t, err)
+			req.Header.Set("X-Tenant", tt.tenant)
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, tt.code, resp.StatusCode, "X-Tenant: %s", tt.tenant)
+		}
+	})
 }
 
 func TestUpdateMetric(t *testing.T) {
@@ -1057,6 +3138,34 @@ func TestGetMetric(t *testing.T) {
 			expectedCode: 404,
 			expectedBody: "",
 		},
+		{
+			mockStore: func(c *gomock.Controller) *mock_handlers.MockStorage {
+				s := mock_handlers.NewMockStorage(c)
+				s.EXPECT().GetGaugeMetric(gomock.Any(), gomock.Any()).Return(
+					0.0, false, storage.ErrUnknownMetric).AnyTimes()
+				return s
+			},
+			name:         "get_unknown_metrics_JSON:OK",
+			method:       http.MethodPost,
+			path:         "/values/",
+			body:         `[{ "id": "PacketsIn", "type": "gauge"}]`,
+			expectedCode: 200,
+			expectedBody: "[]",
+		},
+		{
+			mockStore: func(c *gomock.Controller) *mock_handlers.MockStorage {
+				s := mock_handlers.NewMockStorage(c)
+				s.EXPECT().GetGaugeMetric(gomock.Any(), gomock.Any()).Return(
+					0.0, false, errors.New("connection refused")).AnyTimes()
+				return s
+			},
+			name:         "get_metrics_JSON:FAIL",
+			method:       http.MethodPost,
+			path:         "/values/",
+			body:         `[{ "id": "PacketsIn", "type": "gauge"}]`,
+			expectedCode: 500,
+			expectedBody: "",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -1202,7 +3311,7 @@ func TestUpdateBatchJSON(t *testing.T) {
 			method:       http.MethodPost,
 			path:         "/updates/",
 			body:         `[{"id":"PollCount","type":"counter"}`,
-			expectedCode: 500,
+			expectedCode: 400,
 			expectedBody: "",
 		},
 		{
@@ -1339,3 +3448,197 @@ func BenchmarkUpdateBatch(b *testing.B) {
 		}
 	}
 }
+
+func TestAudit(t *testing.T) {
+	cfg := &models.Config{
+		Logger:         zap.NewNop(),
+		StoreInterval:  300,
+		ContextTimeout: 3,
+		AdminToken:     "secret",
+		TrustedProxies: loopback,
+	}
+	s, err := storage.NewMemStorage(cfg)
+	require.NoError(t, err)
+	for _, name := range []string{"test.one", "test.two"} {
+		_, err := s.UpdateGaugeMetric(cfg, name, 1)
+		require.NoError(t, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	al, err := audit.Open(path)
+	require.NoError(t, err)
+	mr := NewMetricResource(s, cfg)
+	mr.SetAudit(al)
+	ts := httptest.NewServer(NewMetricRouter(mr))
+	defer ts.Close()
+
+	send := func(method, path, id, token string) (int, http.Header) {
+		req, err := http.NewRequest(method, ts.URL+path, http.NoBody)
+		require.NoError(t, err)
+		if id != "" {
+			req.Header.Set(requestid.Header, id)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		req.Header.Set("X-Real-IP", "10.0.0.7")
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode, resp.Header
+	}
+
+	code, header := send(http.MethodPost, "/update/counter/PollCount/5", "req-1", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "req-1", header.Get(requestid.Header), "request ID of the client is echoed")
+
+	code, header = send(http.MethodPost, "/admin/reset/PollCount", "bad id", "secret")
+	assert.Equal(t, http.StatusOK, code)
+	generated := header.Get(requestid.Header)
+	assert.True(t, requestid.Valid(generated) && generated != "bad id", "invalid request ID is replaced")
+
+	send(http.MethodPost, "/admin/reset/Unknown", "req-3", "secret")
+	send(http.MethodDelete, "/admin/metrics?prefix=test.", "req-4", "secret")
+	_, header = send(http.MethodGet, "/value/counter/PollCount", "", "")
+	assert.NotEmpty(t, header.Get(requestid.Header), "requests without ID get one")
+	require.NoError(t, al.Close())
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	require.Len(t, lines, 4, "reads aren't audited")
+	entries := make([]audit.Entry, len(lines))
+	for i, line := range lines {
+		require.NoError(t, json.Unmarshal([]byte(line), &entries[i]))
+	}
+
+	assert.Equal(t, audit.OpUpdate, entries[0].Operation)
+	assert.Equal(t, "req-1", entries[0].RequestID)
+	assert.Equal(t, "ip:10.0.0.7", entries[0].Principal)
+	assert.Equal(t, "10.0.0.7", entries[0].RealIP)
+	assert.Equal(t, "127.0.0.1", entries[0].IP)
+	assert.Equal(t, int64(1), entries[0].Metrics)
+
+	assert.Equal(t, audit.OpResetCounter, entries[1].Operation)
+	assert.Equal(t, generated, entries[1].RequestID)
+	assert.Equal(t, "token:admin", entries[1].Principal)
+	assert.Equal(t, []string{"PollCount"}, entries[1].Names)
+	assert.Equal(t, audit.ResultOK, entries[1].Result)
+
+	assert.Equal(t, audit.ResultRejected, entries[2].Result, "reset of unknown counter")
+	assert.Equal(t, "404", entries[2].Status)
+	assert.Equal(t, int64(0), entries[2].Metrics)
+
+	assert.Equal(t, audit.OpDeleteMetrics, entries[3].Operation)
+	assert.Equal(t, "test.", entries[3].Prefix)
+	assert.Equal(t, int64(2), entries[3].Metrics)
+}
+
+func TestCompression(t *testing.T) {
+	cfg := &models.Config{
+		Logger:             zap.NewNop(),
+		StoreInterval:      300,
+		ContextTimeout:     3,
+		CompressionMinSize: 1024,
+		CompressionLevel:   compression.LevelFastest,
+	}
+	s, err := storage.NewMemStorage(cfg)
+	require.NoError(t, err)
+	for i := range 50 {
+		_, err := s.UpdateGaugeMetric(cfg, "test.gauge"+strconv.Itoa(i), float64(i))
+		require.NoError(t, err)
+	}
+
+	mr := NewMetricResource(s, cfg)
+	ts := httptest.NewServer(NewMetricRouter(mr))
+	defer ts.Close()
+
+	// send returns status, headers and decompressed body of the response.
+	send := func(method, path, acceptEncoding, contentEncoding string, body []byte) (int, http.Header, []byte) {
+		req, err := http.NewRequest(method, ts.URL+path, bytes.NewReader(body))
+		require.NoError(t, err)
+		// Accept-Encoding set explicitly disables transparent gzip of the client.
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		if contentEncoding != "" {
+			req.Header.Set("Content-Encoding", contentEncoding)
+		}
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, resp.Body.Close())
+		}()
+
+		var r io.Reader = resp.Body
+		if ce := resp.Header.Get("Content-Encoding"); ce != "" {
+			codec, ok := compression.Lookup(ce)
+			require.True(t, ok, ce)
+			dr, err := codec.NewReader(resp.Body)
+			require.NoError(t, err)
+			r = dr
+		}
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		return resp.StatusCode, resp.Header, b
+	}
+	encode := func(name string, b []byte) []byte {
+		codec, ok := compression.Lookup(name)
+		require.True(t, ok, name)
+		var buf bytes.Buffer
+		w, err := codec.NewWriter(&buf, compression.LevelDefault)
+		require.NoError(t, err)
+		_, err = w.Write(b)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return buf.Bytes()
+	}
+
+	code, header, plain := send(http.MethodGet, "/api/v1/metrics", "identity", "", nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", header.Get("Vary"))
+	require.Greater(t, len(plain), 1024)
+
+	responses := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{acceptEncoding: "gzip", want: compression.Gzip},
+		{acceptEncoding: "gzip;q=0.5, zstd", want: compression.Zstd},
+		{acceptEncoding: "br, snappy;q=0.9", want: compression.Brotli},
+		{acceptEncoding: "zstd;q=0, *", want: compression.Brotli},
+		{acceptEncoding: "snappy", want: compression.Snappy},
+		{acceptEncoding: "deflate", want: ""},
+	}
+	for _, tt := range responses {
+		code, header, body := send(http.MethodGet, "/api/v1/metrics", tt.acceptEncoding, "", nil)
+		assert.Equal(t, http.StatusOK, code, tt.acceptEncoding)
+		assert.Equal(t, tt.want, header.Get("Content-Encoding"), tt.acceptEncoding)
+		assert.Equal(t, plain, body, tt.acceptEncoding)
+	}
+
+	code, header, body := send(http.MethodGet, "/value/gauge/test.gauge7", "zstd", "", nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, header.Get("Content-Encoding"), "responses below minimum size aren't compressed")
+	assert.Equal(t, "7", string(body))
+
+	batch := []byte(`[{"id":"test.counter","type":"counter","delta":3}]`)
+	for _, name := range compression.Names() {
+		code, _, _ := send(http.MethodPost, "/updates/", "", name, encode(name, batch))
+		assert.Equal(t, http.StatusOK, code, name)
+	}
+	code, _, _ = send(http.MethodPost, "/updates/", "", "identity", batch)
+	assert.Equal(t, http.StatusOK, code)
+	code, _, body = send(http.MethodGet, "/value/counter/test.counter", "", "", nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "15", string(body))
+
+	code, header, body = send(http.MethodPost, "/updates/", "", "deflate", batch)
+	assert.Equal(t, http.StatusUnsupportedMediaType, code)
+	assert.Equal(t, problem.ContentType, header.Get("Content-Type"))
+	var p problem.Details
+	require.NoError(t, json.Unmarshal(body, &p))
+	assert.Equal(t, problem.UnsupportedEncoding, p.Code)
+
+	code, _, _ = send(http.MethodPost, "/updates/", "", compression.Gzip, batch)
+	assert.Equal(t, http.StatusBadRequest, code, "body isn't compressed")
+}
@@ -0,0 +1,159 @@
+//nolint:wrapcheck // errors of the wrapped storage are returned as is
+package handlers
+
+import (
+	"time"
+
+	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/selfmetrics"
+	"github.com/vkupriya/go-metrics/internal/server/storage"
+)
+
+// instrumentedStore records latency and errors of storage operations in self-metrics
+// storage.<backend>.<operation>.duration and storage.<backend>.<operation>.errors.
+type instrumentedStore struct {
+	Storage
+	prefix string
+}
+
+func instrument(s Storage) Storage {
+	return &instrumentedStore{Storage: s, prefix: "storage." + backendName(s) + "."}
+}
+
+// unwrap returns the storage wrapped by instrumentation.
+func unwrap(s Storage) Storage {
+	if is, ok := s.(*instrumentedStore); ok {
+		return is.Storage
+	}
+	return s
+}
+
+// backendName returns name of the storage backend used in self-metrics and health checks.
+func backendName(s Storage) string {
+	switch s.(type) {
+	case *storage.PostgresStorage:
+		return "postgres"
+	case *storage.FileStorage:
+		return "file"
+	default:
+		return "memory"
+	}
+}
+
+// instrumentOpen returns function opening instrumented storages of tenants.
+func instrumentOpen(open func(name string) (Storage, error)) func(name string) (Storage, error) {
+	return func(name string) (Storage, error) {
+		s, err := open(name)
+		if err != nil {
+			return nil, err
+		}
+		return instrument(s), nil
+	}
+}
+
+// observe records the operation started at start.
+func (s *instrumentedStore) observe(op string, start time.Time, err error) {
+	selfmetrics.ObserveDuration(s.prefix+op+".duration", time.Since(start))
+	if err != nil {
+		selfmetrics.Inc(s.prefix + op + ".errors")
+	}
+}
+
+func (s *instrumentedStore) UpdateGaugeMetric(c *models.Config, name string, value float64) (float64, error) {
+	start := time.Now()
+	v, err := s.Storage.UpdateGaugeMetric(c, name, value)
+	s.observe("update_gauge", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) UpdateCounterMetric(c *models.Config, name string, value int64) (int64, error) {
+	start := time.Now()
+	v, err := s.Storage.UpdateCounterMetric(c, name, value)
+	s.observe("update_counter", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore) GetCounterMetric(c *models.Config, name string) (int64, bool, error) {
+	start := time.Now()
+	v, ok, err := s.Storage.GetCounterMetric(c, name)
+	s.observe("get_counter", start, err)
+	return v, ok, err
+}
+
+func (s *instrumentedStore) GetGaugeMetric(c *models.Config, name string) (float64, bool, error) {
+	start := time.Now()
+	v, ok, err := s.Storage.GetGaugeMetric(c, name)
+	s.observe("get_gauge", start, err)
+	return v, ok, err
+}
+
+func (s *instrumentedStore) GetAllMetrics(c *models.Config) (map[string]float64, map[string]int64, error) {
+	start := time.Now()
+	g, cr, err := s.Storage.GetAllMetrics(c)
+	s.observe("get_all", start, err)
+	return g, cr, err
+}
+
+func (s *instrumentedStore) UpdateBatch(c *models.Config, g models.Metrics, cr models.Metrics) error {
+	start := time.Now()
+	err := s.Storage.UpdateBatch(c, g, cr)
+	s.observe("update_batch", start, err)
+	return err
+}
+
+func (s *instrumentedStore) GetMetricHistory(c *models.Config, mtype, name string, from, to time.Time,
+	step time.Duration) ([]models.HistoryPoint, error) {
+	start := time.Now()
+	points, err := s.Storage.GetMetricHistory(c, mtype, name, from, to, step)
+	s.observe("get_history", start, err)
+	return points, err
+}
+
+func (s *instrumentedStore) RollupHistory(c *models.Config) error {
+	start := time.Now()
+	err := s.Storage.RollupHistory(c)
+	s.observe("rollup_history", start, err)
+	return err
+}
+
+func (s *instrumentedStore) DeleteMetric(c *models.Config, mtype, name string) (bool, error) {
+	start := time.Now()
+	ok, err := s.Storage.DeleteMetric(c, mtype, name)
+	s.observe("delete_metric", start, err)
+	return ok, err
+}
+
+func (s *instrumentedStore) ResetCounter(c *models.Config, name string) (bool, error) {
+	start := time.Now()
+	ok, err := s.Storage.ResetCounter(c, name)
+	s.observe("reset_counter", start, err)
+	return ok, err
+}
+
+func (s *instrumentedStore) DeleteMetrics(c *models.Config, prefix string) (int64, error) {
+	start := time.Now()
+	n, err := s.Storage.DeleteMetrics(c, prefix)
+	s.observe("delete_metrics", start, err)
+	return n, err
+}
+
+func (s *instrumentedStore) SetMetadata(c *models.Config, md *models.MetricMetadata) error {
+	start := time.Now()
+	err := s.Storage.SetMetadata(c, md)
+	s.observe("set_metadata", start, err)
+	return err
+}
+
+func (s *instrumentedStore) GetMetadata(c *models.Config) ([]models.MetricMetadata, error) {
+	start := time.Now()
+	mds, err := s.Storage.GetMetadata(c)
+	s.observe("get_metadata", start, err)
+	return mds, err
+}
+
+func (s *instrumentedStore) PingStore(c *models.Config) error {
+	start := time.Now()
+	err := s.Storage.PingStore(c)
+	s.observe("ping", start, err)
+	return err
+}
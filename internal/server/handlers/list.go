@@ -0,0 +1,395 @@
+package handlers
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/problem"
+	"github.com/vkupriya/go-metrics/internal/server/storage"
+)
+
+const (
+	nameKey          string = "name"
+	typeKey          string = "type"
+	defaultListLimit int    = 100
+	maxListLimit     int    = 1000
+)
+
+// listSorts compare metrics by sort keys of metric listing.
+var listSorts = map[string]func(a, b *listEntry) int{
+	nameKey: func(a, b *listEntry) int { return strings.Compare(a.metric.ID, b.metric.ID) },
+	typeKey: func(a, b *listEntry) int { return strings.Compare(a.metric.MType, b.metric.MType) },
+	"value": func(a, b *listEntry) int { return cmp.Compare(a.value, b.value) },
+}
+
+// listFields are fields of listed metrics selectable with 'fields' query parameter.
+var listFields = []string{"id", typeKey, "value", "delta", "unit", "description", "labels", "created_at"}
+
+// listEntry is a listed metric with its metadata.
+type listEntry struct {
+	createdAt time.Time
+	metric    models.Metric
+	value     float64 // gauge value or counter delta used for sorting
+}
+
+// listQuery holds parsed parameters of metric listing.
+type listQuery struct {
+	nameRegex *regexp.Regexp
+	cursor    *listCursor
+	labels    map[string]string
+	sort      func(a, b *listEntry) int
+	mtype     string
+	name      string
+	fields    []string
+	limit     int
+	desc      bool
+}
+
+// listCursor points at the last metric of a listing page, next page starts right after it.
+type listCursor struct {
+	Sort  string  `json:"sort"`
+	ID    string  `json:"id"`
+	MType string  `json:"type"`
+	Value float64 `json:"value"`
+}
+
+// listResponse is a page of metric listing.
+type listResponse struct {
+	NextCursor string           `json:"next_cursor,omitempty"`
+	Metrics    []map[string]any `json:"metrics"`
+}
+
+// ListMetrics endpoint returns metrics with values and metadata in JSON.
+// Query parameters:
+//   - 'type' is gauge or counter;
+//   - 'name' is a glob pattern of metric names, 'name_regex' is a regular expression of metric names;
+//   - 'label' is a 'key=value' label the metrics must have, it can be repeated;
+//   - 'sort' is one of 'name', 'type' and 'value', prefixed with '-' for descending order, defaults to 'name';
+//   - 'limit' is the page size, 100 by default and 1000 at most;
+//   - 'cursor' is 'next_cursor' of the previous page;
+//   - 'fields' is a comma separated list of returned fields, all fields are returned by default.
+func (mr *MetricResource) ListMetrics(rw http.ResponseWriter, r *http.Request) {
+	logger := mr.logger(r)
+
+	store, ok := mr.tenantStore(rw, r)
+	if !ok {
+		return
+	}
+
+	q, err := parseListQuery(r)
+	if err != nil {
+		problem.Write(rw, r, http.StatusBadRequest, problem.InvalidParameter, err.Error())
+		return
+	}
+
+	gauges, counters, err := store.GetAllMetrics(mr.config)
+	if err != nil {
+		logger.Sugar().Error("failed to get all metrics", zap.Error(err))
+		problem.WriteInternal(rw, r)
+		return
+	}
+	mds, err := store.GetMetadata(mr.config)
+	if err != nil {
+		logger.Sugar().Error("failed to get metric metadata", zap.Error(err))
+		problem.WriteInternal(rw, r)
+		return
+	}
+	metadata := make(map[string]models.MetricMetadata, len(mds))
+	for _, md := range mds {
+		metadata[md.Name] = md
+	}
+
+	entries := make([]listEntry, 0, len(gauges)+len(counters))
+	for name, v := range gauges {
+		v := v
+		entries = append(entries, newListEntry(&models.Metric{ID: name, MType: gauge, Value: &v}, v, metadata))
+	}
+	for name, v := range counters {
+		v := v
+		entries = append(entries, newListEntry(&models.Metric{ID: name, MType: counter, Delta: &v}, float64(v), metadata))
+	}
+
+	matched := entries[:0]
+	for i := range entries {
+		if q.match(&entries[i]) {
+			matched = append(matched, entries[i])
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return q.less(&matched[i], &matched[j]) })
+
+	start := 0
+	if q.cursor != nil {
+		last := listEntry{
+			metric: models.Metric{ID: q.cursor.ID, MType: q.cursor.MType},
+			value:  q.cursor.Value,
+		}
+		start = sort.Search(len(matched), func(i int) bool { return q.less(&last, &matched[i]) })
+	}
+	end := min(start+q.limit, len(matched))
+
+	resp := listResponse{Metrics: make([]map[string]any, 0, end-start)}
+	for i := start; i < end; i++ {
+		resp.Metrics = append(resp.Metrics, q.selectFields(&matched[i]))
+	}
+	if end < len(matched) {
+		cursor, err := encodeCursor(&listCursor{
+			Sort:  r.URL.Query().Get("sort"),
+			ID:    matched[end-1].metric.ID,
+			MType: matched[end-1].metric.MType,
+			Value: matched[end-1].value,
+		})
+		if err != nil {
+			logger.Sugar().Error("failed to encode list cursor", zap.Error(err))
+			problem.WriteInternal(rw, r)
+			return
+		}
+		resp.NextCursor = cursor
+	}
+
+	writeJSON(rw, r, logger, resp)
+}
+
+// GetMetricsJSON endpoint returns values of requested gauge and counter metrics in JSON.
+// Unknown metrics are left out of the response.
+func (mr *MetricResource) GetMetricsJSON(rw http.ResponseWriter, r *http.Request) {
+	logger := mr.logger(r)
+
+	store, ok := mr.tenantStore(rw, r)
+	if !ok {
+		return
+	}
+
+	var req models.Metrics
+	if !decodeJSON(rw, r, logger, &req) {
+		return
+	}
+
+	resp := make(models.Metrics, 0, len(req))
+	for _, m := range req {
+		switch m.MType {
+		case gauge:
+			v, ok, err := store.GetGaugeMetric(mr.config, m.ID)
+			if !readOK(rw, r, logger, m.ID, err) {
+				return
+			}
+			if !ok {
+				continue
+			}
+			resp = append(resp, models.Metric{ID: m.ID, MType: m.MType, Value: &v})
+		case counter:
+			v, ok, err := store.GetCounterMetric(mr.config, m.ID)
+			if !readOK(rw, r, logger, m.ID, err) {
+				return
+			}
+			if !ok {
+				continue
+			}
+			resp = append(resp, models.Metric{ID: m.ID, MType: m.MType, Delta: &v})
+		default:
+			writeItemProblem(rw, r, http.StatusBadRequest, problem.InvalidMetricType,
+				fmt.Sprintf("unsupported type '%s' of metric '%s'", m.MType, m.ID), m.ID)
+			return
+		}
+	}
+
+	writeJSON(rw, r, logger, resp)
+}
+
+// readOK writes internal error for failed reads of metric values, metrics missing in the storage
+// aren't errors. It reports whether the read succeeded.
+func readOK(rw http.ResponseWriter, r *http.Request, logger *zap.Logger, name string, err error) bool {
+	if err != nil && !errors.Is(err, storage.ErrUnknownMetric) {
+		logger.Sugar().Error("failed to get metric", zap.String(nameKey, name), zap.Error(err))
+		problem.WriteInternal(rw, r)
+		return false
+	}
+	return true
+}
+
+func newListEntry(m *models.Metric, v float64, metadata map[string]models.MetricMetadata) listEntry {
+	e := listEntry{metric: *m, value: v}
+	if md, ok := metadata[m.ID]; ok && md.MType == m.MType {
+		e.metric.Unit = md.Unit
+		e.metric.Description = md.Description
+		e.metric.Labels = md.Labels
+		e.createdAt = md.CreatedAt
+	}
+	return e
+}
+
+func parseListQuery(r *http.Request) (*listQuery, error) {
+	v := r.URL.Query()
+	q := &listQuery{
+		mtype:  v.Get(typeKey),
+		name:   v.Get(nameKey),
+		sort:   listSorts[nameKey],
+		limit:  defaultListLimit,
+		labels: make(map[string]string),
+	}
+
+	if q.mtype != "" && q.mtype != gauge && q.mtype != counter {
+		return nil, fmt.Errorf("invalid 'type' parameter: %s", q.mtype)
+	}
+	if q.name != "" {
+		if _, err := path.Match(q.name, ""); err != nil {
+			return nil, fmt.Errorf("invalid 'name' parameter: %w", err)
+		}
+	}
+	if s := v.Get("name_regex"); s != "" {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'name_regex' parameter: %w", err)
+		}
+		q.nameRegex = re
+	}
+	for _, l := range v["label"] {
+		k, val, ok := strings.Cut(l, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid 'label' parameter %s, expected key=value", l)
+		}
+		q.labels[k] = val
+	}
+
+	if s := v.Get("sort"); s != "" {
+		key, desc := strings.CutPrefix(s, "-")
+		by, ok := listSorts[key]
+		if !ok {
+			return nil, fmt.Errorf("invalid 'sort' parameter: %s", s)
+		}
+		q.sort, q.desc = by, desc
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxListLimit {
+			return nil, fmt.Errorf("invalid 'limit' parameter, expected 1 to %d", maxListLimit)
+		}
+		q.limit = n
+	}
+	if s := v.Get("cursor"); s != "" {
+		c, err := decodeCursor(s)
+		if err != nil {
+			return nil, err
+		}
+		if c.Sort != v.Get("sort") {
+			return nil, errors.New("'cursor' parameter doesn't match 'sort' parameter")
+		}
+		q.cursor = c
+	}
+	if s := v.Get("fields"); s != "" {
+		for _, f := range strings.Split(s, ",") {
+			f = strings.TrimSpace(f)
+			if !slices.Contains(listFields, f) {
+				return nil, fmt.Errorf("invalid 'fields' parameter, unknown field %s", f)
+			}
+			q.fields = append(q.fields, f)
+		}
+	}
+	return q, nil
+}
+
+// match reports whether the metric satisfies the query filters.
+func (q *listQuery) match(e *listEntry) bool {
+	if q.mtype != "" && e.metric.MType != q.mtype {
+		return false
+	}
+	if q.name != "" {
+		if ok, _ := path.Match(q.name, e.metric.ID); !ok {
+			return false
+		}
+	}
+	if q.nameRegex != nil && !q.nameRegex.MatchString(e.metric.ID) {
+		return false
+	}
+	for k, v := range q.labels {
+		if lv, ok := e.metric.Labels[k]; !ok || lv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// less orders metrics by the query sort key, ties are broken by name and type in ascending order.
+func (q *listQuery) less(a, b *listEntry) bool {
+	c := q.sort(a, b)
+	if q.desc {
+		c = -c
+	}
+	if c == 0 {
+		c = strings.Compare(a.metric.ID, b.metric.ID)
+	}
+	if c == 0 {
+		c = strings.Compare(a.metric.MType, b.metric.MType)
+	}
+	return c < 0
+}
+
+// selectFields returns requested fields of the metric, empty fields are left out.
+func (q *listQuery) selectFields(e *listEntry) map[string]any {
+	all := map[string]any{
+		"id":    e.metric.ID,
+		typeKey: e.metric.MType,
+	}
+	if e.metric.Value != nil {
+		all["value"] = *e.metric.Value
+	}
+	if e.metric.Delta != nil {
+		all["delta"] = *e.metric.Delta
+	}
+	if e.metric.Unit != "" {
+		all["unit"] = e.metric.Unit
+	}
+	if e.metric.Description != "" {
+		all["description"] = e.metric.Description
+	}
+	if len(e.metric.Labels) > 0 {
+		all["labels"] = e.metric.Labels
+	}
+	if !e.createdAt.IsZero() {
+		all["created_at"] = e.createdAt
+	}
+	if len(q.fields) == 0 {
+		return all
+	}
+
+	selected := make(map[string]any, len(q.fields))
+	for _, f := range q.fields {
+		if v, ok := all[f]; ok {
+			selected[f] = v
+		}
+	}
+	return selected
+}
+
+func encodeCursor(c *listCursor) (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeCursor(s string) (*listCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid 'cursor' parameter")
+	}
+	var c listCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, errors.New("invalid 'cursor' parameter")
+	}
+	return &c, nil
+}
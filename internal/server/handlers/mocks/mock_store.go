@@ -6,6 +6,7 @@ package mock_handlers
 
 import (
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 	models "github.com/vkupriya/go-metrics/internal/server/models"
@@ -46,6 +47,36 @@ func (mr *MockStorageMockRecorder) Close() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockStorage)(nil).Close))
 }
 
+// DeleteMetric mocks base method.
+func (m *MockStorage) DeleteMetric(c *models.Config, mtype, name string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteMetric", c, mtype, name)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteMetric indicates an expected call of DeleteMetric.
+func (mr *MockStorageMockRecorder) DeleteMetric(c, mtype, name interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteMetric", reflect.TypeOf((*MockStorage)(nil).DeleteMetric), c, mtype, name)
+}
+
+// DeleteMetrics mocks base method.
+func (m *MockStorage) DeleteMetrics(c *models.Config, prefix string) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteMetrics", c, prefix)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteMetrics indicates an expected call of DeleteMetrics.
+func (mr *MockStorageMockRecorder) DeleteMetrics(c, prefix interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteMetrics", reflect.TypeOf((*MockStorage)(nil).DeleteMetrics), c, prefix)
+}
+
 // GetAllMetrics mocks base method.
 func (m *MockStorage) GetAllMetrics(c *models.Config) (map[string]float64, map[string]int64, error) {
 	m.ctrl.T.Helper()
@@ -94,6 +125,36 @@ func (mr *MockStorageMockRecorder) GetGaugeMetric(c, name interface{}) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetGaugeMetric", reflect.TypeOf((*MockStorage)(nil).GetGaugeMetric), c, name)
 }
 
+// GetMetadata mocks base method.
+func (m *MockStorage) GetMetadata(c *models.Config) ([]models.MetricMetadata, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMetadata", c)
+	ret0, _ := ret[0].([]models.MetricMetadata)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMetadata indicates an expected call of GetMetadata.
+func (mr *MockStorageMockRecorder) GetMetadata(c interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMetadata", reflect.TypeOf((*MockStorage)(nil).GetMetadata), c)
+}
+
+// GetMetricHistory mocks base method.
+func (m *MockStorage) GetMetricHistory(c *models.Config, mtype, name string, from, to time.Time, step time.Duration) ([]models.HistoryPoint, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMetricHistory", c, mtype, name, from, to, step)
+	ret0, _ := ret[0].([]models.HistoryPoint)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMetricHistory indicates an expected call of GetMetricHistory.
+func (mr *MockStorageMockRecorder) GetMetricHistory(c, mtype, name, from, to, step interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMetricHistory", reflect.TypeOf((*MockStorage)(nil).GetMetricHistory), c, mtype, name, from, to, step)
+}
+
 // PingStore mocks base method.
 func (m *MockStorage) PingStore(c *models.Config) error {
 	m.ctrl.T.Helper()
@@ -108,6 +169,49 @@ func (mr *MockStorageMockRecorder) PingStore(c interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PingStore", reflect.TypeOf((*MockStorage)(nil).PingStore), c)
 }
 
+// ResetCounter mocks base method.
+func (m *MockStorage) ResetCounter(c *models.Config, name string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetCounter", c, name)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ResetCounter indicates an expected call of ResetCounter.
+func (mr *MockStorageMockRecorder) ResetCounter(c, name interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetCounter", reflect.TypeOf((*MockStorage)(nil).ResetCounter), c, name)
+}
+
+// RollupHistory mocks base method.
+func (m *MockStorage) RollupHistory(c *models.Config) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RollupHistory", c)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RollupHistory indicates an expected call of RollupHistory.
+func (mr *MockStorageMockRecorder) RollupHistory(c interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RollupHistory", reflect.TypeOf((*MockStorage)(nil).RollupHistory), c)
+}
+
+// SetMetadata mocks base method.
+func (m *MockStorage) SetMetadata(c *models.Config, md *models.MetricMetadata) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetMetadata", c, md)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetMetadata indicates an expected call of SetMetadata.
+func (mr *MockStorageMockRecorder) SetMetadata(c, md interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetMetadata", reflect.TypeOf((*MockStorage)(nil).SetMetadata), c, md)
+}
+
 // UpdateBatch mocks base method.
 func (m *MockStorage) UpdateBatch(c *models.Config, g, cr models.Metrics) error {
 	m.ctrl.T.Helper()
@@ -0,0 +1,135 @@
+//nolint:wrapcheck // errors of the wrapped storage are returned as is
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vkupriya/go-metrics/internal/server/auth"
+	"github.com/vkupriya/go-metrics/internal/server/models"
+)
+
+// restrictedStore limits storage to metrics the principal may access, other metrics are hidden from reads
+// and writes of them fail with auth.ErrForbidden.
+type restrictedStore struct {
+	Storage
+	principal *auth.Principal
+}
+
+func (s *restrictedStore) check(name string) error {
+	if !s.principal.Allowed(name) {
+		return fmt.Errorf("%w: token '%s' has no access to metric '%s'", auth.ErrForbidden, s.principal.Name, name)
+	}
+	return nil
+}
+
+func (s *restrictedStore) UpdateGaugeMetric(c *models.Config, name string, value float64) (float64, error) {
+	if err := s.check(name); err != nil {
+		return 0, err
+	}
+	return s.Storage.UpdateGaugeMetric(c, name, value)
+}
+
+func (s *restrictedStore) UpdateCounterMetric(c *models.Config, name string, value int64) (int64, error) {
+	if err := s.check(name); err != nil {
+		return 0, err
+	}
+	return s.Storage.UpdateCounterMetric(c, name, value)
+}
+
+func (s *restrictedStore) GetCounterMetric(c *models.Config, name string) (int64, bool, error) {
+	if err := s.check(name); err != nil {
+		return 0, false, err
+	}
+	return s.Storage.GetCounterMetric(c, name)
+}
+
+func (s *restrictedStore) GetGaugeMetric(c *models.Config, name string) (float64, bool, error) {
+	if err := s.check(name); err != nil {
+		return 0, false, err
+	}
+	return s.Storage.GetGaugeMetric(c, name)
+}
+
+// GetAllMetrics filters copies of the metric maps, memory and file storages return their own maps.
+func (s *restrictedStore) GetAllMetrics(c *models.Config) (map[string]float64, map[string]int64, error) {
+	gauges, counters, err := s.Storage.GetAllMetrics(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	g := make(map[string]float64, len(gauges))
+	for name, v := range gauges {
+		if s.principal.Allowed(name) {
+			g[name] = v
+		}
+	}
+	cr := make(map[string]int64, len(counters))
+	for name, v := range counters {
+		if s.principal.Allowed(name) {
+			cr[name] = v
+		}
+	}
+	return g, cr, nil
+}
+
+func (s *restrictedStore) UpdateBatch(c *models.Config, g models.Metrics, cr models.Metrics) error {
+	for _, ms := range []models.Metrics{g, cr} {
+		for _, m := range ms {
+			if err := s.check(m.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return s.Storage.UpdateBatch(c, g, cr)
+}
+
+func (s *restrictedStore) GetMetricHistory(c *models.Config, mtype, name string, from, to time.Time,
+	step time.Duration) ([]models.HistoryPoint, error) {
+	if err := s.check(name); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetMetricHistory(c, mtype, name, from, to, step)
+}
+
+func (s *restrictedStore) DeleteMetric(c *models.Config, mtype, name string) (bool, error) {
+	if err := s.check(name); err != nil {
+		return false, err
+	}
+	return s.Storage.DeleteMetric(c, mtype, name)
+}
+
+func (s *restrictedStore) ResetCounter(c *models.Config, name string) (bool, error) {
+	if err := s.check(name); err != nil {
+		return false, err
+	}
+	return s.Storage.ResetCounter(c, name)
+}
+
+// DeleteMetrics is allowed only if all metrics with the prefix are accessible.
+func (s *restrictedStore) DeleteMetrics(c *models.Config, prefix string) (int64, error) {
+	if err := s.check(prefix); err != nil {
+		return 0, err
+	}
+	return s.Storage.DeleteMetrics(c, prefix)
+}
+
+func (s *restrictedStore) SetMetadata(c *models.Config, md *models.MetricMetadata) error {
+	if err := s.check(md.Name); err != nil {
+		return err
+	}
+	return s.Storage.SetMetadata(c, md)
+}
+
+func (s *restrictedStore) GetMetadata(c *models.Config) ([]models.MetricMetadata, error) {
+	mds, err := s.Storage.GetMetadata(c)
+	if err != nil {
+		return nil, err
+	}
+	allowed := make([]models.MetricMetadata, 0, len(mds))
+	for _, md := range mds {
+		if s.principal.Allowed(md.Name) {
+			allowed = append(allowed, md)
+		}
+	}
+	return allowed, nil
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vkupriya/go-metrics/internal/server/health"
+	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/storage"
+	"github.com/vkupriya/go-metrics/internal/server/tenant"
+	"github.com/vkupriya/go-metrics/internal/server/validation"
+)
+
+// ErrUnknownTenant is returned for tenants other than the default one when tenant storages can't be opened.
+var ErrUnknownTenant = errors.New("unknown tenant")
+
+// Tenants keeps metric storage of every tenant, storages of tenants are opened on first use.
+type Tenants struct {
+	stores    map[string]Storage
+	open      func(name string) (Storage, error)
+	config    *models.Config
+	validator *validation.Validator
+	mu        sync.Mutex
+	max       int64 // tenants other than the default one, 0 disables the limit
+	shared    bool  // storages share the backend of the default tenant
+}
+
+// NewTenants initializes Tenants with storage of the default tenant, open is called to get storage of other tenants.
+// Only the default tenant is served if open is nil.
+func NewTenants(store Storage, open func(name string) (Storage, error)) *Tenants {
+	return &Tenants{
+		stores: map[string]Storage{tenant.Default: store},
+		open:   open,
+	}
+}
+
+// NewTenantStores instantiates storage of the default tenant based on configuration parameters,
+// storages of other tenants use the same backend: Postgres tables are shared, every tenant gets own file or memory.
+// Operations of the storages are recorded in self-metrics of the backend.
+func NewTenantStores(c *models.Config) (*Tenants, error) {
+	s, err := NewStore(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var t *Tenants
+	switch db := s.(type) {
+	case *storage.PostgresStorage:
+		t = NewTenants(instrument(db), instrumentOpen(func(name string) (Storage, error) {
+			return db.ForTenant(name), nil
+		}))
+		t.shared = true
+	case *storage.FileStorage:
+		t = NewTenants(instrument(db), instrumentOpen(func(name string) (Storage, error) {
+			return storage.NewTenantFileStorage(c, name)
+		}))
+	default:
+		t = NewTenants(instrument(db), instrumentOpen(func(name string) (Storage, error) {
+			return storage.NewTenantMemStorage(c, name)
+		}))
+	}
+	t.max = c.MaxTenants
+	return t, nil
+}
+
+// Default returns storage of the default tenant.
+func (t *Tenants) Default() Storage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stores[tenant.Default]
+}
+
+// Store returns storage of the tenant, opening it on first use.
+func (t *Tenants) Store(name string) (Storage, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if s, ok := t.stores[name]; ok {
+		return s, nil
+	}
+	if t.open == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTenant, name)
+	}
+	// Storages of tenants are never closed, so every new tenant takes memory or a file for good.
+	if t.max > 0 && int64(len(t.stores)-1) >= t.max {
+		return nil, fmt.Errorf("%w: storages of %d tenants are open", tenant.ErrTooManyTenants, t.max)
+	}
+	s, err := t.open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage of tenant '%s': %w", name, err)
+	}
+	if err := t.seed(name, s); err != nil {
+		s.Close()
+		return nil, err
+	}
+	t.stores[name] = s
+	return s, nil
+}
+
+// StoreContext returns storage of the tenant like Store, Postgres queries of the storage are traced
+// as a part of the request of the context.
+func (t *Tenants) StoreContext(ctx context.Context, name string) (Storage, error) {
+	s, err := t.Store(name)
+	if err != nil {
+		return nil, err
+	}
+	return withContext(ctx, s), nil
+}
+
+// withContext binds Postgres storage wrapped by s to the context.
+func withContext(ctx context.Context, s Storage) Storage {
+	switch st := s.(type) {
+	case *instrumentedStore:
+		return &instrumentedStore{Storage: withContext(ctx, st.Storage), prefix: st.prefix}
+	case *storage.PostgresStorage:
+		return st.WithContext(ctx)
+	default:
+		return s
+	}
+}
+
+// SetValidator makes the validator count series of tenant storages, series of already opened storages
+// are counted immediately and series of other tenants when their storages are opened.
+func (t *Tenants) SetValidator(c *models.Config, v *validation.Validator) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.config, t.validator = c, v
+	for name, s := range t.stores {
+		if err := t.seed(name, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seed counts stored series of the tenant by the validator, t.mu must be held.
+func (t *Tenants) seed(name string, s Storage) error {
+	if t.validator == nil || t.config.MaxSeries <= 0 {
+		return nil
+	}
+	gauges, counters, err := s.GetAllMetrics(t.config)
+	if err != nil {
+		return fmt.Errorf("failed to count series of tenant '%s': %w", name, err)
+	}
+	t.validator.Seed(name, gauges, counters)
+	return nil
+}
+
+// RegisterChecks adds health checks of the storage backend: availability of the storage, results of the last
+// saves of file storages and DB schema migrations.
+func (t *Tenants) RegisterChecks(h *health.Health, c *models.Config) {
+	h.AddCheck("storage", func(context.Context) (string, error) {
+		s := unwrap(t.Default())
+		if err := s.PingStore(c); err != nil {
+			return "", fmt.Errorf("failed to ping storage: %w", err)
+		}
+		return backendName(s), nil
+	})
+	h.AddCheck("storage_save", t.checkSaves)
+	h.AddCheck("migrations", func(context.Context) (string, error) {
+		db, ok := unwrap(t.Default()).(*storage.PostgresStorage)
+		if !ok {
+			return "", fmt.Errorf("%w: metrics aren't stored in Postgres", health.ErrDisabled)
+		}
+		return fmt.Sprintf("DB schema is migrated to version %d", db.MigrationVersion()), nil
+	})
+}
+
+// checkSaves fails if the last save of a file storage failed.
+func (t *Tenants) checkSaves(context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var last time.Time
+	files := false
+	for name, s := range t.stores {
+		fs, ok := unwrap(s).(*storage.FileStorage)
+		if !ok {
+			continue
+		}
+		files = true
+		at, err := fs.LastSave()
+		if err != nil {
+			return "", fmt.Errorf("failed to save metrics of tenant '%s' at %s: %w", name, at.Format(time.RFC3339), err)
+		}
+		if at.After(last) {
+			last = at
+		}
+	}
+	switch {
+	case !files:
+		return "", fmt.Errorf("%w: metrics aren't stored in files", health.ErrDisabled)
+	case last.IsZero():
+		return "metrics haven't been saved yet", nil
+	}
+	return "metrics were saved at " + last.Format(time.RFC3339), nil
+}
+
+// all returns storages which own their data, storages sharing the default tenant backend are skipped.
+func (t *Tenants) all() []Storage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.shared {
+		return []Storage{t.stores[tenant.Default]}
+	}
+	stores := make([]Storage, 0, len(t.stores))
+	for _, s := range t.stores {
+		stores = append(stores, s)
+	}
+	return stores
+}
+
+// RollupHistory rolls up metric history of all tenants.
+func (t *Tenants) RollupHistory(c *models.Config) error {
+	var errs []error
+	for _, s := range t.all() {
+		if err := s.RollupHistory(c); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes storages of all tenants.
+func (t *Tenants) Close() {
+	for _, s := range t.all() {
+		s.Close()
+	}
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/vkupriya/go-metrics/internal/server/problem"
+	"github.com/vkupriya/go-metrics/internal/server/storage"
+)
+
+// ui holds templates and static assets of the web UI, the UI doesn't load anything from other hosts.
+//
+//go:embed ui
+var ui embed.FS
+
+var uiTemplates = template.Must(template.ParseFS(ui, "ui/templates/*.html"))
+
+// uiMetric is a metric shown in the web UI.
+type uiMetric struct {
+	Labels      map[string]string
+	Name        string
+	Type        string
+	Value       string
+	Unit        string
+	Description string
+}
+
+// uiGroup is a table of metrics of one type on the dashboard.
+type uiGroup struct {
+	Title   string
+	Type    string
+	Metrics []uiMetric
+}
+
+// uiStatic serves static assets of the web UI.
+func uiStatic() http.Handler {
+	static, err := fs.Sub(ui, "ui/static")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/ui/static/", http.FileServer(http.FS(static)))
+}
+
+// dashboardGroups groups metrics by type for the dashboard, metrics are sorted by name.
+func dashboardGroups(gauges map[string]float64, counters map[string]int64) []uiGroup {
+	gs := make([]uiMetric, 0, len(gauges))
+	for name, v := range gauges {
+		gs = append(gs, uiMetric{Name: name, Type: gauge, Value: strconv.FormatFloat(v, 'f', -1, 64)})
+	}
+	cs := make([]uiMetric, 0, len(counters))
+	for name, v := range counters {
+		cs = append(cs, uiMetric{Name: name, Type: counter, Value: strconv.FormatInt(v, 10)})
+	}
+	for _, ms := range [][]uiMetric{gs, cs} {
+		sort.Slice(ms, func(i, j int) bool { return ms[i].Name < ms[j].Name })
+	}
+	return []uiGroup{
+		{Title: "Gauges", Type: gauge, Metrics: gs},
+		{Title: "Counters", Type: counter, Metrics: cs},
+	}
+}
+
+// renderPage executes the UI template into the response, the page is buffered so that
+// template errors result in status 500 instead of a partial page.
+func renderPage(rw http.ResponseWriter, r *http.Request, logger *zap.Logger, name string, data any) {
+	var b bytes.Buffer
+	if err := uiTemplates.ExecuteTemplate(&b, name, data); err != nil {
+		logger.Sugar().Error("failed to execute http template", zap.Error(err))
+		problem.WriteInternal(rw, r)
+		return
+	}
+	rw.Header().Set(contentType, "text/html; charset=utf-8")
+	if _, err := b.WriteTo(rw); err != nil {
+		logger.Sugar().Error("failed to write http page", zap.Error(err))
+	}
+}
+
+// metricFound writes not found problem for metrics missing in the storage and internal error for failed
+// reads. It reports whether the metric is found.
+func metricFound(rw http.ResponseWriter, r *http.Request, logger *zap.Logger, name string, found bool, err error) bool {
+	switch {
+	case err != nil && !errors.Is(err, storage.ErrUnknownMetric):
+		logger.Sugar().Error("failed to get metric", zap.String("name", name), zap.Error(err))
+		writeStoreError(rw, r, err, name)
+		return false
+	case !found:
+		writeItemProblem(rw, r, http.StatusNotFound, problem.NotFound, notFoundMsg, name)
+		return false
+	}
+	return true
+}
+
+// MetricPage endpoint renders web UI page of a metric with its metadata and history chart.
+func (mr *MetricResource) MetricPage(rw http.ResponseWriter, r *http.Request) {
+	logger := mr.logger(r)
+
+	store, ok := mr.tenantStore(rw, r)
+	if !ok {
+		return
+	}
+
+	m := uiMetric{Type: chi.URLParam(r, metricTypeParam), Name: chi.URLParam(r, metricNameParam)}
+	switch m.Type {
+	case gauge:
+		v, found, err := store.GetGaugeMetric(mr.config, m.Name)
+		if !metricFound(rw, r, logger, m.Name, found, err) {
+			return
+		}
+		m.Value = strconv.FormatFloat(v, 'f', -1, 64)
+	case counter:
+		v, found, err := store.GetCounterMetric(mr.config, m.Name)
+		if !metricFound(rw, r, logger, m.Name, found, err) {
+			return
+		}
+		m.Value = strconv.FormatInt(v, 10)
+	default:
+		problem.Write(rw, r, http.StatusBadRequest, problem.InvalidMetricType, typeErrorMsg)
+		return
+	}
+
+	mds, err := store.GetMetadata(mr.config)
+	if err != nil {
+		logger.Sugar().Error("failed to get metric metadata", zap.Error(err))
+		problem.WriteInternal(rw, r)
+		return
+	}
+	for _, md := range mds {
+		if md.Name == m.Name {
+			m.Unit, m.Description, m.Labels = md.Unit, md.Description, md.Labels
+		}
+	}
+
+	renderPage(rw, r, logger, "metric.html", m)
+}
@@ -0,0 +1,217 @@
+// Metrics dashboard: sorts and filters metric tables and refreshes them from /api/v1/metrics.
+(function () {
+	"use strict";
+
+	const filterInput = document.getElementById("filter");
+	const refreshSelect = document.getElementById("refresh");
+	const status = document.getElementById("status");
+	const tables = Array.from(document.querySelectorAll("table.metrics"));
+
+	const state = {
+		metrics: null, // metrics from the API, null until the first refresh
+		sort: "name",
+		desc: false,
+		filter: new URLSearchParams(location.search).get("filter") || "",
+		timer: null,
+	};
+
+	function formatValue(m) {
+		return m.type === "counter" ? String(m.delta) : String(m.value);
+	}
+
+	function labelsText(labels) {
+		return Object.keys(labels || {}).sort().map((k) => k + "=" + labels[k]);
+	}
+
+	function matches(m, filter) {
+		if (!filter) {
+			return true;
+		}
+		const f = filter.toLowerCase();
+		if (m.id.toLowerCase().includes(f)) {
+			return true;
+		}
+		return labelsText(m.labels).some((l) => l.toLowerCase().includes(f));
+	}
+
+	function compare(a, b) {
+		let res;
+		switch (state.sort) {
+		case "value":
+			res = Number(formatValue(a)) - Number(formatValue(b));
+			break;
+		case "unit":
+			res = (a.unit || "").localeCompare(b.unit || "");
+			break;
+		default:
+			res = 0;
+		}
+		if (res === 0) {
+			res = a.id.localeCompare(b.id);
+		}
+		return state.desc ? -res : res;
+	}
+
+	function cell(text, className) {
+		const td = document.createElement("td");
+		td.textContent = text;
+		if (className) {
+			td.className = className;
+		}
+		return td;
+	}
+
+	function row(m, previous) {
+		const tr = document.createElement("tr");
+		tr.dataset.name = m.id;
+
+		const link = document.createElement("a");
+		link.href = "/ui/metrics/" + encodeURIComponent(m.type) + "/" + encodeURIComponent(m.id);
+		link.textContent = m.id;
+		const name = document.createElement("td");
+		name.appendChild(link);
+		tr.appendChild(name);
+
+		const value = formatValue(m);
+		tr.appendChild(cell(value, "num"));
+		tr.appendChild(cell(m.unit || ""));
+
+		const labels = document.createElement("td");
+		for (const l of labelsText(m.labels)) {
+			const span = document.createElement("span");
+			span.className = "label";
+			span.textContent = l;
+			labels.appendChild(span);
+		}
+		tr.appendChild(labels);
+
+		if (previous !== undefined && previous !== value) {
+			tr.classList.add("changed");
+			setTimeout(() => tr.classList.remove("changed"), 50);
+		}
+		return tr;
+	}
+
+	// renderTable renders metrics of the table type, rendered rows are filtered and sorted in place
+	// until metrics are loaded from the API.
+	function renderTable(table) {
+		const type = table.dataset.type;
+		const body = table.tBodies[0];
+
+		table.querySelectorAll("th[data-sort]").forEach((th) => {
+			th.classList.toggle("sorted", th.dataset.sort === state.sort);
+			th.classList.toggle("desc", th.dataset.sort === state.sort && state.desc);
+		});
+
+		if (state.metrics === null) {
+			for (const tr of body.rows) {
+				if (tr.dataset.name !== undefined) {
+					tr.hidden = !matches({id: tr.dataset.name, labels: {}}, state.filter);
+				}
+			}
+			return;
+		}
+
+		const previous = {};
+		for (const tr of body.rows) {
+			if (tr.dataset.name !== undefined) {
+				previous[tr.dataset.name] = tr.cells[1].textContent;
+			}
+		}
+
+		const metrics = state.metrics.filter((m) => m.type === type && matches(m, state.filter)).sort(compare);
+		const rows = metrics.map((m) => row(m, previous[m.id]));
+		if (rows.length === 0) {
+			const tr = document.createElement("tr");
+			tr.className = "empty";
+			const td = cell(state.filter ? "No matching " + type + " metrics" : "No " + type + " metrics", "muted");
+			td.colSpan = 4;
+			tr.appendChild(td);
+			rows.push(tr);
+		}
+		body.replaceChildren(...rows);
+
+		const count = table.closest("section").querySelector(".count");
+		const total = state.metrics.filter((m) => m.type === type).length;
+		count.textContent = metrics.length === total ? String(total) : metrics.length + " of " + total;
+	}
+
+	function render() {
+		tables.forEach(renderTable);
+	}
+
+	async function fetchMetrics() {
+		const metrics = [];
+		let cursor = "";
+		do {
+			const params = new URLSearchParams({limit: "1000", fields: "id,type,value,delta,unit,labels"});
+			if (cursor) {
+				params.set("cursor", cursor);
+			}
+			const resp = await fetch("/api/v1/metrics?" + params);
+			if (!resp.ok) {
+				throw new Error("server responded with status " + resp.status);
+			}
+			const page = await resp.json();
+			metrics.push(...page.metrics);
+			cursor = page.next_cursor || "";
+		} while (cursor);
+		return metrics;
+	}
+
+	async function refresh() {
+		try {
+			state.metrics = await fetchMetrics();
+			status.textContent = "Updated " + new Date().toLocaleTimeString();
+			render();
+		} catch (err) {
+			status.textContent = "Failed to refresh: " + err.message;
+		}
+	}
+
+	function schedule() {
+		clearInterval(state.timer);
+		const seconds = Number(refreshSelect.value);
+		localStorage.setItem("go-metrics.refresh", refreshSelect.value);
+		if (seconds > 0) {
+			state.timer = setInterval(refresh, seconds * 1000);
+		}
+	}
+
+	tables.forEach((table) => {
+		table.querySelectorAll("th[data-sort]").forEach((th) => {
+			th.addEventListener("click", () => {
+				if (state.sort === th.dataset.sort) {
+					state.desc = !state.desc;
+				} else {
+					state.sort = th.dataset.sort;
+					state.desc = false;
+				}
+				render();
+			});
+		});
+	});
+
+	filterInput.value = state.filter;
+	filterInput.addEventListener("input", () => {
+		state.filter = filterInput.value.trim();
+		const url = new URL(location.href);
+		if (state.filter) {
+			url.searchParams.set("filter", state.filter);
+		} else {
+			url.searchParams.delete("filter");
+		}
+		history.replaceState(null, "", url);
+		render();
+	});
+
+	const savedRefresh = localStorage.getItem("go-metrics.refresh");
+	if (savedRefresh !== null && refreshSelect.querySelector("option[value='" + savedRefresh + "']")) {
+		refreshSelect.value = savedRefresh;
+	}
+	refreshSelect.addEventListener("change", schedule);
+
+	render();
+	refresh();
+	schedule();
+})();
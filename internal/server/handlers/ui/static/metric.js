@@ -0,0 +1,215 @@
+// Metric page: draws history chart of the metric from /history and keeps the current value live.
+(function () {
+	"use strict";
+
+	const SVG = "http://www.w3.org/2000/svg";
+	const POINTS = 240; // approximate number of points on the chart
+	const LIVE_INTERVAL = 15000;
+	const MARGIN = {top: 12, right: 16, bottom: 24, left: 64};
+
+	const main = document.getElementById("metric");
+	const type = main.dataset.type;
+	const name = main.dataset.name;
+	const chart = document.getElementById("chart");
+	const value = document.getElementById("value");
+	const status = document.getElementById("status");
+	const live = document.getElementById("live");
+	const buttons = Array.from(document.querySelectorAll("button[data-range]"));
+
+	const base = encodeURIComponent(type) + "/" + encodeURIComponent(name);
+	const state = {
+		range: 3600,
+		points: [],
+		timer: null,
+	};
+
+	function el(tag, attrs, parent) {
+		const e = document.createElementNS(SVG, tag);
+		for (const k in attrs) {
+			e.setAttribute(k, attrs[k]);
+		}
+		if (parent) {
+			parent.appendChild(e);
+		}
+		return e;
+	}
+
+	function formatNumber(v) {
+		const abs = Math.abs(v);
+		if (abs !== 0 && (abs >= 1e6 || abs < 1e-3)) {
+			return v.toExponential(2);
+		}
+		return String(Math.round(v * 1000) / 1000);
+	}
+
+	function formatTime(t) {
+		if (state.range > 86400) {
+			return t.toLocaleDateString([], {month: "short", day: "numeric"}) + " " +
+				t.toLocaleTimeString([], {hour: "2-digit", minute: "2-digit"});
+		}
+		return t.toLocaleTimeString([], {hour: "2-digit", minute: "2-digit"});
+	}
+
+	// series converts history points into chart values: average with min/max band for gauges,
+	// increase over each point for counters.
+	function series(points) {
+		return points.map((p) => {
+			const t = new Date(p.timestamp);
+			if (type === "counter") {
+				const inc = p.increase || 0;
+				return {t, v: inc, min: inc, max: inc};
+			}
+			return {t, v: p.avg, min: p.min, max: p.max};
+		});
+	}
+
+	// ticks returns about n round values covering [min, max].
+	function ticks(min, max, n) {
+		const span = max - min || Math.abs(max) || 1;
+		const raw = span / n;
+		const mag = Math.pow(10, Math.floor(Math.log10(raw)));
+		const step = [1, 2, 5, 10].map((m) => m * mag).find((s) => s >= raw);
+		const res = [];
+		for (let v = Math.ceil(min / step) * step; v <= max + step / 2; v += step) {
+			res.push(v);
+		}
+		return res;
+	}
+
+	function message(text) {
+		const div = document.createElement("div");
+		div.className = "message";
+		div.textContent = text;
+		chart.replaceChildren(div);
+	}
+
+	function draw() {
+		const data = series(state.points);
+		if (data.length === 0) {
+			message("No history in the selected range");
+			return;
+		}
+
+		const width = chart.clientWidth;
+		const height = chart.clientHeight;
+		const svg = el("svg", {viewBox: "0 0 " + width + " " + height});
+
+		const to = Date.now();
+		const from = to - state.range * 1000;
+		let min = Math.min(...data.map((d) => d.min));
+		let max = Math.max(...data.map((d) => d.max));
+		if (min === max) {
+			min -= Math.abs(min) * 0.1 || 1;
+			max += Math.abs(max) * 0.1 || 1;
+		}
+		const yTicks = ticks(min, max, 5);
+		min = Math.min(min, yTicks[0]);
+		max = Math.max(max, yTicks[yTicks.length - 1]);
+
+		const x = (t) => MARGIN.left + (t - from) / (to - from) * (width - MARGIN.left - MARGIN.right);
+		const y = (v) => height - MARGIN.bottom - (v - min) / (max - min) * (height - MARGIN.top - MARGIN.bottom);
+
+		for (const v of yTicks) {
+			el("line", {class: "axis", x1: MARGIN.left, x2: width - MARGIN.right, y1: y(v), y2: y(v)}, svg);
+			el("text", {x: MARGIN.left - 6, y: y(v) + 4, "text-anchor": "end"}, svg).textContent = formatNumber(v);
+		}
+		for (let i = 0; i <= 4; i++) {
+			const t = from + (to - from) * i / 4;
+			const anchor = i === 0 ? "start" : i === 4 ? "end" : "middle";
+			el("text", {x: x(t), y: height - 6, "text-anchor": anchor}, svg).textContent = formatTime(new Date(t));
+		}
+
+		if (type === "gauge") {
+			const upper = data.map((d) => x(d.t) + "," + y(d.max));
+			const lower = data.map((d) => x(d.t) + "," + y(d.min)).reverse();
+			el("polygon", {class: "band", points: upper.concat(lower).join(" ")}, svg);
+		}
+		el("polyline", {class: "line", points: data.map((d) => x(d.t) + "," + y(d.v)).join(" ")}, svg);
+
+		const cursor = el("line", {class: "cursor", y1: MARGIN.top, y2: height - MARGIN.bottom, visibility: "hidden"}, svg);
+		const tooltip = document.createElement("div");
+		tooltip.className = "tooltip";
+		tooltip.hidden = true;
+
+		svg.addEventListener("mousemove", (e) => {
+			const rect = svg.getBoundingClientRect();
+			const px = (e.clientX - rect.left) * width / rect.width;
+			let nearest = data[0];
+			for (const d of data) {
+				if (Math.abs(x(d.t) - px) < Math.abs(x(nearest.t) - px)) {
+					nearest = d;
+				}
+			}
+			cursor.setAttribute("x1", x(nearest.t));
+			cursor.setAttribute("x2", x(nearest.t));
+			cursor.setAttribute("visibility", "visible");
+
+			let text = nearest.t.toLocaleString() + ": " + formatNumber(nearest.v);
+			if (type === "counter") {
+				text += " increase";
+			} else if (nearest.min !== nearest.max) {
+				text += " (min " + formatNumber(nearest.min) + ", max " + formatNumber(nearest.max) + ")";
+			}
+			tooltip.textContent = text;
+			tooltip.hidden = false;
+			const left = x(nearest.t) * rect.width / width;
+			tooltip.style.left = Math.min(left + 8, rect.width - tooltip.offsetWidth - 4) + "px";
+			tooltip.style.top = "8px";
+		});
+		svg.addEventListener("mouseleave", () => {
+			cursor.setAttribute("visibility", "hidden");
+			tooltip.hidden = true;
+		});
+
+		chart.replaceChildren(svg, tooltip);
+	}
+
+	async function loadHistory() {
+		const to = Math.floor(Date.now() / 1000);
+		const step = Math.max(1, Math.round(state.range / POINTS));
+		const params = new URLSearchParams({from: String(to - state.range), to: String(to), step: step + "s"});
+		const resp = await fetch("/history/" + base + "?" + params);
+		if (!resp.ok) {
+			throw new Error("history request failed with status " + resp.status);
+		}
+		state.points = await resp.json() || [];
+		draw();
+	}
+
+	async function loadValue() {
+		const resp = await fetch("/value/" + base);
+		if (!resp.ok) {
+			throw new Error("value request failed with status " + resp.status);
+		}
+		value.textContent = await resp.text();
+	}
+
+	async function refresh() {
+		try {
+			await Promise.all([loadHistory(), loadValue()]);
+			status.textContent = "Updated " + new Date().toLocaleTimeString();
+		} catch (err) {
+			status.textContent = "Failed to refresh: " + err.message;
+		}
+	}
+
+	function schedule() {
+		clearInterval(state.timer);
+		if (live.checked) {
+			state.timer = setInterval(refresh, LIVE_INTERVAL);
+		}
+	}
+
+	buttons.forEach((b) => {
+		b.addEventListener("click", () => {
+			state.range = Number(b.dataset.range);
+			buttons.forEach((o) => o.classList.toggle("active", o === b));
+			refresh();
+		});
+	});
+	live.addEventListener("change", schedule);
+	window.addEventListener("resize", draw);
+
+	refresh();
+	schedule();
+})();
@@ -0,0 +1,111 @@
+// Package health reports readiness of the metric server components.
+//
+// Components are checked on demand by registered checks or report their state themselves,
+// e.g. the gRPC server reports whether it is listening. The server is ready if no component is failing,
+// components which aren't configured are reported as disabled and don't affect readiness.
+package health
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Statuses of components.
+const (
+	StatusOK       = "ok"
+	StatusDisabled = "disabled"
+	StatusFailing  = "failing"
+)
+
+// checkTimeout limits duration of a single check.
+const checkTimeout = 3 * time.Second
+
+// ErrDisabled is returned by checks of components which aren't configured.
+var ErrDisabled = errors.New("disabled")
+
+// Check returns details of a ready component or error of a failing one.
+type Check func(ctx context.Context) (string, error)
+
+// Component is the status of a component.
+type Component struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Report is the readiness report of the server.
+type Report struct {
+	Status     string      `json:"status"`
+	Components []Component `json:"components"`
+}
+
+// Ready reports whether no component is failing.
+func (r *Report) Ready() bool {
+	return r.Status == StatusOK
+}
+
+// Health keeps checks and reported states of components, it is safe for concurrent use.
+type Health struct {
+	checks map[string]Check
+	mu     sync.Mutex
+}
+
+func New() *Health {
+	return &Health{
+		checks: make(map[string]Check),
+	}
+}
+
+// AddCheck registers check of the component, it replaces check or state of the component registered before.
+func (h *Health) AddCheck(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks[name] = check
+}
+
+// Set reports state of the component, err is nil for a ready component.
+func (h *Health) Set(name string, detail string, err error) {
+	h.AddCheck(name, func(context.Context) (string, error) {
+		return detail, err
+	})
+}
+
+// Check runs checks of all components.
+func (h *Health) Check(ctx context.Context) Report {
+	h.mu.Lock()
+	names := make([]string, 0, len(h.checks))
+	checks := make(map[string]Check, len(h.checks))
+	for name, check := range h.checks {
+		names = append(names, name)
+		checks[name] = check
+	}
+	h.mu.Unlock()
+	sort.Strings(names)
+
+	report := Report{Status: StatusOK, Components: make([]Component, 0, len(names))}
+	for _, name := range names {
+		c := run(ctx, name, checks[name])
+		if c.Status == StatusFailing {
+			report.Status = StatusFailing
+		}
+		report.Components = append(report.Components, c)
+	}
+	return report
+}
+
+func run(ctx context.Context, name string, check Check) Component {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	detail, err := check(ctx)
+	switch {
+	case errors.Is(err, ErrDisabled):
+		return Component{Name: name, Status: StatusDisabled, Detail: err.Error()}
+	case err != nil:
+		return Component{Name: name, Status: StatusFailing, Detail: err.Error()}
+	}
+	return Component{Name: name, Status: StatusOK, Detail: detail}
+}
@@ -0,0 +1,36 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheck(t *testing.T) {
+	h := New()
+	h.AddCheck("storage", func(context.Context) (string, error) {
+		return "memory", nil
+	})
+	h.AddCheck("migrations", func(context.Context) (string, error) {
+		return "", fmt.Errorf("%w: metrics aren't stored in Postgres", ErrDisabled)
+	})
+	h.Set("grpc", "listening on 127.0.0.1:3200", nil)
+
+	report := h.Check(context.Background())
+	assert.True(t, report.Ready())
+	assert.Equal(t, []Component{
+		{Name: "grpc", Status: StatusOK, Detail: "listening on 127.0.0.1:3200"},
+		{Name: "migrations", Status: StatusDisabled, Detail: "disabled: metrics aren't stored in Postgres"},
+		{Name: "storage", Status: StatusOK, Detail: "memory"},
+	}, report.Components)
+
+	h.Set("grpc", "", errors.New("gRPC server is stopped"))
+	report = h.Check(context.Background())
+	assert.False(t, report.Ready())
+	assert.Equal(t, StatusFailing, report.Status)
+	assert.Equal(t, Component{Name: "grpc", Status: StatusFailing, Detail: "gRPC server is stopped"},
+		report.Components[0])
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/vkupriya/go-metrics/internal/requestid"
+	"github.com/vkupriya/go-metrics/internal/server/audit"
+	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/ratelimit"
+	"github.com/vkupriya/go-metrics/internal/server/tenant"
+)
+
+type MiddlewareAudit struct {
+	config *models.Config
+	log    *audit.Log
+}
+
+func NewMiddlewareAudit(c *models.Config, l *audit.Log) *MiddlewareAudit {
+	return &MiddlewareAudit{
+		config: c,
+		log:    l,
+	}
+}
+
+// Audit records requests of the operation in the audit log when they end, handlers add touched metrics to
+// the entry of the request context. Requests aren't audited if the audit log isn't configured.
+func (m *MiddlewareAudit) Audit(op string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if !m.log.Enabled() {
+			return h
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			e := &audit.Entry{
+				RequestID: requestid.FromContext(r.Context()),
+				Operation: op,
+				Protocol:  audit.ProtocolHTTP,
+				Tenant:    tenant.FromContext(r.Context()),
+				Principal: ratelimit.FromRequest(m.config, r),
+				IP:        remoteIP(r.RemoteAddr),
+				RealIP:    r.Header.Get("X-Real-IP"),
+			}
+			rd := &responseData{status: http.StatusOK}
+			h.ServeHTTP(&loggingResponseWriter{ResponseWriter: w, responseData: rd}, r.WithContext(
+				audit.NewContext(r.Context(), e)))
+
+			e.Status = strconv.Itoa(rd.status)
+			switch {
+			case rd.status >= http.StatusInternalServerError:
+				e.Result = audit.ResultFailed
+			case rd.status >= http.StatusBadRequest:
+				e.Result = audit.ResultRejected
+			default:
+				e.Result = audit.ResultOK
+			}
+			if err := m.log.Record(e); err != nil {
+				httpLogger(m.config, r).Error("failed to record audit entry", zap.Error(err))
+			}
+		})
+	}
+}
+
+// remoteIP returns IP of the remote address, addresses without port, e.g. of Unix sockets, are returned as is.
+func remoteIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
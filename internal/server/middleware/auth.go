@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/vkupriya/go-metrics/internal/server/auth"
+	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/problem"
+)
+
+// authChallenge asks for a bearer token, basic authentication lets browsers pass the token as a password.
+const authChallenge = `Bearer realm="go-metrics", Basic realm="go-metrics"`
+
+type MiddlewareAuth struct {
+	config *models.Config
+	auth   *auth.Authenticator
+}
+
+func NewMiddlewareAuth(c *models.Config, a *auth.Authenticator) *MiddlewareAuth {
+	return &MiddlewareAuth{
+		config: c,
+		auth:   a,
+	}
+}
+
+// Authenticate identifies the client by 'Authorization: Bearer <token>' header or by the password of basic
+// authentication and stores it in request context. Requests without the header are anonymous,
+// requests with an invalid token are rejected.
+func (a *MiddlewareAuth) Authenticate(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := credentials(r)
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		p, err := a.auth.Authenticate(token)
+		if err != nil {
+			a.logRejected(r, "request with invalid token rejected", err)
+			w.Header().Set("WWW-Authenticate", authChallenge)
+			problem.Write(w, r, http.StatusUnauthorized, problem.Unauthorized, err.Error())
+			return
+		}
+
+		setLoggedPrincipal(r, p)
+		h.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), p)))
+	})
+}
+
+// Require allows requests of clients having the role. Anonymous clients may read and write metrics
+// unless a token file is configured, admin role always requires a token.
+func (a *MiddlewareAuth) Require(role auth.Role) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if role == auth.RoleAdmin && !a.auth.Admins() {
+				problem.Write(w, r, http.StatusForbidden, problem.Forbidden, "admin API is disabled")
+				return
+			}
+
+			err := a.auth.Authorize(auth.FromContext(r.Context()), role)
+			switch {
+			case err == nil:
+				h.ServeHTTP(w, r)
+			case errors.Is(err, auth.ErrForbidden):
+				a.logRejected(r, "request rejected", err)
+				problem.Write(w, r, http.StatusForbidden, problem.Forbidden, err.Error())
+			default:
+				a.logRejected(r, "anonymous request rejected", err)
+				w.Header().Set("WWW-Authenticate", authChallenge)
+				problem.Write(w, r, http.StatusUnauthorized, problem.Unauthorized, "API token is required")
+			}
+		})
+	}
+}
+
+func (a *MiddlewareAuth) logRejected(r *http.Request, msg string, err error) {
+	httpLogger(a.config, r).Sugar().Warnw(msg, "uri", r.RequestURI, "method", r.Method, "error", err)
+}
+
+// credentials returns token presented by the request, ok is false if the request has no Authorization header.
+func credentials(r *http.Request) (token string, ok bool) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", false
+	}
+	if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+		return token, true
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		return password, true
+	}
+	return "", true
+}
@@ -0,0 +1,164 @@
+// Package middleware provides custom middlewares: Logger, Compression and Hash for metric REST API service.
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/vkupriya/go-metrics/internal/compression"
+	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/problem"
+)
+
+type MiddlewareCompress struct {
+	config *models.Config
+}
+
+func NewMiddlewareCompress(c *models.Config) *MiddlewareCompress {
+	return &MiddlewareCompress{
+		config: c,
+	}
+}
+
+// compressWriter compresses the response with the codec once its body reaches the minimum size. Smaller
+// bodies are buffered and sent as is by Close, so status code of the response is sent by Close too.
+type compressWriter struct {
+	http.ResponseWriter
+	codec   compression.Codec
+	w       io.WriteCloser // compressing writer, nil until compression starts
+	buf     []byte         // body written before the header is sent
+	level   compression.Level
+	minSize int64
+	status  int
+	sent    bool // header of the response is sent
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	switch {
+	case cw.w != nil:
+		size, err := cw.w.Write(b)
+		if err != nil {
+			return 0, fmt.Errorf("failed to write compressed response: %w", err)
+		}
+		return size, nil
+	case cw.sent:
+		size, err := cw.ResponseWriter.Write(b)
+		if err != nil {
+			return 0, fmt.Errorf("failed to write response: %w", err)
+		}
+		return size, nil
+	}
+
+	cw.buf = append(cw.buf, b...)
+	if int64(len(cw.buf)) >= cw.minSize {
+		if err := cw.send(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// send sends header of the response and the buffered body, compressed if compress is set and the response
+// isn't encoded by the handler.
+func (cw *compressWriter) send(compress bool) error {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	h := cw.Header()
+	if compress && h.Get("Content-Encoding") == "" {
+		w, err := cw.codec.NewWriter(cw.ResponseWriter, cw.level)
+		if err != nil {
+			return fmt.Errorf("failed to compress response: %w", err)
+		}
+		cw.w = w
+		h.Set("Content-Encoding", cw.codec.Name())
+		h.Del("Content-Length")
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	cw.sent = true
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := cw.Write(buf)
+	return err
+}
+
+// Close sends responses smaller than the minimum size and completes compressed ones.
+func (cw *compressWriter) Close() error {
+	if !cw.sent {
+		if err := cw.send(false); err != nil {
+			return err
+		}
+	}
+	if cw.w == nil {
+		return nil
+	}
+	if err := cw.w.Close(); err != nil {
+		return fmt.Errorf("failed to complete compressed response: %w", err)
+	}
+	return nil
+}
+
+// CompressHandle decompresses request bodies of every registered content coding and compresses responses
+// with the codec negotiated by Accept-Encoding header of the request. Responses smaller than the minimum
+// compression size of the configuration are sent uncompressed.
+func (m *MiddlewareCompress) CompressHandle(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := httpLogger(m.config, r)
+		contentEncoding := r.Header.Get("Content-Encoding")
+		if contentEncoding != "" && !strings.EqualFold(contentEncoding, compression.Identity) {
+			codec, ok := compression.Lookup(contentEncoding)
+			if !ok {
+				problem.Write(w, r, http.StatusUnsupportedMediaType, problem.UnsupportedEncoding,
+					fmt.Sprintf("unsupported content encoding '%s', expected one of: %s", contentEncoding,
+						strings.Join(compression.Names(), ", ")))
+				return
+			}
+			body, err := codec.NewReader(r.Body)
+			if err != nil {
+				logger.Sugar().Debug(zap.Error(err))
+				problem.Write(w, r, http.StatusBadRequest, problem.InvalidBody,
+					"request body is not valid "+codec.Name())
+				return
+			}
+			defer func() {
+				if err := body.Close(); err != nil {
+					logger.Sugar().Error(zap.Error(err))
+				}
+			}()
+			r.Body = body
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		codec, ok := compression.Lookup(compression.Negotiate(r.Header.Get("Accept-Encoding")))
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{
+			ResponseWriter: w,
+			codec:          codec,
+			level:          m.config.CompressionLevel,
+			minSize:        m.config.CompressionMinSize,
+		}
+		defer func() {
+			if err := cw.Close(); err != nil {
+				logger.Sugar().Error(zap.Error(err))
+			}
+		}()
+		h.ServeHTTP(cw, r)
+	})
+}
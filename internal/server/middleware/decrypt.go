@@ -1,4 +1,4 @@
-// Package middleware provides custom middlewares: Logger, Gzip and Hash for metric REST API service.
+// Package middleware provides custom middlewares: Logger, Compression and Hash for metric REST API service.
 package middleware
 
 import (
@@ -10,6 +10,7 @@ import (
 	"net/http"
 
 	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/problem"
 	"go.uber.org/zap"
 )
 
@@ -25,40 +26,45 @@ func NewMiddlewareDecrypt(c *models.Config) *MiddlewareDecrypt {
 
 func (d *MiddlewareDecrypt) DecryptHandle(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		logger := d.config.Logger
-		if len(d.config.SecretKey) == 0 {
+		logger := httpLogger(d.config, r)
+		secretKey := d.config.Reloadable().SecretKey
+		if len(secretKey) == 0 {
 			h.ServeHTTP(w, r)
 			return
 		}
 
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
-			logger.Sugar().Error("failed to read request body", zap.Error(err))
-			http.Error(w, "", http.StatusInternalServerError)
+			logger.Sugar().Error(readBodyErrorMsg, zap.Error(err))
+			problem.Write(w, r, http.StatusBadRequest, problem.InvalidBody, readBodyErrorMsg)
 			return
 		}
 		body, _ = hex.DecodeString(string(body))
 
-		block, err := aes.NewCipher(d.config.SecretKey)
+		block, err := aes.NewCipher(secretKey)
 		if err != nil {
 			logger.Sugar().Error("failed to create new cypher block", zap.Error(err))
-			http.Error(w, "", http.StatusInternalServerError)
+			problem.WriteInternal(w, r)
 			return
 		}
 
 		aesgcm, err := cipher.NewGCM(block)
 		if err != nil {
 			logger.Sugar().Error("failed to create new GCM block", zap.Error(err))
-			http.Error(w, "", http.StatusInternalServerError)
+			problem.WriteInternal(w, r)
 			return
 		}
 
+		if len(body) < aesgcm.NonceSize() {
+			problem.Write(w, r, http.StatusBadRequest, problem.InvalidBody, "encrypted body is too short")
+			return
+		}
 		nonce, body := body[:aesgcm.NonceSize()], body[aesgcm.NonceSize():]
 
 		srcBody, err := aesgcm.Open(nil, nonce, body, nil)
 		if err != nil {
 			logger.Sugar().Error("failed to decrypt body", zap.Error(err))
-			http.Error(w, "", http.StatusInternalServerError)
+			problem.Write(w, r, http.StatusBadRequest, problem.InvalidBody, "failed to decrypt request body")
 			return
 		}
 		r.Body = io.NopCloser(bytes.NewBuffer(srcBody))
@@ -5,56 +5,73 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"io"
 	"net/http"
 
 	"go.uber.org/zap"
 
 	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/problem"
+	"github.com/vkupriya/go-metrics/internal/server/selfmetrics"
+	"github.com/vkupriya/go-metrics/internal/signing"
 )
 
+const readBodyErrorMsg = "failed to read request body"
+
 type MiddlewareHash struct {
-	config *models.Config
+	config   *models.Config
+	verifier *signing.Verifier
 }
 
 func NewMiddlewareHash(c *models.Config) *MiddlewareHash {
 	return &MiddlewareHash{
-		config: c,
+		config:   c,
+		verifier: signing.NewVerifier(c.SignMaxAge),
 	}
 }
 
+// HashCheck verifies HMAC signatures of requests. Requests signed with timestamp and nonce are checked
+// against replays, see package signing. Unless strict signing is configured, unsigned requests and legacy
+// signatures of the body only are accepted as well.
 func (m *MiddlewareHash) HashCheck(h http.Handler) http.Handler {
 	logFn := func(w http.ResponseWriter, r *http.Request) {
-		logger := m.config.Logger
+		logger := httpLogger(m.config, r)
+		key := m.config.Reloadable().HashKey
 
-		reqHash := r.Header.Get("HashSHA256")
+		reqHash := r.Header.Get(signing.HeaderSignature)
+		timestamp := r.Header.Get(signing.HeaderTimestamp)
 
-		if m.config.HashKey != "" && reqHash != "" {
-			sig, err := hex.DecodeString(reqHash)
-			if err != nil {
-				http.Error(w, "failed to decode hex string", http.StatusBadRequest)
-				return
-			}
+		if key == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if reqHash == "" && !m.config.SignStrict {
+			h.ServeHTTP(w, r)
+			return
+		}
 
-			b, err := io.ReadAll(r.Body)
-			if err != nil {
-				logger.Sugar().Error("failed to read request body", zap.Error(err))
-				http.Error(w, "", http.StatusInternalServerError)
-				return
-			}
-			r.Body = io.NopCloser(bytes.NewBuffer(b))
-			mac := hmac.New(sha256.New, []byte(m.config.HashKey))
-			_, err = mac.Write(b)
-			if err != nil {
-				logger.Sugar().Debug("failed to write hash.", zap.Error(err))
-				http.Error(w, "", http.StatusInternalServerError)
-				return
-			}
-			if !hmac.Equal(sig, mac.Sum(nil)) {
-				logger.Sugar().Debug("hmac signature does not match.")
-				http.Error(w, "", http.StatusBadRequest)
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			logger.Sugar().Error(readBodyErrorMsg, zap.Error(err))
+			problem.Write(w, r, http.StatusBadRequest, problem.InvalidBody, readBodyErrorMsg)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewBuffer(b))
+
+		if timestamp == "" && !m.config.SignStrict {
+			if !m.checkBody(w, r, key, reqHash, b) {
 				return
 			}
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		err = m.verifier.Verify(key, r.Method, r.URL.Path, reqHash, timestamp, r.Header.Get(signing.HeaderNonce), b)
+		if err != nil {
+			logger.Sugar().Warnf("request signature of %s rejected: %v", r.URL.Path, err)
+			writeSignatureError(w, r, err)
+			return
 		}
 
 		h.ServeHTTP(w, r)
@@ -62,23 +79,63 @@ func (m *MiddlewareHash) HashCheck(h http.Handler) http.Handler {
 	return http.HandlerFunc(logFn)
 }
 
+// checkBody verifies legacy signature of the request body, it writes error response if the signature is invalid.
+func (m *MiddlewareHash) checkBody(w http.ResponseWriter, r *http.Request, key, reqHash string, b []byte) bool {
+	logger := httpLogger(m.config, r)
+
+	sig, err := hex.DecodeString(reqHash)
+	if err != nil {
+		selfmetrics.Inc("rejected.signature.invalid")
+		problem.Write(w, r, http.StatusBadRequest, problem.InvalidSignature, "HashSHA256 header is not a hex string")
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	_, err = mac.Write(b)
+	if err != nil {
+		logger.Sugar().Debug("failed to write hash.", zap.Error(err))
+		problem.WriteInternal(w, r)
+		return false
+	}
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		logger.Sugar().Debug("hmac signature does not match.")
+		selfmetrics.Inc("rejected.signature.invalid")
+		problem.Write(w, r, http.StatusBadRequest, problem.InvalidSignature, "HashSHA256 signature does not match")
+		return false
+	}
+	return true
+}
+
+func writeSignatureError(w http.ResponseWriter, r *http.Request, err error) {
+	selfmetrics.Inc("rejected.signature." + signing.Reason(err))
+	switch {
+	case errors.Is(err, signing.ErrMissing):
+		problem.Write(w, r, http.StatusUnauthorized, problem.SignatureRequired, err.Error())
+	case errors.Is(err, signing.ErrStale):
+		problem.Write(w, r, http.StatusUnauthorized, problem.StaleSignature, err.Error())
+	case errors.Is(err, signing.ErrReplayed):
+		problem.Write(w, r, http.StatusUnauthorized, problem.ReplayedRequest, err.Error())
+	default:
+		problem.Write(w, r, http.StatusBadRequest, problem.InvalidSignature, err.Error())
+	}
+}
+
 func (m *MiddlewareHash) HashSend(h http.Handler) http.Handler {
 	logFn := func(w http.ResponseWriter, r *http.Request) {
-		logger := m.config.Logger
+		logger := httpLogger(m.config, r)
 
-		if m.config.HashKey != "" {
+		if key := m.config.Reloadable().HashKey; key != "" {
 			b, err := io.ReadAll(r.Body)
 			if err != nil {
-				logger.Sugar().Debug("failed to read request body", zap.Error(err))
-				http.Error(w, "", http.StatusInternalServerError)
+				logger.Sugar().Debug(readBodyErrorMsg, zap.Error(err))
+				problem.WriteInternal(w, r)
 				return
 			}
 			r.Body = io.NopCloser(bytes.NewBuffer(b))
-			mac := hmac.New(sha256.New, []byte(m.config.HashKey))
+			mac := hmac.New(sha256.New, []byte(key))
 			_, err = mac.Write(b)
 			if err != nil {
 				logger.Sugar().Debug("failed to write hash.", zap.Error(err))
-				http.Error(w, "", http.StatusInternalServerError)
+				problem.WriteInternal(w, r)
 				return
 			}
 			hdst := mac.Sum(nil)
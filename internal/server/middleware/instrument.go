@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/selfmetrics"
+)
+
+type MiddlewareInstrument struct {
+	config *models.Config
+}
+
+func NewMiddlewareInstrument(c *models.Config) *MiddlewareInstrument {
+	return &MiddlewareInstrument{
+		config: c,
+	}
+}
+
+// Instrument records requests in self-metrics per route and method: http.<route>.<method>.requests,
+// http.<route>.<method>.status_<class> and latency histogram http.<route>.<method>.duration.
+// Requests which match no route and requests of non-standard methods are recorded as http.unmatched
+// and method 'other', so clients can't create self-metrics with arbitrary names.
+func (m *MiddlewareInstrument) Instrument(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		lw := loggingResponseWriter{
+			ResponseWriter: w,
+			responseData:   &responseData{status: http.StatusOK},
+		}
+
+		h.ServeHTTP(&lw, r)
+
+		var pattern string
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			pattern = rctx.RoutePattern()
+		}
+		name := "http." + routeName(pattern) + "." + methodName(r.Method)
+		selfmetrics.Inc(name + ".requests")
+		selfmetrics.Inc(name + ".status_" + strconv.Itoa(lw.responseData.status)[:1] + "xx")
+		selfmetrics.ObserveDuration(name+".duration", time.Since(start))
+	})
+}
+
+// methodName returns lower case name of the standard HTTP method, 'other' for the rest.
+func methodName(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return strings.ToLower(method)
+	default:
+		return "other"
+	}
+}
+
+// routeName converts chi route pattern into a metric name part, e.g. /value/{metricType}/{metricName}
+// becomes value.metricType.metricName.
+func routeName(pattern string) string {
+	if pattern == "" {
+		return "unmatched"
+	}
+	parts := make([]string, 0, strings.Count(pattern, "/"))
+	for _, p := range strings.Split(pattern, "/") {
+		p = strings.Trim(p, "{}")
+		switch p {
+		case "":
+			continue
+		case "*":
+			p = "any"
+		}
+		parts = append(parts, p)
+	}
+	if len(parts) == 0 {
+		return "root"
+	}
+	return strings.Join(parts, ".")
+}
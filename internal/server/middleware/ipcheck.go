@@ -5,6 +5,8 @@ import (
 	"net/http"
 
 	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/problem"
+	"github.com/vkupriya/go-metrics/internal/server/selfmetrics"
 )
 
 type MiddlewareIPCheck struct {
@@ -19,8 +21,9 @@ func NewMiddlewareIPCheck(c *models.Config) *MiddlewareIPCheck {
 
 func (i *MiddlewareIPCheck) IPCheckHandle(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		logger := i.config.Logger
-		if i.config.TrustedSubnet == nil {
+		logger := httpLogger(i.config, r)
+		subnet := i.config.Reloadable().TrustedSubnet
+		if subnet == nil {
 			h.ServeHTTP(w, r)
 			return
 		}
@@ -28,9 +31,10 @@ func (i *MiddlewareIPCheck) IPCheckHandle(h http.Handler) http.Handler {
 		ipStr := r.Header.Get("X-Real-IP")
 		ip := net.ParseIP(ipStr)
 
-		if !i.config.TrustedSubnet.Contains(ip) {
+		if !subnet.Contains(ip) {
 			logger.Sugar().Error("agent source IP is not trusted")
-			http.Error(w, "agent source IP is not trusted", http.StatusBadRequest)
+			selfmetrics.Inc("rejected.ip")
+			problem.Write(w, r, http.StatusBadRequest, problem.UntrustedSource, "agent source IP is not trusted")
 			return
 		}
 
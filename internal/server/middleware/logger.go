@@ -1,11 +1,18 @@
 package middleware
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"time"
 
+	"go.uber.org/zap"
+
+	"github.com/vkupriya/go-metrics/internal/logging"
+	"github.com/vkupriya/go-metrics/internal/requestid"
+	"github.com/vkupriya/go-metrics/internal/server/auth"
 	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/ratelimit"
 )
 
 type (
@@ -24,6 +31,26 @@ type (
 	}
 )
 
+// httpLogger returns logger of HTTP middlewares, log lines carry ID of the request.
+func httpLogger(c *models.Config, r *http.Request) *zap.Logger {
+	logger := c.Logger.Named(logging.ComponentHTTP)
+	if id := requestid.FromContext(r.Context()); id != "" {
+		return logger.With(zap.String(requestid.LogKey, id))
+	}
+	return logger
+}
+
+// principalKey is the context key of the principal slot of a logged request.
+type principalKey struct{}
+
+// setLoggedPrincipal tells the logging middleware the principal of the request, it is authenticated
+// after the request is logged.
+func setLoggedPrincipal(r *http.Request, p *auth.Principal) {
+	if slot, ok := r.Context().Value(principalKey{}).(**auth.Principal); ok {
+		*slot = p
+	}
+}
+
 func NewMiddlewareLogger(c *models.Config) *MiddlewareLogger {
 	return &MiddlewareLogger{
 		config: c,
@@ -46,7 +73,7 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 
 func (m *MiddlewareLogger) Logging(h http.Handler) http.Handler {
 	logFn := func(w http.ResponseWriter, r *http.Request) {
-		logger := m.config.Logger
+		logger := httpLogger(m.config, r)
 
 		start := time.Now()
 
@@ -63,12 +90,14 @@ func (m *MiddlewareLogger) Logging(h http.Handler) http.Handler {
 		uri := r.RequestURI
 		method := r.Method
 
-		h.ServeHTTP(&lw, r)
+		var principal *auth.Principal
+		h.ServeHTTP(&lw, r.WithContext(context.WithValue(r.Context(), principalKey{}, &principal)))
 
 		duration := time.Since(start)
-		logger.Sugar().Infoln(
+		logger.Sugar().Infow("request",
 			"uri", uri,
 			"method", method,
+			"client", ratelimit.Identity(m.config, principal, r.TLS, r.Header.Get("X-Real-IP"), r.RemoteAddr),
 			"status", responseData.status,
 			"duration", duration,
 			"size", responseData.size,
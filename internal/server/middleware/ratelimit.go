@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/problem"
+	"github.com/vkupriya/go-metrics/internal/server/ratelimit"
+)
+
+type MiddlewareRateLimit struct {
+	config  *models.Config
+	limiter *ratelimit.Limiter
+}
+
+func NewMiddlewareRateLimit(c *models.Config, l *ratelimit.Limiter) *MiddlewareRateLimit {
+	return &MiddlewareRateLimit{
+		config:  c,
+		limiter: l,
+	}
+}
+
+// RateLimitHandle rejects requests of agents exceeding their request rate with status code 429,
+// Retry-After header tells when the agent may send again.
+func (m *MiddlewareRateLimit) RateLimitHandle(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delay, err := m.limiter.Allow(ratelimit.FromRequest(m.config, r))
+		if err != nil {
+			httpLogger(m.config, r).Sugar().Warn(err)
+			w.Header().Set("Retry-After", strconv.FormatInt(ratelimit.RetryAfter(delay), 10))
+			problem.Write(w, r, http.StatusTooManyRequests, problem.RateLimited, err.Error())
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/vkupriya/go-metrics/internal/requestid"
+	"github.com/vkupriya/go-metrics/internal/server/models"
+)
+
+type MiddlewareRequestID struct {
+	config *models.Config
+}
+
+func NewMiddlewareRequestID(c *models.Config) *MiddlewareRequestID {
+	return &MiddlewareRequestID{
+		config: c,
+	}
+}
+
+// RequestID identifies the request by a valid X-Request-ID header of the client or by a new ID, stores it
+// in request context and echoes it in X-Request-ID header of the response. The ID is recorded in the span
+// of the request, so logs and traces of a request can be matched.
+func (m *MiddlewareRequestID) RequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := requestid.Resolve(r.Header.Get(requestid.Header))
+		w.Header().Set(requestid.Header, id)
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("request.id", id))
+
+		h.ServeHTTP(w, r.WithContext(requestid.NewContext(r.Context(), id)))
+	})
+}
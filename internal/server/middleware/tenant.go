@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/problem"
+	"github.com/vkupriya/go-metrics/internal/server/tenant"
+)
+
+type MiddlewareTenant struct {
+	config *models.Config
+}
+
+func NewMiddlewareTenant(c *models.Config) *MiddlewareTenant {
+	return &MiddlewareTenant{
+		config: c,
+	}
+}
+
+// TenantHandle identifies request tenant by X-Tenant-Token or X-Tenant header and stores it in request context.
+// Requests without tenant headers belong to the default tenant, see tenant.Resolve.
+func (t *MiddlewareTenant) TenantHandle(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := httpLogger(t.config, r)
+
+		name, err := tenant.Resolve(t.config, r.Header.Get(tenant.TokenHeader), r.Header.Get(tenant.Header))
+		if err != nil {
+			logger.Sugar().Warnw("tenant request rejected", "uri", r.RequestURI, "method", r.Method, "error", err)
+			if errors.Is(err, tenant.ErrInvalidName) {
+				problem.Write(w, r, http.StatusBadRequest, problem.InvalidParameter, err.Error())
+				return
+			}
+			problem.Write(w, r, http.StatusUnauthorized, problem.Unauthorized, err.Error())
+			return
+		}
+
+		h.ServeHTTP(w, r.WithContext(tenant.NewContext(r.Context(), name)))
+	})
+}
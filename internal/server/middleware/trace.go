@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/tracing"
+)
+
+type MiddlewareTrace struct {
+	config *models.Config
+}
+
+func NewMiddlewareTrace(c *models.Config) *MiddlewareTrace {
+	return &MiddlewareTrace{
+		config: c,
+	}
+}
+
+// Trace traces requests continuing trace context of W3C traceparent headers, the span of a request covers
+// middleware following this one and the handler. Spans are named after the method and the route of requests.
+func (m *MiddlewareTrace) Trace(h http.Handler) http.Handler {
+	named := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r)
+
+		if pattern := routePattern(r); pattern != "" {
+			span := trace.SpanFromContext(r.Context())
+			span.SetName(r.Method + " " + pattern)
+			span.SetAttributes(semconv.HTTPRoute(pattern))
+		}
+	})
+	return otelhttp.NewHandler(named, "http", otelhttp.WithSpanNameFormatter(
+		func(_ string, r *http.Request) string {
+			return r.Method
+		}))
+}
+
+// Handler traces the handler of the route in a child span of the request.
+func (m *MiddlewareTrace) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.Tracer().Start(r.Context(), "handler "+routePattern(r))
+		defer span.End()
+
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// routePattern returns chi route pattern of the request, it is known after routing only.
+func routePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		return rctx.RoutePattern()
+	}
+	return ""
+}
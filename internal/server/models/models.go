@@ -3,31 +3,143 @@ package models
 
 import (
 	"net"
+	"regexp"
+	"sync"
+	"time"
 
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/vkupriya/go-metrics/internal/compression"
+	"github.com/vkupriya/go-metrics/internal/logging"
+	"github.com/vkupriya/go-metrics/internal/tracing"
 )
 
+// Config is the configuration of the metric server. Fields of Reloadable settings are changed
+// while the server runs, after start they must be read with Reloadable and changed with Reload.
 type Config struct {
-	Logger          *zap.Logger
-	TrustedSubnet   *net.IPNet
-	HashKey         string
-	Address         string
-	FileStoragePath string
-	PostgresDSN     string
-	CryptoKey       []byte
-	SecretKey       []byte
-	StoreInterval   int64
-	RestoreMetrics  bool
-	ContextTimeout  int64
+	Logger             *zap.Logger
+	TrustedSubnet      *net.IPNet
+	TrustedProxies     []*net.IPNet      // X-Real-IP of connections from these networks identifies agents
+	NamePattern        *regexp.Regexp    // metric names must match, default pattern is used if nil
+	TenantTokens       map[string]string // tenant API token to tenant name
+	TenantQuotas       map[string]int64  // series quota per tenant, overrides TenantMaxSeries
+	HashKey            string
+	AdminToken         string
+	Address            string // address of HTTP server: host:port or unix:path of Unix domain socket
+	GRPCAddress        string // address of gRPC server: host:port or unix:path of Unix domain socket
+	FileStoragePath    string
+	PostgresDSN        string
+	RulesFile          string
+	TokensFile         string
+	AuditLog           string // path of the audit log of write and admin operations, empty disables audit
+	SeriesOverflow     string // reject or drop writes of new series over MaxSeries
+	ConfigFile         string // path of the config file, configuration is reloaded on its changes if ConfigWatch is set
+	CryptoKey          []byte
+	SecretKey          []byte
+	Tracing            tracing.Config
+	Log                logging.Config // levels of logs are reloadable, other logging settings are not
+	StoreInterval      int64
+	SignMaxAge         int64   // allowed age of request signatures in seconds
+	RateLimit          float64 // write requests per second per agent, 0 disables the limit
+	RateBurst          int64   // write requests an agent may send at once
+	MaxBatchSize       int64   // metrics per batch, 0 disables the limit
+	AgentMaxSeries     int64   // distinct series per agent, 0 disables the limit
+	MaxNameLength      int64   // bytes of metric names
+	MaxSeries          int64   // distinct series of all tenants, 0 disables the limit
+	SelfInterval       int64   // interval of writing self-metrics in seconds, 0 disables self-metrics
+	ConfigWatch        int64   // interval of checking the config file for changes in seconds, 0 disables watching
+	CompressionMinSize int64   // bytes of responses below which they aren't compressed
+	CompressionLevel   compression.Level
+	RestoreMetrics     bool
+	SignStrict         bool // requires signatures with timestamp and nonce on signed routes
+	GRPCDisabled       bool
+	DefaultAccess      bool // requests without tenant token may use the default tenant if tenant tokens are set
+	ContextTimeout     int64
+	RollupInterval     int64
+	RulesInterval      int64
+	TenantMaxSeries    int64
+	MaxTenants         int64 // tenants other than the default one with open storages, 0 disables the limit
+	Retention          Retention
+	mu                 sync.RWMutex // guards reloadable settings
+}
+
+// Reloadable are settings which can be changed without restart of the server.
+type Reloadable struct {
+	TrustedSubnet *net.IPNet
+	LogLevels     map[string]zapcore.Level // levels of components
+	HashKey       string
+	CryptoKey     []byte
+	SecretKey     []byte // AES key exchanged with agents, it is changed by key exchange only
+	StoreInterval int64
+	LogLevel      zapcore.Level
+}
+
+// Reloadable returns current reloadable settings, they are consistent with each other.
+func (c *Config) Reloadable() Reloadable {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return Reloadable{
+		TrustedSubnet: c.TrustedSubnet,
+		HashKey:       c.HashKey,
+		CryptoKey:     c.CryptoKey,
+		SecretKey:     c.SecretKey,
+		StoreInterval: c.StoreInterval,
+		LogLevel:      c.Log.Level,
+		LogLevels:     c.Log.ComponentLevels,
+	}
+}
+
+// Reload replaces reloadable settings at once, SecretKey of r is ignored.
+func (c *Config) Reload(r *Reloadable) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.TrustedSubnet = r.TrustedSubnet
+	c.HashKey = r.HashKey
+	c.CryptoKey = r.CryptoKey
+	c.StoreInterval = r.StoreInterval
+	c.Log.Level = r.LogLevel
+	c.Log.ComponentLevels = r.LogLevels
+}
+
+// SetSecretKey replaces the AES key exchanged with agents.
+func (c *Config) SetSecretKey(key []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.SecretKey = key
+}
+
+// Retention defines how long history is kept for each resolution, in seconds.
+// Zero keeps history of the resolution forever.
+type Retention struct {
+	Raw    int64
+	Minute int64
+	Hour   int64
+	Day    int64
 }
 
 type Metrics []Metric
 
 type Metric struct {
-	Delta *int64   `json:"delta,omitempty"` // value of counter metric
-	Value *float64 `json:"value,omitempty"` // value of gauge metric
-	ID    string   `json:"id"`              // metric name
-	MType string   `json:"type"`            // metric type: counter or gauge
+	Delta  *int64            `json:"delta,omitempty"`  // value of counter metric
+	Value  *float64          `json:"value,omitempty"`  // value of gauge metric
+	Labels map[string]string `json:"labels,omitempty"` // optional labels recorded on the first write
+	ID     string            `json:"id"`               // metric name
+	MType  string            `json:"type"`             // metric type: counter or gauge
+
+	Unit        string `json:"unit,omitempty"`        // optional unit recorded on the first write
+	Description string `json:"description,omitempty"` // optional help text recorded on the first write
+}
+
+// MetricMetadata describes a metric, it is recorded on the first write of the metric.
+// Metric type can't be changed until the metric is deleted.
+type MetricMetadata struct {
+	CreatedAt   time.Time         `json:"created_at"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Name        string            `json:"name"`
+	MType       string            `json:"type"`
+	Unit        string            `json:"unit,omitempty"`
+	Description string            `json:"description,omitempty"`
 }
 
 type CounterModel struct {
@@ -39,3 +151,15 @@ type GaugeModel struct {
 	Name  string
 	Value float64
 }
+
+// HistoryPoint is a single aggregated point of metric history.
+// Raw samples are points with Count equal to 1.
+type HistoryPoint struct {
+	Timestamp time.Time `json:"timestamp"`          // bucket start or sample time
+	Increase  *int64    `json:"increase,omitempty"` // counter increase over the bucket
+	Min       float64   `json:"min"`
+	Max       float64   `json:"max"`
+	Avg       float64   `json:"avg"`
+	Last      float64   `json:"last"`
+	Count     int64     `json:"count"` // number of raw samples in the bucket
+}
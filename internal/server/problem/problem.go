@@ -0,0 +1,106 @@
+// Package problem writes error responses of the REST API as RFC 7807 problem details.
+//
+// Every error response has 'application/problem+json' content type and a body like:
+//
+//	{
+//	  "type": "about:blank",
+//	  "title": "Conflict",
+//	  "status": 409,
+//	  "detail": "metric 'Alloc' is registered as gauge, got counter",
+//	  "instance": "/update/counter/Alloc/1",
+//	  "code": "type_conflict",
+//	  "metric_id": "Alloc"
+//	}
+//
+// Members 'code', 'metric_id' and 'errors' extend RFC 7807. Clients should rely on 'code'
+// rather than on 'detail' text, 'errors' lists rejected items of batch requests.
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ContentType is the media type of problem details.
+const ContentType = "application/problem+json"
+
+// Error codes.
+const (
+	InvalidJSON         = "invalid_json"
+	InvalidMetricType   = "invalid_metric_type"
+	InvalidMetricID     = "invalid_metric_id"
+	InvalidValue        = "invalid_value"
+	InvalidMetadata     = "invalid_metadata"
+	InvalidParameter    = "invalid_parameter"
+	InvalidQuery        = "invalid_query"
+	InvalidSignature    = "invalid_signature"
+	SignatureRequired   = "signature_required"
+	StaleSignature      = "stale_signature"
+	ReplayedRequest     = "replayed_request"
+	InvalidBody         = "invalid_body"
+	UnsupportedEncoding = "unsupported_encoding"
+	NotFound            = "not_found"
+	TypeConflict        = "type_conflict"
+	QuotaExceeded       = "quota_exceeded"
+	RateLimited         = "rate_limited"
+	BatchTooLarge       = "batch_too_large"
+	BatchRejected       = "batch_rejected"
+	UnknownTenant       = "unknown_tenant"
+	Unauthorized        = "unauthorized"
+	Forbidden           = "forbidden"
+	UntrustedSource     = "untrusted_source"
+	Unavailable         = "unavailable"
+	Internal            = "internal_error"
+)
+
+// Details is a problem details object.
+type Details struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code"`
+	MetricID string `json:"metric_id,omitempty"`
+	Errors   []Item `json:"errors,omitempty"`
+	Status   int    `json:"status"`
+}
+
+// Item is a rejected item of a batch request.
+type Item struct {
+	ID     string `json:"id"`
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+	Index  int    `json:"index"` // position of the item in the batch
+}
+
+// New returns problem details of the status, title is the status text.
+func New(status int, code, detail string) *Details {
+	return &Details{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Code:   code,
+		Detail: detail,
+	}
+}
+
+// Write writes the problem into the response, instance is set to the request path.
+func (d *Details) Write(rw http.ResponseWriter, r *http.Request) {
+	if d.Instance == "" && r != nil {
+		d.Instance = r.URL.Path
+	}
+	rw.Header().Set("Content-Type", ContentType)
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	rw.WriteHeader(d.Status)
+	_ = json.NewEncoder(rw).Encode(d)
+}
+
+// Write writes problem details of the status into the response.
+func Write(rw http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	New(status, code, detail).Write(rw, r)
+}
+
+// WriteInternal writes internal server error, details of the error are not disclosed to clients.
+func WriteInternal(rw http.ResponseWriter, r *http.Request) {
+	Write(rw, r, http.StatusInternalServerError, Internal, "")
+}
@@ -0,0 +1,374 @@
+package query
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vkupriya/go-metrics/internal/server/models"
+)
+
+const (
+	gauge   string = "gauge"
+	counter string = "counter"
+)
+
+// Resolutions of rolled up metric history, from the finest to the coarsest.
+var resolutions = []time.Duration{time.Minute, time.Hour, 24 * time.Hour}
+
+// value is an intermediate result of evaluation, either scalar or vector is set.
+type value struct {
+	scalar *float64
+	vector []Sample
+}
+
+// series is a stored metric matched by a selector.
+type series struct {
+	labels map[string]string
+	name   string
+	mtype  string
+	value  float64
+}
+
+// evaluator evaluates a single query, stored metrics are read once and shared by all selectors.
+type evaluator struct {
+	ts     time.Time
+	engine *Engine
+	stored []series
+	loaded bool
+}
+
+func scalar(v float64) value {
+	return value{scalar: &v}
+}
+
+func (ev *evaluator) eval(n node) (value, error) {
+	switch n := n.(type) {
+	case *numberNode:
+		return scalar(n.value), nil
+	case *negNode:
+		v, err := ev.eval(n.expr)
+		if err != nil {
+			return value{}, err
+		}
+		return binary("*", scalar(-1), v)
+	case *selectorNode:
+		matched, err := ev.selectSeries(n)
+		if err != nil {
+			return value{}, err
+		}
+		vector := make([]Sample, 0, len(matched))
+		for _, s := range matched {
+			vector = append(vector, Sample{Name: s.name, Labels: s.labels, Value: s.value})
+		}
+		return value{vector: vector}, nil
+	case *rangeNode:
+		return ev.evalRange(n)
+	case *aggregateNode:
+		return ev.evalAggregate(n)
+	case *binaryNode:
+		lhs, err := ev.eval(n.lhs)
+		if err != nil {
+			return value{}, err
+		}
+		rhs, err := ev.eval(n.rhs)
+		if err != nil {
+			return value{}, err
+		}
+		return binary(n.op, lhs, rhs)
+	default:
+		return value{}, fmt.Errorf("unknown expression node %T", n)
+	}
+}
+
+// load reads current values and labels of all stored metrics.
+func (ev *evaluator) load() error {
+	if ev.loaded {
+		return nil
+	}
+	c := ev.engine.config
+	gauges, counters, err := ev.engine.store.GetAllMetrics(c)
+	if err != nil {
+		return fmt.Errorf("failed to read metrics: %w", err)
+	}
+	mds, err := ev.engine.store.GetMetadata(c)
+	if err != nil {
+		return fmt.Errorf("failed to read metric metadata: %w", err)
+	}
+	labels := make(map[string]map[string]string, len(mds))
+	for _, md := range mds {
+		labels[md.Name] = md.Labels
+	}
+
+	for name, v := range gauges {
+		ev.stored = append(ev.stored, series{name: name, mtype: gauge, value: v, labels: labels[name]})
+	}
+	for name, v := range counters {
+		ev.stored = append(ev.stored, series{name: name, mtype: counter, value: float64(v), labels: labels[name]})
+	}
+	sort.Slice(ev.stored, func(i, j int) bool { return ev.stored[i].name < ev.stored[j].name })
+	ev.loaded = true
+	return nil
+}
+
+func (ev *evaluator) selectSeries(s *selectorNode) ([]series, error) {
+	if err := ev.load(); err != nil {
+		return nil, err
+	}
+	matched := make([]series, 0)
+	for _, st := range ev.stored {
+		if s.matches(&st) {
+			matched = append(matched, st)
+		}
+	}
+	return matched, nil
+}
+
+func (s *selectorNode) matches(st *series) bool {
+	if s.name != "" {
+		if ok, _ := path.Match(s.name, st.name); !ok {
+			return false
+		}
+	}
+	for _, m := range s.matchers {
+		v := st.labels[m.label]
+		var ok bool
+		switch m.op {
+		case "=":
+			ok = v == m.value
+		case "!=":
+			ok = v != m.value
+		case "=~":
+			ok = m.re.MatchString(v)
+		case "!~":
+			ok = !m.re.MatchString(v)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// evalRange computes increase or rate of counters over the window from metric history. Rollups are read
+// for windows longer than retention of raw samples, the window is extended to whole buckets then.
+// Gauges matched by the selector are left out.
+func (ev *evaluator) evalRange(n *rangeNode) (value, error) {
+	hs, ok := ev.engine.store.(HistoryStorage)
+	if !ok {
+		return value{}, invalid("%s() requires metric history which is not supported by the storage", n.fn)
+	}
+	step, err := historyStep(ev.engine.config, n.window)
+	if err != nil {
+		return value{}, err
+	}
+	matched, err := ev.selectSeries(n.selector)
+	if err != nil {
+		return value{}, err
+	}
+
+	vector := make([]Sample, 0, len(matched))
+	for _, s := range matched {
+		if s.mtype != counter {
+			continue
+		}
+		points, err := hs.GetMetricHistory(ev.engine.config, counter, s.name, ev.ts.Add(-n.window), ev.ts, step)
+		if err != nil {
+			return value{}, fmt.Errorf("failed to read history of metric '%s': %w", s.name, err)
+		}
+		var increase float64
+		for _, p := range points {
+			if p.Increase != nil {
+				increase += float64(*p.Increase)
+			}
+		}
+		if n.fn == "rate" {
+			increase /= n.window.Seconds()
+		}
+		vector = append(vector, Sample{Name: s.name, Labels: s.labels, Value: increase})
+	}
+	return value{vector: vector}, nil
+}
+
+// historyStep returns step of history read over the window: zero for raw samples if they are kept for
+// the whole window, otherwise the finest rollup resolution kept for the window. History is trimmed by
+// rollups only, raw samples are read if rollups are disabled.
+func historyStep(c *models.Config, window time.Duration) (time.Duration, error) {
+	keep := []int64{c.Retention.Raw, c.Retention.Minute, c.Retention.Hour, c.Retention.Day}
+	if c.RollupInterval <= 0 || covers(keep[0], window) {
+		return 0, nil
+	}
+	for i, res := range resolutions {
+		if covers(keep[i+1], window) {
+			return res, nil
+		}
+	}
+	return 0, invalid("window %s is longer than retention of metric history", window)
+}
+
+// covers reports whether history kept for keep seconds covers the window, zero keeps history forever.
+func covers(keep int64, window time.Duration) bool {
+	return keep <= 0 || window <= time.Duration(keep)*time.Second
+}
+
+func (ev *evaluator) evalAggregate(n *aggregateNode) (value, error) {
+	v, err := ev.eval(n.expr)
+	if err != nil {
+		return value{}, err
+	}
+	if v.scalar != nil {
+		return value{}, invalid("%s() expects series, got a number", n.op)
+	}
+
+	if n.op == opTopk || n.op == opBottomk {
+		k, err := ev.eval(n.param)
+		if err != nil {
+			return value{}, err
+		}
+		if k.scalar == nil || math.IsNaN(*k.scalar) || math.IsInf(*k.scalar, 0) || *k.scalar < 0 {
+			return value{}, invalid("%s() expects a finite non-negative number of series", n.op)
+		}
+		// k is clamped while it is a float, huge numbers overflow int.
+		count := int(math.Min(*k.scalar, float64(len(v.vector))))
+		return value{vector: topk(v.vector, count, n.op == opBottomk)}, nil
+	}
+
+	groups := make(map[string][]Sample)
+	keys := make([]string, 0)
+	for _, s := range v.vector {
+		key := signature(s.Labels, n.by)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], s)
+	}
+	sort.Strings(keys)
+
+	vector := make([]Sample, 0, len(keys))
+	for _, key := range keys {
+		g := groups[key]
+		var labels map[string]string
+		for _, l := range n.by {
+			if lv, ok := g[0].Labels[l]; ok {
+				if labels == nil {
+					labels = make(map[string]string)
+				}
+				labels[l] = lv
+			}
+		}
+		vector = append(vector, Sample{Labels: labels, Value: aggregate(n.op, g)})
+	}
+	return value{vector: vector}, nil
+}
+
+func aggregate(op string, samples []Sample) float64 {
+	res := samples[0].Value
+	for _, s := range samples[1:] {
+		switch op {
+		case "sum", "avg":
+			res += s.Value
+		case "min":
+			res = math.Min(res, s.Value)
+		case "max":
+			res = math.Max(res, s.Value)
+		}
+	}
+	switch op {
+	case "avg":
+		res /= float64(len(samples))
+	case "count":
+		res = float64(len(samples))
+	}
+	return res
+}
+
+// topk returns k samples with the highest values, or the lowest ones if bottom is true.
+func topk(samples []Sample, k int, bottom bool) []Sample {
+	sorted := append([]Sample(nil), samples...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if bottom {
+			return sorted[i].Value < sorted[j].Value
+		}
+		return sorted[i].Value > sorted[j].Value
+	})
+	return sorted[:min(k, len(sorted))]
+}
+
+// labelsSignature identifies a label set.
+func labelsSignature(ls map[string]string) string {
+	keys := make([]string, 0, len(ls))
+	for k := range ls {
+		keys = append(keys, k)
+	}
+	return signature(ls, keys)
+}
+
+// signature identifies values of the given labels of a label set.
+func signature(ls map[string]string, labels []string) string {
+	keys := append([]string(nil), labels...)
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		if v, ok := ls[k]; ok {
+			b.WriteString(k + "\xff" + v + "\xff")
+		}
+	}
+	return b.String()
+}
+
+// binary applies the arithmetic operator, vectors are matched by equal label sets.
+func binary(op string, lhs, rhs value) (value, error) {
+	if lhs.scalar != nil && rhs.scalar != nil {
+		return scalar(arith(op, *lhs.scalar, *rhs.scalar)), nil
+	}
+	if lhs.scalar != nil || rhs.scalar != nil {
+		vector := make([]Sample, 0, max(len(lhs.vector), len(rhs.vector)))
+		for _, s := range lhs.vector {
+			vector = append(vector, Sample{Labels: s.Labels, Value: arith(op, s.Value, *rhs.scalar)})
+		}
+		for _, s := range rhs.vector {
+			vector = append(vector, Sample{Labels: s.Labels, Value: arith(op, *lhs.scalar, s.Value)})
+		}
+		return value{vector: vector}, nil
+	}
+
+	rhsBySig := make(map[string]Sample, len(rhs.vector))
+	for _, s := range rhs.vector {
+		sig := labelsSignature(s.Labels)
+		if _, ok := rhsBySig[sig]; ok {
+			return value{}, invalid("many series with equal labels on the right side of '%s'", op)
+		}
+		rhsBySig[sig] = s
+	}
+	seen := make(map[string]bool, len(lhs.vector))
+	vector := make([]Sample, 0, len(lhs.vector))
+	for _, s := range lhs.vector {
+		sig := labelsSignature(s.Labels)
+		if seen[sig] {
+			return value{}, invalid("many series with equal labels on the left side of '%s'", op)
+		}
+		seen[sig] = true
+		if r, ok := rhsBySig[sig]; ok {
+			vector = append(vector, Sample{Labels: s.Labels, Value: arith(op, s.Value, r.Value)})
+		}
+	}
+	return value{vector: vector}, nil
+}
+
+func arith(op string, a, b float64) float64 {
+	switch op {
+	case "+":
+		return a + b
+	case "-":
+		return a - b
+	case "*":
+		return a * b
+	case "/":
+		return a / b
+	}
+	return math.NaN()
+}
@@ -0,0 +1,99 @@
+package query
+
+import (
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokDuration
+	tokOp // arithmetic and matching operators
+	tokLParen
+	tokRParen
+	tokLBrace
+	tokRBrace
+	tokComma
+)
+
+var punctuation = map[rune]tokenKind{'(': tokLParen, ')': tokRParen, '{': tokLBrace, '}': tokRBrace, ',': tokComma}
+
+type token struct {
+	text string
+	kind tokenKind
+	pos  int
+}
+
+// lex splits the query into tokens. Durations are lexed inside square brackets only.
+func lex(q string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(q); {
+		c := rune(q[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case punctuation[c] != tokEOF:
+			tokens = append(tokens, token{kind: punctuation[c], text: string(c), pos: i})
+			i++
+		case c == '[':
+			end := strings.IndexByte(q[i:], ']')
+			if end < 0 {
+				return nil, invalid("unclosed '[' at position %d", i)
+			}
+			tokens = append(tokens, token{kind: tokDuration, text: strings.TrimSpace(q[i+1 : i+end]), pos: i})
+			i += end + 1
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(q[i+1:], q[i])
+			if end < 0 {
+				return nil, invalid("unclosed string at position %d", i)
+			}
+			end += i + 1 // position of the closing quote
+			tokens = append(tokens, token{kind: tokString, text: q[i+1 : end], pos: i})
+			i = end + 1
+		case matchOp(q[i:]) != "":
+			op := matchOp(q[i:])
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		case strings.ContainsRune("+-*/=", c) && (c != '*' || i+1 == len(q) || !isIdentRune(rune(q[i+1]))):
+			tokens = append(tokens, token{kind: tokOp, text: string(c), pos: i})
+			i++
+		case unicode.IsDigit(c) || c == '.' && i+1 < len(q) && unicode.IsDigit(rune(q[i+1])):
+			j := i
+			for j < len(q) && (unicode.IsDigit(rune(q[j])) || q[j] == '.' || q[j] == 'e' || q[j] == 'E') {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: q[i:j], pos: i})
+			i = j
+		case isIdentRune(c) || c == '*':
+			j := i
+			for j < len(q) && (isIdentRune(rune(q[j])) || unicode.IsDigit(rune(q[j])) || q[j] == '*') {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: q[i:j], pos: i})
+			i = j
+		default:
+			return nil, invalid("unexpected character '%c' at position %d", c, i)
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(q)}), nil
+}
+
+// matchOp returns two character label matching operator the string starts with.
+func matchOp(s string) string {
+	for _, op := range []string{"!=", "=~", "!~"} {
+		if strings.HasPrefix(s, op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// isIdentRune reports whether the rune may start a metric or label name.
+func isIdentRune(c rune) bool {
+	return unicode.IsLetter(c) || c == '_' || c == '.' || c == ':'
+}
@@ -0,0 +1,309 @@
+package query
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Expression tree nodes.
+type (
+	node interface{}
+
+	numberNode struct {
+		value float64
+	}
+
+	matcher struct {
+		re    *regexp.Regexp // compiled value of =~ and !~ matchers
+		label string
+		op    string
+		value string
+	}
+
+	selectorNode struct {
+		name     string // glob of metric names, empty matches any name
+		matchers []matcher
+	}
+
+	rangeNode struct {
+		selector *selectorNode
+		fn       string // rate or increase
+		window   time.Duration
+	}
+
+	aggregateNode struct {
+		expr  node
+		param node // k of topk and bottomk
+		op    string
+		by    []string
+	}
+
+	binaryNode struct {
+		lhs node
+		rhs node
+		op  string
+	}
+
+	negNode struct {
+		expr node
+	}
+)
+
+const (
+	opTopk    string = "topk"
+	opBottomk string = "bottomk"
+)
+
+var (
+	aggregations   = []string{"sum", "avg", "min", "max", "count", opTopk, opBottomk}
+	rangeFunctions = []string{"rate", "increase"}
+)
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func parse(q string) (node, error) {
+	tokens, err := lex(q)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	n, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, invalid("unexpected '%s' at position %d", t.text, t.pos)
+	}
+	return n, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, invalid("expected %s at position %d", what, t.pos)
+	}
+	return t, nil
+}
+
+// parseExpr parses additive expressions.
+func (p *parser) parseExpr() (node, error) {
+	return p.parseBinary(p.parseTerm, "+", "-")
+}
+
+// parseTerm parses multiplicative expressions.
+func (p *parser) parseTerm() (node, error) {
+	return p.parseBinary(p.parseUnary, "*", "/")
+}
+
+// parseBinary parses left associative chain of operands joined by the operators.
+func (p *parser) parseBinary(operand func() (node, error), ops ...string) (node, error) {
+	lhs, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t.kind == tokOp && slices.Contains(ops, t.text); t = p.peek() {
+		p.next()
+		rhs, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &binaryNode{op: t.text, lhs: lhs, rhs: rhs}
+	}
+	return lhs, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if t := p.peek(); t.kind == tokOp && t.text == "-" {
+		p.next()
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &negNode{expr: n}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokNumber:
+		p.next()
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, invalid("malformed number '%s' at position %d", t.text, t.pos)
+		}
+		return &numberNode{value: v}, nil
+	case tokLParen:
+		p.next()
+		n, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen, "')'"); err != nil {
+			return nil, err
+		}
+		return n, nil
+	case tokIdent:
+		following := p.tokens[p.pos+1]
+		if slices.Contains(aggregations, t.text) &&
+			(following.kind == tokLParen || following.kind == tokIdent && following.text == "by") {
+			return p.parseAggregation()
+		}
+		if slices.Contains(rangeFunctions, t.text) && following.kind == tokLParen {
+			return p.parseRange()
+		}
+		return p.parseSelector()
+	case tokLBrace:
+		return p.parseSelector()
+	default:
+		if t.kind == tokEOF {
+			return nil, invalid("unexpected end of query")
+		}
+		return nil, invalid("unexpected '%s' at position %d", t.text, t.pos)
+	}
+}
+
+// parseAggregation parses 'op [by (labels)] ([k,] expr) [by (labels)]'.
+func (p *parser) parseAggregation() (node, error) {
+	n := &aggregateNode{op: p.next().text}
+
+	by, err := p.parseBy()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokLParen, "'('"); err != nil {
+		return nil, err
+	}
+	if n.op == opTopk || n.op == opBottomk {
+		if n.param, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokComma, "','"); err != nil {
+			return nil, err
+		}
+	}
+	if n.expr, err = p.parseExpr(); err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokRParen, "')'"); err != nil {
+		return nil, err
+	}
+	if by == nil {
+		if by, err = p.parseBy(); err != nil {
+			return nil, err
+		}
+	}
+	n.by = by
+	return n, nil
+}
+
+// parseBy parses optional 'by (label, ...)' clause.
+func (p *parser) parseBy() ([]string, error) {
+	if t := p.peek(); t.kind != tokIdent || t.text != "by" {
+		return nil, nil
+	}
+	p.next()
+	if _, err := p.expect(tokLParen, "'(' after 'by'"); err != nil {
+		return nil, err
+	}
+	by := make([]string, 0)
+	for p.peek().kind != tokRParen {
+		t, err := p.expect(tokIdent, "label name")
+		if err != nil {
+			return nil, err
+		}
+		by = append(by, t.text)
+		if p.peek().kind == tokComma {
+			p.next()
+		}
+	}
+	p.next()
+	return by, nil
+}
+
+// parseRange parses 'fn(selector[window])'.
+func (p *parser) parseRange() (node, error) {
+	n := &rangeNode{fn: p.next().text}
+	p.next()
+
+	s, err := p.parseSelector()
+	if err != nil {
+		return nil, err
+	}
+	n.selector = s
+
+	t, err := p.expect(tokDuration, "range window like '[5m]'")
+	if err != nil {
+		return nil, err
+	}
+	if n.window, err = time.ParseDuration(t.text); err != nil || n.window <= 0 {
+		return nil, invalid("malformed range window '%s' at position %d", t.text, t.pos)
+	}
+	if _, err := p.expect(tokRParen, "')'"); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// parseSelector parses 'name{label="value", ...}', either name or labels may be omitted.
+func (p *parser) parseSelector() (*selectorNode, error) {
+	s := &selectorNode{}
+	if t := p.peek(); t.kind == tokIdent {
+		s.name = p.next().text
+	}
+	if p.peek().kind != tokLBrace {
+		if s.name == "" {
+			t := p.peek()
+			return nil, invalid("expected metric selector at position %d", t.pos)
+		}
+		return s, nil
+	}
+	p.next()
+
+	for p.peek().kind != tokRBrace {
+		label, err := p.expect(tokIdent, "label name")
+		if err != nil {
+			return nil, err
+		}
+		op := p.next()
+		if op.kind != tokOp || !slices.Contains([]string{"=", "!=", "=~", "!~"}, op.text) {
+			return nil, invalid("expected label matching operator at position %d", op.pos)
+		}
+		value, err := p.expect(tokString, "quoted label value")
+		if err != nil {
+			return nil, err
+		}
+
+		m := matcher{label: label.text, op: op.text, value: value.text}
+		if op.text == "=~" || op.text == "!~" {
+			if m.re, err = regexp.Compile("^(?:" + value.text + ")$"); err != nil {
+				return nil, invalid("malformed regular expression '%s' at position %d", value.text, value.pos)
+			}
+		}
+		s.matchers = append(s.matchers, m)
+
+		if p.peek().kind == tokComma {
+			p.next()
+		}
+	}
+	p.next()
+	return s, nil
+}
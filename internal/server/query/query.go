@@ -0,0 +1,114 @@
+// Package query implements a small query language evaluated over stored metrics.
+//
+// An expression is one of:
+//
+//	Alloc                        selector of a metric by name, '*' matches any characters: Heap*
+//	{env="prod", host!~"db.*"}   selector of metrics by labels, operators are =, !=, =~ and !~
+//	Alloc{env="prod"}            selector by name and labels
+//	rate(PollCount[5m])          per second increase of counters over the window, from metric history
+//	increase(PollCount[1h])      increase of counters over the window, from metric history
+//	sum(Heap*)                   sum, avg, min, max and count aggregate series into one
+//	sum by (env) (Heap*)         aggregation keeping series of distinct 'env' label values
+//	topk(3, Heap*)               top 3 series by value, bottomk returns the bottom ones
+//	HeapAlloc / HeapSys * 100    arithmetic between series and numbers
+//
+// Arithmetic between two vectors matches series with equal labels. Aggregations and arithmetic
+// drop metric names from the result. Series evaluated to infinity or NaN, e.g. on division by zero,
+// are left out of the result.
+package query
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/vkupriya/go-metrics/internal/server/models"
+)
+
+// ErrInvalidQuery is returned for queries which can't be parsed or evaluated.
+var ErrInvalidQuery = errors.New("invalid query")
+
+// Storage provides current values of metrics evaluated by queries.
+type Storage interface {
+	GetAllMetrics(c *models.Config) (map[string]float64, map[string]int64, error)
+	GetMetadata(c *models.Config) ([]models.MetricMetadata, error)
+}
+
+// HistoryStorage provides metric history to range functions, rate and increase.
+// They fail on storages without history.
+type HistoryStorage interface {
+	GetMetricHistory(c *models.Config, mtype, name string, from, to time.Time, step time.Duration) (
+		[]models.HistoryPoint, error)
+}
+
+// Sample is a value of a single series.
+type Sample struct {
+	Labels map[string]string `json:"labels,omitempty"`
+	Name   string            `json:"name,omitempty"`
+	Value  float64           `json:"value"`
+}
+
+// Result is a value of evaluated expression, either a vector of samples or a scalar.
+type Result struct {
+	Scalar *float64 `json:"scalar,omitempty"`
+	Type   string   `json:"type"` // "vector" or "scalar"
+	Vector []Sample `json:"vector,omitempty"`
+}
+
+// Engine evaluates queries over metrics of a single storage.
+type Engine struct {
+	store  Storage
+	config *models.Config
+}
+
+// NewEngine instantiates query engine over the storage.
+func NewEngine(c *models.Config, s Storage) *Engine {
+	return &Engine{
+		store:  s,
+		config: c,
+	}
+}
+
+// Query parses the expression and evaluates it at time ts.
+// Selectors return current values of metrics, ts limits windows of range functions only.
+func (e *Engine) Query(q string, ts time.Time) (*Result, error) {
+	n, err := parse(q)
+	if err != nil {
+		return nil, err
+	}
+
+	ev := &evaluator{engine: e, ts: ts}
+	v, err := ev.eval(n)
+	if err != nil {
+		return nil, err
+	}
+	if v.scalar != nil {
+		if !finite(*v.scalar) {
+			return nil, invalid("result is not a finite number")
+		}
+		return &Result{Type: "scalar", Scalar: v.scalar}, nil
+	}
+
+	vector := make([]Sample, 0, len(v.vector))
+	for _, s := range v.vector {
+		if finite(s.Value) {
+			vector = append(vector, s)
+		}
+	}
+	return &Result{Type: "vector", Vector: vector}, nil
+}
+
+// Validate checks that the expression can be parsed, it isn't evaluated.
+func Validate(q string) error {
+	_, err := parse(q)
+	return err
+}
+
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+func invalid(format string, a ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidQuery, fmt.Sprintf(format, a...))
+}
@@ -0,0 +1,221 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/storage"
+)
+
+// currentOnly hides metric history of the storage.
+type currentOnly struct {
+	Storage
+}
+
+// stepRecorder records step of the last history read.
+type stepRecorder struct {
+	Storage
+	step time.Duration
+}
+
+func (s *stepRecorder) GetMetricHistory(_ *models.Config, _, _ string, _, _ time.Time, step time.Duration) (
+	[]models.HistoryPoint, error) {
+	s.step = step
+	return []models.HistoryPoint{}, nil
+}
+
+func TestQuery(t *testing.T) {
+	cfg := &models.Config{
+		Logger:         zap.NewNop(),
+		ContextTimeout: 3,
+	}
+
+	s, err := storage.NewMemStorage(cfg)
+	require.NoError(t, err)
+	values := []float64{100, 300, 50, 1000}
+	require.NoError(t, s.UpdateBatch(cfg, models.Metrics{
+		{ID: "HeapAlloc.a", MType: "gauge", Value: &values[0], Labels: map[string]string{"env": "prod", "host": "a"}},
+		{ID: "HeapAlloc.b", MType: "gauge", Value: &values[1], Labels: map[string]string{"env": "prod", "host": "b"}},
+		{ID: "HeapAlloc.c", MType: "gauge", Value: &values[2], Labels: map[string]string{"env": "dev", "host": "c"}},
+		{ID: "HeapSys.a", MType: "gauge", Value: &values[3], Labels: map[string]string{"env": "prod", "host": "a"}},
+	}, nil))
+	_, err = s.UpdateCounterMetric(cfg, "PollCount", 5)
+	require.NoError(t, err)
+	_, err = s.UpdateCounterMetric(cfg, "PollCount", 1)
+	require.NoError(t, err)
+
+	engine := NewEngine(cfg, s)
+	now := time.Now().Add(time.Second)
+
+	tests := []struct {
+		scalar   *float64
+		name     string
+		query    string
+		expected []Sample
+		wantErr  bool
+	}{
+		{
+			name:     "selector: OK",
+			query:    "HeapAlloc.a",
+			expected: []Sample{{Name: "HeapAlloc.a", Labels: map[string]string{"env": "prod", "host": "a"}, Value: 100}},
+		},
+		{
+			name:  "glob_selector: OK",
+			query: `HeapAlloc*{env="prod"}`,
+			expected: []Sample{
+				{Name: "HeapAlloc.a", Labels: map[string]string{"env": "prod", "host": "a"}, Value: 100},
+				{Name: "HeapAlloc.b", Labels: map[string]string{"env": "prod", "host": "b"}, Value: 300},
+			},
+		},
+		{
+			name:  "label_regex: OK",
+			query: `{host=~"a|c", env!="dev"}`,
+			expected: []Sample{
+				{Name: "HeapAlloc.a", Labels: map[string]string{"env": "prod", "host": "a"}, Value: 100},
+				{Name: "HeapSys.a", Labels: map[string]string{"env": "prod", "host": "a"}, Value: 1000},
+			},
+		},
+		{name: "sum: OK", query: "sum(HeapAlloc*)", expected: []Sample{{Value: 450}}},
+		{name: "avg: OK", query: "avg(HeapAlloc*)", expected: []Sample{{Value: 150}}},
+		{name: "min: OK", query: "min(HeapAlloc*)", expected: []Sample{{Value: 50}}},
+		{name: "max: OK", query: "max(HeapAlloc*)", expected: []Sample{{Value: 300}}},
+		{name: "count: OK", query: "count(HeapAlloc*)", expected: []Sample{{Value: 3}}},
+		{name: "sum_empty: OK", query: "sum(Unknown)", expected: []Sample{}},
+		{
+			name:  "sum_by: OK",
+			query: "sum by (env) (HeapAlloc*)",
+			expected: []Sample{
+				{Labels: map[string]string{"env": "dev"}, Value: 50},
+				{Labels: map[string]string{"env": "prod"}, Value: 400},
+			},
+		},
+		{
+			name:     "sum_by_suffix: OK",
+			query:    `sum(HeapAlloc*{env="dev"}) by (env)`,
+			expected: []Sample{{Labels: map[string]string{"env": "dev"}, Value: 50}},
+		},
+		{
+			name:  "topk: OK",
+			query: "topk(2, HeapAlloc*)",
+			expected: []Sample{
+				{Name: "HeapAlloc.b", Labels: map[string]string{"env": "prod", "host": "b"}, Value: 300},
+				{Name: "HeapAlloc.a", Labels: map[string]string{"env": "prod", "host": "a"}, Value: 100},
+			},
+		},
+		{
+			name:  "topk_huge_k: OK",
+			query: "topk(1e300, HeapAlloc*)",
+			expected: []Sample{
+				{Name: "HeapAlloc.b", Labels: map[string]string{"env": "prod", "host": "b"}, Value: 300},
+				{Name: "HeapAlloc.a", Labels: map[string]string{"env": "prod", "host": "a"}, Value: 100},
+				{Name: "HeapAlloc.c", Labels: map[string]string{"env": "dev", "host": "c"}, Value: 50},
+			},
+		},
+		{
+			name:  "bottomk_overflowing_k: OK",
+			query: "bottomk(9e18*10, HeapAlloc*)",
+			expected: []Sample{
+				{Name: "HeapAlloc.c", Labels: map[string]string{"env": "dev", "host": "c"}, Value: 50},
+				{Name: "HeapAlloc.a", Labels: map[string]string{"env": "prod", "host": "a"}, Value: 100},
+				{Name: "HeapAlloc.b", Labels: map[string]string{"env": "prod", "host": "b"}, Value: 300},
+			},
+		},
+		{
+			name:     "bottomk: OK",
+			query:    "bottomk(1, HeapAlloc*)",
+			expected: []Sample{{Name: "HeapAlloc.c", Labels: map[string]string{"env": "dev", "host": "c"}, Value: 50}},
+		},
+		{
+			name:     "vector_arithmetic: OK",
+			query:    "HeapAlloc.a / HeapSys.a * 100",
+			expected: []Sample{{Labels: map[string]string{"env": "prod", "host": "a"}, Value: 10}},
+		},
+		{name: "scalar_arithmetic: OK", query: "-(1 + 2) * 3", scalar: func() *float64 { v := -9.0; return &v }()},
+		{name: "increase: OK", query: "increase(PollCount[1m])", expected: []Sample{{Name: "PollCount", Value: 6}}},
+		{name: "rate: OK", query: "rate(PollCount[1m])", expected: []Sample{{Name: "PollCount", Value: 0.1}}},
+		{name: "rate_of_gauge: OK", query: "rate(HeapAlloc.a[1m])", expected: []Sample{}},
+		{name: "division_by_zero: OK", query: "HeapAlloc.a / 0", expected: []Sample{}},
+		{name: "many_to_many: FAIL", query: `{env="prod"} + HeapAlloc*`, wantErr: true},
+		{name: "unclosed_paren: FAIL", query: "sum(HeapAlloc*", wantErr: true},
+		{name: "missing_window: FAIL", query: "rate(PollCount)", wantErr: true},
+		{name: "malformed_window: FAIL", query: "rate(PollCount[5 minutes])", wantErr: true},
+		{name: "malformed_regex: FAIL", query: `{host=~"("}`, wantErr: true},
+		{name: "aggregate_number: FAIL", query: "sum(1)", wantErr: true},
+		{name: "trailing_tokens: FAIL", query: "HeapAlloc.a HeapAlloc.b", wantErr: true},
+		{name: "unexpected_character: FAIL", query: "HeapAlloc.a % 2", wantErr: true},
+		{name: "scalar_division_by_zero: FAIL", query: "1 / 0", wantErr: true},
+		{name: "topk_infinite_k: FAIL", query: "topk(1/0, HeapAlloc*)", wantErr: true},
+		{name: "topk_nan_k: FAIL", query: "topk(0/0, HeapAlloc*)", wantErr: true},
+		{name: "topk_negative_k: FAIL", query: "topk(-1, HeapAlloc*)", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := engine.Query(tt.query, now)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidQuery)
+				return
+			}
+			require.NoError(t, err)
+			if tt.scalar != nil {
+				assert.Equal(t, "scalar", res.Type)
+				assert.Equal(t, *tt.scalar, *res.Scalar)
+				return
+			}
+			assert.Equal(t, "vector", res.Type)
+			assert.Equal(t, tt.expected, res.Vector)
+		})
+	}
+
+	t.Run("range_without_history: FAIL", func(t *testing.T) {
+		_, err := NewEngine(cfg, currentOnly{s}).Query("rate(PollCount[1m])", now)
+		assert.ErrorIs(t, err, ErrInvalidQuery)
+	})
+}
+
+func TestRangeResolution(t *testing.T) {
+	config := func(rollups int64) *models.Config {
+		return &models.Config{
+			Logger:         zap.NewNop(),
+			ContextTimeout: 3,
+			RollupInterval: rollups,
+			Retention:      models.Retention{Raw: 3600, Minute: 86400, Hour: 30 * 86400, Day: 365 * 86400},
+		}
+	}
+	cfg := config(60)
+	s, err := storage.NewMemStorage(cfg)
+	require.NoError(t, err)
+	_, err = s.UpdateCounterMetric(cfg, "PollCount", 1)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		query    string
+		rollups  int64
+		expected time.Duration
+		wantErr  bool
+	}{
+		{name: "raw: OK", query: "increase(PollCount[1h])", rollups: 60, expected: 0},
+		{name: "minute: OK", query: "increase(PollCount[2h])", rollups: 60, expected: time.Minute},
+		{name: "hour: OK", query: "rate(PollCount[168h])", rollups: 60, expected: time.Hour},
+		{name: "day: OK", query: "increase(PollCount[2160h])", rollups: 60, expected: 24 * time.Hour},
+		{name: "no_rollups: OK", query: "increase(PollCount[2160h])", rollups: 0, expected: 0},
+		{name: "beyond_retention: FAIL", query: "increase(PollCount[9600h])", rollups: 60, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hs := &stepRecorder{Storage: s, step: -1}
+			_, err := NewEngine(config(tt.rollups), hs).Query(tt.query, time.Now())
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidQuery)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, hs.step)
+		})
+	}
+}
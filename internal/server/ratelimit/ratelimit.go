@@ -0,0 +1,194 @@
+// Package ratelimit limits ingestion of metric agents: rate of write requests, size of batches
+// and number of distinct series written by each agent.
+//
+// Agents are identified by API token, TLS client certificate, X-Real-IP header or remote address,
+// whichever is available first. X-Real-IP is used only on connections from the trusted subnet or
+// a trusted proxy, other clients could evade their limits by changing the header. Request rate is
+// limited by a token bucket per agent. Series written by an agent are remembered in memory until
+// the agent stays idle for idleTimeout, so the series limit of an agent is reset by server restarts.
+package ratelimit
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/vkupriya/go-metrics/internal/server/auth"
+	"github.com/vkupriya/go-metrics/internal/server/models"
+)
+
+// idleTimeout is the time after which state of an inactive agent is forgotten.
+const idleTimeout = time.Hour
+
+var (
+	ErrRateLimited   = errors.New("request rate limit of the agent is exceeded")
+	ErrBatchTooLarge = errors.New("batch size limit is exceeded")
+	ErrSeriesLimit   = errors.New("series limit of the agent is exceeded")
+)
+
+type agent struct {
+	limiter  *rate.Limiter
+	series   map[string]struct{}
+	lastSeen time.Time
+}
+
+// Limiter keeps ingestion state of agents, it is safe for concurrent use.
+type Limiter struct {
+	now       func() time.Time
+	config    *models.Config
+	agents    map[string]*agent
+	lastPrune time.Time
+	mu        sync.Mutex
+}
+
+// New returns limiter using limits of the configuration, zero limits are disabled.
+func New(c *models.Config) *Limiter {
+	return &Limiter{
+		now:    time.Now,
+		config: c,
+		agents: make(map[string]*agent),
+	}
+}
+
+// agent returns state of the agent, l.mu must be held.
+func (l *Limiter) agent(id string, now time.Time) *agent {
+	if now.Sub(l.lastPrune) > idleTimeout {
+		for k, a := range l.agents {
+			if now.Sub(a.lastSeen) > idleTimeout {
+				delete(l.agents, k)
+			}
+		}
+		l.lastPrune = now
+	}
+
+	a, ok := l.agents[id]
+	if !ok {
+		a = &agent{
+			limiter: rate.NewLimiter(rate.Limit(l.config.RateLimit), int(l.config.RateBurst)),
+			series:  make(map[string]struct{}),
+		}
+		l.agents[id] = a
+	}
+	a.lastSeen = now
+	return a
+}
+
+// Allow takes a token from the bucket of the agent. If the bucket is empty ErrRateLimited is returned
+// along with the time after which the request would be allowed.
+func (l *Limiter) Allow(id string) (time.Duration, error) {
+	if l.config.RateLimit <= 0 {
+		return 0, nil
+	}
+
+	l.mu.Lock()
+	now := l.now()
+	a := l.agent(id, now)
+	l.mu.Unlock()
+
+	r := a.limiter.ReserveN(now, 1)
+	if !r.OK() {
+		return time.Second, fmt.Errorf("%w: agent '%s' has zero burst", ErrRateLimited, id)
+	}
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return delay, fmt.Errorf("%w: agent '%s' is limited to %g requests per second",
+			ErrRateLimited, id, l.config.RateLimit)
+	}
+	return 0, nil
+}
+
+// CheckBatch returns ErrBatchTooLarge if the batch has more metrics than allowed.
+func (l *Limiter) CheckBatch(size int) error {
+	if l.config.MaxBatchSize > 0 && int64(size) > l.config.MaxBatchSize {
+		return fmt.Errorf("%w: batch of %d metrics, limit is %d", ErrBatchTooLarge, size, l.config.MaxBatchSize)
+	}
+	return nil
+}
+
+// AdmitSeries records the series written by the agent and reports whether it is recorded by the call,
+// such series are forgotten by ForgetSeries if the write fails. ErrSeriesLimit is returned for a new
+// series if the agent has already written as many series as allowed.
+func (l *Limiter) AdmitSeries(id, series string) (bool, error) {
+	if l.config.AgentMaxSeries <= 0 {
+		return false, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	a := l.agent(id, l.now())
+	if _, ok := a.series[series]; ok {
+		return false, nil
+	}
+	if int64(len(a.series)) >= l.config.AgentMaxSeries {
+		return false, fmt.Errorf("%w: agent '%s' is limited to %d series", ErrSeriesLimit, id, l.config.AgentMaxSeries)
+	}
+	a.series[series] = struct{}{}
+	return true, nil
+}
+
+// ForgetSeries stops counting the series of the agent.
+func (l *Limiter) ForgetSeries(id, series string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if a, ok := l.agents[id]; ok {
+		delete(a.series, series)
+	}
+}
+
+// SeriesKey returns key of the series of the tenant.
+func SeriesKey(tenant, mtype, name string) string {
+	return tenant + "/" + mtype + "/" + name
+}
+
+// Identity returns identity of an agent, p is nil for anonymous clients and state is nil for
+// connections without TLS. X-Real-IP value realIP is ignored unless remoteAddr is in the trusted
+// subnet or trusted proxies of c.
+func Identity(c *models.Config, p *auth.Principal, state *tls.ConnectionState, realIP, remoteAddr string) string {
+	if p != nil {
+		return "token:" + p.Name
+	}
+	if state != nil && len(state.PeerCertificates) > 0 {
+		return "cert:" + state.PeerCertificates[0].Subject.CommonName
+	}
+	host := remoteAddr
+	if h, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		host = h
+	}
+	if ip := net.ParseIP(realIP); ip != nil && trusted(c, net.ParseIP(host)) {
+		return "ip:" + ip.String()
+	}
+	return "ip:" + host
+}
+
+// trusted reports whether X-Real-IP of connections from ip is trusted.
+func trusted(c *models.Config, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if subnet := c.Reloadable().TrustedSubnet; subnet != nil && subnet.Contains(ip) {
+		return true
+	}
+	for _, proxy := range c.TrustedProxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// FromRequest returns identity of the agent sending the request.
+func FromRequest(c *models.Config, r *http.Request) string {
+	return Identity(c, auth.FromContext(r.Context()), r.TLS, r.Header.Get("X-Real-IP"), r.RemoteAddr)
+}
+
+// RetryAfter returns value of Retry-After header for the delay, it is rounded up to whole seconds.
+func RetryAfter(delay time.Duration) int64 {
+	return int64(math.Ceil(delay.Seconds()))
+}
@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vkupriya/go-metrics/internal/server/auth"
+	"github.com/vkupriya/go-metrics/internal/server/models"
+)
+
+func TestAllow(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	l := New(&models.Config{RateLimit: 2, RateBurst: 2})
+	l.now = func() time.Time { return now }
+
+	for range 2 {
+		_, err := l.Allow("ip:10.0.0.1")
+		require.NoError(t, err)
+	}
+	delay, err := l.Allow("ip:10.0.0.1")
+	assert.ErrorIs(t, err, ErrRateLimited)
+	assert.Equal(t, 500*time.Millisecond, delay)
+	assert.Equal(t, int64(1), RetryAfter(delay))
+
+	_, err = l.Allow("ip:10.0.0.2")
+	assert.NoError(t, err, "agents have own buckets")
+
+	now = now.Add(delay)
+	_, err = l.Allow("ip:10.0.0.1")
+	assert.NoError(t, err, "rejected requests don't take tokens")
+
+	t.Run("disabled: OK", func(t *testing.T) {
+		l := New(&models.Config{})
+		for range 100 {
+			_, err := l.Allow("ip:10.0.0.1")
+			require.NoError(t, err)
+		}
+	})
+}
+
+func TestCheckBatch(t *testing.T) {
+	l := New(&models.Config{MaxBatchSize: 2})
+	assert.NoError(t, l.CheckBatch(2))
+	assert.ErrorIs(t, l.CheckBatch(3), ErrBatchTooLarge)
+	assert.NoError(t, New(&models.Config{}).CheckBatch(1000))
+}
+
+func TestAdmitSeries(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	l := New(&models.Config{AgentMaxSeries: 2})
+	l.now = func() time.Time { return now }
+
+	admit := func(id, mtype, name string) error {
+		_, err := l.AdmitSeries(id, SeriesKey("", mtype, name))
+		return err
+	}
+	added, err := l.AdmitSeries("a", SeriesKey("", "gauge", "Alloc"))
+	require.NoError(t, err)
+	assert.True(t, added)
+	require.NoError(t, admit("a", "counter", "PollCount"))
+	added, err = l.AdmitSeries("a", SeriesKey("", "gauge", "Alloc"))
+	assert.NoError(t, err, "known series are admitted")
+	assert.False(t, added)
+	assert.ErrorIs(t, admit("a", "gauge", "Frees"), ErrSeriesLimit)
+	assert.NoError(t, admit("b", "gauge", "Frees"))
+
+	l.ForgetSeries("a", SeriesKey("", "counter", "PollCount"))
+	assert.NoError(t, admit("a", "gauge", "Frees"), "forgotten series aren't counted")
+
+	now = now.Add(2 * idleTimeout)
+	assert.NoError(t, admit("b", "gauge", "Mallocs"))
+	assert.NoError(t, admit("a", "gauge", "Sys"), "idle agents are forgotten")
+}
+
+func TestIdentity(t *testing.T) {
+	cert := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "agent-1"}}}}
+	_, proxy, err := net.ParseCIDR("127.0.0.0/8")
+	require.NoError(t, err)
+	_, subnet, err := net.ParseCIDR("10.1.0.0/16")
+	require.NoError(t, err)
+	cfg := &models.Config{TrustedSubnet: subnet, TrustedProxies: []*net.IPNet{proxy}}
+	tests := []struct {
+		p          *auth.Principal
+		state      *tls.ConnectionState
+		name       string
+		realIP     string
+		remoteAddr string
+		want       string
+	}{
+		{name: "token", p: &auth.Principal{Name: "agents"}, state: cert, realIP: "10.0.0.1", want: "token:agents"},
+		{name: "certificate", state: cert, realIP: "10.0.0.1", want: "cert:agent-1"},
+		{name: "real_ip", realIP: "10.0.0.1", remoteAddr: "127.0.0.1:5000", want: "ip:10.0.0.1"},
+		{name: "remote_addr", realIP: "bogus", remoteAddr: "127.0.0.1:5000", want: "ip:127.0.0.1"},
+		{name: "trusted_subnet", realIP: "10.0.0.1", remoteAddr: "10.1.0.1:5000", want: "ip:10.0.0.1"},
+		{name: "untrusted_real_ip", realIP: "10.0.0.1", remoteAddr: "192.168.0.1:5000", want: "ip:192.168.0.1"},
+		{name: "unix_socket", realIP: "10.0.0.1", remoteAddr: "@", want: "ip:@"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Identity(cfg, tt.p, tt.state, tt.realIP, tt.remoteAddr))
+		})
+	}
+}
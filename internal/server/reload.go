@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/vkupriya/go-metrics/internal/logging"
+	"github.com/vkupriya/go-metrics/internal/server/config"
+	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/selfmetrics"
+)
+
+// watchReloads reloads configuration on SIGHUP and on changes of the config file if it is watched,
+// until the context is cancelled. Log levels of the server are switched to the reloaded ones.
+func watchReloads(ctx context.Context, c *models.Config, levels *logging.Levels) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var fileChecks <-chan time.Time
+	var modTime time.Time
+	if c.ConfigFile != "" && c.ConfigWatch > 0 {
+		ticker := time.NewTicker(time.Duration(c.ConfigWatch) * time.Second)
+		defer ticker.Stop()
+		fileChecks = ticker.C
+		modTime = fileModTime(c.ConfigFile)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			reload(c, levels, "SIGHUP")
+		case <-fileChecks:
+			if t := fileModTime(c.ConfigFile); !t.Equal(modTime) {
+				modTime = t
+				reload(c, levels, "config file change")
+			}
+		}
+	}
+}
+
+// fileModTime returns modification time of the file, zero time is returned if the file can't be read.
+func fileModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// reload applies reloadable settings of the new configuration and reports changed settings which
+// need restart. Running configuration is kept if the new one is invalid.
+func reload(c *models.Config, levels *logging.Levels, reason string) {
+	logger := c.Logger
+
+	changed, err := config.Reload(c)
+	if err != nil {
+		selfmetrics.Inc("config.reload_failures")
+		logger.Sugar().Errorw("failed to reload configuration, running configuration is kept",
+			"reason", reason, zap.Error(err))
+		return
+	}
+	r := c.Reloadable()
+	levels.Set(r.LogLevel, r.LogLevels)
+	selfmetrics.Inc("config.reloads")
+
+	if len(changed) > 0 {
+		logger.Sugar().Warnw("configuration reloaded, changed settings can't be reloaded and need restart",
+			"reason", reason, "settings", changed)
+		return
+	}
+	logger.Sugar().Infow("configuration reloaded", "reason", reason)
+}
@@ -0,0 +1,231 @@
+package rules
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/vkupriya/go-metrics/internal/server/query"
+)
+
+// Alert states.
+const (
+	StatePending  string = "pending"
+	StateFiring   string = "firing"
+	StateResolved string = "resolved"
+)
+
+const (
+	alertNameLabel string = "alertname"
+	metricLabel    string = "metric"
+
+	resolvedRetention = 15 * time.Minute // how long resolved alerts are kept for the API
+)
+
+// Alert is an alert raised by an alerting rule for a single series.
+type Alert struct {
+	ActiveAt    time.Time         `json:"active_at"`
+	FiredAt     *time.Time        `json:"fired_at,omitempty"`
+	ResolvedAt  *time.Time        `json:"resolved_at,omitempty"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Rule        string            `json:"rule"`
+	State       string            `json:"state"`
+	Value       float64           `json:"value"`
+}
+
+// alert is an alert tracked by the manager.
+type alert struct {
+	sentAt time.Time // last notification about the current state, zero if it wasn't sent yet
+	Alert
+}
+
+type alertingRule struct {
+	annotations map[string]*template.Template
+	alerts      map[string]*alert // by signature of alert labels
+	health
+	AlertingRule
+}
+
+func newAlertingRule(r *AlertingRule) (*alertingRule, error) {
+	ts, err := r.templates()
+	if err != nil {
+		return nil, err
+	}
+	return &alertingRule{
+		AlertingRule: *r,
+		annotations:  ts,
+		alerts:       make(map[string]*alert),
+	}, nil
+}
+
+// labels returns labels of the alert raised for the sample.
+func (r *alertingRule) labels(s *query.Sample) map[string]string {
+	labels := make(map[string]string, len(s.Labels)+len(r.Labels)+2) //nolint:mnd // metric and alertname
+	for k, v := range s.Labels {
+		labels[k] = v
+	}
+	if s.Name != "" {
+		labels[metricLabel] = s.Name
+	}
+	for k, v := range r.Labels {
+		labels[k] = v
+	}
+	labels[alertNameLabel] = r.Name
+	return labels
+}
+
+// evalAlerting updates alerts of the rule with the current query result.
+func (m *Manager) evalAlerting(r *alertingRule, now time.Time) error {
+	samples, err := m.query(r.Tenant, r.Expr, now)
+	if err != nil {
+		return err
+	}
+
+	compare := comparisons[r.Op]
+	active := make(map[string]bool, len(samples))
+	for i := range samples {
+		s := &samples[i]
+		if !compare(s.Value, r.Threshold) {
+			continue
+		}
+		labels := r.labels(s)
+		key := signature(labels, nil)
+		active[key] = true
+
+		a, ok := r.alerts[key]
+		if !ok || a.State == StateResolved {
+			a = &alert{Alert: Alert{Rule: r.Name, Labels: labels, State: StatePending, ActiveAt: now}}
+			r.alerts[key] = a
+		}
+		a.Value = s.Value
+		a.Annotations = expand(r.annotations, labels, s.Value)
+		if a.State == StatePending && now.Sub(a.ActiveAt) >= r.For.Duration {
+			firedAt := now
+			a.State = StateFiring
+			a.FiredAt = &firedAt
+		}
+	}
+
+	for key, a := range r.alerts {
+		if active[key] {
+			continue
+		}
+		switch a.State {
+		case StatePending:
+			delete(r.alerts, key)
+		case StateFiring:
+			resolvedAt := now
+			a.State = StateResolved
+			a.ResolvedAt = &resolvedAt
+			a.sentAt = time.Time{}
+		case StateResolved:
+			if now.Sub(*a.ResolvedAt) >= resolvedRetention {
+				delete(r.alerts, key)
+			}
+		}
+	}
+	return nil
+}
+
+// Notification is posted to webhooks, it groups alerts with equal values of group_by labels.
+type Notification struct {
+	GroupLabels map[string]string `json:"group_labels"`
+	Status      string            `json:"status"` // firing if any of the alerts is firing, resolved otherwise
+	Tenant      string            `json:"tenant"`
+	Alerts      []Alert           `json:"alerts"`
+}
+
+// notifications groups alerts which became firing or resolved since the last notification,
+// and firing alerts due to be repeated. Alerts are marked as sent.
+func (m *Manager) notifications(now time.Time) []*Notification {
+	groups := make(map[string]*Notification)
+	for _, r := range m.alerting {
+		for _, a := range r.alerts {
+			switch {
+			case a.State == StateFiring && (a.sentAt.IsZero() || now.Sub(a.sentAt) >= m.repeat):
+			case a.State == StateResolved && a.sentAt.IsZero():
+			default:
+				continue
+			}
+			a.sentAt = now
+
+			key := r.Tenant + "\xff" + signature(a.Labels, m.groupBy)
+			n, ok := groups[key]
+			if !ok {
+				n = &Notification{Tenant: r.Tenant, Status: StateResolved, GroupLabels: make(map[string]string)}
+				for _, l := range m.groupBy {
+					if v, ok := a.Labels[l]; ok {
+						n.GroupLabels[l] = v
+					}
+				}
+				groups[key] = n
+			}
+			if a.State == StateFiring {
+				n.Status = StateFiring
+			}
+			n.Alerts = append(n.Alerts, a.Alert)
+		}
+	}
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	ns := make([]*Notification, 0, len(keys))
+	for _, key := range keys {
+		sortAlerts(groups[key].Alerts)
+		ns = append(ns, groups[key])
+	}
+	return ns
+}
+
+// Alerts returns alerts of rules evaluated over metrics of the tenant, sorted by rule and labels.
+func (m *Manager) Alerts(tenantName string) []Alert {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	alerts := make([]Alert, 0)
+	for _, r := range m.alerting {
+		if r.Tenant != tenantName {
+			continue
+		}
+		for _, a := range r.alerts {
+			alerts = append(alerts, a.Alert)
+		}
+	}
+	sortAlerts(alerts)
+	return alerts
+}
+
+func sortAlerts(alerts []Alert) {
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Rule != alerts[j].Rule {
+			return alerts[i].Rule < alerts[j].Rule
+		}
+		return signature(alerts[i].Labels, nil) < signature(alerts[j].Labels, nil)
+	})
+}
+
+// signature identifies values of the given labels, all labels if names are nil.
+func signature(labels map[string]string, names []string) string {
+	if names == nil {
+		names = make([]string, 0, len(labels))
+		for k := range labels {
+			names = append(names, k)
+		}
+	}
+	names = append([]string(nil), names...)
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, k := range names {
+		if v, ok := labels[k]; ok {
+			fmt.Fprintf(&b, "%s\xff%s\xff", k, v)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,199 @@
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/vkupriya/go-metrics/internal/server/query"
+	"github.com/vkupriya/go-metrics/internal/server/tenant"
+)
+
+const defaultRepeatInterval = 4 * time.Hour
+
+// File is the rules file, see the package documentation for an example.
+type File struct {
+	Webhooks       []Webhook       `json:"webhooks,omitempty"`
+	GroupBy        []string        `json:"group_by,omitempty"`
+	AlertingRules  []AlertingRule  `json:"alerting_rules,omitempty"`
+	RecordingRules []RecordingRule `json:"recording_rules,omitempty"`
+	RepeatInterval Duration        `json:"repeat_interval,omitempty"`
+}
+
+// Webhook is an HTTP endpoint receiving alert notifications as JSON POST requests.
+type Webhook struct {
+	Headers map[string]string `json:"headers,omitempty"`
+	URL     string            `json:"url"`
+}
+
+// AlertingRule raises an alert for every series of the expression result
+// whose value compares to the threshold with the operator.
+type AlertingRule struct {
+	Labels      map[string]string `json:"labels,omitempty"`      // added to labels of the alerts
+	Annotations map[string]string `json:"annotations,omitempty"` // text/template with .Labels and .Value
+	Name        string            `json:"name"`
+	Expr        string            `json:"expr"`
+	Op          string            `json:"op"`               // >, >=, <, <=, == or !=
+	Tenant      string            `json:"tenant,omitempty"` // tenant of metrics, the default tenant if empty
+	Threshold   float64           `json:"threshold"`
+	For         Duration          `json:"for,omitempty"` // alert is pending until the condition holds that long
+}
+
+// RecordingRule stores every series of the expression result as a gauge.
+type RecordingRule struct {
+	Labels map[string]string `json:"labels,omitempty"` // added to labels of the gauges
+	Record string            `json:"record"`           // name of the gauges
+	Expr   string            `json:"expr"`
+	Tenant string            `json:"tenant,omitempty"` // tenant of metrics, the default tenant if empty
+}
+
+// Duration is time.Duration decoded from JSON strings like "5m".
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("expected duration string like \"5m\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("failed to parse duration: %w", err)
+	}
+	if v < 0 {
+		return fmt.Errorf("negative duration %s", s)
+	}
+	d.Duration = v
+	return nil
+}
+
+var comparisons = map[string]func(v, threshold float64) bool{
+	">":  func(v, t float64) bool { return v > t },
+	">=": func(v, t float64) bool { return v >= t },
+	"<":  func(v, t float64) bool { return v < t },
+	"<=": func(v, t float64) bool { return v <= t },
+	"==": func(v, t float64) bool { return v == t },
+	"!=": func(v, t float64) bool { return v != t },
+}
+
+// Load reads and validates the rules file.
+func Load(path string) (*File, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules file %s: %w", path, err)
+	}
+	f := &File{}
+	if err := json.Unmarshal(content, f); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal rules file %s: %w", path, err)
+	}
+	if err := f.validate(); err != nil {
+		return nil, fmt.Errorf("invalid rules file %s: %w", path, err)
+	}
+	return f, nil
+}
+
+func (f *File) validate() error {
+	for _, w := range f.Webhooks {
+		if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("webhook URL '%s' must be http or https URL", w.URL)
+		}
+	}
+
+	names := make(map[string]bool, len(f.AlertingRules))
+	for i := range f.AlertingRules {
+		r := &f.AlertingRules[i]
+		if r.Name == "" {
+			return fmt.Errorf("alerting rule #%d has no name", i+1)
+		}
+		if names[r.Name] {
+			return fmt.Errorf("duplicate alerting rule '%s'", r.Name)
+		}
+		names[r.Name] = true
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("alerting rule '%s': %w", r.Name, err)
+		}
+	}
+
+	records := make(map[string]bool, len(f.RecordingRules))
+	for i := range f.RecordingRules {
+		r := &f.RecordingRules[i]
+		if r.Record == "" {
+			return fmt.Errorf("recording rule #%d has no record name", i+1)
+		}
+		key := r.Tenant + "/" + r.Record
+		if records[key] {
+			return fmt.Errorf("duplicate recording rule '%s'", r.Record)
+		}
+		records[key] = true
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("recording rule '%s': %w", r.Record, err)
+		}
+	}
+	return nil
+}
+
+func (r *AlertingRule) validate() error {
+	if err := query.Validate(r.Expr); err != nil {
+		return fmt.Errorf("invalid expression: %w", err)
+	}
+	if _, ok := comparisons[r.Op]; !ok {
+		return fmt.Errorf("unknown operator '%s', expected one of >, >=, <, <=, == or !=", r.Op)
+	}
+	if r.Tenant != "" && !tenant.Valid(r.Tenant) {
+		return fmt.Errorf("invalid tenant name '%s'", r.Tenant)
+	}
+	if _, err := r.templates(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *RecordingRule) validate() error {
+	if err := query.Validate(r.Expr); err != nil {
+		return fmt.Errorf("invalid expression: %w", err)
+	}
+	if r.Tenant != "" && !tenant.Valid(r.Tenant) {
+		return fmt.Errorf("invalid tenant name '%s'", r.Tenant)
+	}
+	return nil
+}
+
+// templates parses annotations of the rule.
+func (r *AlertingRule) templates() (map[string]*template.Template, error) {
+	ts := make(map[string]*template.Template, len(r.Annotations))
+	for name, text := range r.Annotations {
+		t, err := template.New(name).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid annotation '%s': %w", name, err)
+		}
+		ts[name] = t
+	}
+	return ts, nil
+}
+
+// expand executes annotation templates, annotations which fail to execute keep the error text.
+func expand(ts map[string]*template.Template, labels map[string]string, value float64) map[string]string {
+	if len(ts) == 0 {
+		return nil
+	}
+	data := struct {
+		Labels map[string]string
+		Value  float64
+	}{labels, value}
+
+	annotations := make(map[string]string, len(ts))
+	for name, t := range ts {
+		var b strings.Builder
+		if err := t.Execute(&b, data); err != nil {
+			annotations[name] = err.Error()
+			continue
+		}
+		annotations[name] = b.String()
+	}
+	return annotations
+}
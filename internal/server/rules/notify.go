@@ -0,0 +1,117 @@
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"go.uber.org/zap"
+
+	"github.com/vkupriya/go-metrics/internal/server/models"
+)
+
+const (
+	webhookTimeout = 10 * time.Second
+	webhookRetries = 3
+	queueSize      = 100
+)
+
+// notifier posts notifications to webhooks, one notification at a time.
+type notifier struct {
+	client     *resty.Client
+	config     *models.Config
+	queue      chan *Notification
+	webhooks   []Webhook
+	retryDelay time.Duration
+}
+
+func newNotifier(c *models.Config, webhooks []Webhook) *notifier {
+	return &notifier{
+		client:     resty.New().SetTimeout(webhookTimeout),
+		config:     c,
+		queue:      make(chan *Notification, queueSize),
+		webhooks:   webhooks,
+		retryDelay: time.Second,
+	}
+}
+
+// enqueue queues the notification, it is dropped if the queue is full.
+func (n *notifier) enqueue(nt *Notification) {
+	if len(n.webhooks) == 0 {
+		return
+	}
+	select {
+	case n.queue <- nt:
+	default:
+		n.config.Logger.Sugar().Errorw("notification queue is full, dropping notification",
+			"group", nt.GroupLabels, "alerts", len(nt.Alerts))
+	}
+}
+
+// run sends queued notifications until the context is cancelled.
+func (n *notifier) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case nt := <-n.queue:
+			n.notify(ctx, nt)
+		}
+	}
+}
+
+// notify posts the notification to every webhook, failures are logged.
+func (n *notifier) notify(ctx context.Context, nt *Notification) {
+	body, err := json.Marshal(nt)
+	if err != nil {
+		n.config.Logger.Sugar().Error("failed to encode notification", zap.Error(err))
+		return
+	}
+	for _, w := range n.webhooks {
+		if err := n.send(ctx, &w, body); err != nil {
+			n.config.Logger.Sugar().Errorw("failed to notify webhook", "url", w.URL, zap.Error(err))
+		}
+	}
+}
+
+// send posts the body to the webhook, retrying with growing delay on connection errors,
+// 429 and 5xx responses.
+func (n *notifier) send(ctx context.Context, w *Webhook, body []byte) error {
+	for retry := 0; ; retry++ {
+		retriable, err := n.post(ctx, w, body)
+		if err == nil {
+			return nil
+		}
+		if !retriable || retry == webhookRetries {
+			return err
+		}
+		n.config.Logger.Sugar().Warnw("failed to notify webhook, retrying", "url", w.URL, zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("notification cancelled: %w", err)
+		case <-time.After(time.Duration(1+2*retry) * n.retryDelay):
+		}
+	}
+}
+
+// post posts the body once, retriable is true for errors which may succeed on retry.
+func (n *notifier) post(ctx context.Context, w *Webhook, body []byte) (retriable bool, err error) {
+	resp, err := n.client.R().
+		SetContext(ctx).
+		SetHeaders(w.Headers).
+		SetHeader("Content-Type", "application/json").
+		SetBody(body).
+		Post(w.URL)
+	if err != nil {
+		return true, fmt.Errorf("failed to post notification: %w", err)
+	}
+	if code := resp.StatusCode(); code >= http.StatusBadRequest {
+		retriable := code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+		return retriable, fmt.Errorf("webhook responded with status %d", code)
+	}
+	return false, nil
+}
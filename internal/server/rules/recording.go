@@ -0,0 +1,116 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/query"
+	"github.com/vkupriya/go-metrics/internal/server/validation"
+)
+
+const gauge string = "gauge"
+
+type recordingRule struct {
+	health
+	RecordingRule
+}
+
+func newRecordingRule(r *RecordingRule) *recordingRule {
+	return &recordingRule{RecordingRule: *r}
+}
+
+// evalRecording stores the current query result as gauges.
+func (m *Manager) evalRecording(r *recordingRule, now time.Time) error {
+	samples, err := m.query(r.Tenant, r.Expr, now)
+	if err != nil {
+		return err
+	}
+
+	gauges := make(models.Metrics, 0, len(samples))
+	seen := make(map[string]bool, len(samples))
+	for i := range samples {
+		name, labels := r.series(&samples[i])
+		if seen[name] {
+			return fmt.Errorf("result has many series recorded as '%s', aggregate them by distinct labels", name)
+		}
+		seen[name] = true
+		gauges = append(gauges, models.Metric{ID: name, MType: gauge, Value: &samples[i].Value, Labels: labels})
+	}
+
+	store, err := m.stores(r.Tenant)
+	if err != nil {
+		return fmt.Errorf("failed to get storage of tenant '%s': %w", r.Tenant, err)
+	}
+
+	// Series are validated like metrics written by agents, rejected ones fail the rule while the rest
+	// of the result is recorded.
+	var rejected []error
+	var added []string
+	admitted := gauges[:0]
+	for _, g := range gauges {
+		isNew, err := m.admit(r.Tenant, g.ID)
+		if err != nil {
+			if !errors.Is(err, validation.ErrDropped) {
+				rejected = append(rejected, err)
+			}
+			continue
+		}
+		if isNew {
+			added = append(added, g.ID)
+		}
+		admitted = append(admitted, g)
+	}
+	if len(admitted) == 0 {
+		return errors.Join(rejected...)
+	}
+
+	if err := store.UpdateBatch(m.config, admitted, nil); err != nil {
+		for _, name := range added {
+			m.validator.Forget(r.Tenant, gauge, name)
+		}
+		return fmt.Errorf("failed to store recorded metrics: %w", err)
+	}
+	return errors.Join(rejected...)
+}
+
+// admit checks name of the recorded gauge and counts its series in the series limit of the server,
+// it reports whether the series is counted by the call.
+func (m *Manager) admit(tenantName, name string) (bool, error) {
+	if err := m.validator.Name(name); err != nil {
+		return false, fmt.Errorf("failed to record '%s': %w", name, err)
+	}
+	added, err := m.validator.Admit(tenantName, gauge, name)
+	if err != nil {
+		return false, fmt.Errorf("failed to record '%s': %w", name, err)
+	}
+	return added, nil
+}
+
+// series returns name and labels of the gauge recording the sample.
+func (r *recordingRule) series(s *query.Sample) (string, map[string]string) {
+	labels := make(map[string]string, len(s.Labels)+len(r.Labels))
+	for k, v := range s.Labels {
+		labels[k] = v
+	}
+	for k, v := range r.Labels {
+		labels[k] = v
+	}
+	if len(s.Labels) == 0 {
+		return r.Record, labels
+	}
+
+	keys := make([]string, 0, len(s.Labels))
+	for k := range s.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := []string{r.Record}
+	for _, k := range keys {
+		parts = append(parts, s.Labels[k])
+	}
+	return strings.Join(parts, "."), labels
+}
@@ -0,0 +1,238 @@
+// Package rules periodically evaluates rules over stored metrics.
+//
+// Alerting rules compare every series of a query result with a threshold. A matching series raises
+// a pending alert, which turns firing once the condition holds for the 'for' duration, and resolved
+// when the series no longer matches. Firing and resolved alerts are posted to webhooks, alerts are
+// grouped into one notification by values of 'group_by' labels. Firing alerts are repeated every
+// 'repeat_interval'. Example of the rules file:
+//
+//	{
+//	  "webhooks": [{"url": "http://localhost:9000/alerts", "headers": {"Authorization": "Bearer token"}}],
+//	  "group_by": ["alertname"],
+//	  "repeat_interval": "4h",
+//	  "alerting_rules": [{
+//	    "name": "LowFreeMemory",
+//	    "expr": "FreeMemory",
+//	    "op": "<",
+//	    "threshold": 104857600,
+//	    "for": "5m",
+//	    "labels": {"severity": "page"},
+//	    "annotations": {"summary": "{{ .Labels.host }} has {{ .Value }} bytes of free memory"}
+//	  }]
+//	}
+//
+// Alert labels are labels of the series, 'metric' label with the metric name if the series has one,
+// labels of the rule and 'alertname' label with the rule name.
+//
+// Recording rules store every series of a query result as a gauge, so the result can be read and
+// queried like any other metric. The gauge is named by 'record', values of the series labels are
+// appended to the name separated by dots, e.g. "heap:ratio.a" for a series with label host="a".
+// Recorded names are checked and new series are counted in the series limit of the server like metrics
+// written by agents. Recording rules are evaluated before alerting rules, alerting rules see the recorded
+// values:
+//
+//	{
+//	  "recording_rules": [
+//	    {"record": "heap:ratio", "expr": "HeapInuse / HeapSys"},
+//	    {"record": "polls:rate5m", "expr": "rate(PollCount[5m])"}
+//	  ]
+//	}
+package rules
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/query"
+	"github.com/vkupriya/go-metrics/internal/server/validation"
+)
+
+// Storage provides metrics evaluated by rules and stores results of recording rules.
+type Storage interface {
+	query.Storage
+	UpdateBatch(c *models.Config, g models.Metrics, cr models.Metrics) error
+}
+
+// Stores returns metric storage of the tenant.
+type Stores func(tenant string) (Storage, error)
+
+// Manager evaluates rules of the rules file.
+type Manager struct {
+	stores    Stores
+	validator *validation.Validator
+	notifier  *notifier
+	config    *models.Config
+	recording []*recordingRule
+	alerting  []*alertingRule
+	groupBy   []string
+	repeat    time.Duration
+	mu        sync.Mutex
+}
+
+// Rule types.
+const (
+	TypeAlerting  string = "alerting"
+	TypeRecording string = "recording"
+)
+
+// RuleStatus describes a rule and its last evaluation.
+type RuleStatus struct {
+	LastEvaluation     *time.Time `json:"last_evaluation,omitempty"`
+	Name               string     `json:"name"` // name of alerting rule or record of recording rule
+	Type               string     `json:"type"`
+	Expr               string     `json:"expr"`
+	Health             string     `json:"health"` // ok, err or unknown until the rule is evaluated
+	LastError          string     `json:"last_error,omitempty"`
+	EvaluationDuration float64    `json:"evaluation_duration_seconds"`
+}
+
+// health keeps result of the last rule evaluation.
+type health struct {
+	lastEvaluation time.Time
+	lastError      error
+	duration       time.Duration
+}
+
+func (h *health) status(typ, name, expr string) RuleStatus {
+	rs := RuleStatus{
+		Name:               name,
+		Type:               typ,
+		Expr:               expr,
+		Health:             "unknown",
+		EvaluationDuration: h.duration.Seconds(),
+	}
+	if !h.lastEvaluation.IsZero() {
+		ts := h.lastEvaluation
+		rs.LastEvaluation = &ts
+		rs.Health = "ok"
+	}
+	if h.lastError != nil {
+		rs.Health = "err"
+		rs.LastError = h.lastError.Error()
+	}
+	return rs
+}
+
+// NewManager instantiates manager of the rules, stores provide metrics of rule tenants. Series recorded
+// by recording rules are checked by the validator shared with the servers, so they are counted in the
+// series limit of the server.
+func NewManager(c *models.Config, f *File, stores Stores, v *validation.Validator) (*Manager, error) {
+	m := &Manager{
+		stores:    stores,
+		validator: v,
+		notifier:  newNotifier(c, f.Webhooks),
+		config:    c,
+		groupBy:   f.GroupBy,
+		repeat:    f.RepeatInterval.Duration,
+	}
+	if m.groupBy == nil {
+		m.groupBy = []string{alertNameLabel}
+	}
+	if m.repeat == 0 {
+		m.repeat = defaultRepeatInterval
+	}
+
+	for i := range f.RecordingRules {
+		m.recording = append(m.recording, newRecordingRule(&f.RecordingRules[i]))
+	}
+	for i := range f.AlertingRules {
+		r, err := newAlertingRule(&f.AlertingRules[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to load alerting rule '%s': %w", f.AlertingRules[i].Name, err)
+		}
+		m.alerting = append(m.alerting, r)
+	}
+	return m, nil
+}
+
+// Run evaluates the rules every interval until the context is cancelled.
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		m.notifier.run(ctx)
+	}()
+	defer wg.Wait()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, n := range m.Evaluate(now) {
+				m.notifier.enqueue(n)
+			}
+		}
+	}
+}
+
+// Evaluate evaluates all rules at the time and returns notifications due to be sent.
+// Rules failing to evaluate are logged and keep their alerts unchanged.
+func (m *Manager) Evaluate(now time.Time) []*Notification {
+	logger := m.config.Logger
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, r := range m.recording {
+		start := time.Now()
+		err := m.evalRecording(r, now)
+		r.health = health{lastEvaluation: now, lastError: err, duration: time.Since(start)}
+		if err != nil {
+			logger.Sugar().Errorw("failed to evaluate recording rule", "record", r.Record, zap.Error(err))
+		}
+	}
+	for _, r := range m.alerting {
+		start := time.Now()
+		err := m.evalAlerting(r, now)
+		r.health = health{lastEvaluation: now, lastError: err, duration: time.Since(start)}
+		if err != nil {
+			logger.Sugar().Errorw("failed to evaluate alerting rule", "rule", r.Name, zap.Error(err))
+		}
+	}
+	return m.notifications(now)
+}
+
+// Rules returns status of rules evaluated over metrics of the tenant, in order of evaluation.
+func (m *Manager) Rules(tenantName string) []RuleStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rs := make([]RuleStatus, 0)
+	for _, r := range m.recording {
+		if r.Tenant == tenantName {
+			rs = append(rs, r.status(TypeRecording, r.Record, r.Expr))
+		}
+	}
+	for _, r := range m.alerting {
+		if r.Tenant == tenantName {
+			rs = append(rs, r.status(TypeAlerting, r.Name, r.Expr))
+		}
+	}
+	return rs
+}
+
+// query evaluates the expression over metrics of the tenant, scalar result is returned as a single sample.
+func (m *Manager) query(tenantName, expr string, now time.Time) ([]query.Sample, error) {
+	store, err := m.stores(tenantName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage of tenant '%s': %w", tenantName, err)
+	}
+	res, err := query.NewEngine(m.config, store).Query(expr, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate expression: %w", err)
+	}
+	if res.Scalar != nil {
+		return []query.Sample{{Value: *res.Scalar}}, nil
+	}
+	return res.Vector, nil
+}
@@ -0,0 +1,357 @@
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/storage"
+	"github.com/vkupriya/go-metrics/internal/server/tenant"
+	"github.com/vkupriya/go-metrics/internal/server/validation"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{
+			name: "rules: OK",
+			content: `{"webhooks": [{"url": "http://localhost:9000/"}], "repeat_interval": "1h",
+				"alerting_rules": [{"name": "LowMemory", "expr": "FreeMemory", "op": "<", "threshold": 1, "for": "5m"}]}`,
+		},
+		{name: "empty: OK", content: `{}`},
+		{name: "malformed_json: FAIL", content: `{"alerting_rules": [`, wantErr: true},
+		{name: "malformed_duration: FAIL", content: `{"repeat_interval": "hour"}`, wantErr: true},
+		{name: "webhook_scheme: FAIL", content: `{"webhooks": [{"url": "ftp://localhost/"}]}`, wantErr: true},
+		{
+			name:    "missing_name: FAIL",
+			content: `{"alerting_rules": [{"expr": "FreeMemory", "op": "<", "threshold": 1}]}`,
+			wantErr: true,
+		},
+		{
+			name: "duplicate_name: FAIL",
+			content: `{"alerting_rules": [{"name": "a", "expr": "FreeMemory", "op": "<", "threshold": 1},
+				{"name": "a", "expr": "FreeMemory", "op": ">", "threshold": 1}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid_expr: FAIL",
+			content: `{"alerting_rules": [{"name": "a", "expr": "sum(FreeMemory", "op": "<", "threshold": 1}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown_op: FAIL",
+			content: `{"alerting_rules": [{"name": "a", "expr": "FreeMemory", "op": "=<", "threshold": 1}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "recording: OK",
+			content: `{"recording_rules": [{"record": "heap:ratio", "expr": "HeapInuse / HeapSys"}]}`,
+		},
+		{
+			name:    "missing_record: FAIL",
+			content: `{"recording_rules": [{"expr": "HeapInuse / HeapSys"}]}`,
+			wantErr: true,
+		},
+		{
+			name: "duplicate_record: FAIL",
+			content: `{"recording_rules": [{"record": "a", "expr": "HeapInuse"},
+				{"record": "a", "expr": "HeapSys"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid_record_expr: FAIL",
+			content: `{"recording_rules": [{"record": "a", "expr": "HeapInuse /"}]}`,
+			wantErr: true,
+		},
+		{
+			name: "invalid_annotation: FAIL",
+			content: `{"alerting_rules": [{"name": "a", "expr": "FreeMemory", "op": "<", "threshold": 1,
+				"annotations": {"summary": "{{ .Value"}}]}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rules.json")
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0o600))
+
+			_, err := Load(path)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestAlerting(t *testing.T) {
+	cfg := &models.Config{
+		Logger:         zap.NewNop(),
+		ContextTimeout: 3,
+	}
+	s, err := storage.NewMemStorage(cfg)
+	require.NoError(t, err)
+	setFree := func(host string, v float64) {
+		t.Helper()
+		require.NoError(t, s.UpdateBatch(cfg, models.Metrics{
+			{ID: "FreeMemory." + host, MType: "gauge", Value: &v, Labels: map[string]string{"host": host}},
+		}, nil))
+	}
+	setFree("a", 1000)
+	setFree("b", 1000)
+
+	f := &File{
+		GroupBy:        []string{alertNameLabel},
+		RepeatInterval: Duration{10 * time.Minute},
+		AlertingRules: []AlertingRule{{
+			Name:        "LowFreeMemory",
+			Expr:        "FreeMemory*",
+			Op:          "<",
+			Threshold:   100,
+			For:         Duration{time.Minute},
+			Labels:      map[string]string{"severity": "page"},
+			Annotations: map[string]string{"summary": "{{ .Labels.host }} has {{ .Value }} bytes free"},
+		}},
+	}
+	m, err := NewManager(cfg, f, func(string) (Storage, error) { return s, nil }, validation.New(cfg))
+	require.NoError(t, err)
+
+	start := time.Now()
+	labelsA := map[string]string{
+		"host": "a", "metric": "FreeMemory.a", "severity": "page", "alertname": "LowFreeMemory",
+	}
+
+	assert.Empty(t, m.Evaluate(start))
+	assert.Empty(t, m.Alerts(tenant.Default))
+
+	setFree("a", 50)
+	assert.Empty(t, m.Evaluate(start.Add(time.Minute)), "pending alerts are not notified")
+	alerts := m.Alerts(tenant.Default)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, StatePending, alerts[0].State)
+	assert.Equal(t, labelsA, alerts[0].Labels)
+	assert.Equal(t, map[string]string{"summary": "a has 50 bytes free"}, alerts[0].Annotations)
+
+	setFree("b", 10)
+	ns := m.Evaluate(start.Add(2 * time.Minute))
+	require.Len(t, ns, 1, "alerts are grouped by alertname")
+	assert.Equal(t, StateFiring, ns[0].Status)
+	assert.Equal(t, map[string]string{"alertname": "LowFreeMemory"}, ns[0].GroupLabels)
+	require.Len(t, ns[0].Alerts, 1, "only alert a is firing")
+	assert.Equal(t, labelsA, ns[0].Alerts[0].Labels)
+	assert.NotNil(t, ns[0].Alerts[0].FiredAt)
+
+	ns = m.Evaluate(start.Add(3*time.Minute + time.Second))
+	require.Len(t, ns, 1)
+	require.Len(t, ns[0].Alerts, 1, "firing alert a is not repeated yet")
+	assert.Equal(t, "b", ns[0].Alerts[0].Labels["host"])
+	assert.Len(t, m.Alerts(tenant.Default), 2)
+	assert.Empty(t, m.Alerts("team-a"), "alerts of other tenants are not returned")
+
+	ns = m.Evaluate(start.Add(12 * time.Minute))
+	require.Len(t, ns, 1)
+	require.Len(t, ns[0].Alerts, 1, "firing alert a is repeated")
+	assert.Equal(t, "a", ns[0].Alerts[0].Labels["host"])
+
+	setFree("a", 1000)
+	setFree("b", 1000)
+	ns = m.Evaluate(start.Add(13 * time.Minute))
+	require.Len(t, ns, 1)
+	assert.Equal(t, StateResolved, ns[0].Status)
+	require.Len(t, ns[0].Alerts, 2)
+	assert.NotNil(t, ns[0].Alerts[0].ResolvedAt)
+
+	setFree("a", 50)
+	assert.Empty(t, m.Evaluate(start.Add(14*time.Minute)))
+	setFree("a", 1000)
+	assert.Empty(t, m.Evaluate(start.Add(15*time.Minute)), "pending alerts are dropped without notification")
+	alerts = m.Alerts(tenant.Default)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, "b", alerts[0].Labels["host"])
+	assert.Equal(t, StateResolved, alerts[0].State)
+
+	assert.Empty(t, m.Evaluate(start.Add(13*time.Minute+resolvedRetention)))
+	assert.Empty(t, m.Alerts(tenant.Default), "resolved alerts are dropped after retention")
+}
+
+func TestRecording(t *testing.T) {
+	cfg := &models.Config{
+		Logger:         zap.NewNop(),
+		ContextTimeout: 3,
+	}
+	s, err := storage.NewMemStorage(cfg)
+	require.NoError(t, err)
+	values := []float64{50, 200, 100, 300}
+	require.NoError(t, s.UpdateBatch(cfg, models.Metrics{
+		{ID: "HeapInuse", MType: "gauge", Value: &values[0]},
+		{ID: "HeapSys", MType: "gauge", Value: &values[1]},
+		{ID: "HeapAlloc.a", MType: "gauge", Value: &values[2], Labels: map[string]string{"host": "a"}},
+		{ID: "HeapAlloc.b", MType: "gauge", Value: &values[3], Labels: map[string]string{"host": "b"}},
+	}, nil))
+	_, err = s.UpdateCounterMetric(cfg, "PollCount", 1)
+	require.NoError(t, err)
+
+	f := &File{
+		RecordingRules: []RecordingRule{
+			{Record: "heap:ratio", Expr: "HeapInuse / HeapSys", Labels: map[string]string{"source": "rule"}},
+			{Record: "heap:by_host", Expr: "sum by (host) (HeapAlloc*)"},
+			{Record: "heap:all", Expr: "Heap*"},
+			{Record: "PollCount", Expr: "HeapSys"},
+		},
+		AlertingRules: []AlertingRule{{Name: "HighHeapRatio", Expr: "heap:ratio", Op: ">", Threshold: 0.2}},
+	}
+	m, err := NewManager(cfg, f, func(string) (Storage, error) { return s, nil }, validation.New(cfg))
+	require.NoError(t, err)
+
+	statuses := m.Rules(tenant.Default)
+	require.Len(t, statuses, 5)
+	assert.Equal(t, "unknown", statuses[0].Health)
+	assert.Nil(t, statuses[0].LastEvaluation)
+
+	now := time.Now()
+	m.Evaluate(now)
+
+	v, _, err := s.GetGaugeMetric(cfg, "heap:ratio")
+	require.NoError(t, err)
+	assert.Equal(t, 0.25, v)
+	v, _, err = s.GetGaugeMetric(cfg, "heap:by_host.a")
+	require.NoError(t, err)
+	assert.Equal(t, 100.0, v)
+	v, _, err = s.GetGaugeMetric(cfg, "heap:by_host.b")
+	require.NoError(t, err)
+	assert.Equal(t, 300.0, v)
+
+	mds, err := s.GetMetadata(cfg)
+	require.NoError(t, err)
+	labels := make(map[string]map[string]string)
+	for _, md := range mds {
+		labels[md.Name] = md.Labels
+	}
+	assert.Equal(t, map[string]string{"source": "rule"}, labels["heap:ratio"])
+	assert.Equal(t, map[string]string{"host": "b"}, labels["heap:by_host.b"])
+
+	statuses = m.Rules(tenant.Default)
+	names := make([]string, 0, len(statuses))
+	for _, st := range statuses {
+		names = append(names, st.Name)
+	}
+	assert.Equal(t, []string{"heap:ratio", "heap:by_host", "heap:all", "PollCount", "HighHeapRatio"}, names)
+	assert.Equal(t, "ok", statuses[0].Health)
+	assert.Equal(t, TypeRecording, statuses[0].Type)
+	assert.Equal(t, now, *statuses[0].LastEvaluation)
+	assert.Equal(t, "err", statuses[2].Health, "series of equal labels are recorded under one name")
+	assert.NotEmpty(t, statuses[2].LastError)
+	assert.Equal(t, "err", statuses[3].Health, "counter can't be recorded as a gauge")
+	assert.Contains(t, statuses[3].LastError, storage.ErrTypeConflict.Error())
+	assert.Equal(t, TypeAlerting, statuses[4].Type)
+	assert.Equal(t, "ok", statuses[4].Health)
+	assert.Empty(t, m.Rules("team-a"))
+
+	alerts := m.Alerts(tenant.Default)
+	require.Len(t, alerts, 1, "alerting rules see recorded values")
+	assert.Equal(t, 0.25, alerts[0].Value)
+}
+
+func TestRecordingValidation(t *testing.T) {
+	cfg := &models.Config{
+		Logger:         zap.NewNop(),
+		ContextTimeout: 3,
+		MaxSeries:      2,
+	}
+	s, err := storage.NewMemStorage(cfg)
+	require.NoError(t, err)
+	values := []float64{100, 300, 200}
+	require.NoError(t, s.UpdateBatch(cfg, models.Metrics{
+		{ID: "HeapAlloc.a", MType: "gauge", Value: &values[0], Labels: map[string]string{"host": "a"}},
+		{ID: "HeapAlloc.b", MType: "gauge", Value: &values[1], Labels: map[string]string{"host": "b c"}},
+		{ID: "HeapSys", MType: "gauge", Value: &values[2]},
+	}, nil))
+
+	f := &File{
+		RecordingRules: []RecordingRule{
+			{Record: "heap:by_host", Expr: "sum by (host) (HeapAlloc*)"},
+			{Record: "heap:sys", Expr: "HeapSys"},
+			{Record: "heap:sys2", Expr: "HeapSys"},
+		},
+	}
+	m, err := NewManager(cfg, f, func(string) (Storage, error) { return s, nil }, validation.New(cfg))
+	require.NoError(t, err)
+	m.Evaluate(time.Now())
+
+	statuses := m.Rules(tenant.Default)
+	require.Len(t, statuses, 3)
+	assert.Equal(t, "err", statuses[0].Health, "recorded names must match the name pattern")
+	assert.Contains(t, statuses[0].LastError, validation.ErrInvalidName.Error())
+	v, _, err := s.GetGaugeMetric(cfg, "heap:by_host.a")
+	require.NoError(t, err, "valid series of the result are recorded")
+	assert.Equal(t, 100.0, v)
+	_, _, err = s.GetGaugeMetric(cfg, "heap:by_host.b c")
+	assert.Error(t, err)
+
+	assert.Equal(t, "ok", statuses[1].Health)
+	assert.Equal(t, "err", statuses[2].Health, "recorded series are counted in the series limit")
+	assert.Contains(t, statuses[2].LastError, validation.ErrSeriesLimit.Error())
+	_, _, err = s.GetGaugeMetric(cfg, "heap:sys2")
+	assert.Error(t, err)
+}
+
+func TestNotify(t *testing.T) {
+	cfg := &models.Config{Logger: zap.NewNop()}
+
+	var calls atomic.Int32
+	received := make(chan Notification, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		var n Notification
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&n))
+		received <- n
+	}))
+	defer srv.Close()
+
+	n := newNotifier(cfg, []Webhook{{URL: srv.URL, Headers: map[string]string{"Authorization": "Bearer secret"}}})
+	n.retryDelay = time.Millisecond
+	n.notify(context.Background(), &Notification{
+		Status: StateFiring,
+		Tenant: tenant.Default,
+		Alerts: []Alert{{Rule: "LowFreeMemory", State: StateFiring, Value: 50}},
+	})
+
+	assert.Equal(t, int32(2), calls.Load(), "failed notification is retried")
+	got := <-received
+	assert.Equal(t, StateFiring, got.Status)
+	require.Len(t, got.Alerts, 1)
+	assert.Equal(t, "LowFreeMemory", got.Alerts[0].Rule)
+
+	t.Run("client_error: FAIL", func(t *testing.T) {
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			rw.WriteHeader(http.StatusBadRequest)
+		}))
+		defer srv.Close()
+
+		n := newNotifier(cfg, nil)
+		n.retryDelay = time.Millisecond
+		assert.Error(t, n.send(context.Background(), &Webhook{URL: srv.URL}, []byte(`{}`)))
+		assert.Equal(t, int32(1), calls.Load(), "client errors are not retried")
+	})
+}
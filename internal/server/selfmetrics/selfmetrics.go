@@ -0,0 +1,182 @@
+// Package selfmetrics counts events of the metric server itself.
+//
+// Counts are periodically added to counter metrics of the default tenant named with the reserved Prefix,
+// so self-metrics are queryable through the same APIs as metrics of agents. Clients can't write metrics
+// with the prefix.
+//
+// Histograms are written as counters too: <name>.count is the number of observations, <name>.sum is their
+// sum and <name>.le_<bound> is the number of observations less than or equal to the bound, so buckets are
+// cumulative like buckets of Prometheus histograms. Durations are observed in microseconds and their
+// buckets are named after the bound duration, e.g. <name>.le_250ms.
+package selfmetrics
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/vkupriya/go-metrics/internal/server/models"
+)
+
+// Prefix is the reserved name prefix of self-metrics.
+const Prefix = "gometrics."
+
+const counterType = "counter"
+
+var (
+	counters   sync.Map // metric name without prefix to *atomic.Int64 holding count since the last flush
+	histograms sync.Map // histogram name without prefix to *histogram
+)
+
+// buckets are upper bounds of histogram buckets along with suffixes of their counters.
+type buckets struct {
+	bounds   []int64
+	suffixes []string
+}
+
+func newBuckets(bounds []int64, label func(int64) string) *buckets {
+	b := &buckets{bounds: bounds}
+	for _, bound := range bounds {
+		b.suffixes = append(b.suffixes, ".le_"+label(bound))
+	}
+	return b
+}
+
+var (
+	latencyBuckets = newBuckets([]int64{1_000, 5_000, 10_000, 25_000, 50_000, 100_000, 250_000, 500_000,
+		1_000_000, 2_500_000, 5_000_000, 10_000_000}, func(us int64) string {
+		return (time.Duration(us) * time.Microsecond).String()
+	})
+	sizeBuckets = newBuckets([]int64{1, 10, 100, 1_000, 10_000}, func(n int64) string {
+		return strconv.FormatInt(n, 10)
+	})
+)
+
+type histogram struct {
+	buckets *buckets
+	count   *atomic.Int64
+	sum     *atomic.Int64
+	inf     *atomic.Int64
+	le      []*atomic.Int64
+}
+
+// counter returns the counter, it is created on first use.
+func counter(name string) *atomic.Int64 {
+	v, ok := counters.Load(name)
+	if !ok {
+		v, _ = counters.LoadOrStore(name, new(atomic.Int64))
+	}
+	c, _ := v.(*atomic.Int64)
+	return c
+}
+
+// Inc increments the counter.
+func Inc(name string) {
+	Add(name, 1)
+}
+
+// Add adds n to the counter.
+func Add(name string, n int64) {
+	counter(name).Add(n)
+}
+
+// ObserveDuration adds the duration to the latency histogram.
+func ObserveDuration(name string, d time.Duration) {
+	observe(name, latencyBuckets, d.Microseconds())
+}
+
+// ObserveSize adds the size, e.g. number of metrics in a batch, to the size histogram.
+func ObserveSize(name string, n int) {
+	observe(name, sizeBuckets, int64(n))
+}
+
+func observe(name string, b *buckets, v int64) {
+	h, ok := histograms.Load(name)
+	if !ok {
+		nh := &histogram{
+			buckets: b,
+			count:   counter(name + ".count"),
+			sum:     counter(name + ".sum"),
+			inf:     counter(name + ".le_inf"),
+		}
+		for _, suffix := range b.suffixes {
+			nh.le = append(nh.le, counter(name+suffix))
+		}
+		h, _ = histograms.LoadOrStore(name, nh)
+	}
+	hist, ok := h.(*histogram)
+	if !ok {
+		return
+	}
+
+	hist.count.Add(1)
+	hist.sum.Add(v)
+	for i, bound := range hist.buckets.bounds {
+		if v <= bound {
+			hist.le[i].Add(1)
+		}
+	}
+	hist.inf.Add(1)
+}
+
+// Storage is the storage self-metrics are written into.
+type Storage interface {
+	UpdateBatch(c *models.Config, g models.Metrics, cr models.Metrics) error
+}
+
+// Flush adds counts since the previous flush to counter metrics of the storage. Counts are kept
+// for the next flush if the storage fails.
+func Flush(c *models.Config, s Storage) error {
+	var cr models.Metrics
+	counters.Range(func(k, v any) bool {
+		name, _ := k.(string)
+		if c, ok := v.(*atomic.Int64); ok {
+			if n := c.Swap(0); n != 0 {
+				cr = append(cr, models.Metric{ID: Prefix + name, MType: counterType, Delta: &n})
+			}
+		}
+		return true
+	})
+	if len(cr) == 0 {
+		return nil
+	}
+	if err := s.UpdateBatch(c, nil, cr); err != nil {
+		for _, m := range cr {
+			Add(m.ID[len(Prefix):], *m.Delta)
+		}
+		return fmt.Errorf("failed to write self-metrics: %w", err)
+	}
+	return nil
+}
+
+// Run flushes counts into the storage every interval until the context is cancelled.
+func Run(ctx context.Context, c *models.Config, s Storage, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := Flush(c, s); err != nil {
+				c.Logger.Sugar().Error("failed to flush self-metrics", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Value returns count of the counter since the last flush.
+func Value(name string) int64 {
+	if v, ok := counters.Load(name); ok {
+		if c, ok := v.(*atomic.Int64); ok {
+			return c.Load()
+		}
+	}
+	return 0
+}
@@ -0,0 +1,62 @@
+package selfmetrics_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/selfmetrics"
+	"github.com/vkupriya/go-metrics/internal/server/storage"
+)
+
+type failingStorage struct{}
+
+func (failingStorage) UpdateBatch(c *models.Config, g models.Metrics, cr models.Metrics) error {
+	return errors.New("storage is down")
+}
+
+func TestFlush(t *testing.T) {
+	cfg := &models.Config{Logger: zap.NewNop()}
+	s, err := storage.NewMemStorage(cfg)
+	require.NoError(t, err)
+
+	selfmetrics.Add("test.flushed", 2)
+	selfmetrics.Inc("test.flushed")
+	assert.Equal(t, int64(3), selfmetrics.Value("test.flushed"))
+
+	assert.Error(t, selfmetrics.Flush(cfg, failingStorage{}))
+	assert.Equal(t, int64(3), selfmetrics.Value("test.flushed"), "counts are kept if storage fails")
+
+	require.NoError(t, selfmetrics.Flush(cfg, s))
+	assert.Equal(t, int64(0), selfmetrics.Value("test.flushed"))
+	v, _, err := s.GetCounterMetric(cfg, selfmetrics.Prefix+"test.flushed")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), v)
+
+	selfmetrics.Inc("test.flushed")
+	require.NoError(t, selfmetrics.Flush(cfg, s))
+	v, _, err = s.GetCounterMetric(cfg, selfmetrics.Prefix+"test.flushed")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), v)
+}
+
+func TestObserve(t *testing.T) {
+	selfmetrics.ObserveDuration("test.duration", 3*time.Millisecond)
+	selfmetrics.ObserveDuration("test.duration", time.Minute)
+	assert.Equal(t, int64(2), selfmetrics.Value("test.duration.count"))
+	assert.Equal(t, int64(60_003_000), selfmetrics.Value("test.duration.sum"))
+	assert.Equal(t, int64(0), selfmetrics.Value("test.duration.le_1ms"))
+	assert.Equal(t, int64(1), selfmetrics.Value("test.duration.le_5ms"))
+	assert.Equal(t, int64(1), selfmetrics.Value("test.duration.le_2.5s"), "buckets are cumulative")
+	assert.Equal(t, int64(2), selfmetrics.Value("test.duration.le_inf"))
+
+	selfmetrics.ObserveSize("test.size", 10)
+	assert.Equal(t, int64(0), selfmetrics.Value("test.size.le_1"))
+	assert.Equal(t, int64(1), selfmetrics.Value("test.size.le_10"))
+	assert.Equal(t, int64(1), selfmetrics.Value("test.size.le_10000"))
+}
@@ -7,16 +7,29 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 
+	"github.com/vkupriya/go-metrics/internal/address"
+	"github.com/vkupriya/go-metrics/internal/compression"
+	"github.com/vkupriya/go-metrics/internal/configfile"
+	"github.com/vkupriya/go-metrics/internal/logging"
+	"github.com/vkupriya/go-metrics/internal/server/audit"
+	"github.com/vkupriya/go-metrics/internal/server/auth"
 	"github.com/vkupriya/go-metrics/internal/server/config"
 	grpcserver "github.com/vkupriya/go-metrics/internal/server/grpc"
 	"github.com/vkupriya/go-metrics/internal/server/handlers"
+	"github.com/vkupriya/go-metrics/internal/server/health"
 	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/ratelimit"
+	"github.com/vkupriya/go-metrics/internal/server/rules"
+	"github.com/vkupriya/go-metrics/internal/server/selfmetrics"
+	"github.com/vkupriya/go-metrics/internal/server/validation"
+	"github.com/vkupriya/go-metrics/internal/tracing"
 
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
@@ -25,6 +38,9 @@ import (
 const TimeoutShutdown time.Duration = 10 * time.Second
 const TimeoutServerShutdown time.Duration = 5 * time.Second
 
+// serviceName is the name of the server in trace spans.
+const serviceName = "metric-server"
+
 func NewServer(c *models.Config, gr chi.Router) *http.Server {
 	return &http.Server{
 		Addr:    c.Address,
@@ -32,12 +48,47 @@ func NewServer(c *models.Config, gr chi.Router) *http.Server {
 	}
 }
 
-func Start(logger *zap.Logger) error {
-	cfg, err := config.NewConfig()
+// BuildInfo is version information of the server binary.
+type BuildInfo struct {
+	Version string
+	Date    string
+	Commit  string
+}
+
+func Start(build BuildInfo) error {
+	cfg, settings, err := config.Load()
 	if err != nil {
 		log.Fatal(zap.Error(err))
 	}
+	if config.PrintConfig() {
+		if err := configfile.Print(os.Stdout, settings); err != nil {
+			return fmt.Errorf("failed to print configuration: %w", err)
+		}
+		return nil
+	}
+	logger, levels, err := logging.New(&cfg.Log)
+	if err != nil {
+		return fmt.Errorf("failed to initialize logger: %w", err)
+	}
+	defer func() {
+		_ = logger.Sync()
+	}()
 	cfg.Logger = logger
+	compression.SetGRPCLevel(cfg.CompressionLevel)
+	logger.Sugar().Infow("starting metric server",
+		"version", build.Version, "date", build.Date, "commit", build.Commit)
+
+	shutdownTracing, err := tracing.Start(context.Background(), &cfg.Tracing, serviceName, build.Version)
+	if err != nil {
+		return fmt.Errorf("failed to initialize tracing: %w", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), TimeoutServerShutdown)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Sugar().Errorw("failed to flush trace spans", zap.Error(err))
+		}
+	}()
 
 	rootCtx, cancelCtx := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancelCtx()
@@ -52,12 +103,58 @@ func Start(logger *zap.Logger) error {
 		logger.Sugar().Error("failed to gracefully shutdown the service")
 	})
 
-	s, err := handlers.NewStore(cfg)
+	tenants, err := handlers.NewTenantStores(cfg)
 	if err != nil {
 		logger.Sugar().Fatal(err)
 	}
 
-	mr := handlers.NewMetricResource(s, cfg)
+	var tokens *auth.File
+	if cfg.TokensFile != "" {
+		if tokens, err = auth.Load(cfg.TokensFile); err != nil {
+			logger.Sugar().Fatal(err)
+		}
+	}
+	authenticator := auth.New(cfg, tokens)
+
+	var auditLog *audit.Log
+	if cfg.AuditLog != "" {
+		if auditLog, err = audit.Open(cfg.AuditLog); err != nil {
+			logger.Sugar().Fatal(err)
+		}
+		defer func() {
+			if err := auditLog.Close(); err != nil {
+				logger.Sugar().Error(zap.Error(err))
+			}
+		}()
+	}
+
+	mr := handlers.NewTenantMetricResource(tenants, cfg)
+	mr.SetAuth(authenticator)
+	mr.SetAudit(auditLog)
+	limiter := ratelimit.New(cfg)
+	mr.SetLimiter(limiter)
+	validator := validation.New(cfg)
+	if err := tenants.SetValidator(cfg, validator); err != nil {
+		logger.Sugar().Fatal(err)
+	}
+	mr.SetValidator(validator)
+	checks := health.New()
+	mr.SetHealth(checks)
+
+	var ruleManager *rules.Manager
+	if cfg.RulesFile != "" {
+		f, err := rules.Load(cfg.RulesFile)
+		if err != nil {
+			logger.Sugar().Fatal(err)
+		}
+		ruleManager, err = rules.NewManager(cfg, f, func(tenant string) (rules.Storage, error) {
+			return tenants.Store(tenant)
+		}, validator)
+		if err != nil {
+			logger.Sugar().Fatal(err)
+		}
+		mr.SetRules(ruleManager)
+	}
 
 	r := handlers.NewMetricRouter(mr)
 	srv := NewServer(cfg, r)
@@ -67,13 +164,54 @@ func Start(logger *zap.Logger) error {
 		"addr", cfg.Address,
 	)
 
-	g.Go(func() error {
-		defer logger.Sugar().Info("closed GRPC server")
+	if cfg.GRPCDisabled {
+		checks.Set(grpcserver.HealthComponent, "", fmt.Errorf("%w: gRPC server is disabled", health.ErrDisabled))
+	} else {
+		g.Go(func() error {
+			defer logger.Sugar().Info("closed GRPC server")
 
-		if err := grpcserver.Run(ctx, s, cfg); err != nil {
-			return fmt.Errorf("failed to run grpc server: %w", err)
-		}
+			stores := func(ctx context.Context, tenant string) (grpcserver.Storage, error) {
+				return tenants.StoreContext(ctx, tenant)
+			}
+			if err := grpcserver.Run(ctx, stores, cfg, authenticator, limiter, validator, auditLog, checks); err != nil {
+				return fmt.Errorf("failed to run grpc server: %w", err)
+			}
+
+			return nil
+		})
+	}
+
+	if cfg.RollupInterval > 0 {
+		g.Go(func() error {
+			defer logger.Sugar().Info("stopped history rollups")
+
+			runRollups(ctx, tenants, cfg)
+			return nil
+		})
+	}
+
+	if cfg.SelfInterval > 0 {
+		g.Go(func() error {
+			defer logger.Sugar().Info("stopped self-metrics")
+
+			selfmetrics.Run(ctx, cfg, tenants.Default(), time.Duration(cfg.SelfInterval)*time.Second)
+			return nil
+		})
+	}
 
+	if ruleManager != nil {
+		g.Go(func() error {
+			defer logger.Sugar().Info("stopped rules evaluation")
+
+			ruleManager.Run(ctx, time.Duration(cfg.RulesInterval)*time.Second)
+			return nil
+		})
+	}
+
+	g.Go(func() error {
+		defer logger.Sugar().Info("stopped configuration reloads")
+
+		watchReloads(ctx, cfg, levels)
 		return nil
 	})
 
@@ -82,7 +220,7 @@ func Start(logger *zap.Logger) error {
 
 		<-ctx.Done()
 
-		mr.Store.Close()
+		tenants.Close()
 		return nil
 	})
 
@@ -103,7 +241,11 @@ func Start(logger *zap.Logger) error {
 				}
 			}
 		}()
-		if err = srv.ListenAndServe(); err != nil {
+		listener, err := address.Listen(cfg.Address)
+		if err != nil {
+			return fmt.Errorf("failed to set up HTTP listener: %w", err)
+		}
+		if err = srv.Serve(listener); err != nil {
 			if errors.Is(err, http.ErrServerClosed) {
 				return nil
 			}
@@ -129,3 +271,22 @@ func Start(logger *zap.Logger) error {
 	}
 	return nil
 }
+
+// runRollups periodically aggregates metric history of all tenants until the context is cancelled.
+func runRollups(ctx context.Context, s *handlers.Tenants, cfg *models.Config) {
+	logger := cfg.Logger
+
+	ticker := time.NewTicker(time.Duration(cfg.RollupInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.RollupHistory(cfg); err != nil {
+				logger.Sugar().Error("failed to roll up metric history", zap.Error(err))
+			}
+		}
+	}
+}
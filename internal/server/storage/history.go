@@ -0,0 +1,382 @@
+package storage
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/vkupriya/go-metrics/internal/server/models"
+)
+
+const (
+	insertSampleSQL = "INSERT INTO metric_samples (tenant, mtype, name, value, delta) VALUES($1, $2, $3, $4, $5)"
+
+	// Raw samples are aggregated into buckets of $1 seconds, starting from the latest existing bucket.
+	rollupRawSQL = `INSERT INTO metric_rollups
+		(resolution, tenant, mtype, name, bucket, min, max, avg, last, increase, count)
+	SELECT $1::integer, tenant, mtype, name,
+		to_timestamp(floor(extract(epoch FROM ts))::bigint / $1::integer * $1::integer) AS b,
+		MIN(value), MAX(value), AVG(value), (ARRAY_AGG(value ORDER BY ts DESC))[1], SUM(delta), COUNT(*)
+	FROM metric_samples
+	WHERE ts >= COALESCE((SELECT MAX(bucket) FROM metric_rollups WHERE resolution = $1::integer), 'epoch')
+	GROUP BY tenant, mtype, name, b
+	ON CONFLICT (resolution, tenant, mtype, name, bucket) DO UPDATE SET
+		min = EXCLUDED.min, max = EXCLUDED.max, avg = EXCLUDED.avg, last = EXCLUDED.last,
+		increase = EXCLUDED.increase, count = EXCLUDED.count`
+
+	// Buckets of $2 seconds are aggregated into buckets of $1 seconds, starting from the latest existing bucket.
+	rollupSQL = `INSERT INTO metric_rollups
+		(resolution, tenant, mtype, name, bucket, min, max, avg, last, increase, count)
+	SELECT $1::integer, tenant, mtype, name,
+		to_timestamp(floor(extract(epoch FROM bucket))::bigint / $1::integer * $1::integer) AS b,
+		MIN(min), MAX(max), SUM(avg * count) / SUM(count), (ARRAY_AGG(last ORDER BY bucket DESC))[1],
+		SUM(increase), SUM(count)
+	FROM metric_rollups
+	WHERE resolution = $2::integer
+		AND bucket >= COALESCE((SELECT MAX(bucket) FROM metric_rollups WHERE resolution = $1::integer), 'epoch')
+	GROUP BY tenant, mtype, name, b
+	ON CONFLICT (resolution, tenant, mtype, name, bucket) DO UPDATE SET
+		min = EXCLUDED.min, max = EXCLUDED.max, avg = EXCLUDED.avg, last = EXCLUDED.last,
+		increase = EXCLUDED.increase, count = EXCLUDED.count`
+)
+
+const day = 24 * time.Hour
+
+// Resolutions of rolled up history, from the finest to the coarsest.
+// Each resolution is aggregated from the previous one, the first one from raw samples.
+var resolutions = []time.Duration{time.Minute, time.Hour, day}
+
+// retention returns how long history of the resolution is kept, zero resolution stands for raw samples.
+func retention(c *models.Config, res time.Duration) time.Duration {
+	keep := []int64{c.Retention.Raw, c.Retention.Minute, c.Retention.Hour, c.Retention.Day}
+	return time.Duration(keep[slices.Index(resolutions, res)+1]) * time.Second
+}
+
+// pickResolution returns the coarsest resolution which is not wider than the requested step.
+// Zero is returned when only raw samples satisfy the step.
+func pickResolution(step time.Duration) time.Duration {
+	var res time.Duration
+	for _, r := range resolutions {
+		if r <= step {
+			res = r
+		}
+	}
+	return res
+}
+
+// mergePoint folds point p into aggregate a, points are expected to be merged in time order.
+func mergePoint(a *models.HistoryPoint, p models.HistoryPoint) {
+	if a.Count == 0 {
+		ts := a.Timestamp
+		*a = p
+		a.Timestamp = ts
+		if p.Increase != nil {
+			inc := *p.Increase
+			a.Increase = &inc
+		}
+		return
+	}
+	if p.Min < a.Min {
+		a.Min = p.Min
+	}
+	if p.Max > a.Max {
+		a.Max = p.Max
+	}
+	total := a.Count + p.Count
+	a.Avg = (a.Avg*float64(a.Count) + p.Avg*float64(p.Count)) / float64(total)
+	a.Count = total
+	a.Last = p.Last
+	if p.Increase != nil {
+		if a.Increase == nil {
+			a.Increase = new(int64)
+		}
+		*a.Increase += *p.Increase
+	}
+}
+
+// downsample aggregates points sorted by time into buckets of the given width.
+// Zero width returns points unchanged.
+func downsample(points []models.HistoryPoint, width time.Duration) []models.HistoryPoint {
+	if width <= 0 || len(points) == 0 {
+		return points
+	}
+	out := make([]models.HistoryPoint, 0)
+	for _, p := range points {
+		start := p.Timestamp.UTC().Truncate(width)
+		if len(out) == 0 || !out[len(out)-1].Timestamp.Equal(start) {
+			out = append(out, models.HistoryPoint{Timestamp: start})
+		}
+		mergePoint(&out[len(out)-1], p)
+	}
+	return out
+}
+
+type seriesKey struct {
+	mtype string
+	name  string
+}
+
+// memHistory keeps raw samples and rollups in memory, it is used by MemStorage and FileStorage.
+// History is not persisted into the FileStorage file and is lost on restart.
+type memHistory struct {
+	raw     map[seriesKey][]models.HistoryPoint
+	rollups map[time.Duration]map[seriesKey][]models.HistoryPoint
+	mu      sync.Mutex
+}
+
+func newMemHistory() *memHistory {
+	rollups := make(map[time.Duration]map[seriesKey][]models.HistoryPoint)
+	for _, res := range resolutions {
+		rollups[res] = make(map[seriesKey][]models.HistoryPoint)
+	}
+	return &memHistory{
+		raw:     make(map[seriesKey][]models.HistoryPoint),
+		rollups: rollups,
+	}
+}
+
+func (h *memHistory) recordGauge(c *models.Config, name string, value float64) {
+	h.record(c, seriesKey{mtype: gauge, name: name}, models.HistoryPoint{
+		Timestamp: time.Now().UTC(),
+		Min:       value,
+		Max:       value,
+		Avg:       value,
+		Last:      value,
+		Count:     1,
+	})
+}
+
+func (h *memHistory) recordCounter(c *models.Config, name string, value int64, delta int64) {
+	v := float64(value)
+	h.record(c, seriesKey{mtype: counter, name: name}, models.HistoryPoint{
+		Timestamp: time.Now().UTC(),
+		Increase:  &delta,
+		Min:       v,
+		Max:       v,
+		Avg:       v,
+		Last:      v,
+		Count:     1,
+	})
+}
+
+// record appends the raw sample and drops samples of the series beyond raw retention, so raw history
+// stays bounded when rollups are disabled.
+func (h *memHistory) record(c *models.Config, key seriesKey, p models.HistoryPoint) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.raw[key] = append(h.raw[key], p)
+	points := h.raw[key]
+	if keep := retention(c, 0); keep > 0 {
+		cutoff := p.Timestamp.Add(-keep)
+		i := sort.Search(len(points), func(i int) bool {
+			return !points[i].Timestamp.Before(cutoff)
+		})
+		// Expired samples are released when append reallocates the slice.
+		points = points[i:]
+	}
+	h.raw[key] = points
+}
+
+// forget drops history of series matching the filter.
+func (h *memHistory) forget(match func(mtype, name string) bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, series := range append([]map[seriesKey][]models.HistoryPoint{h.raw}, h.rollupSeries()...) {
+		for key := range series {
+			if match(key.mtype, key.name) {
+				delete(series, key)
+			}
+		}
+	}
+}
+
+func (h *memHistory) rollupSeries() []map[seriesKey][]models.HistoryPoint {
+	series := make([]map[seriesKey][]models.HistoryPoint, 0, len(resolutions))
+	for _, res := range resolutions {
+		series = append(series, h.rollups[res])
+	}
+	return series
+}
+
+// rollup aggregates raw samples into resolution buckets and drops history beyond retention.
+// The last bucket of every series is recalculated as it may have been incomplete on the previous run.
+func (h *memHistory) rollup(c *models.Config, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	source := h.raw
+	for _, res := range resolutions {
+		target := h.rollups[res]
+		for key, points := range source {
+			buckets := target[key]
+			var start time.Time
+			if len(buckets) > 0 {
+				start = buckets[len(buckets)-1].Timestamp
+				buckets = buckets[:len(buckets)-1]
+			}
+			i := sort.Search(len(points), func(i int) bool {
+				return !points[i].Timestamp.Before(start)
+			})
+			target[key] = append(buckets, downsample(points[i:], res)...)
+		}
+		source = target
+	}
+
+	trim(h.raw, retention(c, 0), now)
+	for _, res := range resolutions {
+		trim(h.rollups[res], retention(c, res), now)
+	}
+}
+
+func trim(series map[seriesKey][]models.HistoryPoint, keep time.Duration, now time.Time) {
+	if keep <= 0 {
+		return
+	}
+	cutoff := now.Add(-keep)
+	for key, points := range series {
+		i := sort.Search(len(points), func(i int) bool {
+			return !points[i].Timestamp.Before(cutoff)
+		})
+		switch {
+		case i == len(points):
+			delete(series, key)
+		case i > 0:
+			series[key] = append([]models.HistoryPoint(nil), points[i:]...)
+		}
+	}
+}
+
+func (h *memHistory) query(mtype, name string, from, to time.Time, step time.Duration) []models.HistoryPoint {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := seriesKey{mtype: mtype, name: name}
+	res := pickResolution(step)
+
+	points := h.raw[key]
+	if res != 0 {
+		points = h.rollups[res][key]
+		from = from.UTC().Truncate(res)
+	}
+
+	i := sort.Search(len(points), func(i int) bool {
+		return !points[i].Timestamp.Before(from)
+	})
+	j := sort.Search(len(points), func(i int) bool {
+		return !points[i].Timestamp.Before(to)
+	})
+	if i >= j {
+		return []models.HistoryPoint{}
+	}
+
+	selected := append([]models.HistoryPoint(nil), points[i:j]...)
+	return downsample(selected, step)
+}
+
+// GetMetricHistory returns history of the metric between from and to, aggregated into buckets of step width.
+// Data is read from the coarsest resolution satisfying the step.
+func (p *PostgresStorage) GetMetricHistory(
+	c *models.Config, mtype, name string, from, to time.Time, step time.Duration,
+) ([]models.HistoryPoint, error) {
+	db := p.pool
+
+	ctx, cancel := p.queryContext(time.Duration(c.ContextTimeout) * time.Second)
+	defer cancel()
+
+	res := pickResolution(step)
+
+	var rows pgx.Rows
+	var err error
+	if res == 0 {
+		rows, err = db.Query(ctx, `SELECT ts, value AS min, value AS max, value AS avg, value AS last, delta, 1
+			FROM metric_samples
+			WHERE tenant = $1 AND mtype = $2 AND name = $3 AND ts >= $4 AND ts < $5 ORDER BY ts`,
+			p.tenant, mtype, name, from, to)
+	} else {
+		rows, err = db.Query(ctx, `SELECT bucket, min, max, avg, last, increase, count FROM metric_rollups
+			WHERE resolution = $1 AND tenant = $2 AND mtype = $3 AND name = $4 AND bucket >= $5 AND bucket < $6
+			ORDER BY bucket`,
+			int64(res/time.Second), p.tenant, mtype, name, from.UTC().Truncate(res), to)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s metric '%s' history: %w", mtype, name, err)
+	}
+	defer rows.Close()
+
+	points := make([]models.HistoryPoint, 0)
+	for rows.Next() {
+		var hp models.HistoryPoint
+		if err := rows.Scan(&hp.Timestamp, &hp.Min, &hp.Max, &hp.Avg, &hp.Last, &hp.Increase, &hp.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan %s metric '%s' history row: %w", mtype, name, err)
+		}
+		hp.Timestamp = hp.Timestamp.UTC()
+		points = append(points, hp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s metric '%s' history: %w", mtype, name, err)
+	}
+
+	return downsample(points, step), nil
+}
+
+// RollupHistory aggregates raw samples into 1m/1h/1d buckets and removes history beyond retention.
+// The latest bucket of each resolution is recalculated as it may have been incomplete on the previous run.
+// History of all tenants is rolled up, regardless of the tenant of p.
+func (p *PostgresStorage) RollupHistory(c *models.Config) error {
+	db := p.pool
+
+	var source time.Duration
+	for _, res := range resolutions {
+		ctx, cancel := p.queryContext(time.Duration(c.ContextTimeout) * time.Second)
+		err := retryOnConnErr(func() error {
+			var err error
+			if source == 0 {
+				_, err = db.Exec(ctx, rollupRawSQL, int64(res/time.Second))
+			} else {
+				_, err = db.Exec(ctx, rollupSQL, int64(res/time.Second), int64(source/time.Second))
+			}
+			return err //nolint:wrapcheck // wrapped by retryOnConnErr
+		})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to roll up history into %s buckets: %w", res, err)
+		}
+		source = res
+	}
+
+	if keep := retention(c, 0); keep > 0 {
+		if err := p.expireHistory(c, "DELETE FROM metric_samples WHERE ts < $1", keep); err != nil {
+			return fmt.Errorf("failed to expire raw samples: %w", err)
+		}
+	}
+	for _, res := range resolutions {
+		keep := retention(c, res)
+		if keep <= 0 {
+			continue
+		}
+		querySQL := fmt.Sprintf("DELETE FROM metric_rollups WHERE resolution = %d AND bucket < $1", int64(res/time.Second))
+		if err := p.expireHistory(c, querySQL, keep); err != nil {
+			return fmt.Errorf("failed to expire %s buckets: %w", res, err)
+		}
+	}
+	return nil
+}
+
+func (p *PostgresStorage) expireHistory(c *models.Config, querySQL string, keep time.Duration) error {
+	ctx, cancel := p.queryContext(time.Duration(c.ContextTimeout) * time.Second)
+	defer cancel()
+
+	if err := retryOnConnErr(func() error {
+		_, err := p.pool.Exec(ctx, querySQL, time.Now().Add(-keep))
+		return err //nolint:wrapcheck // wrapped by retryOnConnErr
+	}); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/vkupriya/go-metrics/internal/server/models"
+)
+
+// ErrTypeConflict is returned when a metric is written with a type other than the registered one.
+var ErrTypeConflict = errors.New("metric type conflict")
+
+// Metadata is registered on conflict only if the type matches, so no row is returned for a conflicting type.
+// Unit and description are filled in if they were not recorded on the first write.
+const registerMetadataSQL = `INSERT INTO metric_metadata (tenant, name, mtype, unit, description, labels)
+	VALUES($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (tenant, name) DO UPDATE SET
+		unit = CASE WHEN metric_metadata.unit = '' THEN EXCLUDED.unit ELSE metric_metadata.unit END,
+		description = CASE WHEN metric_metadata.description = ''
+			THEN EXCLUDED.description ELSE metric_metadata.description END,
+		labels = CASE WHEN metric_metadata.labels = '{}' THEN EXCLUDED.labels ELSE metric_metadata.labels END
+	WHERE metric_metadata.mtype = EXCLUDED.mtype
+	RETURNING mtype`
+
+func typeConflict(name, mtype, registered string) error {
+	return fmt.Errorf("%w: metric '%s' is registered as %s, not %s", ErrTypeConflict, name, registered, mtype)
+}
+
+// batchMetadata returns metadata of metrics written by a batch.
+func batchMetadata(g, cr models.Metrics) []models.MetricMetadata {
+	mds := make([]models.MetricMetadata, 0, len(g)+len(cr))
+	for _, ms := range []models.Metrics{g, cr} {
+		for _, m := range ms {
+			mds = append(mds, models.MetricMetadata{
+				Name:        m.ID,
+				MType:       m.MType,
+				Unit:        m.Unit,
+				Description: m.Description,
+				Labels:      m.Labels,
+			})
+		}
+	}
+	return mds
+}
+
+// checkTypes reports metrics passed more than once with different types.
+func checkTypes(mds []models.MetricMetadata) error {
+	types := make(map[string]string, len(mds))
+	for _, md := range mds {
+		if t, ok := types[md.Name]; ok && t != md.MType {
+			return typeConflict(md.Name, md.MType, t)
+		}
+		types[md.Name] = md.MType
+	}
+	return nil
+}
+
+// register records metadata of metrics, nothing is recorded if any of them conflicts with registered type.
+// The caller must hold m.mu.
+func (m *MemStorage) register(mds ...models.MetricMetadata) error {
+	if err := checkTypes(mds); err != nil {
+		return err
+	}
+	for _, md := range mds {
+		if r, ok := m.metadata[md.Name]; ok && r.MType != md.MType {
+			return typeConflict(md.Name, md.MType, r.MType)
+		}
+	}
+	for _, md := range mds {
+		r, ok := m.metadata[md.Name]
+		if !ok {
+			r = models.MetricMetadata{Name: md.Name, MType: md.MType, CreatedAt: time.Now().UTC()}
+		}
+		if r.Unit == "" {
+			r.Unit = md.Unit
+		}
+		if r.Description == "" {
+			r.Description = md.Description
+		}
+		if len(r.Labels) == 0 {
+			r.Labels = md.Labels
+		}
+		m.metadata[md.Name] = r
+	}
+	return nil
+}
+
+// unregister drops metadata of metrics matching the filter, m.mu must be held.
+func (m *MemStorage) unregister(match func(mtype, name string) bool) {
+	for name, md := range m.metadata {
+		if match(md.MType, name) {
+			delete(m.metadata, name)
+		}
+	}
+}
+
+// SetMetadata records unit and description of the metric, type conflicts are reported as ErrTypeConflict.
+func (m *MemStorage) SetMetadata(c *models.Config, md *models.MetricMetadata) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.register(*md)
+}
+
+// GetMetadata returns metadata of all registered metrics sorted by name.
+func (m *MemStorage) GetMetadata(c *models.Config) ([]models.MetricMetadata, error) {
+	m.mu.RLock()
+	mds := make([]models.MetricMetadata, 0, len(m.metadata))
+	for _, md := range m.metadata {
+		mds = append(mds, md)
+	}
+	m.mu.RUnlock()
+	sort.Slice(mds, func(i, j int) bool { return mds[i].Name < mds[j].Name })
+	return mds, nil
+}
+
+// SetMetadata records unit and description of the metric, type conflicts are reported as ErrTypeConflict.
+func (f *FileStorage) SetMetadata(c *models.Config, md *models.MetricMetadata) error {
+	if err := f.MemStorage.SetMetadata(c, md); err != nil {
+		return err
+	}
+	return f.saveOnUpdate(c)
+}
+
+// registerRestored records types of metrics restored from a file written without metadata.
+// Gauges are registered first, so names stored as both types keep the gauge type.
+func (m *MemStorage) registerRestored() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for name := range m.gauge {
+		if _, ok := m.metadata[name]; !ok {
+			_ = m.register(models.MetricMetadata{Name: name, MType: gauge})
+		}
+	}
+	for name := range m.counter {
+		if _, ok := m.metadata[name]; !ok {
+			_ = m.register(models.MetricMetadata{Name: name, MType: counter})
+		}
+	}
+}
+
+// register records metadata of metrics in a transaction, nothing is recorded if any of them conflicts
+// with registered type.
+func (p *PostgresStorage) register(c *models.Config, mds ...models.MetricMetadata) error {
+	if err := checkTypes(mds); err != nil {
+		return err
+	}
+	return p.inTx(c, func(ctx context.Context, tx pgx.Tx) error {
+		for _, md := range mds {
+			labels := md.Labels
+			if labels == nil {
+				labels = map[string]string{}
+			}
+			var registered string
+			err := tx.QueryRow(ctx, registerMetadataSQL,
+				p.tenant, md.Name, md.MType, md.Unit, md.Description, labels).Scan(&registered)
+			if errors.Is(err, pgx.ErrNoRows) {
+				if err := tx.QueryRow(ctx, "SELECT mtype FROM metric_metadata WHERE tenant = $1 AND name = $2",
+					p.tenant, md.Name).Scan(&registered); err != nil {
+					return fmt.Errorf("failed to read metadata of metric '%s': %w", md.Name, err)
+				}
+				return typeConflict(md.Name, md.MType, registered)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to register metadata of metric '%s': %w", md.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// SetMetadata records unit and description of the metric, type conflicts are reported as ErrTypeConflict.
+func (p *PostgresStorage) SetMetadata(c *models.Config, md *models.MetricMetadata) error {
+	return p.register(c, *md)
+}
+
+// GetMetadata returns metadata of all registered metrics sorted by name.
+func (p *PostgresStorage) GetMetadata(c *models.Config) ([]models.MetricMetadata, error) {
+	ctx, cancel := p.queryContext(time.Duration(c.ContextTimeout) * time.Second)
+	defer cancel()
+
+	rows, err := p.pool.Query(ctx, `SELECT name, mtype, unit, description, labels, created_at FROM metric_metadata
+		WHERE tenant = $1 ORDER BY name`, p.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query metric metadata: %w", err)
+	}
+	defer rows.Close()
+
+	mds := make([]models.MetricMetadata, 0)
+	for rows.Next() {
+		var md models.MetricMetadata
+		if err := rows.Scan(&md.Name, &md.MType, &md.Unit, &md.Description, &md.Labels, &md.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan metric metadata row: %w", err)
+		}
+		md.CreatedAt = md.CreatedAt.UTC()
+		mds = append(mds, md)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read metric metadata: %w", err)
+	}
+	return mds, nil
+}
+
+// hasPrefix returns a filter of metrics with names starting with prefix.
+func hasPrefix(prefix string) func(mtype, name string) bool {
+	return func(_, name string) bool { return strings.HasPrefix(name, prefix) }
+}
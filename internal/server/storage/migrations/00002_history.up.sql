@@ -0,0 +1,31 @@
+BEGIN TRANSACTION;
+
+CREATE TABLE metric_samples(
+    id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    mtype VARCHAR(16) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    ts TIMESTAMPTZ NOT NULL DEFAULT now(),
+    value DOUBLE PRECISION NOT NULL,
+    delta BIGINT
+);
+
+CREATE INDEX metric_samples_series_idx ON metric_samples (mtype, name, ts);
+CREATE INDEX metric_samples_ts_idx ON metric_samples (ts);
+
+CREATE TABLE metric_rollups(
+    resolution INTEGER NOT NULL,
+    mtype VARCHAR(16) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    bucket TIMESTAMPTZ NOT NULL,
+    min DOUBLE PRECISION NOT NULL,
+    max DOUBLE PRECISION NOT NULL,
+    avg DOUBLE PRECISION NOT NULL,
+    last DOUBLE PRECISION NOT NULL,
+    increase BIGINT,
+    count BIGINT NOT NULL,
+    PRIMARY KEY (resolution, mtype, name, bucket)
+);
+
+CREATE INDEX metric_rollups_bucket_idx ON metric_rollups (resolution, bucket);
+
+COMMIT;
@@ -0,0 +1,19 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE gauge ADD COLUMN tenant VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE gauge DROP CONSTRAINT gauge_name_key;
+ALTER TABLE gauge ADD CONSTRAINT gauge_tenant_name_key UNIQUE (tenant, name);
+
+ALTER TABLE counter ADD COLUMN tenant VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE counter DROP CONSTRAINT counter_name_key;
+ALTER TABLE counter ADD CONSTRAINT counter_tenant_name_key UNIQUE (tenant, name);
+
+ALTER TABLE metric_samples ADD COLUMN tenant VARCHAR(64) NOT NULL DEFAULT '';
+DROP INDEX metric_samples_series_idx;
+CREATE INDEX metric_samples_series_idx ON metric_samples (tenant, mtype, name, ts);
+
+ALTER TABLE metric_rollups ADD COLUMN tenant VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE metric_rollups DROP CONSTRAINT metric_rollups_pkey;
+ALTER TABLE metric_rollups ADD PRIMARY KEY (resolution, tenant, mtype, name, bucket);
+
+COMMIT;
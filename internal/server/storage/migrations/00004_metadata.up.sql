@@ -0,0 +1,19 @@
+BEGIN TRANSACTION;
+
+CREATE TABLE metric_metadata(
+    tenant VARCHAR(64) NOT NULL DEFAULT '',
+    name VARCHAR(255) NOT NULL,
+    mtype VARCHAR(16) NOT NULL,
+    unit VARCHAR(64) NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (tenant, name)
+);
+
+-- Existing metrics are registered with their stored type, gauges win for names stored as both types.
+INSERT INTO metric_metadata (tenant, name, mtype) SELECT tenant, name, 'gauge' FROM gauge
+ON CONFLICT DO NOTHING;
+INSERT INTO metric_metadata (tenant, name, mtype) SELECT tenant, name, 'counter' FROM counter
+ON CONFLICT DO NOTHING;
+
+COMMIT;
@@ -0,0 +1 @@
+ALTER TABLE metric_metadata ADD COLUMN labels JSONB NOT NULL DEFAULT '{}';
@@ -10,7 +10,10 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"maps"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-migrate/migrate/v4"
@@ -21,31 +24,78 @@ import (
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 
+	"github.com/vkupriya/go-metrics/internal/logging"
 	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/selfmetrics"
+	"github.com/vkupriya/go-metrics/internal/server/tenant"
 	"go.uber.org/zap"
 )
 
+const (
+	gauge   string = "gauge"
+	counter string = "counter"
+)
+
+// storeIntervalCheck is how often the file storage checks whether the store interval has passed.
+const storeIntervalCheck = time.Second
+
+const (
+	upsertGaugeSQL = `INSERT INTO gauge (tenant, name, value) VALUES($1, $2, $3)
+	ON CONFLICT (tenant, name) DO UPDATE SET value = $3`
+	// Concurrent first writes of a counter don't conflict, the delta is added to the row inserted first.
+	upsertCounterSQL = `INSERT INTO counter (tenant, name, value) VALUES($1, $2, $3)
+	ON CONFLICT (tenant, name) DO UPDATE SET value = counter.value + EXCLUDED.value
+	RETURNING value`
+)
+
+// ErrUnknownMetric is returned by the in-memory and file storages when the requested metric isn't stored.
+var ErrUnknownMetric = errors.New("unknown metric")
+
+func unknownMetric(name string) error {
+	return fmt.Errorf("%w %s", ErrUnknownMetric, name)
+}
+
+// MemStorage keeps metrics in memory, it is safe for concurrent use.
 type MemStorage struct {
-	gauge   map[string]float64
-	counter map[string]int64
+	gauge    map[string]float64
+	counter  map[string]int64
+	metadata map[string]models.MetricMetadata
+	history  *memHistory
+	tenant   string
+	mu       sync.RWMutex // guards gauge, counter and metadata
 }
 
 type FileStorage struct {
 	*MemStorage
+	lastSave *saveResult
+	path     string
+}
+
+// saveResult is the result of the last save of a file storage.
+type saveResult struct {
+	at  time.Time
+	err error
+	mu  sync.Mutex
 }
 
+// PostgresStorage keeps metrics of all tenants in shared tables, each instance is scoped to a single tenant.
 type PostgresStorage struct {
-	pool *pgxpool.Pool
+	ctx        context.Context //nolint:containedctx // parent of query contexts, see WithContext
+	pool       *pgxpool.Pool
+	tenant     string
+	migrations uint // schema version after migrations
 }
 
 func NewPostgresStorage(dsn string) (*PostgresStorage, error) {
-	if err := runMigrations(dsn); err != nil {
+	version, err := runMigrations(dsn)
+	if err != nil {
 		return nil, fmt.Errorf("failed to run DB migrations: %w", err)
 	}
 	poolCfg, err := pgxpool.ParseConfig(dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse the DSN: %w", err)
 	}
+	poolCfg.ConnConfig.Tracer = queryTracer{}
 
 	ctx := context.Background()
 
@@ -55,57 +105,93 @@ func NewPostgresStorage(dsn string) (*PostgresStorage, error) {
 	}
 
 	return &PostgresStorage{
-		pool: pool,
+		pool:       pool,
+		migrations: version,
 	}, nil
 }
 
+// ForTenant returns storage of the tenant sharing the connection pool with p.
+func (p *PostgresStorage) ForTenant(name string) *PostgresStorage {
+	return &PostgresStorage{
+		ctx:        p.ctx,
+		pool:       p.pool,
+		tenant:     name,
+		migrations: p.migrations,
+	}
+}
+
+// MigrationVersion returns version of the DB schema migrations were applied up to.
+func (p *PostgresStorage) MigrationVersion() uint {
+	return p.migrations
+}
+
 //go:embed migrations/*.sql
 var migrationsDir embed.FS
 
-func runMigrations(dsn string) error {
+// runMigrations applies migrations and returns version of the schema.
+func runMigrations(dsn string) (uint, error) {
 	d, err := iofs.New(migrationsDir, "migrations")
 	if err != nil {
-		return fmt.Errorf("failed to return an iofs driver: %w", err)
+		return 0, fmt.Errorf("failed to return an iofs driver: %w", err)
 	}
 
 	m, err := migrate.NewWithSourceInstance("iofs", d, dsn)
 	if err != nil {
-		return fmt.Errorf("failed to get a new migrate instance: %w", err)
+		return 0, fmt.Errorf("failed to get a new migrate instance: %w", err)
 	}
 	if err := m.Up(); err != nil {
 		if !errors.Is(err, migrate.ErrNoChange) {
-			return fmt.Errorf("failed to apply migrations to the DB: %w", err)
+			return 0, fmt.Errorf("failed to apply migrations to the DB: %w", err)
 		}
 	}
-	return nil
+	version, _, err := m.Version()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get version of the DB schema: %w", err)
+	}
+	return version, nil
 }
 
 func NewMemStorage(c *models.Config) (*MemStorage, error) {
+	return NewTenantMemStorage(c, tenant.Default)
+}
+
+// NewTenantMemStorage instantiates in memory storage of the tenant.
+func NewTenantMemStorage(c *models.Config, name string) (*MemStorage, error) {
 	return &MemStorage{
-		gauge:   make(map[string]float64),
-		counter: make(map[string]int64),
+		gauge:    make(map[string]float64),
+		counter:  make(map[string]int64),
+		metadata: make(map[string]models.MetricMetadata),
+		history:  newMemHistory(),
+		tenant:   name,
 	}, nil
 }
 
 func NewFileStorage(c *models.Config) (*FileStorage, error) {
-	logger := c.Logger
+	return NewTenantFileStorage(c, tenant.Default)
+}
+
+// NewTenantFileStorage instantiates file storage of the tenant, see TenantFilePath for the file name.
+func NewTenantFileStorage(c *models.Config, name string) (*FileStorage, error) {
+	logger := c.Logger.Named(logging.ComponentStorage)
+	path := TenantFilePath(c.FileStoragePath, name)
 
 	var FilePermissions fs.FileMode = 0o600
 	FileExists := false
 
 	gauge := make(map[string]float64)
 	counter := make(map[string]int64)
+	metadata := make(map[string]models.MetricMetadata)
 
 	// Checking if file exists
-	_, err := os.Stat(c.FileStoragePath)
+	_, err := os.Stat(path)
 	if err == nil {
 		FileExists = true
 	}
 
-	file, err := os.OpenFile(c.FileStoragePath, os.O_RDWR|os.O_CREATE, FilePermissions)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, FilePermissions)
 	if err != nil {
 		logger.Error("File open error", zap.Error(err))
-		return nil, fmt.Errorf("failed to create metrics db file %s", c.FileStoragePath)
+		return nil, fmt.Errorf("failed to create metrics db file %s", path)
 	}
 
 	defer func() {
@@ -116,8 +202,9 @@ func NewFileStorage(c *models.Config) (*FileStorage, error) {
 
 	if c.RestoreMetrics && FileExists {
 		var data struct {
-			Gauge   *map[string]float64 `json:"gauge"`
-			Counter *map[string]int64   `json:"counter"`
+			Gauge    *map[string]float64               `json:"gauge"`
+			Counter  *map[string]int64                 `json:"counter"`
+			Metadata *map[string]models.MetricMetadata `json:"metadata"`
 		}
 
 		err := json.NewDecoder(file).Decode(&data)
@@ -131,6 +218,9 @@ func NewFileStorage(c *models.Config) (*FileStorage, error) {
 		if data.Counter != nil {
 			counter = *data.Counter
 		}
+		if data.Metadata != nil {
+			metadata = *data.Metadata
+		}
 
 		if len(gauge) > 0 || len(counter) > 0 {
 			logger.Sugar().Infow(
@@ -142,60 +232,175 @@ func NewFileStorage(c *models.Config) (*FileStorage, error) {
 	}
 
 	f := &FileStorage{
-		&MemStorage{
-			gauge:   gauge,
-			counter: counter,
+		MemStorage: &MemStorage{
+			gauge:    gauge,
+			counter:  counter,
+			metadata: metadata,
+			history:  newMemHistory(),
+			tenant:   name,
 		},
+		lastSave: &saveResult{},
+		path:     path,
 	}
+	f.registerRestored()
 
-	if c.StoreInterval != 0 {
-		go f.SaveMetricsTicker(c)
-	}
+	f.SaveMetricsTicker(c)
 	return f, nil
 }
 
 func (m *MemStorage) UpdateGaugeMetric(c *models.Config, name string, value float64) (float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.admit(c, models.Metrics{{ID: name}}, nil); err != nil {
+		return 0, err
+	}
+	if err := m.register(models.MetricMetadata{Name: name, MType: gauge}); err != nil {
+		return 0, err
+	}
 	m.gauge[name] = value
+	m.history.recordGauge(c, name, value)
 	return m.gauge[name], nil
 }
 
 func (m *MemStorage) UpdateCounterMetric(c *models.Config, name string, value int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.admit(c, nil, models.Metrics{{ID: name}}); err != nil {
+		return 0, err
+	}
+	if err := m.register(models.MetricMetadata{Name: name, MType: counter}); err != nil {
+		return 0, err
+	}
 	m.counter[name] += value
+	m.history.recordCounter(c, name, m.counter[name], value)
 	return m.counter[name], nil
 }
 
 func (m *MemStorage) GetCounterMetric(c *models.Config, name string) (int64, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	v, ok := m.counter[name]
 	if ok {
 		return v, true, nil
 	}
-	return v, false, fmt.Errorf("unknown metric %s ", name)
+	return v, false, unknownMetric(name)
 }
 
 func (m *MemStorage) GetGaugeMetric(c *models.Config, name string) (float64, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	v, ok := m.gauge[name]
 	if ok {
 		return v, true, nil
 	}
-	return v, false, fmt.Errorf("unknown metric %s ", name)
+	return v, false, unknownMetric(name)
 }
 
+// GetAllMetrics returns copies of stored gauges and counters.
 func (m *MemStorage) GetAllMetrics(c *models.Config) (map[string]float64, map[string]int64, error) {
-	return m.gauge, m.counter, nil
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return maps.Clone(m.gauge), maps.Clone(m.counter), nil
 }
 
 func (m *MemStorage) UpdateBatch(c *models.Config, g models.Metrics, cr models.Metrics) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.admit(c, g, cr); err != nil {
+		return err
+	}
+	if err := m.register(batchMetadata(g, cr)...); err != nil {
+		return err
+	}
 	if g != nil || cr != nil {
 		for _, i := range g {
 			m.gauge[i.ID] = *i.Value
+			m.history.recordGauge(c, i.ID, *i.Value)
 		}
 		for _, i := range cr {
 			m.counter[i.ID] += *i.Delta
+			m.history.recordCounter(c, i.ID, m.counter[i.ID], *i.Delta)
 		}
 	}
 	return nil
 }
 
+// GetMetricHistory returns history of the metric between from and to, aggregated into buckets of step width.
+// Data is read from the coarsest resolution satisfying the step.
+func (m *MemStorage) GetMetricHistory(
+	c *models.Config, mtype, name string, from, to time.Time, step time.Duration,
+) ([]models.HistoryPoint, error) {
+	return m.history.query(mtype, name, from, to, step), nil
+}
+
+// RollupHistory aggregates raw samples into 1m/1h/1d buckets and removes history beyond retention.
+func (m *MemStorage) RollupHistory(c *models.Config) error {
+	m.history.rollup(c, time.Now().UTC())
+	return nil
+}
+
+// DeleteMetric removes the metric and its history, false is returned if the metric doesn't exist.
+func (m *MemStorage) DeleteMetric(c *models.Config, mtype, name string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ok bool
+	switch mtype {
+	case gauge:
+		_, ok = m.gauge[name]
+		delete(m.gauge, name)
+	case counter:
+		_, ok = m.counter[name]
+		delete(m.counter, name)
+	default:
+		return false, fmt.Errorf("unknown metric type %s", mtype)
+	}
+	m.history.forget(func(t, n string) bool { return t == mtype && n == name })
+	m.unregister(func(t, n string) bool { return t == mtype && n == name })
+	return ok, nil
+}
+
+// ResetCounter sets the counter metric to zero, false is returned if the counter doesn't exist.
+func (m *MemStorage) ResetCounter(c *models.Config, name string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.counter[name]; !ok {
+		return false, nil
+	}
+	m.counter[name] = 0
+	m.history.recordCounter(c, name, 0, 0)
+	return true, nil
+}
+
+// DeleteMetrics removes metrics of both types with names starting with prefix and returns their number.
+func (m *MemStorage) DeleteMetrics(c *models.Config, prefix string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for name := range m.gauge {
+		if strings.HasPrefix(name, prefix) {
+			delete(m.gauge, name)
+			n++
+		}
+	}
+	for name := range m.counter {
+		if strings.HasPrefix(name, prefix) {
+			delete(m.counter, name)
+			n++
+		}
+	}
+	m.history.forget(hasPrefix(prefix))
+	m.unregister(hasPrefix(prefix))
+	return n, nil
+}
+
 func (m *MemStorage) PingStore(c *models.Config) error {
 	return nil
 }
@@ -204,79 +409,120 @@ func (m *MemStorage) Close() {
 }
 
 func (f *FileStorage) UpdateGaugeMetric(c *models.Config, name string, value float64) (float64, error) {
-	f.gauge[name] = value
-	if c.StoreInterval == 0 {
-		err := f.SaveMetrics(c)
-		if err != nil {
-			return 0, fmt.Errorf("failed to save metrics to file: %w", err)
-		}
+	v, err := f.MemStorage.UpdateGaugeMetric(c, name, value)
+	if err != nil {
+		return 0, err
 	}
-	return f.gauge[name], nil
+	if err := f.saveOnUpdate(c); err != nil {
+		return 0, err
+	}
+	return v, nil
 }
 
 func (f *FileStorage) UpdateCounterMetric(c *models.Config, name string, value int64) (int64, error) {
-	f.counter[name] += value
-	if c.StoreInterval == 0 {
-		err := f.SaveMetrics(c)
-		if err != nil {
-			return 0, fmt.Errorf("failed to save metrics to file: %w", err)
-		}
+	v, err := f.MemStorage.UpdateCounterMetric(c, name, value)
+	if err != nil {
+		return 0, err
+	}
+	if err := f.saveOnUpdate(c); err != nil {
+		return 0, err
+	}
+	return v, nil
+}
+
+func (f *FileStorage) UpdateBatch(c *models.Config, g models.Metrics, cr models.Metrics) error {
+	if err := f.MemStorage.UpdateBatch(c, g, cr); err != nil {
+		return err
 	}
-	return f.counter[name], nil
+	if g != nil || cr != nil {
+		return f.saveOnUpdate(c)
+	}
+	return nil
 }
 
-func (f *FileStorage) GetCounterMetric(c *models.Config, name string) (int64, bool, error) {
-	v, ok := f.counter[name]
+// DeleteMetric removes the metric and its history, false is returned if the metric doesn't exist.
+func (f *FileStorage) DeleteMetric(c *models.Config, mtype, name string) (bool, error) {
+	ok, err := f.MemStorage.DeleteMetric(c, mtype, name)
+	if err != nil {
+		return false, err
+	}
 	if ok {
-		return v, true, nil
+		return ok, f.saveOnUpdate(c)
 	}
-	return v, false, fmt.Errorf("unknown counter metric %s ", name)
+	return ok, nil
 }
 
-func (f *FileStorage) GetGaugeMetric(c *models.Config, name string) (float64, bool, error) {
-	v, ok := f.gauge[name]
+// ResetCounter sets the counter metric to zero, false is returned if the counter doesn't exist.
+func (f *FileStorage) ResetCounter(c *models.Config, name string) (bool, error) {
+	ok, err := f.MemStorage.ResetCounter(c, name)
+	if err != nil {
+		return false, err
+	}
 	if ok {
-		return v, true, nil
+		return ok, f.saveOnUpdate(c)
 	}
-	return v, false, fmt.Errorf("unknown gauge metric %s ", name)
+	return ok, nil
 }
 
-func (f *FileStorage) GetAllMetrics(c *models.Config) (map[string]float64, map[string]int64, error) {
-	return f.gauge, f.counter, nil
+// DeleteMetrics removes metrics of both types with names starting with prefix and returns their number.
+func (f *FileStorage) DeleteMetrics(c *models.Config, prefix string) (int64, error) {
+	n, err := f.MemStorage.DeleteMetrics(c, prefix)
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		return n, f.saveOnUpdate(c)
+	}
+	return n, nil
 }
 
-func (f *FileStorage) UpdateBatch(c *models.Config, g models.Metrics, cr models.Metrics) error {
-	if g != nil || cr != nil {
-		for _, i := range g {
-			f.gauge[i.ID] = *i.Value
-		}
-		for _, i := range cr {
-			f.counter[i.ID] += *i.Delta
-		}
-		if c.StoreInterval == 0 {
-			err := f.SaveMetrics(c)
-			if err != nil {
-				return fmt.Errorf("failed to save metrics to file: %w", err)
-			}
-		}
+// saveOnUpdate writes metrics to file right away when the store interval is 0.
+func (f *FileStorage) saveOnUpdate(c *models.Config) error {
+	if c.Reloadable().StoreInterval != 0 {
+		return nil
+	}
+	if err := f.SaveMetrics(c); err != nil {
+		return fmt.Errorf("failed to save metrics to file: %w", err)
 	}
 	return nil
 }
 
+// SaveMetrics writes metrics to the file, duration and errors of saves are recorded in self-metrics.
 func (f *FileStorage) SaveMetrics(c *models.Config) error {
-	logger := c.Logger
+	start := time.Now()
+	err := f.saveMetrics(c)
+	selfmetrics.ObserveDuration("storage.file.save.duration", time.Since(start))
+	if err != nil {
+		selfmetrics.Inc("storage.file.save.errors")
+	}
+
+	f.lastSave.mu.Lock()
+	defer f.lastSave.mu.Unlock()
+	f.lastSave.at, f.lastSave.err = start, err
+	return err
+}
+
+// LastSave returns start time and error of the last save, zero time is returned if metrics haven't been saved yet.
+func (f *FileStorage) LastSave() (time.Time, error) {
+	f.lastSave.mu.Lock()
+	defer f.lastSave.mu.Unlock()
+	return f.lastSave.at, f.lastSave.err
+}
+
+func (f *FileStorage) saveMetrics(c *models.Config) error {
+	logger := c.Logger.Named(logging.ComponentStorage)
 	logger.Sugar().Info("Saving metrics to file db.")
 	var FilePermissions fs.FileMode = 0o600
 
-	_, err := os.Stat(c.FileStoragePath)
+	_, err := os.Stat(f.path)
 	if err != nil {
 		zap.L().Warn("File doesn't exist")
 	}
 
-	file, err := os.OpenFile(c.FileStoragePath, os.O_RDWR|os.O_CREATE, FilePermissions)
+	file, err := os.OpenFile(f.path, os.O_RDWR|os.O_CREATE, FilePermissions)
 	if err != nil {
 		logger.Sugar().Error(zap.Error(err))
-		return fmt.Errorf("failed to open file %s: %w", c.FileStoragePath, err)
+		return fmt.Errorf("failed to open file %s: %w", f.path, err)
 	}
 	defer func() {
 		if err := file.Close(); err != nil {
@@ -284,9 +530,13 @@ func (f *FileStorage) SaveMetrics(c *models.Config) error {
 		}
 	}()
 
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
 	data := make(map[string]any)
 	data["gauge"] = f.gauge
 	data["counter"] = f.counter
+	data["metadata"] = f.metadata
 
 	if err := json.NewEncoder(file).Encode(data); err != nil {
 		logger.Sugar().Error("File encode error", zap.Error(err))
@@ -297,24 +547,29 @@ func (f *FileStorage) SaveMetrics(c *models.Config) error {
 	return nil
 }
 
+// SaveMetricsTicker saves metrics every store interval in background. The interval is reloadable,
+// it is checked every storeIntervalCheck, so a shorter interval takes effect without waiting
+// for the longer one. While the interval is 0 metrics are saved on updates instead.
+// Failed saves are logged and reported by LastSave, the next save is attempted after the interval.
 func (f *FileStorage) SaveMetricsTicker(c *models.Config) {
-	if c.StoreInterval == 0 {
-		return
-	}
-
-	logger := c.Logger
+	logger := c.Logger.Named(logging.ComponentStorage)
 	logger.Sugar().Infow(
 		`SaveMetricsTicker started`,
-		zap.Int64(`StoreInterval`, c.StoreInterval),
+		zap.Int64(`StoreInterval`, c.Reloadable().StoreInterval),
 	)
 
-	saveTicker := time.NewTicker(time.Duration(c.StoreInterval) * time.Second)
+	saveTicker := time.NewTicker(storeIntervalCheck)
 
 	go func() {
-		for range saveTicker.C {
+		last := time.Now()
+		for now := range saveTicker.C {
+			interval := time.Duration(c.Reloadable().StoreInterval) * time.Second
+			if interval == 0 || now.Sub(last) < interval {
+				continue
+			}
+			last = now
 			if err := f.SaveMetrics(c); err != nil {
 				logger.Sugar().Error("failed to save metrics to file using ticker", zap.Error(err))
-				return
 			}
 		}
 	}()
@@ -328,67 +583,74 @@ func (f *FileStorage) Close() {
 }
 
 func (p *PostgresStorage) UpdateGaugeMetric(c *models.Config, name string, value float64) (float64, error) {
-	db := p.pool
-	mtype := "gauge"
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.ContextTimeout)*time.Second)
-	defer cancel()
+	mtype := gauge
 
-	querySQL := "INSERT INTO gauge (name, value) VALUES($1, $2) ON CONFLICT (name) DO UPDATE SET value = $2"
+	if err := p.admit(c, models.Metrics{{ID: name}}, nil); err != nil {
+		return value, err
+	}
+	if err := p.register(c, models.MetricMetadata{Name: name, MType: mtype}); err != nil {
+		return value, err
+	}
 
-	_, err := db.Exec(ctx, querySQL, name, value)
-	if err != nil {
-		return value, fmt.Errorf("failed to insert/update %s metric into Postgres DB: %w", mtype, err)
+	// Value and its history sample are written together, so a retried write doesn't lose the sample.
+	if err := retryOnConnErr(func() error {
+		return p.inTx(c, func(ctx context.Context, tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, upsertGaugeSQL, p.tenant, name, value); err != nil {
+				return fmt.Errorf("failed to insert/update %s metric into Postgres DB: %w", mtype, err)
+			}
+			if _, err := tx.Exec(ctx, insertSampleSQL, p.tenant, mtype, name, value, nil); err != nil {
+				return fmt.Errorf("failed to record %s metric sample into Postgres DB: %w", mtype, err)
+			}
+			return nil
+		})
+	}); err != nil {
+		return value, fmt.Errorf("failed to update gauge metric '%s': %w", name, err)
 	}
 	return value, nil
 }
 
 func (p *PostgresStorage) UpdateCounterMetric(c *models.Config, name string, value int64) (int64, error) {
-	db := p.pool
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.ContextTimeout)*time.Second)
-	defer cancel()
-
-	v, e, err := p.GetCounterMetric(c, name)
-	if err != nil {
-		return value, fmt.Errorf("failed query: %w", err)
+	if err := p.admit(c, nil, models.Metrics{{ID: name}}); err != nil {
+		return value, err
+	}
+	if err := p.register(c, models.MetricMetadata{Name: name, MType: counter}); err != nil {
+		return value, err
 	}
 
-	if !e {
-		if err := retryOnConnErr(func() error {
-			_, err := db.Exec(ctx, "INSERT INTO counter (name, value) VALUES($1, $2)", name, value)
-			if err != nil {
-				return fmt.Errorf("error inserting into db: %w", err)
-			}
-			return nil
-		}); err != nil {
-			return value, fmt.Errorf("failed to insert counter metric '%s': %w", name, err)
-		}
-	} else {
-		value += v
-		if err := retryOnConnErr(func() error {
-			_, err := db.Exec(ctx, "UPDATE counter SET value = $1 WHERE name = $2", value, name)
-			if err != nil {
-				return fmt.Errorf("error updating db: %w", err)
-			}
-			return nil
-		}); err != nil {
-			return value, fmt.Errorf("failed to update counter metric '%s': %w", name, err)
-		}
+	// The counter is written with its history sample in one transaction, so concurrent and retried writes
+	// neither lose deltas nor samples.
+	delta := value
+	if err := retryOnConnErr(func() error {
+		return p.inTx(c, func(ctx context.Context, tx pgx.Tx) error {
+			return upsertCounter(ctx, tx, p.tenant, name, delta, &value)
+		})
+	}); err != nil {
+		return value, fmt.Errorf("failed to update counter metric '%s': %w", name, err)
 	}
 
 	return value, nil
 }
 
+// upsertCounter adds delta to the counter and records the history sample, the new total is stored into v.
+func upsertCounter(ctx context.Context, tx pgx.Tx, tenantName, name string, delta int64, v *int64) error {
+	if err := tx.QueryRow(ctx, upsertCounterSQL, tenantName, name, delta).Scan(v); err != nil {
+		return fmt.Errorf("failed to write counter metric '%s': %w", name, err)
+	}
+	if _, err := tx.Exec(ctx, insertSampleSQL, tenantName, counter, name, float64(*v), delta); err != nil {
+		return fmt.Errorf("failed to record counter metric sample '%s': %w", name, err)
+	}
+	return nil
+}
+
 func (p *PostgresStorage) GetCounterMetric(c *models.Config, name string) (int64, bool, error) {
 	db := p.pool
 	var i int64
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.ContextTimeout)*time.Second)
+	ctx, cancel := p.queryContext(time.Duration(c.ContextTimeout) * time.Second)
 	defer cancel()
 
 	if err := retryOnConnErr(func() error {
-		return db.QueryRow(ctx, "SELECT value FROM counter WHERE name=$1", name).Scan(&i)
+		return db.QueryRow(ctx, "SELECT value FROM counter WHERE tenant = $1 AND name = $2", p.tenant, name).Scan(&i)
 	}); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return i, false, nil
@@ -401,12 +663,12 @@ func (p *PostgresStorage) GetCounterMetric(c *models.Config, name string) (int64
 func (p *PostgresStorage) GetGaugeMetric(c *models.Config, name string) (float64, bool, error) {
 	db := p.pool
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.ContextTimeout)*time.Second)
+	ctx, cancel := p.queryContext(time.Duration(c.ContextTimeout) * time.Second)
 	defer cancel()
 	var f float64
 
 	if err := retryOnConnErr(func() error {
-		return db.QueryRow(ctx, "SELECT value FROM gauge WHERE name=$1", name).Scan(&f)
+		return db.QueryRow(ctx, "SELECT value FROM gauge WHERE tenant = $1 AND name = $2", p.tenant, name).Scan(&f)
 	}); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return f, false, nil
@@ -417,16 +679,16 @@ func (p *PostgresStorage) GetGaugeMetric(c *models.Config, name string) (float64
 }
 
 func (p *PostgresStorage) GetAllMetrics(c *models.Config) (map[string]float64, map[string]int64, error) {
-	logger := c.Logger
+	logger := c.Logger.Named(logging.ComponentStorage)
 	gaugeAll := make(map[string]float64)
 	counterAll := make(map[string]int64)
 
 	db := p.pool
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.ContextTimeout)*time.Second)
+	ctx, cancel := p.queryContext(time.Duration(c.ContextTimeout) * time.Second)
 	defer cancel()
 
-	rows, err := db.Query(ctx, "SELECT name, value FROM gauge")
+	rows, err := db.Query(ctx, "SELECT name, value FROM gauge WHERE tenant = $1", p.tenant)
 	if err != nil {
 		return nil, nil, fmt.Errorf("gauge table query error: %w", err)
 	}
@@ -445,7 +707,7 @@ func (p *PostgresStorage) GetAllMetrics(c *models.Config) (map[string]float64, m
 	if err = rows.Err(); err != nil {
 		logger.Sugar().Error("errors reading rows.", zap.Error(err))
 	}
-	rows, err = db.Query(ctx, "SELECT name, value FROM counter")
+	rows, err = db.Query(ctx, "SELECT name, value FROM counter WHERE tenant = $1", p.tenant)
 	if err != nil {
 		return nil, nil, fmt.Errorf("counter table query error: %w", err)
 	}
@@ -469,29 +731,33 @@ func (p *PostgresStorage) GetAllMetrics(c *models.Config) (map[string]float64, m
 }
 
 func (p *PostgresStorage) UpdateBatch(c *models.Config, g models.Metrics, cr models.Metrics) error {
-	logger := c.Logger
+	logger := c.Logger.Named(logging.ComponentStorage)
 	db := p.pool
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.ContextTimeout)*time.Second)
+	if err := p.admit(c, g, cr); err != nil {
+		return err
+	}
+	if err := p.register(c, batchMetadata(g, cr)...); err != nil {
+		return err
+	}
+
+	ctx, cancel := p.queryContext(time.Duration(c.ContextTimeout) * time.Second)
 	defer cancel()
 
 	// processing counter metrics
-	for _, i := range cr {
-		v, e, err := p.GetCounterMetric(c, i.ID)
-		if err != nil {
-			return fmt.Errorf("failed query: %w", err)
-		}
-		if !e {
-			_, err := db.Exec(ctx, "INSERT INTO counter (name, value) VALUES($1, $2)", i.ID, i.Delta)
-			if err != nil {
-				return fmt.Errorf("failed to insert counter metric '%s': %w", i.ID, err)
-			}
-		} else {
-			v += *i.Delta
-			_, err := db.Exec(ctx, "UPDATE counter SET value = $1 WHERE name = $2", v, i.ID)
-			if err != nil {
-				return fmt.Errorf("failed to update counter metric '%s': %w", i.ID, err)
-			}
+	if len(cr) > 0 {
+		if err := retryOnConnErr(func() error {
+			return p.inTx(c, func(ctx context.Context, tx pgx.Tx) error {
+				var v int64
+				for _, i := range cr {
+					if err := upsertCounter(ctx, tx, p.tenant, i.ID, *i.Delta, &v); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		}); err != nil {
+			return fmt.Errorf("failed to update counter metrics: %w", err)
 		}
 	}
 
@@ -501,9 +767,11 @@ func (p *PostgresStorage) UpdateBatch(c *models.Config, g models.Metrics, cr mod
 		if err != nil {
 			return fmt.Errorf("failed to start transaction: %w", err)
 		}
-		querySQL := "INSERT INTO gauge (name, value) VALUES($1, $2) ON CONFLICT (name) DO UPDATE SET value = $2"
 		for _, i := range g {
-			_, err := tx.Exec(ctx, querySQL, i.ID, i.Value)
+			_, err := tx.Exec(ctx, upsertGaugeSQL, p.tenant, i.ID, i.Value)
+			if err == nil {
+				_, err = tx.Exec(ctx, insertSampleSQL, p.tenant, gauge, i.ID, i.Value, nil)
+			}
 			if err != nil {
 				logger.Sugar().Error(zap.Error(err))
 				if err := tx.Rollback(ctx); err != nil {
@@ -520,11 +788,108 @@ func (p *PostgresStorage) UpdateBatch(c *models.Config, g models.Metrics, cr mod
 	return nil
 }
 
+// DeleteMetric removes the metric and its history, false is returned if the metric doesn't exist.
+func (p *PostgresStorage) DeleteMetric(c *models.Config, mtype, name string) (bool, error) {
+	if mtype != gauge && mtype != counter {
+		return false, fmt.Errorf("unknown metric type %s", mtype)
+	}
+
+	var n int64
+	if err := p.inTx(c, func(ctx context.Context, tx pgx.Tx) error {
+		// table name is one of the two known metric types checked above
+		tag, err := tx.Exec(ctx, "DELETE FROM "+mtype+" WHERE tenant = $1 AND name = $2", p.tenant, name)
+		if err != nil {
+			return fmt.Errorf("failed to delete %s metric '%s': %w", mtype, name, err)
+		}
+		n = tag.RowsAffected()
+		if _, err := tx.Exec(ctx, "DELETE FROM metric_samples WHERE tenant = $1 AND mtype = $2 AND name = $3",
+			p.tenant, mtype, name); err != nil {
+			return fmt.Errorf("failed to delete %s metric '%s' samples: %w", mtype, name, err)
+		}
+		if _, err := tx.Exec(ctx, "DELETE FROM metric_rollups WHERE tenant = $1 AND mtype = $2 AND name = $3",
+			p.tenant, mtype, name); err != nil {
+			return fmt.Errorf("failed to delete %s metric '%s' rollups: %w", mtype, name, err)
+		}
+		if _, err := tx.Exec(ctx, "DELETE FROM metric_metadata WHERE tenant = $1 AND mtype = $2 AND name = $3",
+			p.tenant, mtype, name); err != nil {
+			return fmt.Errorf("failed to delete %s metric '%s' metadata: %w", mtype, name, err)
+		}
+		return nil
+	}); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// ResetCounter sets the counter metric to zero, false is returned if the counter doesn't exist.
+func (p *PostgresStorage) ResetCounter(c *models.Config, name string) (bool, error) {
+	var n int64
+	if err := retryOnConnErr(func() error {
+		return p.inTx(c, func(ctx context.Context, tx pgx.Tx) error {
+			tag, err := tx.Exec(ctx, "UPDATE counter SET value = 0 WHERE tenant = $1 AND name = $2", p.tenant, name)
+			if err != nil {
+				return fmt.Errorf("error resetting counter in db: %w", err)
+			}
+			if n = tag.RowsAffected(); n == 0 {
+				return nil
+			}
+			if _, err := tx.Exec(ctx, insertSampleSQL, p.tenant, counter, name, 0, 0); err != nil {
+				return fmt.Errorf("failed to record counter metric sample: %w", err)
+			}
+			return nil
+		})
+	}); err != nil {
+		return false, fmt.Errorf("failed to reset counter metric '%s': %w", name, err)
+	}
+	return n > 0, nil
+}
+
+// DeleteMetrics removes metrics of both types with names starting with prefix and returns their number.
+func (p *PostgresStorage) DeleteMetrics(c *models.Config, prefix string) (int64, error) {
+	var n int64
+	if err := p.inTx(c, func(ctx context.Context, tx pgx.Tx) error {
+		for _, table := range []string{gauge, counter, "metric_samples", "metric_rollups", "metric_metadata"} {
+			tag, err := tx.Exec(ctx, "DELETE FROM "+table+" WHERE tenant = $1 AND starts_with(name, $2)", p.tenant, prefix)
+			if err != nil {
+				return fmt.Errorf("failed to delete metrics from %s table: %w", table, err)
+			}
+			if table == gauge || table == counter {
+				n += tag.RowsAffected()
+			}
+		}
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// inTx runs f in a transaction which is rolled back if f fails.
+func (p *PostgresStorage) inTx(c *models.Config, f func(ctx context.Context, tx pgx.Tx) error) error {
+	ctx, cancel := p.queryContext(time.Duration(c.ContextTimeout) * time.Second)
+	defer cancel()
+
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	if err := f(ctx, tx); err != nil {
+		if errRb := tx.Rollback(ctx); errRb != nil {
+			return fmt.Errorf("failed to rollback transaction: %w", errRb)
+		}
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
 func (p *PostgresStorage) PingStore(c *models.Config) error {
-	logger := c.Logger
+	logger := c.Logger.Named(logging.ComponentStorage)
 	db := p.pool
 
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	ctx, cancel := p.queryContext(1 * time.Second)
 	defer cancel()
 
 	if err := retryOnConnErr(func() error {
@@ -4,11 +4,13 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -184,7 +186,7 @@ func getHostPort(hostPort string) (string, uint16, error) {
 //nolint:dupl // TestUpdateGaugeMetric follows same pattern
 func TestUpdateGaugeMetric(t *testing.T) {
 	dsn := getDSN()
-	if err := runMigrations(dsn); err != nil {
+	if _, err := runMigrations(dsn); err != nil {
 		t.Errorf("failed to run migrations using dsn %s: %v", dsn, err)
 		return
 	}
@@ -234,7 +236,7 @@ func TestUpdateGaugeMetric(t *testing.T) {
 
 func TestGetGaugeMetric(t *testing.T) {
 	dsn := getDSN()
-	if err := runMigrations(dsn); err != nil {
+	if _, err := runMigrations(dsn); err != nil {
 		t.Errorf("failed to run migrations using dsn %s: %v", dsn, err)
 		return
 	}
@@ -289,7 +291,7 @@ func TestGetGaugeMetric(t *testing.T) {
 //nolint:dupl // storage integration tests follow same pattern
 func TestUpdateCounterMetric(t *testing.T) {
 	dsn := getDSN()
-	if err := runMigrations(dsn); err != nil {
+	if _, err := runMigrations(dsn); err != nil {
 		t.Errorf("failed to run migrations using dsn %s: %v", dsn, err)
 		return
 	}
@@ -310,7 +312,7 @@ func TestUpdateCounterMetric(t *testing.T) {
 		{
 			name: "updating_counter_metric:OK",
 			metric: metric{
-				name:  "test",
+				name:  "testcounter",
 				value: 2056,
 			},
 			ExpectedErr: nil,
@@ -339,7 +341,7 @@ func TestUpdateCounterMetric(t *testing.T) {
 
 func TestGetCounterMetric(t *testing.T) {
 	dsn := getDSN()
-	if err := runMigrations(dsn); err != nil {
+	if _, err := runMigrations(dsn); err != nil {
 		t.Errorf("failed to run migrations using dsn %s: %v", dsn, err)
 		return
 	}
@@ -360,7 +362,7 @@ func TestGetCounterMetric(t *testing.T) {
 		{
 			name: "get_counter_metric:OK",
 			metric: metric{
-				name:  "test",
+				name:  "testcounter",
 				value: 2056,
 			},
 			ExpectedErr: nil,
@@ -401,7 +403,7 @@ func TestGetCounterMetric(t *testing.T) {
 
 func TestUpdateBatch(t *testing.T) {
 	dsn := getDSN()
-	if err := runMigrations(dsn); err != nil {
+	if _, err := runMigrations(dsn); err != nil {
 		t.Errorf("failed to run migrations using dsn %s: %v", dsn, err)
 		return
 	}
@@ -470,7 +472,7 @@ func TestUpdateBatch(t *testing.T) {
 
 func TestGetAllMetrics(t *testing.T) {
 	dsn := getDSN()
-	if err := runMigrations(dsn); err != nil {
+	if _, err := runMigrations(dsn); err != nil {
 		t.Errorf("failed to run migrations using dsn %s: %v", dsn, err)
 		return
 	}
@@ -511,7 +513,7 @@ func TestGetAllMetrics(t *testing.T) {
 
 func TestPingStore(t *testing.T) {
 	dsn := getDSN()
-	if err := runMigrations(dsn); err != nil {
+	if _, err := runMigrations(dsn); err != nil {
 		t.Errorf("failed to run migrations using dsn %s: %v", dsn, err)
 		return
 	}
@@ -554,6 +556,292 @@ func TestPingStore(t *testing.T) {
 	}
 }
 
+func TestMetricHistory(t *testing.T) {
+	dsn := getDSN()
+	if _, err := runMigrations(dsn); err != nil {
+		t.Errorf("failed to run migrations using dsn %s: %v", dsn, err)
+		return
+	}
+
+	cfg := models.Config{
+		ContextTimeout: 10,
+	}
+
+	db, err := NewPostgresStorage(dsn)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	for _, v := range []float64{10, 30, 20} {
+		if _, err := db.UpdateGaugeMetric(&cfg, "testhistory01", v); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if err := db.RollupHistory(&cfg); err != nil {
+		t.Error(err)
+		return
+	}
+
+	cases := []struct {
+		name          string
+		step          time.Duration
+		ExpectedCount int
+	}{
+		{
+			name:          "raw_history:OK",
+			step:          0,
+			ExpectedCount: 3,
+		},
+		{
+			name:          "hourly_history:OK",
+			step:          time.Hour,
+			ExpectedCount: 1,
+		},
+	}
+
+	for i, tc := range cases {
+		i, tc := i, tc
+
+		t.Run(fmt.Sprintf("test #%d: %s", i, tc.name), func(t *testing.T) {
+			points, err := db.GetMetricHistory(&cfg, "gauge", "testhistory01", time.Now().Add(-time.Hour),
+				time.Now().Add(time.Minute), tc.step)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if len(points) != tc.ExpectedCount {
+				t.Errorf("expected %d history points, got %d", tc.ExpectedCount, len(points))
+				return
+			}
+			last := points[len(points)-1]
+			if last.Last != 20 {
+				t.Errorf("expected last value 20, got %v", last.Last)
+			}
+		})
+	}
+}
+
+func TestDeleteAndResetMetrics(t *testing.T) {
+	dsn := getDSN()
+	if _, err := runMigrations(dsn); err != nil {
+		t.Errorf("failed to run migrations using dsn %s: %v", dsn, err)
+		return
+	}
+
+	cfg := models.Config{
+		ContextTimeout: 10,
+	}
+
+	db, err := NewPostgresStorage(dsn)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	for _, name := range []string{"testdelete01", "testdelete02"} {
+		if _, err := db.UpdateGaugeMetric(&cfg, name, 1); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if _, err := db.UpdateCounterMetric(&cfg, "testreset01", 5); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if ok, err := db.DeleteMetric(&cfg, "gauge", "testdelete01"); err != nil || !ok {
+		t.Errorf("failed to delete gauge metric: %v", err)
+	}
+	if ok, err := db.DeleteMetric(&cfg, "gauge", "testdelete01"); err != nil || ok {
+		t.Errorf("deleted gauge metric twice: %v", err)
+	}
+	if ok, err := db.ResetCounter(&cfg, "testreset01"); err != nil || !ok {
+		t.Errorf("failed to reset counter metric: %v", err)
+	}
+	if v, _, err := db.GetCounterMetric(&cfg, "testreset01"); err != nil || v != 0 {
+		t.Errorf("counter metric is not reset: %d, %v", v, err)
+	}
+	if n, err := db.DeleteMetrics(&cfg, "testdelete"); err != nil || n != 1 {
+		t.Errorf("expected 1 metric deleted by prefix, got %d: %v", n, err)
+	}
+}
+
+func TestConcurrentCounterWrites(t *testing.T) {
+	dsn := getDSN()
+	if _, err := runMigrations(dsn); err != nil {
+		t.Errorf("failed to run migrations using dsn %s: %v", dsn, err)
+		return
+	}
+
+	cfg := models.Config{
+		ContextTimeout: 10,
+	}
+	db, err := NewPostgresStorage(dsn)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	// First writes of a new counter race to insert the row, none of them may fail or lose its delta.
+	const writers = 8
+	var d int64 = 1
+	errs := make(chan error, 2*writers)
+	var wg sync.WaitGroup
+	for range writers {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := db.UpdateCounterMetric(&cfg, "testconcurrent01", 1)
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			errs <- db.UpdateBatch(&cfg, nil, models.Metrics{{ID: "testconcurrent02", MType: "counter", Delta: &d}})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent counter write failed: %v", err)
+		}
+	}
+	for _, name := range []string{"testconcurrent01", "testconcurrent02"} {
+		if v, _, err := db.GetCounterMetric(&cfg, name); err != nil || v != writers {
+			t.Errorf("expected counter %s of %d, got %d: %v", name, writers, v, err)
+		}
+	}
+}
+
+func TestTenants(t *testing.T) {
+	dsn := getDSN()
+	if _, err := runMigrations(dsn); err != nil {
+		t.Errorf("failed to run migrations using dsn %s: %v", dsn, err)
+		return
+	}
+
+	cfg := models.Config{
+		ContextTimeout: 10,
+		TenantQuotas:   map[string]int64{"team-b": 1},
+	}
+
+	db, err := NewPostgresStorage(dsn)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	a, b := db.ForTenant("team-a"), db.ForTenant("team-b")
+	if _, err := a.UpdateGaugeMetric(&cfg, "testtenant01", 1); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := b.UpdateGaugeMetric(&cfg, "testtenant01", 2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if v, _, err := a.GetGaugeMetric(&cfg, "testtenant01"); err != nil || v != 1 {
+		t.Errorf("expected gauge value 1 of tenant team-a, got %v: %v", v, err)
+	}
+	if v, _, err := b.GetGaugeMetric(&cfg, "testtenant01"); err != nil || v != 2 {
+		t.Errorf("expected gauge value 2 of tenant team-b, got %v: %v", v, err)
+	}
+	if _, ok, err := db.GetGaugeMetric(&cfg, "testtenant01"); err != nil || ok {
+		t.Errorf("gauge of tenant leaked into default tenant: %v", err)
+	}
+	if _, err := b.UpdateCounterMetric(&cfg, "testtenant02", 1); !errors.Is(err, ErrSeriesQuota) {
+		t.Errorf("expected series quota error, got %v", err)
+	}
+	if _, err := b.UpdateGaugeMetric(&cfg, "testtenant01", 3); err != nil {
+		t.Errorf("update of existing series failed: %v", err)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	dsn := getDSN()
+	if _, err := runMigrations(dsn); err != nil {
+		t.Errorf("failed to run migrations using dsn %s: %v", dsn, err)
+		return
+	}
+
+	cfg := models.Config{
+		ContextTimeout: 10,
+	}
+
+	db, err := NewPostgresStorage(dsn)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	if _, err := db.UpdateGaugeMetric(&cfg, "testmeta01", 1); err != nil {
+		t.Error(err)
+		return
+	}
+	md := models.MetricMetadata{
+		Name:        "testmeta01",
+		MType:       "gauge",
+		Unit:        "bytes",
+		Description: "test gauge",
+		Labels:      map[string]string{"env": "test"},
+	}
+	if err := db.SetMetadata(&cfg, &md); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := db.UpdateCounterMetric(&cfg, "testmeta01", 1); !errors.Is(err, ErrTypeConflict) {
+		t.Errorf("expected type conflict error, got %v", err)
+	}
+	delta := int64(1)
+	cr := models.Metrics{
+		{ID: "testmeta02", MType: "counter", Delta: &delta},
+		{ID: "testmeta01", MType: "counter", Delta: &delta},
+	}
+	if err := db.UpdateBatch(&cfg, nil, cr); !errors.Is(err, ErrTypeConflict) {
+		t.Errorf("expected type conflict error of batch, got %v", err)
+	}
+	if _, ok, err := db.GetCounterMetric(&cfg, "testmeta02"); err != nil || ok {
+		t.Errorf("counter of rejected batch is stored: %v", err)
+	}
+
+	mds, err := db.GetMetadata(&cfg)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var found bool
+	for _, m := range mds {
+		switch m.Name {
+		case "testmeta01":
+			found = true
+			if m.MType != "gauge" || m.Unit != "bytes" || m.Description != "test gauge" || m.Labels["env"] != "test" {
+				t.Errorf("unexpected metadata of gauge metric: %+v", m)
+			}
+		case "testmeta02":
+			t.Errorf("metadata of rejected batch is stored: %+v", m)
+		}
+	}
+	if !found {
+		t.Error("metadata of gauge metric is not found")
+	}
+
+	if _, err := db.DeleteMetric(&cfg, "gauge", "testmeta01"); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := db.UpdateCounterMetric(&cfg, "testmeta01", 1); err != nil {
+		t.Errorf("type of deleted metric is not forgotten: %v", err)
+	}
+}
+
 func checkErrors(actual error, expected error) error {
 	if actual == nil && expected == nil {
 		return nil
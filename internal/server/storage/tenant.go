@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/tenant"
+)
+
+// ErrSeriesQuota is returned when a write would store more series than the tenant quota allows.
+var ErrSeriesQuota = errors.New("series quota of the tenant is exceeded")
+
+// TenantFilePath returns metrics file of the tenant, tenant name is inserted before the file extension.
+// The default tenant keeps using the configured file.
+func TenantFilePath(path, name string) string {
+	if name == tenant.Default {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + name + ext
+}
+
+// seriesNames returns distinct names of metrics.
+func seriesNames(ms models.Metrics) []string {
+	seen := make(map[string]struct{}, len(ms))
+	names := make([]string, 0, len(ms))
+	for _, m := range ms {
+		if _, ok := seen[m.ID]; !ok {
+			seen[m.ID] = struct{}{}
+			names = append(names, m.ID)
+		}
+	}
+	return names
+}
+
+// checkQuota returns ErrSeriesQuota if stored and added series together exceed the quota of the tenant.
+func checkQuota(c *models.Config, name string, stored, added int64) error {
+	quota := tenant.SeriesQuota(c, name)
+	if quota > 0 && added > 0 && stored+added > quota {
+		return fmt.Errorf("%w: tenant '%s' is limited to %d series", ErrSeriesQuota, name, quota)
+	}
+	return nil
+}
+
+// admit checks that writing metrics g and cr keeps the tenant within its series quota, m.mu must be held.
+func (m *MemStorage) admit(c *models.Config, g, cr models.Metrics) error {
+	if tenant.SeriesQuota(c, m.tenant) <= 0 {
+		return nil
+	}
+	var added int64
+	for _, name := range seriesNames(g) {
+		if _, ok := m.gauge[name]; !ok {
+			added++
+		}
+	}
+	for _, name := range seriesNames(cr) {
+		if _, ok := m.counter[name]; !ok {
+			added++
+		}
+	}
+	return checkQuota(c, m.tenant, int64(len(m.gauge)+len(m.counter)), added)
+}
+
+// admit checks that writing metrics g and cr keeps the tenant within its series quota.
+func (p *PostgresStorage) admit(c *models.Config, g, cr models.Metrics) error {
+	if tenant.SeriesQuota(c, p.tenant) <= 0 {
+		return nil
+	}
+
+	ctx, cancel := p.queryContext(time.Duration(c.ContextTimeout) * time.Second)
+	defer cancel()
+
+	gauges, counters := seriesNames(g), seriesNames(cr)
+
+	var stored, known int64
+	if err := retryOnConnErr(func() error {
+		return p.pool.QueryRow(ctx, `SELECT
+			(SELECT COUNT(*) FROM gauge WHERE tenant = $1) + (SELECT COUNT(*) FROM counter WHERE tenant = $1),
+			(SELECT COUNT(*) FROM gauge WHERE tenant = $1 AND name = ANY($2))
+				+ (SELECT COUNT(*) FROM counter WHERE tenant = $1 AND name = ANY($3))`,
+			p.tenant, gauges, counters).Scan(&stored, &known)
+	}); err != nil {
+		return fmt.Errorf("failed to count series of tenant '%s': %w", p.tenant, err)
+	}
+	return checkQuota(c, p.tenant, stored, int64(len(gauges)+len(counters))-known)
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/vkupriya/go-metrics/internal/tracing"
+)
+
+// querySpanKey is the context key of the span of a query.
+type querySpanKey struct{}
+
+// queryTracer traces Postgres queries in spans of the requests they are made for, see WithContext.
+// Queries outside of traced requests, e.g. of history rollups, aren't traced.
+type queryTracer struct{}
+
+func (queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	op := operation(data.SQL)
+	ctx, span := tracing.Tracer().Start(ctx, "postgres "+op, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBOperationName(op), semconv.DBQueryText(data.SQL)))
+	return context.WithValue(ctx, querySpanKey{}, span)
+}
+
+func (queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	if span, ok := ctx.Value(querySpanKey{}).(trace.Span); ok {
+		tracing.End(span, data.Err)
+	}
+}
+
+// operation returns the SQL command of the query, e.g. SELECT.
+func operation(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToUpper(fields[0])
+}
+
+// WithContext returns storage of the same tenant sharing the connection pool with p, its queries are traced
+// as a part of the request of the context. Queries aren't cancelled with the request.
+func (p *PostgresStorage) WithContext(ctx context.Context) *PostgresStorage {
+	return &PostgresStorage{
+		ctx:        context.WithoutCancel(ctx),
+		pool:       p.pool,
+		tenant:     p.tenant,
+		migrations: p.migrations,
+	}
+}
+
+// queryContext returns context of queries of an operation limited by the timeout.
+func (p *PostgresStorage) queryContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	parent := p.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	return context.WithTimeout(parent, timeout)
+}
@@ -0,0 +1,85 @@
+// Package tenant identifies tenants of metric server requests.
+// Every tenant has its own metric namespace, requests without tenant belong to the default tenant.
+// If tenant tokens are configured, the default tenant is open only to requests without token if allowed
+// by DefaultAccess.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"regexp"
+
+	"github.com/vkupriya/go-metrics/internal/server/models"
+)
+
+const (
+	// Header carries tenant name, it is accepted only when no tenant tokens are configured.
+	Header = "X-Tenant"
+	// TokenHeader carries API token of the tenant.
+	TokenHeader = "X-Tenant-Token"
+	// Default is the tenant of requests which don't identify a tenant.
+	Default = ""
+)
+
+var (
+	ErrInvalidToken  = errors.New("invalid tenant token")
+	ErrTokenRequired = errors.New("tenant token is required")
+	ErrInvalidName   = errors.New("invalid tenant name")
+	// ErrTooManyTenants is returned for a new tenant when storages of MaxTenants tenants are open.
+	ErrTooManyTenants = errors.New("too many tenants")
+)
+
+// Tenant names are used in storage file names, so only safe characters are allowed.
+var nameRe = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// Valid reports whether name can be used as a tenant name.
+func Valid(name string) bool {
+	return nameRe.MatchString(name)
+}
+
+// Resolve returns the tenant of a request presenting token and name, either of them may be empty.
+// Token takes precedence over name. If tenant tokens are configured a token is required, requests
+// without it are let into the default tenant only if DefaultAccess is set.
+func Resolve(c *models.Config, token, name string) (string, error) {
+	if token != "" {
+		t, ok := c.TenantTokens[token]
+		if !ok {
+			return "", ErrInvalidToken
+		}
+		return t, nil
+	}
+	if len(c.TenantTokens) > 0 {
+		if name == Default && c.DefaultAccess {
+			return Default, nil
+		}
+		return "", ErrTokenRequired
+	}
+	if name == Default {
+		return Default, nil
+	}
+	if !Valid(name) {
+		return "", ErrInvalidName
+	}
+	return name, nil
+}
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying the tenant.
+func NewContext(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, tenant)
+}
+
+// FromContext returns the tenant carried by ctx, Default is returned if ctx has no tenant.
+func FromContext(ctx context.Context) string {
+	t, _ := ctx.Value(ctxKey{}).(string)
+	return t
+}
+
+// SeriesQuota returns the maximum number of series the tenant may store, zero means no limit.
+func SeriesQuota(c *models.Config, tenant string) int64 {
+	if q, ok := c.TenantQuotas[tenant]; ok {
+		return q
+	}
+	return c.TenantMaxSeries
+}
@@ -0,0 +1,192 @@
+// Package validation checks names and metadata of written metrics and caps the number of distinct series
+// stored for all tenants.
+//
+// Names must be shorter than the length limit and match the name pattern, names with the reserved
+// prefix of self-metrics are rejected. Units, descriptions and labels are limited in length, so they
+// fit into the metadata table. Series are counted in memory: stored series of a tenant are
+// counted when its storage is opened and new series when they are written first. Once the cap is
+// reached writes of new series are rejected or silently dropped, depending on the overflow policy.
+// Rejected names, metadata and series are counted in self-metrics.
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/selfmetrics"
+)
+
+// Overflow policies.
+const (
+	OverflowReject = "reject"
+	OverflowDrop   = "drop"
+)
+
+const (
+	// DefaultNamePattern allows names of letters, digits, '_', '.', ':' and '-' not starting with a digit.
+	DefaultNamePattern = `^[A-Za-z_][A-Za-z0-9_.:-]*$`
+	// DefaultMaxNameLength is the length of name columns of Postgres tables.
+	DefaultMaxNameLength = 255
+	// MaxUnitLength is the length of the unit column of Postgres metadata table.
+	MaxUnitLength = 64
+	// MaxDescriptionLength limits descriptions of metrics.
+	MaxDescriptionLength = 1024
+	// MaxLabels limits the number of labels of a metric.
+	MaxLabels = 32
+	// MaxLabelLength limits names and values of labels.
+	MaxLabelLength = 255
+)
+
+var defaultPattern = regexp.MustCompile(DefaultNamePattern)
+
+var (
+	ErrInvalidName     = errors.New("invalid metric name")
+	ErrInvalidMetadata = errors.New("invalid metric metadata")
+	ErrSeriesLimit     = errors.New("series limit of the server is exceeded")
+	ErrDropped         = errors.New("metric is dropped by the series limit of the server")
+)
+
+// Validator validates metric names and counts series, it is safe for concurrent use.
+type Validator struct {
+	config *models.Config
+	series map[string]struct{} // tenant, type and name of known series
+	mu     sync.Mutex
+}
+
+// New returns validator using limits of the configuration, default pattern and length limit are
+// used if they aren't configured.
+func New(c *models.Config) *Validator {
+	return &Validator{
+		config: c,
+		series: make(map[string]struct{}),
+	}
+}
+
+// Name returns error wrapping ErrInvalidName if the name can't be written.
+func (v *Validator) Name(name string) error {
+	pattern := v.config.NamePattern
+	if pattern == nil {
+		pattern = defaultPattern
+	}
+	maxLen := v.config.MaxNameLength
+	if maxLen <= 0 {
+		maxLen = DefaultMaxNameLength
+	}
+
+	var reason, detail string
+	switch {
+	case name == "":
+		reason, detail = "empty", "metric name is empty"
+	case int64(len(name)) > maxLen:
+		reason, detail = "too_long", fmt.Sprintf("metric name is longer than %d bytes", maxLen)
+	case strings.HasPrefix(name, selfmetrics.Prefix):
+		reason, detail = "reserved", fmt.Sprintf("prefix '%s' is reserved for self-metrics", selfmetrics.Prefix)
+	case !pattern.MatchString(name):
+		reason, detail = "pattern", fmt.Sprintf("metric name '%s' doesn't match pattern %s", name, pattern)
+	default:
+		return nil
+	}
+	selfmetrics.Inc("validation.rejected_names." + reason)
+	return fmt.Errorf("%w: %s", ErrInvalidName, detail)
+}
+
+// Metadata returns error wrapping ErrInvalidMetadata if unit, description or labels of a metric exceed
+// their length limits.
+func (v *Validator) Metadata(unit, description string, labels map[string]string) error {
+	var reason, detail string
+	switch {
+	case len(unit) > MaxUnitLength:
+		reason, detail = "unit", fmt.Sprintf("unit is longer than %d bytes", MaxUnitLength)
+	case len(description) > MaxDescriptionLength:
+		reason, detail = "description", fmt.Sprintf("description is longer than %d bytes", MaxDescriptionLength)
+	case len(labels) > MaxLabels:
+		reason, detail = "labels", fmt.Sprintf("metric has more than %d labels", MaxLabels)
+	default:
+		for k, val := range labels {
+			if k == "" || len(k) > MaxLabelLength || len(val) > MaxLabelLength {
+				reason = "labels"
+				detail = fmt.Sprintf("label names must be 1 to %d bytes long and values up to %d bytes",
+					MaxLabelLength, MaxLabelLength)
+				break
+			}
+		}
+		if reason == "" {
+			return nil
+		}
+	}
+	selfmetrics.Inc("validation.rejected_metadata." + reason)
+	return fmt.Errorf("%w: %s", ErrInvalidMetadata, detail)
+}
+
+func seriesKey(tenant, mtype, name string) string {
+	return tenant + "/" + mtype + "/" + name
+}
+
+// Admit counts the series if it is new and reports whether it is counted by the call, such series are
+// forgotten by the caller if the write fails. ErrSeriesLimit or ErrDropped is returned for a new series
+// if the cap is reached.
+func (v *Validator) Admit(tenant, mtype, name string) (bool, error) {
+	if v.config.MaxSeries <= 0 {
+		return false, nil
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	key := seriesKey(tenant, mtype, name)
+	if _, ok := v.series[key]; ok {
+		return false, nil
+	}
+	if int64(len(v.series)) >= v.config.MaxSeries {
+		if v.config.SeriesOverflow == OverflowDrop {
+			selfmetrics.Inc("validation.dropped_series")
+			return false, ErrDropped
+		}
+		selfmetrics.Inc("validation.rejected_series")
+		return false, fmt.Errorf("%w: server is limited to %d series", ErrSeriesLimit, v.config.MaxSeries)
+	}
+	v.series[key] = struct{}{}
+	return true, nil
+}
+
+// Seed counts stored series of the tenant, they are counted even if the cap is exceeded.
+func (v *Validator) Seed(tenant string, gauges map[string]float64, counters map[string]int64) {
+	if v.config.MaxSeries <= 0 {
+		return
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for name := range gauges {
+		v.series[seriesKey(tenant, "gauge", name)] = struct{}{}
+	}
+	for name := range counters {
+		v.series[seriesKey(tenant, "counter", name)] = struct{}{}
+	}
+}
+
+// Forget stops counting the deleted series of the tenant.
+func (v *Validator) Forget(tenant, mtype, name string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.series, seriesKey(tenant, mtype, name))
+}
+
+// ForgetPrefix stops counting deleted series of the tenant with names starting with the prefix.
+func (v *Validator) ForgetPrefix(tenant, prefix string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, mtype := range []string{"gauge", "counter"} {
+		p := seriesKey(tenant, mtype, prefix)
+		for key := range v.series {
+			if strings.HasPrefix(key, p) {
+				delete(v.series, key)
+			}
+		}
+	}
+}
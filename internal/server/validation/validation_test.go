@@ -0,0 +1,130 @@
+package validation
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vkupriya/go-metrics/internal/server/models"
+	"github.com/vkupriya/go-metrics/internal/server/selfmetrics"
+)
+
+func TestName(t *testing.T) {
+	v := New(&models.Config{})
+	tests := []struct {
+		name    string
+		metric  string
+		reason  string
+		wantErr bool
+	}{
+		{name: "valid: OK", metric: "go.gc:pause-ns_total"},
+		{name: "empty: FAIL", metric: "", reason: "empty", wantErr: true},
+		{name: "too_long: FAIL", metric: strings.Repeat("a", DefaultMaxNameLength+1), reason: "too_long", wantErr: true},
+		{name: "reserved: FAIL", metric: selfmetrics.Prefix + "requests", reason: "reserved", wantErr: true},
+		{name: "pattern: FAIL", metric: "1Alloc", reason: "pattern", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.wantErr {
+				assert.NoError(t, v.Name(tt.metric))
+				return
+			}
+			counter := "validation.rejected_names." + tt.reason
+			before := selfmetrics.Value(counter)
+			assert.ErrorIs(t, v.Name(tt.metric), ErrInvalidName)
+			assert.Equal(t, before+1, selfmetrics.Value(counter))
+		})
+	}
+
+	t.Run("configured: OK", func(t *testing.T) {
+		v := New(&models.Config{NamePattern: regexp.MustCompile(`^[a-z]+$`), MaxNameLength: 5})
+		assert.NoError(t, v.Name("alloc"))
+		assert.ErrorIs(t, v.Name("Alloc"), ErrInvalidName)
+		assert.ErrorIs(t, v.Name("allocs"), ErrInvalidName)
+	})
+}
+
+func TestMetadata(t *testing.T) {
+	v := New(&models.Config{})
+	tooManyLabels := make(map[string]string, MaxLabels+1)
+	for i := range MaxLabels + 1 {
+		tooManyLabels["l"+strconv.Itoa(i)] = "v"
+	}
+	long := strings.Repeat("a", MaxLabelLength+1)
+
+	tests := []struct {
+		labels      map[string]string
+		name        string
+		unit        string
+		description string
+		reason      string
+	}{
+		{name: "valid: OK", unit: "bytes", description: "Free memory", labels: map[string]string{"host": "a"}},
+		{name: "unit: FAIL", unit: strings.Repeat("b", MaxUnitLength+1), reason: "unit"},
+		{name: "description: FAIL", description: strings.Repeat("d", MaxDescriptionLength+1), reason: "description"},
+		{name: "too_many_labels: FAIL", labels: tooManyLabels, reason: "labels"},
+		{name: "long_label_name: FAIL", labels: map[string]string{long: "a"}, reason: "labels"},
+		{name: "long_label_value: FAIL", labels: map[string]string{"host": long}, reason: "labels"},
+		{name: "empty_label_name: FAIL", labels: map[string]string{"": "a"}, reason: "labels"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.reason == "" {
+				assert.NoError(t, v.Metadata(tt.unit, tt.description, tt.labels))
+				return
+			}
+			counter := "validation.rejected_metadata." + tt.reason
+			before := selfmetrics.Value(counter)
+			assert.ErrorIs(t, v.Metadata(tt.unit, tt.description, tt.labels), ErrInvalidMetadata)
+			assert.Equal(t, before+1, selfmetrics.Value(counter))
+		})
+	}
+}
+
+func TestAdmit(t *testing.T) {
+	v := New(&models.Config{MaxSeries: 3})
+	v.Seed("", map[string]float64{"Alloc": 1}, map[string]int64{"PollCount": 1})
+
+	added, err := v.Admit("", "gauge", "Alloc")
+	require.NoError(t, err, "seeded series are known")
+	assert.False(t, added)
+	added, err = v.Admit("acme", "gauge", "Alloc")
+	require.NoError(t, err)
+	assert.True(t, added)
+	_, err = v.Admit("acme", "gauge", "Frees")
+	assert.ErrorIs(t, err, ErrSeriesLimit)
+
+	v.Forget("acme", "gauge", "Alloc")
+	_, err = v.Admit("acme", "gauge", "Frees")
+	assert.NoError(t, err, "deleted series are forgotten")
+
+	v.ForgetPrefix("", "All")
+	_, err = v.Admit("", "gauge", "Mallocs")
+	assert.NoError(t, err)
+	_, err = v.Admit("", "gauge", "Sys")
+	assert.ErrorIs(t, err, ErrSeriesLimit)
+
+	t.Run("drop: OK", func(t *testing.T) {
+		v := New(&models.Config{MaxSeries: 1, SeriesOverflow: OverflowDrop})
+		_, err := v.Admit("", "gauge", "Alloc")
+		require.NoError(t, err)
+		before := selfmetrics.Value("validation.dropped_series")
+		added, err := v.Admit("", "gauge", "Frees")
+		assert.ErrorIs(t, err, ErrDropped)
+		assert.False(t, added)
+		assert.Equal(t, before+1, selfmetrics.Value("validation.dropped_series"))
+	})
+
+	t.Run("unlimited: OK", func(t *testing.T) {
+		v := New(&models.Config{})
+		for _, name := range []string{"Alloc", "Frees", "Mallocs"} {
+			added, err := v.Admit("", "gauge", name)
+			assert.NoError(t, err)
+			assert.False(t, added, "series aren't counted without the cap")
+		}
+	})
+}
@@ -0,0 +1,139 @@
+// Package signing signs requests of metric agents with HMAC-SHA256 and verifies them on the metric server.
+//
+// Signature covers request method, path, timestamp, nonce and body:
+//
+//	HMAC-SHA256(key, method + "\n" + path + "\n" + timestamp + "\n" + nonce + "\n" + body)
+//
+// Timestamp is unix time in seconds and nonce is a random string unique per request. Signature, timestamp
+// and nonce are sent in HashSHA256, X-Signature-Timestamp and X-Signature-Nonce headers or gRPC metadata.
+// Signatures of gRPC calls use POST method, full method name as path and deterministic protobuf encoding
+// of the request as body.
+//
+// Server rejects signatures with timestamps outside of the allowed window and nonces used before,
+// so captured requests can't be replayed.
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderSignature = "HashSHA256"
+	HeaderTimestamp = "X-Signature-Timestamp"
+	HeaderNonce     = "X-Signature-Nonce"
+)
+
+// maxNonceLen limits length of nonces kept by verifier.
+const maxNonceLen = 64
+
+var (
+	ErrMissing  = errors.New("request is not signed")
+	ErrInvalid  = errors.New("invalid signature")
+	ErrStale    = errors.New("signature timestamp is out of the allowed window")
+	ErrReplayed = errors.New("nonce of the signature has already been used")
+)
+
+// Reason returns short name of the verification error: missing, stale, replayed or invalid.
+func Reason(err error) string {
+	switch {
+	case errors.Is(err, ErrMissing):
+		return "missing"
+	case errors.Is(err, ErrStale):
+		return "stale"
+	case errors.Is(err, ErrReplayed):
+		return "replayed"
+	default:
+		return "invalid"
+	}
+}
+
+// Sign returns hex encoded signature of the request.
+func Sign(key, method, path string, ts int64, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	// Writes to hash.Hash never return an error.
+	_, _ = fmt.Fprintf(mac, "%s\n%s\n%d\n%s\n", method, path, ts, nonce)
+	_, _ = mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewNonce returns a random nonce.
+func NewNonce() (string, error) {
+	const nonceSize = 16
+	b := make([]byte, nonceSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Verifier verifies signatures and remembers nonces of accepted signatures until their timestamps leave
+// the allowed window. Key is passed to every verification, so it can be rotated while nonces are kept.
+type Verifier struct {
+	now       func() time.Time
+	nonces    map[string]int64 // nonce to unix time it can be forgotten at
+	maxAge    int64
+	lastPrune int64
+	mu        sync.Mutex
+}
+
+// NewVerifier returns verifier of signatures, maxAge is the allowed difference between signature
+// timestamp and server time in seconds.
+func NewVerifier(maxAge int64) *Verifier {
+	return &Verifier{
+		now:    time.Now,
+		nonces: make(map[string]int64),
+		maxAge: maxAge,
+	}
+}
+
+// Verify checks signature of the request made with the key, timestamp and nonce are values of the request headers.
+func (v *Verifier) Verify(key, method, path, signature, timestamp, nonce string, body []byte) error {
+	if signature == "" || timestamp == "" || nonce == "" {
+		return fmt.Errorf("%w: signature, timestamp and nonce are required", ErrMissing)
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: timestamp is not unix time in seconds", ErrInvalid)
+	}
+	if len(nonce) > maxNonceLen {
+		return fmt.Errorf("%w: nonce is longer than %d characters", ErrInvalid, maxNonceLen)
+	}
+	if !hmac.Equal([]byte(signature), []byte(Sign(key, method, path, ts, nonce, body))) {
+		return fmt.Errorf("%w: signature does not match", ErrInvalid)
+	}
+
+	now := v.now().Unix()
+	if ts < now-v.maxAge || ts > now+v.maxAge {
+		return ErrStale
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.prune(now)
+	if _, ok := v.nonces[nonce]; ok {
+		return ErrReplayed
+	}
+	v.nonces[nonce] = ts + v.maxAge
+	return nil
+}
+
+// prune forgets nonces of timestamps outside of the window, it runs at most once per window.
+func (v *Verifier) prune(now int64) {
+	if now-v.lastPrune < v.maxAge {
+		return
+	}
+	for nonce, expires := range v.nonces {
+		if expires < now {
+			delete(v.nonces, nonce)
+		}
+	}
+	v.lastPrune = now
+}
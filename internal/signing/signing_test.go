@@ -0,0 +1,97 @@
+package signing
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifier(t *testing.T) {
+	const (
+		key    = "secret"
+		maxAge = 300
+		path   = "/updates/"
+	)
+	now := time.Unix(1_700_000_000, 0)
+	v := NewVerifier(maxAge)
+	v.now = func() time.Time { return now }
+
+	body := []byte(`[{"id":"PollCount","type":"counter","delta":1}]`)
+	sign := func(ts int64, nonce string) (string, string) {
+		return Sign(key, "POST", path, ts, nonce, body), strconv.FormatInt(ts, 10)
+	}
+
+	tests := []struct {
+		wantErr   error
+		name      string
+		method    string
+		path      string
+		nonce     string
+		signature string
+		timestamp string
+		body      []byte
+		unsigned  bool
+	}{
+		{name: "signed: OK", nonce: "n1"},
+		{name: "replayed: FAIL", nonce: "n1", wantErr: ErrReplayed},
+		{name: "other_method: FAIL", nonce: "n2", method: "PUT", wantErr: ErrInvalid},
+		{name: "other_path: FAIL", nonce: "n2", path: "/update/", wantErr: ErrInvalid},
+		{name: "other_body: FAIL", nonce: "n2", body: []byte(`[]`), wantErr: ErrInvalid},
+		{name: "other_nonce: FAIL", nonce: "n2", signature: Sign(key, "POST", path, now.Unix(), "n3", body),
+			wantErr: ErrInvalid},
+		{name: "bad_timestamp: FAIL", nonce: "n2", timestamp: "yesterday", wantErr: ErrInvalid},
+		{name: "no_nonce: FAIL", wantErr: ErrMissing},
+		{name: "no_signature: FAIL", nonce: "n2", unsigned: true, wantErr: ErrMissing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig, ts := sign(now.Unix(), tt.nonce)
+			method, p, b := "POST", path, body
+			if tt.signature != "" {
+				sig = tt.signature
+			}
+			if tt.unsigned {
+				sig = ""
+			}
+			if tt.timestamp != "" {
+				ts = tt.timestamp
+			}
+			if tt.method != "" {
+				method = tt.method
+			}
+			if tt.path != "" {
+				p = tt.path
+			}
+			if tt.body != nil {
+				b = tt.body
+			}
+			assert.ErrorIs(t, v.Verify(key, method, p, sig, ts, tt.nonce, b), tt.wantErr)
+		})
+	}
+
+	t.Run("stale: FAIL", func(t *testing.T) {
+		sig, ts := sign(now.Unix()-maxAge-1, "old")
+		assert.ErrorIs(t, v.Verify(key, "POST", path, sig, ts, "old", body), ErrStale)
+		sig, ts = sign(now.Unix()+maxAge+1, "future")
+		assert.ErrorIs(t, v.Verify(key, "POST", path, sig, ts, "future", body), ErrStale)
+	})
+
+	t.Run("prune: OK", func(t *testing.T) {
+		now = now.Add(2 * maxAge * time.Second)
+		sig, ts := sign(now.Unix(), "n4")
+		assert.NoError(t, v.Verify(key, "POST", path, sig, ts, "n4", body))
+		assert.NotContains(t, v.nonces, "n1")
+		assert.Contains(t, v.nonces, "n4")
+	})
+
+	t.Run("rotated_key: OK", func(t *testing.T) {
+		ts := strconv.FormatInt(now.Unix(), 10)
+		sig := Sign("rotated", "POST", path, now.Unix(), "n5", body)
+		assert.ErrorIs(t, v.Verify(key, "POST", path, sig, ts, "n5", body), ErrInvalid)
+		assert.NoError(t, v.Verify("rotated", "POST", path, sig, ts, "n5", body))
+		assert.ErrorIs(t, v.Verify("rotated", "POST", path, sig, ts, "n5", body), ErrReplayed,
+			"nonces are kept on rotation")
+	})
+}
@@ -0,0 +1,91 @@
+package tracing
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/vkupriya/go-metrics/internal/configfile"
+)
+
+const (
+	defaultEndpoint    = "localhost:4317"
+	defaultSampleRatio = 1.0
+)
+
+// Options of tracing: names in config files, flags and env vars.
+var (
+	optExporter    = configfile.Option{Key: "tracing_exporter", Flag: "tracing-exporter", Env: "TRACING_EXPORTER"}
+	optEndpoint    = configfile.Option{Key: "tracing_endpoint", Flag: "tracing-endpoint", Env: "TRACING_ENDPOINT"}
+	optInsecure    = configfile.Option{Key: "tracing_insecure", Flag: "tracing-insecure", Env: "TRACING_INSECURE"}
+	optFile        = configfile.Option{Key: "tracing_file", Flag: "tracing-file", Env: "TRACING_FILE"}
+	optSampleRatio = configfile.Option{
+		Key: "tracing_sample_ratio", Flag: "tracing-sample-ratio", Env: "TRACING_SAMPLE_RATIO",
+	}
+)
+
+// Flags are values of tracing flags defined by AddFlags.
+type Flags struct {
+	exporter, endpoint, file string
+	sampleRatio              float64
+	insecure                 bool
+}
+
+// FileConfig are tracing options of config files, config files of the server and agent embed them.
+type FileConfig struct {
+	Exporter    string  `json:"tracing_exporter,omitempty"`
+	Endpoint    string  `json:"tracing_endpoint,omitempty"`
+	File        string  `json:"tracing_file,omitempty"`
+	SampleRatio float64 `json:"tracing_sample_ratio,omitempty"`
+	Insecure    bool    `json:"tracing_insecure,omitempty"`
+}
+
+// AddFlags defines tracing flags of the default flag set.
+func AddFlags(f *Flags) {
+	flag.StringVar(&f.exporter, optExporter.Flag, ExporterNone, "Exporter of trace spans: none, otlp, stdout or file.")
+	flag.StringVar(&f.endpoint, optEndpoint.Flag, defaultEndpoint, "Host and port of OTLP gRPC collector of spans.")
+	flag.BoolVar(&f.insecure, optInsecure.Flag, false, "Connect to OTLP collector without TLS.")
+	flag.StringVar(&f.file, optFile.Flag, "", "Path of the file spans are written to by the file exporter.")
+	flag.Float64Var(&f.sampleRatio, optSampleRatio.Flag, defaultSampleRatio,
+		"Fraction of new traces which are sampled, traces continued from requests follow the caller.")
+}
+
+// Resolve resolves tracing options from values of flags f, the config file and env vars.
+// Values of f are replaced by the resolved ones.
+func Resolve(l *configfile.Loader, f *Flags, file *FileConfig) (*Config, error) {
+	err := errors.Join(
+		configfile.Resolve(l, optExporter, &f.exporter, file.Exporter, configfile.String),
+		configfile.Resolve(l, optEndpoint, &f.endpoint, file.Endpoint, configfile.String),
+		configfile.Resolve(l, optInsecure, &f.insecure, file.Insecure, configfile.Bool),
+		configfile.Resolve(l, optFile, &f.file, file.File, configfile.String),
+		configfile.Resolve(l, optSampleRatio, &f.sampleRatio, file.SampleRatio, configfile.Float),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve tracing options: %w", err)
+	}
+
+	switch f.exporter {
+	case ExporterNone, ExporterStdout:
+	case ExporterOTLP:
+		if f.endpoint == "" {
+			return nil, errors.New("OTLP exporter requires tracing endpoint")
+		}
+	case ExporterFile:
+		if f.file == "" {
+			return nil, errors.New("file exporter requires tracing file")
+		}
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter '%s', expected none, otlp, stdout or file", f.exporter)
+	}
+	if f.sampleRatio < 0 || f.sampleRatio > 1 {
+		return nil, errors.New("tracing sample ratio must be between 0 and 1")
+	}
+
+	return &Config{
+		Exporter:    f.exporter,
+		Endpoint:    f.endpoint,
+		File:        f.file,
+		SampleRatio: f.sampleRatio,
+		Insecure:    f.insecure,
+	}, nil
+}
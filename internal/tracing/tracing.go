@@ -0,0 +1,143 @@
+// Package tracing sets up OpenTelemetry tracing of the metric server and agent from tracing options.
+//
+// Spans are exported by OTLP over gRPC to a collector, or written as JSON to stdout or a file for local use.
+// Trace context is propagated between the agent and the server in W3C traceparent and tracestate headers
+// of HTTP requests and in gRPC metadata under the same keys.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters of spans.
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+// scope is the instrumentation scope of spans of the server and agent.
+const scope = "github.com/vkupriya/go-metrics"
+
+// Config defines where spans are exported and which traces are sampled.
+type Config struct {
+	Exporter    string
+	Endpoint    string  // host:port of the OTLP collector
+	File        string  // path of the file spans are appended to by the file exporter
+	SampleRatio float64 // fraction of traces started by the process which are sampled
+	Insecure    bool    // connect to the OTLP collector without TLS
+}
+
+// Shutdown flushes spans which aren't exported yet and stops the exporter.
+type Shutdown func(ctx context.Context) error
+
+// Start installs the global tracer provider exporting spans of the service and the W3C trace context propagator,
+// version of the service is optional.
+// With exporter none spans aren't recorded, but trace context of incoming requests is still propagated.
+func Start(ctx context.Context, c *Config, service, version string) (Shutdown, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	if c.Exporter == ExporterNone {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, closer, err := newExporter(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	attrs := []attribute.KeyValue{semconv.ServiceName(service)}
+	if version != "" {
+		attrs = append(attrs, semconv.ServiceVersion(version))
+	}
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithAttributes(attrs...),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build tracing resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.SampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if closer != nil {
+			err = errors.Join(err, closer.Close())
+		}
+		if err != nil {
+			return fmt.Errorf("failed to shut down tracing: %w", err)
+		}
+		return nil
+	}, nil
+}
+
+// newExporter returns exporter of the config and the file it writes to, if any.
+func newExporter(ctx context.Context, c *Config) (sdktrace.SpanExporter, io.Closer, error) {
+	switch c.Exporter {
+	case ExporterOTLP:
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(c.Endpoint)}
+		if c.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		exp, err := otlptracegrpc.New(ctx, opts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+		return exp, nil, nil
+	case ExporterStdout:
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create stdout exporter: %w", err)
+		}
+		return exp, nil, nil
+	case ExporterFile:
+		const perm = 0o600
+		f, err := os.OpenFile(c.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, perm)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open tracing file: %w", err)
+		}
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			return nil, nil, errors.Join(fmt.Errorf("failed to create file exporter: %w", err), f.Close())
+		}
+		return exp, f, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown tracing exporter '%s'", c.Exporter)
+	}
+}
+
+// Tracer returns tracer of spans of the server and agent.
+func Tracer() trace.Tracer {
+	return otel.Tracer(scope)
+}
+
+// End records the error of the operation traced by the span, if any, and ends the span.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,133 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/vkupriya/go-metrics/internal/configfile"
+)
+
+func TestStart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	shutdown, err := Start(context.Background(), &Config{Exporter: ExporterFile, File: path, SampleRatio: 1},
+		"test-service", "1.0")
+	require.NoError(t, err)
+
+	_, span := Tracer().Start(context.Background(), "test span")
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var exported struct {
+		Name     string
+		Resource []struct {
+			Key   string
+			Value struct{ Value any }
+		}
+	}
+	require.NoError(t, json.Unmarshal(b, &exported), "spans are JSON")
+	assert.Equal(t, "test span", exported.Name)
+	assert.Contains(t, exported.Resource, struct {
+		Key   string
+		Value struct{ Value any }
+	}{Key: "service.name", Value: struct{ Value any }{Value: "test-service"}})
+
+	t.Run("unknown_exporter: FAIL", func(t *testing.T) {
+		_, err := Start(context.Background(), &Config{Exporter: "jaeger"}, "test-service", "")
+		assert.Error(t, err)
+	})
+}
+
+func TestInterceptors(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(tp)
+	_, err := Start(context.Background(), &Config{Exporter: ExporterNone}, "test-service", "")
+	require.NoError(t, err)
+
+	const method = "/metrics.Metrics/UpdateMetrics"
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("x-tenant", "acme"))
+	var outgoing metadata.MD
+	invoker := func(ctx context.Context, _ string, _, _ any, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+		outgoing, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	}
+	require.NoError(t, UnaryClientInterceptor()(ctx, method, nil, nil, nil, invoker))
+	assert.Equal(t, []string{"acme"}, outgoing.Get("x-tenant"), "metadata of the call is kept")
+	require.Len(t, outgoing.Get("traceparent"), 1)
+
+	var handled trace.SpanContext
+	handler := func(ctx context.Context, _ any) (any, error) {
+		handled = trace.SpanContextFromContext(ctx)
+		return struct{}{}, nil
+	}
+	_, err = UnaryServerInterceptor()(metadata.NewIncomingContext(context.Background(), outgoing), nil,
+		&grpc.UnaryServerInfo{FullMethod: method}, handler)
+	require.NoError(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	client, server := spans[0], spans[1]
+	assert.Equal(t, "metrics.Metrics/UpdateMetrics", client.Name())
+	assert.Equal(t, trace.SpanKindClient, client.SpanKind())
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind())
+	assert.Equal(t, client.SpanContext().TraceID(), server.SpanContext().TraceID(), "trace is continued by the server")
+	assert.Equal(t, client.SpanContext().SpanID(), server.Parent().SpanID())
+	assert.Equal(t, server.SpanContext().SpanID(), handled.SpanID(), "handler runs in the span of the call")
+}
+
+func TestResolve(t *testing.T) {
+	l := configfile.NewLoader(flag.NewFlagSet("test", flag.ContinueOnError))
+	tests := []struct {
+		name  string
+		flags Flags
+		file  FileConfig
+		err   string
+	}{
+		{name: "none", flags: Flags{exporter: ExporterNone, sampleRatio: 1}},
+		{name: "otlp", flags: Flags{exporter: ExporterOTLP, endpoint: "collector:4317", sampleRatio: 0.5}},
+		{
+			name:  "unknown_exporter: FAIL",
+			flags: Flags{exporter: "zipkin", sampleRatio: 1},
+			err:   "unknown tracing exporter 'zipkin'",
+		},
+		{
+			name:  "file_without_path: FAIL",
+			flags: Flags{exporter: ExporterFile, sampleRatio: 1},
+			err:   "file exporter requires tracing file",
+		},
+		{
+			name:  "sample_ratio: FAIL",
+			flags: Flags{exporter: ExporterStdout, sampleRatio: 2},
+			err:   "sample ratio",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Resolve(l, &tt.flags, &tt.file)
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.True(t, strings.Contains(err.Error(), tt.err), err.Error())
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.flags.exporter, c.Exporter)
+			assert.InDelta(t, tt.flags.sampleRatio, c.SampleRatio, 0)
+		})
+	}
+}